@@ -3,10 +3,12 @@ package convert
 import (
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/eric2788/bilirec/internal/modules/rest"
 	"github.com/eric2788/bilirec/internal/services/convert"
 	"github.com/eric2788/bilirec/internal/services/path"
+	"github.com/eric2788/bilirec/internal/services/recorder"
 	"github.com/eric2788/bilirec/utils"
 	"github.com/gofiber/fiber/v3"
 	"github.com/sirupsen/logrus"
@@ -15,20 +17,24 @@ import (
 var logger = logrus.WithField("controller", "convert")
 
 type Controller struct {
-	convertSvc *convert.Service
-	pathSvc    *path.Service
+	convertSvc  *convert.Service
+	pathSvc     *path.Service
+	recorderSvc *recorder.Service
 }
 
-func NewController(app *fiber.App, convertSvc *convert.Service, pathSvc *path.Service) *Controller {
+func NewController(app *fiber.App, convertSvc *convert.Service, pathSvc *path.Service, recorderSvc *recorder.Service) *Controller {
 	cc := &Controller{
-		convertSvc: convertSvc,
-		pathSvc:    pathSvc,
+		convertSvc:  convertSvc,
+		pathSvc:     pathSvc,
+		recorderSvc: recorderSvc,
 	}
 
 	converts := app.Group("/convert")
 	converts.Get("/tasks", cc.listConvertTasks)
 	converts.Delete("/tasks/:task_id", rest.AdminOnly, cc.cancelTask)
 	converts.Post("/tasks/*", rest.AdminOnly, cc.enqueueTask)
+	converts.Post("/clip/*", rest.AdminOnly, cc.clipTask)
+	converts.Post("/merge", rest.AdminOnly, cc.mergeTask)
 	return cc
 }
 
@@ -63,6 +69,14 @@ func (c *Controller) listConvertTasks(ctx fiber.Ctx) error {
 			logger.Warnf("error getting relative path for %s: %v", tasks[i].OutputPath, err)
 		}
 
+		for j, input := range tasks[i].InputPaths {
+			if rel, err := c.pathSvc.GetRelativePath(input); err == nil {
+				tasks[i].InputPaths[j] = rel
+			} else {
+				logger.Warnf("error getting relative path for %s: %v", input, err)
+			}
+		}
+
 	}
 	return ctx.JSON(tasks)
 }
@@ -141,6 +155,137 @@ func (c *Controller) enqueueTask(ctx fiber.Ctx) error {
 	}
 }
 
+// @Summary Enqueue clip task
+// @Description Enqueue a task cutting a time range out of the given video file, the clip is saved beside the source.
+// @Description FLV files are cut from the keyframe before the start when ffmpeg is not installed.
+// @Tags convert
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param path path string true "Video file path"
+// @Param request body ClipRequest true "Time range of the clip in seconds"
+// @Success 200 {object} convert.TaskQueue "Enqueued clip task"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Conflict: File is recording or in convert queue, or clip already exists"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /convert/clip/{path} [post]
+func (c *Controller) clipTask(ctx fiber.Ctx) error {
+	raw := ctx.Params("*", "/")
+	path, err := url.PathUnescape(raw)
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	var req ClipRequest
+	if err := ctx.Bind().Body(&req); err != nil {
+		logger.Warnf("cannot parse clip body: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的請求資料")
+	}
+	fullPath, err := c.pathSvc.ValidatePath(path)
+	if err != nil {
+		logger.Warnf("error validating file path %s: %v", path, err)
+		return c.parseFiberError(err)
+	}
+	// a file being written would be cut short, and a queued one may be deleted after converted
+	if c.recorderSvc.IsRecording(fullPath) {
+		return fiber.NewError(fiber.StatusConflict, "無法剪輯正在錄製的文件")
+	} else if inQueue, err := c.convertSvc.IsInQueue(fullPath); err != nil {
+		logger.Errorf("error checking if path %s is in convert queue: %v", path, err)
+		return c.parseFiberError(err)
+	} else if inQueue {
+		return fiber.NewError(fiber.StatusConflict, "該文件已在轉檔佇列中")
+	}
+	start := time.Duration(req.Start * float64(time.Second))
+	end := time.Duration(req.End * float64(time.Second))
+	q, err := c.convertSvc.Clip(fullPath, start, end)
+	switch {
+	case err == nil:
+		return ctx.JSON(q)
+	case err == convert.ErrInvalidClipRange:
+		return fiber.NewError(fiber.StatusBadRequest, "無效的剪輯時間範圍")
+	case err == convert.ErrUnsupportedFormat:
+		return fiber.NewError(fiber.StatusBadRequest, "未安裝 ffmpeg 時只支援剪輯 FLV 文件")
+	case err == convert.ErrOutputExists:
+		return fiber.NewError(fiber.StatusConflict, "該片段已存在或已在轉檔佇列中")
+	default:
+		logger.Errorf("error enqueueing clip task for path %s: %v", path, err)
+		return c.parseFiberError(err)
+	}
+}
+
+// @Summary Enqueue merge task
+// @Description Enqueue a task concatenating the given files, or all files of a recorded session, into one file beside the first one.
+// @Description The timestamps continue across the files, FLV files are merged without ffmpeg when it is not installed.
+// @Tags convert
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body MergeRequest true "Files or session to merge"
+// @Success 200 {object} convert.TaskQueue "Enqueued merge task"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Conflict: File already in convert queue or merged file exists"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /convert/merge [post]
+func (c *Controller) mergeTask(ctx fiber.Ctx) error {
+	var req MergeRequest
+	if err := ctx.Bind().Body(&req); err != nil {
+		logger.Warnf("cannot parse merge body: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的請求資料")
+	} else if (len(req.Paths) == 0) == (req.SessionID == "") {
+		return fiber.NewError(fiber.StatusBadRequest, "請提供要合併的文件或錄製場次其中之一")
+	} else if req.Format != "" && req.Format != "flv" && req.Format != "mp4" {
+		return fiber.NewError(fiber.StatusBadRequest, "不支援的輸出格式")
+	}
+
+	var fullPaths []string
+	if req.SessionID != "" {
+		segments, err := c.recorderSvc.SessionSegments(req.SessionID)
+		if err == recorder.ErrSessionNotFound {
+			return fiber.NewError(fiber.StatusNotFound, "找不到該錄製場次")
+		} else if err != nil {
+			logger.Errorf("error getting segments of session %s: %v", req.SessionID, err)
+			return fiber.ErrInternalServerError
+		}
+		fullPaths = segments
+	} else {
+		for _, p := range req.Paths {
+			fullPath, err := c.pathSvc.ValidatePath(p)
+			if err != nil {
+				logger.Warnf("error validating file path %s: %v", p, err)
+				return c.parseFiberError(err)
+			}
+			fullPaths = append(fullPaths, fullPath)
+		}
+	}
+
+	for _, fullPath := range fullPaths {
+		if inQueue, err := c.convertSvc.IsInQueue(fullPath); err != nil {
+			logger.Errorf("error checking if path %s is in convert queue: %v", fullPath, err)
+			return c.parseFiberError(err)
+		} else if inQueue {
+			return fiber.NewError(fiber.StatusConflict, "要合併的文件中包含已在轉檔佇列中的文件")
+		}
+	}
+
+	q, err := c.convertSvc.Merge(fullPaths, req.Format, req.Delete)
+	switch {
+	case err == nil:
+		return ctx.JSON(q)
+	case err == convert.ErrInvalidMerge:
+		return fiber.NewError(fiber.StatusBadRequest, "至少需要兩個相同格式的文件才能合併")
+	case err == convert.ErrUnsupportedFormat:
+		return fiber.NewError(fiber.StatusBadRequest, "未安裝 ffmpeg 時只支援合併 FLV 文件")
+	case err == convert.ErrOutputExists:
+		return fiber.NewError(fiber.StatusConflict, "合併後的文件已存在或已在轉檔佇列中")
+	default:
+		logger.Errorf("error enqueueing merge task: %v", err)
+		return c.parseFiberError(err)
+	}
+}
+
 func (c *Controller) parseFiberError(err error) error {
 	switch {
 	case os.IsNotExist(err):
@@ -0,0 +1,14 @@
+package convert
+
+type ClipRequest struct {
+	Start float64 `json:"start"` // offset from the beginning of the recording in seconds
+	End   float64 `json:"end"`   // must be later than start, in seconds
+}
+
+// MergeRequest merges either the listed files or all files of a recorded session
+type MergeRequest struct {
+	Paths     []string `json:"paths"`                   // files to merge in order
+	SessionID string   `json:"session_id"`              // recorded session to merge, instead of paths
+	Format    string   `json:"format" enums:",flv,mp4"` // empty to keep the format of the files
+	Delete    bool     `json:"delete"`                  // whether to delete the files after merging
+}
@@ -1,6 +1,8 @@
 package file
 
 import (
+	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -54,17 +56,20 @@ func NewController(
 }
 
 // @Summary Playback a video file
-// @Description Stream a video file inline for browser playback (VOD only)
+// @Description Stream a video file inline for browser playback (VOD only), FLV files are remuxed into fragmented MP4 on the fly
 // @Tags files
 // @Security BearerAuth
 // @Accept json
 // @Produce video/mp4
 // @Param path path string true "Video file path"
+// @Param Range header string false "Byte range of the video"
 // @Success 200 {file} binary "Video stream"
+// @Success 206 {file} binary "Partial video stream"
 // @Failure 400 {string} string "Bad request"
 // @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "Not found"
 // @Failure 415 {string} string "Unsupported media type"
+// @Failure 416 {string} string "Range not satisfiable"
 // @Router /files/playback/{path} [get]
 func (c *Controller) playbackFile(ctx fiber.Ctx) error {
 	raw := ctx.Params("*", "/")
@@ -87,9 +92,46 @@ func (c *Controller) playbackFile(ctx fiber.Ctx) error {
 		"inline; filename=\""+filepath.Base(fullPath)+"\"",
 	)
 
+	if file.NeedsRemux(fullPath) {
+		return c.playbackRemuxed(ctx, fullPath)
+	}
 	return ctx.SendFile(fullPath, fiber.SendFile{ByteRange: true})
 }
 
+// playbackRemuxed streams a FLV file as fragmented MP4, the byte ranges are of the MP4 output
+// which lets the player seek to any GOP without the file being converted.
+func (c *Controller) playbackRemuxed(ctx fiber.Ctx, fullPath string) error {
+	media, err := c.fileSvc.OpenRemuxed(fullPath)
+	if err != nil {
+		logger.Warnf("error remuxing playback file %s: %v", fullPath, err)
+		return c.parseFiberError(err)
+	}
+
+	size := media.Size()
+	start, end := int64(0), size-1
+	ctx.Set(fiber.HeaderAcceptRanges, "bytes")
+	if ctx.Get(fiber.HeaderRange) != "" {
+		ranges, err := ctx.Range(size)
+		if err != nil || len(ranges.Ranges) != 1 {
+			media.Close()
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return fiber.ErrRequestedRangeNotSatisfiable
+		}
+		start, end = ranges.Ranges[0].Start, ranges.Ranges[0].End
+		ctx.Status(fiber.StatusPartialContent)
+		ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	length := end - start + 1
+	body := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(media.NewReader(start), length), media}
+	// the body stream is closed after it is sent
+	ctx.Response().SetBodyStream(body, int(length))
+	return nil
+}
+
 // @Summary List files and directories
 // @Description List files and directories under a given path
 // @Tags files
@@ -0,0 +1,70 @@
+package hook
+
+import (
+	"strconv"
+
+	"github.com/eric2788/bilirec/internal/modules/rest"
+	"github.com/eric2788/bilirec/internal/services/hook"
+	"github.com/gofiber/fiber/v3"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("controller", "hook")
+
+const maxDeliveryPageSize = 100
+
+type Controller struct {
+	service *hook.Service
+}
+
+func NewController(app *fiber.App, service *hook.Service) *Controller {
+	hc := &Controller{service: service}
+	hooks := app.Group("/hook")
+	hooks.Get("/list", rest.AdminOnly, hc.listHooks)
+	hooks.Get("/deliveries", rest.AdminOnly, hc.listDeliveries)
+	return hc
+}
+
+// @Summary List hooks
+// @Description Get the hooks loaded from the hooks file, secrets and headers are not included
+// @Tags hook
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {array} hook.Info "Configured hooks"
+// @Failure 403 {string} string "Forbidden"
+// @Router /hook/list [get]
+func (h *Controller) listHooks(ctx fiber.Ctx) error {
+	return ctx.JSON(h.service.ListHooks())
+}
+
+// @Summary List hook deliveries
+// @Description Get the recent deliveries of hooks from newest to oldest, including failures and retries
+// @Tags hook
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param page query int false "Page number, starts from 1" default(1)
+// @Param size query int false "Page size, at most 100" default(20)
+// @Param hook query string false "Filter by hook name"
+// @Success 200 {object} hook.DeliveryPage "Hook deliveries"
+// @Failure 400 {string} string "Invalid query"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /hook/deliveries [get]
+func (h *Controller) listDeliveries(ctx fiber.Ctx) error {
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的頁數")
+	}
+	size, err := strconv.Atoi(ctx.Query("size", "20"))
+	if err != nil || size < 1 || size > maxDeliveryPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的每頁數量")
+	}
+	deliveries, err := h.service.ListDeliveries(ctx.Query("hook"), page, size)
+	if err != nil {
+		logger.Errorf("error listing hook deliveries: %v", err)
+		return fiber.ErrInternalServerError
+	}
+	return ctx.JSON(deliveries)
+}
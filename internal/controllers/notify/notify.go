@@ -3,11 +3,24 @@ package notify
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/eric2788/bilirec/internal/modules/rest"
 	ns "github.com/eric2788/bilirec/internal/services/notify"
+	"github.com/eric2788/bilirec/utils"
 	"github.com/gofiber/fiber/v3"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("controller", "notify")
+
+const (
+	maxHistoryPageSize = 100
+	// events read from the history at a time while replaying
+	replayBatchSize = 100
 )
 
 type Controller struct {
@@ -18,9 +31,25 @@ func NewController(app *fiber.App, notifySvc *ns.Service) *Controller {
 	c := &Controller{notifySvc: notifySvc}
 	group := app.Group("/notify")
 	group.Get("/stream", c.stream)
+	group.Get("/history", c.listHistory)
+	group.Get("/channels", rest.AdminOnly, c.listChannels)
+	group.Post("/channels", rest.AdminOnly, c.createChannel)
+	group.Put("/channels/:id", rest.AdminOnly, c.updateChannel)
+	group.Delete("/channels/:id", rest.AdminOnly, c.deleteChannel)
+	group.Post("/channels/:id/test", rest.AdminOnly, c.testChannel)
 	return c
 }
 
+// @Summary Subscribe notifications
+// @Description Stream the notification events by Server-Sent Events, each event has its history id as the SSE id.
+// @Description Reconnecting with Last-Event-ID replays the events missed since then, the events missed by a slow connection are also replayed.
+// @Tags notify
+// @Security BearerAuth
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "Replay the events after this id"
+// @Param last_event_id query int false "Same as Last-Event-ID, for clients cannot set headers"
+// @Success 200 {object} notify.Event "Event stream"
+// @Router /notify/stream [get]
 func (c *Controller) stream(ctx fiber.Ctx) error {
 	ctx.Set(fiber.HeaderContentType, "text/event-stream")
 	ctx.Set(fiber.HeaderCacheControl, "no-cache")
@@ -29,12 +58,24 @@ func (c *Controller) stream(ctx fiber.Ctx) error {
 	// force nginx to not buffer the response
 	ctx.Set("X-Accel-Buffering", "no")
 
+	lastID, err := strconv.ParseUint(utils.EmptyOrElse(ctx.Get("Last-Event-ID"), ctx.Query("last_event_id", "0")), 10, 64)
+	if err != nil {
+		lastID = 0
+	}
+	// the history may be reset, ids after the latest would skip every new event
+	lastID = min(lastID, c.notifySvc.LastEventID())
+
 	_, ch, unsubscribe := c.notifySvc.Subscribe(32)
 
 	requestCtx := ctx.RequestCtx()
 	requestCtx.SetBodyStreamWriter(func(w *bufio.Writer) {
 		defer unsubscribe()
-		writeEvent := func(event string, payload []byte) bool {
+		writeEvent := func(id uint64, event string, payload []byte) bool {
+			if id > 0 {
+				if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+					return false
+				}
+			}
 			if _, err := fmt.Fprintf(w, "event: %s\n", event); err != nil {
 				return false
 			}
@@ -44,7 +85,41 @@ func (c *Controller) stream(ctx fiber.Ctx) error {
 			return w.Flush() == nil
 		}
 
-		if !writeEvent("ping", []byte(`{"ok":true}`)) {
+		sendEvent := func(evt *ns.Event) bool {
+			payload, err := json.Marshal(evt)
+			if err != nil {
+				return true
+			}
+			lastID = max(lastID, evt.ID)
+			return writeEvent(evt.ID, "notification", payload)
+		}
+
+		// replay sends the saved events after the last sent one and before the given id, zero for all
+		replay := func(before uint64) bool {
+			for {
+				events, err := c.notifySvc.EventsSince(lastID, replayBatchSize)
+				if err != nil {
+					logger.Warnf("cannot replay notification events: %v", err)
+					return true
+				}
+				for _, evt := range events {
+					if before > 0 && evt.ID >= before {
+						return true
+					}
+					if !sendEvent(evt) {
+						return false
+					}
+				}
+				if len(events) < replayBatchSize {
+					return true
+				}
+			}
+		}
+
+		if !writeEvent(0, "ping", []byte(`{"ok":true}`)) {
+			return
+		}
+		if lastID > 0 && !replay(0) {
 			return
 		}
 
@@ -56,18 +131,23 @@ func (c *Controller) stream(ctx fiber.Ctx) error {
 			case <-requestCtx.Done():
 				return
 			case <-heartbeat.C:
-				if !writeEvent("ping", []byte(`{"ok":true}`)) {
+				if !writeEvent(0, "ping", []byte(`{"ok":true}`)) {
 					return
 				}
 			case evt, ok := <-ch:
 				if !ok {
 					return
 				}
-				payload, err := json.Marshal(evt)
-				if err != nil {
-					continue
+				if evt.ID > 0 {
+					if evt.ID <= lastID {
+						// already replayed
+						continue
+					} else if lastID > 0 && evt.ID > lastID+1 && !replay(evt.ID) {
+						// events were dropped while the connection was slow
+						return
+					}
 				}
-				if !writeEvent("notification", payload) {
+				if !sendEvent(&evt) {
 					return
 				}
 			}
@@ -76,3 +156,159 @@ func (c *Controller) stream(ctx fiber.Ctx) error {
 
 	return nil
 }
+
+// @Summary List notification history
+// @Description Get the recent notification events from newest to oldest
+// @Tags notify
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number, starts from 1" default(1)
+// @Param size query int false "Page size, at most 100" default(20)
+// @Param room_id query int false "Filter by room id"
+// @Param type query string false "Filter by event types, separated by commas"
+// @Success 200 {object} notify.EventPage "Notification events"
+// @Failure 400 {string} string "Invalid query"
+// @Failure 500 {string} string "Internal server error"
+// @Router /notify/history [get]
+func (c *Controller) listHistory(ctx fiber.Ctx) error {
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的頁數")
+	}
+	size, err := strconv.Atoi(ctx.Query("size", "20"))
+	if err != nil || size < 1 || size > maxHistoryPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的每頁數量")
+	}
+	roomID, err := strconv.Atoi(ctx.Query("room_id", "0"))
+	if err != nil || roomID < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的房間 ID")
+	}
+	filter := ns.HistoryFilter{RoomID: roomID}
+	for _, t := range utils.SplitAndTrim(ctx.Query("type"), ",") {
+		filter.Types = append(filter.Types, ns.EventType(t))
+	}
+	events, err := c.notifySvc.ListHistory(filter, page, size)
+	if err != nil {
+		logger.Errorf("error listing notification history: %v", err)
+		return fiber.ErrInternalServerError
+	}
+	return ctx.JSON(events)
+}
+
+// @Summary List notification channels
+// @Description Get the outbound notification channels without credentials: smtp_password, bot_token, header values and the url of discord and slack are empty
+// @Tags notify
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} notify.Channel "Notification channels"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /notify/channels [get]
+func (c *Controller) listChannels(ctx fiber.Ctx) error {
+	channels, err := c.notifySvc.ListChannels()
+	if err != nil {
+		logger.Errorf("error listing notification channels: %v", err)
+		return fiber.ErrInternalServerError
+	}
+	return ctx.JSON(channels)
+}
+
+// @Summary Create a notification channel
+// @Description Create an outbound notification channel of webhook, email, discord, slack or telegram.
+// @Description The message is rendered by the Go text/template of the channel with the event, such as {{.Type}}, {{.RoomID}}, {{.Message}} and {{formatTime .Timestamp}}.
+// @Tags notify
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body notify.Channel true "Notification channel, id is ignored"
+// @Success 200 {object} notify.Channel "Created channel without credentials"
+// @Failure 400 {string} string "Invalid channel"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /notify/channels [post]
+func (c *Controller) createChannel(ctx fiber.Ctx) error {
+	var channel ns.Channel
+	if err := ctx.Bind().Body(&channel); err != nil {
+		logger.Warnf("cannot parse notification channel body: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的請求資料")
+	}
+	if err := c.notifySvc.CreateChannel(&channel); err != nil {
+		return channelError(err)
+	}
+	return ctx.JSON(channel.Redacted())
+}
+
+// @Summary Update a notification channel
+// @Description Replace the settings of an outbound notification channel, empty smtp_password, bot_token, url and header values keep the saved values
+// @Tags notify
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Channel ID"
+// @Param request body notify.Channel true "Notification channel, id is ignored"
+// @Success 200 {object} notify.Channel "Updated channel without credentials"
+// @Failure 400 {string} string "Invalid channel"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Channel not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /notify/channels/{id} [put]
+func (c *Controller) updateChannel(ctx fiber.Ctx) error {
+	var channel ns.Channel
+	if err := ctx.Bind().Body(&channel); err != nil {
+		logger.Warnf("cannot parse notification channel body: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的請求資料")
+	}
+	if err := c.notifySvc.UpdateChannel(ctx.Params("id"), &channel); err != nil {
+		return channelError(err)
+	}
+	return ctx.JSON(channel.Redacted())
+}
+
+// @Summary Delete a notification channel
+// @Tags notify
+// @Security BearerAuth
+// @Param id path string true "Channel ID"
+// @Success 204 "Channel deleted"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Channel not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /notify/channels/{id} [delete]
+func (c *Controller) deleteChannel(ctx fiber.Ctx) error {
+	if err := c.notifySvc.DeleteChannel(ctx.Params("id")); err != nil {
+		return channelError(err)
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// @Summary Send a test notification
+// @Description Send a test event through the channel, even if it is disabled
+// @Tags notify
+// @Security BearerAuth
+// @Param id path string true "Channel ID"
+// @Success 204 "Test notification sent"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Channel not found"
+// @Failure 502 {string} string "Cannot send the notification"
+// @Router /notify/channels/{id}/test [post]
+func (c *Controller) testChannel(ctx fiber.Ctx) error {
+	if err := c.notifySvc.TestChannel(ctx.Context(), ctx.Params("id")); err != nil {
+		if errors.Is(err, ns.ErrChannelNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "通知渠道不存在")
+		}
+		logger.Warnf("cannot send test notification: %v", err)
+		return fiber.NewError(fiber.StatusBadGateway, fmt.Sprintf("發送測試通知失敗: %v", err))
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func channelError(err error) error {
+	switch {
+	case errors.Is(err, ns.ErrInvalidChannel):
+		return fiber.NewError(fiber.StatusBadRequest, "無效的通知渠道設定: "+err.Error())
+	case errors.Is(err, ns.ErrChannelNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "通知渠道不存在")
+	default:
+		logger.Errorf("error saving notification channel: %v", err)
+		return fiber.ErrInternalServerError
+	}
+}
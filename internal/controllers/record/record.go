@@ -1,10 +1,17 @@
 package record
 
 import (
+	"bufio"
+	"errors"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/eric2788/bilirec/internal/modules/bilibili"
 	"github.com/eric2788/bilirec/internal/modules/rest"
+	"github.com/eric2788/bilirec/internal/services/dvr"
+	"github.com/eric2788/bilirec/internal/services/path"
 	"github.com/eric2788/bilirec/internal/services/recorder"
 	"github.com/gofiber/fiber/v3"
 	"github.com/sirupsen/logrus"
@@ -12,16 +19,27 @@ import (
 
 var logger = logrus.WithField("controller", "record")
 
+const maxHistoryPageSize = 100
+
 type Controller struct {
 	service *recorder.Service
+	pathSvc *path.Service
+	dvrSvc  *dvr.Service
 }
 
-func NewController(app *fiber.App, service *recorder.Service) *Controller {
-	rc := &Controller{service: service}
+func NewController(app *fiber.App, service *recorder.Service, pathSvc *path.Service, dvrSvc *dvr.Service) *Controller {
+	rc := &Controller{service: service, pathSvc: pathSvc, dvrSvc: dvrSvc}
 	record := app.Group("/record")
 	record.Get("/list", rc.listRecordings)
+	record.Get("/history", rc.listHistory)
+	record.Get("/shutdown-report", rc.getShutdownReport)
+	record.Get("/summary/*", rc.getEventsFileSummary)
+	record.Get("/:roomID/summary", rc.getSessionSummary)
 	record.Get("/:roomID/status", rc.getRecordingStatus)
 	record.Get("/:roomID/stats", rc.getRecordingStats)
+	record.Get("/:roomID/live.flv", rc.previewRecording)
+	record.Get("/:roomID/dvr/index.m3u8", rc.getDvrPlaylist)
+	record.Get("/:roomID/dvr/:sessionID/:file/:segment", rc.getDvrSegment)
 	record.Post("/:roomID/start", rest.AdminOnly, rc.startRecording)
 	record.Post("/:roomID/stop", rest.AdminOnly, rc.stopRecording)
 	return rc
@@ -48,30 +66,35 @@ func (r *Controller) startRecording(ctx fiber.Ctx) error {
 	err = r.service.Start(roomId)
 	if err != nil {
 		logger.Errorf("error starting recording for room %d: %v", roomId, err)
-		switch err {
-		case bilibili.ErrRoomNotFound:
-			return fiber.NewError(fiber.StatusNotFound, "房間不存在")
-		case recorder.ErrRoomBanned:
-			return fiber.NewError(fiber.StatusBadRequest, "房間已被封禁")
-		case recorder.ErrRoomEncrypted:
-			return fiber.NewError(fiber.StatusBadRequest, "房間已被上鎖")
-		case recorder.ErrEmptyStreamURLs:
-			return fiber.NewError(fiber.StatusBadRequest, "無可用的視頻流 URL")
-		case recorder.ErrStreamNotLive:
-			return fiber.NewError(fiber.StatusBadRequest, "房間並非直播狀態")
-		case recorder.ErrRecordingStarted:
-			return fiber.NewError(fiber.StatusBadRequest, "此房間已經正在錄製中")
-		case recorder.ErrMaxConcurrentRecordingsReached:
-			return fiber.NewError(fiber.StatusTooManyRequests, "已達到最大同時錄製數")
-		case recorder.ErrInsufficientDiskSpace:
-			return fiber.NewError(fiber.StatusInsufficientStorage, "磁碟空間低於設定值")
-		default:
-			return fiber.ErrInternalServerError
-		}
+		return StartError(err)
 	}
 	return ctx.SendStatus(fiber.StatusOK)
 }
 
+// StartError maps the error of starting a recording to the response error
+func StartError(err error) *fiber.Error {
+	switch err {
+	case bilibili.ErrRoomNotFound:
+		return fiber.NewError(fiber.StatusNotFound, "房間不存在")
+	case recorder.ErrRoomBanned:
+		return fiber.NewError(fiber.StatusBadRequest, "房間已被封禁")
+	case recorder.ErrRoomEncrypted:
+		return fiber.NewError(fiber.StatusBadRequest, "房間已被上鎖")
+	case recorder.ErrEmptyStreamURLs:
+		return fiber.NewError(fiber.StatusBadRequest, "無可用的視頻流 URL")
+	case recorder.ErrStreamNotLive:
+		return fiber.NewError(fiber.StatusBadRequest, "房間並非直播狀態")
+	case recorder.ErrRecordingStarted:
+		return fiber.NewError(fiber.StatusBadRequest, "此房間已經正在錄製中")
+	case recorder.ErrMaxConcurrentRecordingsReached:
+		return fiber.NewError(fiber.StatusTooManyRequests, "已達到最大同時錄製數")
+	case recorder.ErrInsufficientDiskSpace:
+		return fiber.NewError(fiber.StatusInsufficientStorage, "磁碟空間低於設定值")
+	default:
+		return fiber.ErrInternalServerError
+	}
+}
+
 // @Summary Stop recording a live stream
 // @Description Stop recording a Bilibili live stream for the specified room
 // @Tags record
@@ -143,6 +166,138 @@ func (r *Controller) getRecordingStats(ctx fiber.Ctx) error {
 	return ctx.JSON(stats)
 }
 
+// @Summary Live preview of a recording
+// @Description Stream the recording FLV stream over HTTP-FLV, starting from the latest keyframe. Only available for FLV recordings, slow clients are disconnected.
+// @Tags record
+// @Security BearerAuth
+// @Produce video/x-flv
+// @Param roomID path int true "Room ID"
+// @Success 200 {file} binary "HTTP-FLV stream"
+// @Failure 400 {string} string "Invalid room ID or not a FLV recording"
+// @Failure 404 {string} string "Recording not found"
+// @Router /record/{roomID}/live.flv [get]
+func (r *Controller) previewRecording(ctx fiber.Ctx) error {
+	roomId, err := strconv.Atoi(ctx.Params("roomID"))
+	if err != nil {
+		logger.Warnf("cannot parse roomId to int: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的房間 ID")
+	}
+	ch, detach, err := r.service.AttachPreview(roomId)
+	if errors.Is(err, recorder.ErrNotRecording) {
+		return fiber.NewError(fiber.StatusNotFound, "此房間並未在錄製中")
+	} else if errors.Is(err, recorder.ErrPreviewUnsupported) {
+		return fiber.NewError(fiber.StatusBadRequest, "只有 FLV 格式的錄製支援即時預覽")
+	} else if err != nil {
+		logger.Errorf("cannot attach live preview for room %d: %v", roomId, err)
+		return fiber.ErrInternalServerError
+	}
+
+	ctx.Set(fiber.HeaderContentType, "video/x-flv")
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	ctx.Set("X-Accel-Buffering", "no")
+
+	ctx.RequestCtx().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer detach()
+		for data := range ch {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			// flush once the buffered tags are written
+			if len(ch) > 0 {
+				continue
+			}
+			if err := w.Flush(); err != nil {
+				logger.Debugf("live preview client of room %d disconnected: %v", roomId, err)
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// @Summary Time-shift playlist of a recording
+// @Description Get the EVENT HLS playlist of an ongoing FLV recording, which grows as the recording continues, so the live can be watched from the beginning
+// @Tags record
+// @Security BearerAuth
+// @Produce application/vnd.apple.mpegurl
+// @Param roomID path int true "Room ID"
+// @Success 200 {string} string "HLS playlist"
+// @Failure 400 {string} string "Invalid room ID or not a FLV recording"
+// @Failure 404 {string} string "Recording not found"
+// @Router /record/{roomID}/dvr/index.m3u8 [get]
+func (r *Controller) getDvrPlaylist(ctx fiber.Ctx) error {
+	roomId, err := strconv.Atoi(ctx.Params("roomID"))
+	if err != nil {
+		logger.Warnf("cannot parse roomId to int: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的房間 ID")
+	}
+	pl, err := r.dvrSvc.Playlist(roomId)
+	if err != nil {
+		return dvrError(roomId, err)
+	}
+	ctx.Set(fiber.HeaderContentType, "application/vnd.apple.mpegurl")
+	// the playlist grows while recording
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	_, err = pl.WriteTo(ctx)
+	return err
+}
+
+// @Summary Time-shift segment of a recording
+// @Description Get a MPEG-TS segment of the time-shift playlist, repackaged from the FLV file on the fly
+// @Tags record
+// @Security BearerAuth
+// @Produce video/mp2t
+// @Param roomID path int true "Room ID"
+// @Param sessionID path string true "Recording session ID"
+// @Param file path int true "File number in the session"
+// @Param segment path string true "Segment sequence in the file, such as 0.ts"
+// @Success 200 {file} binary "MPEG-TS segment"
+// @Failure 400 {string} string "Invalid room ID or not a FLV recording"
+// @Failure 404 {string} string "Recording or segment not found"
+// @Router /record/{roomID}/dvr/{sessionID}/{file}/{segment} [get]
+func (r *Controller) getDvrSegment(ctx fiber.Ctx) error {
+	roomId, err := strconv.Atoi(ctx.Params("roomID"))
+	if err != nil {
+		logger.Warnf("cannot parse roomId to int: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的房間 ID")
+	}
+	file, err := strconv.Atoi(ctx.Params("file"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "片段不存在")
+	}
+	seq, err := strconv.Atoi(strings.TrimSuffix(ctx.Params("segment"), ".ts"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "片段不存在")
+	}
+	src, err := r.dvrSvc.Segment(roomId, ctx.Params("sessionID"), file, seq)
+	if err != nil {
+		return dvrError(roomId, err)
+	}
+	ctx.Set(fiber.HeaderContentType, "video/mp2t")
+	ctx.RequestCtx().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if _, err := src.WriteTo(w); err != nil {
+			logger.Warnf("cannot write dvr segment %d/%d of room %d: %v", file, seq, roomId, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+func dvrError(roomId int, err error) error {
+	switch {
+	case errors.Is(err, recorder.ErrNotRecording):
+		return fiber.NewError(fiber.StatusNotFound, "此房間並未在錄製中")
+	case errors.Is(err, dvr.ErrNoFlvFiles):
+		return fiber.NewError(fiber.StatusBadRequest, "只有 FLV 格式的錄製支援時移回放")
+	case errors.Is(err, dvr.ErrSegmentNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "片段不存在")
+	default:
+		logger.Errorf("cannot time-shift recording of room %d: %v", roomId, err)
+		return fiber.ErrInternalServerError
+	}
+}
+
 // @Summary List all recordings
 // @Description Get a list of all room IDs that are currently being recorded
 // @Tags record
@@ -155,3 +310,121 @@ func (r *Controller) listRecordings(ctx fiber.Ctx) error {
 	roomIds := r.service.ListRecording()
 	return ctx.JSON(roomIds)
 }
+
+// @Summary List recording history
+// @Description Get the finished recording sessions from newest to oldest, including why each of them stopped
+// @Tags record
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param page query int false "Page number, starts from 1" default(1)
+// @Param size query int false "Page size, at most 100" default(20)
+// @Param room query int false "Filter by room ID"
+// @Success 200 {object} recorder.HistoryPage "Recording history"
+// @Failure 400 {string} string "Invalid query"
+// @Failure 500 {string} string "Internal server error"
+// @Router /record/history [get]
+func (r *Controller) listHistory(ctx fiber.Ctx) error {
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的頁數")
+	}
+	size, err := strconv.Atoi(ctx.Query("size", "20"))
+	if err != nil || size < 1 || size > maxHistoryPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的每頁數量")
+	}
+	roomId, err := strconv.Atoi(ctx.Query("room", "0"))
+	if err != nil || roomId < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的房間 ID")
+	}
+	history, err := r.service.ListHistory(roomId, page, size)
+	if err != nil {
+		logger.Errorf("error listing record history: %v", err)
+		return fiber.ErrInternalServerError
+	}
+	return ctx.JSON(history)
+}
+
+// @Summary Get last shutdown report
+// @Description Get the recordings interrupted by the last shutdown, they are resumed after restart if the rooms are still live
+// @Tags record
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {object} recorder.ShutdownReport "Shutdown report"
+// @Failure 404 {string} string "No shutdown report"
+// @Failure 500 {string} string "Internal server error"
+// @Router /record/shutdown-report [get]
+func (r *Controller) getShutdownReport(ctx fiber.Ctx) error {
+	report, err := r.service.GetShutdownReport()
+	if err != nil {
+		logger.Errorf("error getting shutdown report: %v", err)
+		return fiber.ErrInternalServerError
+	} else if report == nil {
+		return fiber.NewError(fiber.StatusNotFound, "沒有關閉程序的記錄")
+	}
+	return ctx.JSON(report)
+}
+
+// @Summary Get live events summary of the ongoing session
+// @Description Get the gifts, super chats and guard purchases summary of the ongoing recording session of a room
+// @Tags record
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param roomID path int true "Room ID"
+// @Success 200 {object} recorder.SessionSummary "Session summary"
+// @Failure 400 {string} string "Invalid room ID"
+// @Failure 404 {string} string "Recording not found"
+// @Router /record/{roomID}/summary [get]
+func (r *Controller) getSessionSummary(ctx fiber.Ctx) error {
+	roomId, err := strconv.Atoi(ctx.Params("roomID"))
+	if err != nil {
+		logger.Warnf("cannot parse roomId to int: %v", err)
+		return fiber.NewError(fiber.StatusBadRequest, "無效的房間 ID")
+	}
+	summary, ok := r.service.GetSessionSummary(roomId)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "此房間沒有正在記錄直播事件的錄製")
+	}
+	return ctx.JSON(summary)
+}
+
+// @Summary Get live events summary of a recorded session
+// @Description Get the gifts, super chats and guard purchases summary from a session events file (.events.jsonl)
+// @Tags record
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param path path string true "Events file path"
+// @Success 200 {object} recorder.SessionSummary "Session summary"
+// @Failure 400 {string} string "Invalid path"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /record/summary/{path} [get]
+func (r *Controller) getEventsFileSummary(ctx fiber.Ctx) error {
+	raw := ctx.Params("*")
+	p, err := url.PathUnescape(raw)
+	if err != nil {
+		return fiber.ErrBadRequest
+	} else if !strings.HasSuffix(p, ".events.jsonl") {
+		return fiber.NewError(fiber.StatusBadRequest, "此檔案並非直播事件檔案")
+	}
+
+	fullPath, err := r.pathSvc.ValidatePath(p)
+	if err != nil {
+		if err == path.ErrAccessDenied {
+			return fiber.NewError(fiber.StatusForbidden, "無法存取該文件路徑")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "無效文件路徑")
+	}
+
+	summary, err := recorder.SummarizeEventsFile(fullPath)
+	if os.IsNotExist(err) {
+		return fiber.NewError(fiber.StatusNotFound, "找不到所屬文件夾或檔案")
+	} else if err != nil {
+		logger.Errorf("error summarizing events file %s: %v", fullPath, err)
+		return fiber.ErrInternalServerError
+	}
+	return ctx.JSON(summary)
+}
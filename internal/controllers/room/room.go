@@ -1,10 +1,12 @@
 package room
 
 import (
+	"slices"
 	"strconv"
 
 	"github.com/eric2788/bilirec/internal/modules/bilibili"
 	"github.com/eric2788/bilirec/internal/modules/rest"
+	"github.com/eric2788/bilirec/internal/services/recorder"
 	"github.com/eric2788/bilirec/internal/services/room"
 	"github.com/eric2788/bilirec/internal/services/subscribe"
 	"github.com/eric2788/bilirec/utils"
@@ -285,6 +287,11 @@ func (r *Controller) getRoomConfig(ctx fiber.Ctx) error {
 		RoomId:     roomId,
 		AutoRecord: cfg.AutoRecord,
 		Notify:     cfg.Notify,
+		Quality:    cfg.Quality,
+		Codec:      cfg.Codec,
+		Format:     cfg.Format,
+
+		NamingTemplate: cfg.NamingTemplate,
 	})
 }
 
@@ -315,7 +322,28 @@ func (r *Controller) updateRoomConfig(ctx fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "無效的請求資料")
 	}
 
-	if err := r.subSvc.UpdateConfig(roomId, &subscribe.RoomConfig{AutoRecord: req.AutoRecord, Notify: req.Notify}); err != nil {
+	if req.Quality < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的畫質")
+	} else if !slices.Contains([]string{"", bilibili.CodecAVC, bilibili.CodecHEVC}, req.Codec) {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的編碼格式")
+	} else if !slices.Contains([]string{"", bilibili.FormatFLV, bilibili.FormatTS, bilibili.FormatFMP4}, req.Format) {
+		return fiber.NewError(fiber.StatusBadRequest, "無效的串流格式")
+	} else if req.NamingTemplate != "" {
+		if _, err := recorder.ParseNamingTemplate(req.NamingTemplate); err != nil {
+			logger.Warnf("invalid naming template %q: %v", req.NamingTemplate, err)
+			return fiber.NewError(fiber.StatusBadRequest, "無效的命名模板")
+		}
+	}
+
+	if err := r.subSvc.UpdateConfig(roomId, &subscribe.RoomConfig{
+		AutoRecord: req.AutoRecord,
+		Notify:     req.Notify,
+		Quality:    req.Quality,
+		Codec:      req.Codec,
+		Format:     req.Format,
+
+		NamingTemplate: req.NamingTemplate,
+	}); err != nil {
 		logger.Errorf("error updating room config for room %d: %v", roomId, err)
 		if err == subscribe.ErrRoomNotSubscribed {
 			return fiber.NewError(fiber.StatusNotFound, "未訂閱此房間")
@@ -327,5 +355,10 @@ func (r *Controller) updateRoomConfig(ctx fiber.Ctx) error {
 		RoomId:     roomId,
 		AutoRecord: req.AutoRecord,
 		Notify:     req.Notify,
+		Quality:    req.Quality,
+		Codec:      req.Codec,
+		Format:     req.Format,
+
+		NamingTemplate: req.NamingTemplate,
 	})
 }
@@ -15,12 +15,22 @@ type SubscribeList struct {
 }
 
 type RoomConfigResponse struct {
-	RoomId     int  `json:"room_id"`
-	AutoRecord bool `json:"auto_record"`
-	Notify     bool `json:"notify"`
+	RoomId     int    `json:"room_id"`
+	AutoRecord bool   `json:"auto_record"`
+	Notify     bool   `json:"notify"`
+	Quality    int    `json:"quality"`
+	Codec      string `json:"codec"`
+	Format     string `json:"format"`
+	// empty for the global naming template
+	NamingTemplate string `json:"naming_template"`
 }
 
 type UpdateRoomConfigRequest struct {
-	AutoRecord bool `json:"auto_record"`
-	Notify     bool `json:"notify"`
+	AutoRecord bool   `json:"auto_record"`
+	Notify     bool   `json:"notify"`
+	Quality    int    `json:"quality"`                     // qn, 0 for the highest available
+	Codec      string `json:"codec" enums:",avc,hevc"`     // empty for no preference
+	Format     string `json:"format" enums:",flv,ts,fmp4"` // empty for no preference
+	// e.g. {uname}-{room_id}/{title:20}-{date}_{time}, empty for the global naming template
+	NamingTemplate string `json:"naming_template"`
 }
@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"github.com/eric2788/bilirec/internal/services/notify"
+	"github.com/eric2788/bilirec/internal/services/recorder"
+)
+
+const (
+	ActionSubscribe = "subscribe"
+	ActionStart     = "start"
+	ActionStop      = "stop"
+)
+
+const (
+	MessageHello  = "hello"
+	MessageEvent  = "event"
+	MessageStats  = "stats"
+	MessageResult = "result"
+	MessageError  = "error"
+)
+
+// ClientMessage is sent by the client to change the subscription or control the recordings
+type ClientMessage struct {
+	Action string `json:"action" enums:"subscribe,start,stop"`
+	// subscribe: the rooms and event types to receive, empty for all
+	Rooms  []int              `json:"rooms,omitempty"`
+	Events []notify.EventType `json:"events,omitempty"`
+	// subscribe: whether to receive the stats snapshots of active recordings
+	Stats bool `json:"stats,omitempty"`
+	// start and stop, only allowed for admin
+	RoomID int `json:"room_id,omitempty"`
+}
+
+type ServerMessage struct {
+	Type string `json:"type" enums:"hello,event,stats,result,error"`
+	// the action of the result or error
+	Action string `json:"action,omitempty"`
+	RoomID int    `json:"room_id,omitempty"`
+	// hello: the role of the connection, viewers cannot start or stop recordings
+	Role  string            `json:"role,omitempty"`
+	Event *notify.Event     `json:"event,omitempty"`
+	Stats []*RecordingStats `json:"stats,omitempty"`
+	// result: whether the action succeeded, stop fails if the room is not recording
+	Success   *bool  `json:"success,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type RecordingStats struct {
+	RoomID int `json:"room_id"`
+	*recorder.Stats
+	// average bitrate since the last snapshot
+	BitrateKbps float64 `json:"bitrate_kbps"`
+}
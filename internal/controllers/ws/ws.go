@@ -0,0 +1,284 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/controllers/record"
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/internal/modules/rest"
+	"github.com/eric2788/bilirec/internal/services/notify"
+	"github.com/eric2788/bilirec/internal/services/recorder"
+	"github.com/eric2788/bilirec/pkg/ds"
+	"github.com/eric2788/bilirec/utils"
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+var logger = logrus.WithField("controller", "ws")
+
+const (
+	statsInterval = 3 * time.Second
+	pingInterval  = 25 * time.Second
+	// the connection is closed if no pong is received in time
+	pongTimeout  = 60 * time.Second
+	writeTimeout = 10 * time.Second
+	maxReadSize  = 4096
+)
+
+type Controller struct {
+	notifySvc *notify.Service
+	recSvc    *recorder.Service
+	upgrader  *websocket.FastHTTPUpgrader
+}
+
+func NewController(app *fiber.App, cfg *config.Config, notifySvc *notify.Service, recSvc *recorder.Service) *Controller {
+	allowedOrigins := rest.AllowedOrigins(cfg)
+	c := &Controller{
+		notifySvc: notifySvc,
+		recSvc:    recSvc,
+		upgrader: &websocket.FastHTTPUpgrader{
+			// the cookie is sent by any site, only the frontend and same origin are allowed
+			CheckOrigin: func(ctx *fasthttp.RequestCtx) bool {
+				origin := string(ctx.Request.Header.Peek(fiber.HeaderOrigin))
+				if origin == "" || slices.Contains(allowedOrigins, origin) {
+					return true
+				}
+				u, err := url.Parse(origin)
+				return err == nil && u.Host == string(ctx.Host())
+			},
+		},
+	}
+	app.Get("/ws", c.connect)
+	return c
+}
+
+// @Summary Subscribe events and recorder stats by WebSocket
+// @Description Upgrade to a WebSocket connection which pushes the notification events and the stats snapshots of active recordings every 3 seconds.
+// @Description Send {"action":"subscribe","rooms":[...],"events":[...],"stats":true} to replace the subscription, empty rooms and events receive all.
+// @Description Admins can also send {"action":"start"|"stop","room_id":...} to control the recordings.
+// @Tags notify
+// @Security BearerAuth
+// @Success 101 {object} ServerMessage "Switching protocols, messages are pushed as JSON"
+// @Failure 426 {string} string "Upgrade required"
+// @Router /ws [get]
+func (c *Controller) connect(ctx fiber.Ctx) error {
+	if !websocket.FastHTTPIsWebSocketUpgrade(ctx.RequestCtx()) {
+		return fiber.ErrUpgradeRequired
+	}
+	// the fiber context is released after upgraded
+	admin := rest.IsAdmin(ctx)
+	return c.upgrader.Upgrade(ctx.RequestCtx(), func(conn *websocket.Conn) {
+		c.serve(newClient(conn, admin))
+	})
+}
+
+func (c *Controller) serve(cl *client) {
+	defer cl.conn.Close()
+
+	_, events, unsubscribe := c.notifySvc.Subscribe(64)
+	defer unsubscribe()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	requests := make(chan ClientMessage)
+	replies := make(chan *ServerMessage, 8)
+	done := make(chan struct{})
+	go cl.read(requests, done, stop)
+
+	if !cl.write(&ServerMessage{Type: MessageHello, Role: string(cl.role())}) {
+		return
+	}
+	if !cl.write(c.snapshot(cl)) {
+		return
+	}
+
+	statsTicker := time.NewTicker(statsInterval)
+	defer statsTicker.Stop()
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		var msg *ServerMessage
+		select {
+		case <-done:
+			return
+		case req := <-requests:
+			msg = c.handle(cl, req, replies, stop)
+		case msg = <-replies:
+		case evt, ok := <-events:
+			if !ok {
+				return
+			} else if cl.matches(&evt) {
+				msg = &ServerMessage{Type: MessageEvent, RoomID: evt.RoomID, Event: &evt}
+			}
+		case <-statsTicker.C:
+			if cl.stats {
+				msg = c.snapshot(cl)
+			}
+		case <-pingTicker.C:
+			if err := cl.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		}
+		if msg != nil && !cl.write(msg) {
+			return
+		}
+	}
+}
+
+// handle applies the subscription immediately, starting and stopping reply later since they may take a while
+func (c *Controller) handle(cl *client, req ClientMessage, replies chan<- *ServerMessage, stop <-chan struct{}) *ServerMessage {
+	switch req.Action {
+	case ActionSubscribe:
+		cl.subscribe(req)
+		return &ServerMessage{Type: MessageResult, Action: req.Action, Success: utils.Ptr(true)}
+	case ActionStart, ActionStop:
+		if !cl.admin {
+			return &ServerMessage{Type: MessageError, Action: req.Action, RoomID: req.RoomID, Error: "沒有權限"}
+		} else if req.RoomID <= 0 {
+			return &ServerMessage{Type: MessageError, Action: req.Action, Error: "無效的房間 ID"}
+		}
+		go func() {
+			reply := c.control(req)
+			select {
+			case replies <- reply:
+			case <-stop:
+			}
+		}()
+		return nil
+	default:
+		return &ServerMessage{Type: MessageError, Action: req.Action, Error: "無效的請求資料"}
+	}
+}
+
+func (c *Controller) control(req ClientMessage) *ServerMessage {
+	if req.Action == ActionStop {
+		return &ServerMessage{Type: MessageResult, Action: req.Action, RoomID: req.RoomID, Success: utils.Ptr(c.recSvc.Stop(req.RoomID))}
+	}
+	if err := c.recSvc.Start(req.RoomID); err != nil {
+		logger.Errorf("error starting recording for room %d: %v", req.RoomID, err)
+		return &ServerMessage{Type: MessageError, Action: req.Action, RoomID: req.RoomID, Error: record.StartError(err).Message}
+	}
+	return &ServerMessage{Type: MessageResult, Action: req.Action, RoomID: req.RoomID, Success: utils.Ptr(true)}
+}
+
+func (c *Controller) snapshot(cl *client) *ServerMessage {
+	now := time.Now()
+	elapsed := now.Sub(cl.lastSample).Seconds()
+	bytes := make(map[int]uint64)
+	stats := make([]*RecordingStats, 0)
+	for _, roomID := range c.recSvc.ListRecording() {
+		if cl.rooms != nil && !cl.rooms.Contains(roomID) {
+			continue
+		}
+		s, ok := c.recSvc.GetStats(roomID)
+		if !ok {
+			continue
+		}
+		entry := &RecordingStats{RoomID: roomID, Stats: s}
+		// the bytes restart from zero after recovered
+		if last, ok := cl.lastBytes[roomID]; ok && s.BytesWritten >= last && elapsed > 0 {
+			entry.BitrateKbps = float64(s.BytesWritten-last) * 8 / elapsed / 1000
+		} else if s.ElapsedSeconds > 0 {
+			entry.BitrateKbps = float64(s.BytesWritten) * 8 / float64(s.ElapsedSeconds) / 1000
+		}
+		bytes[roomID] = s.BytesWritten
+		stats = append(stats, entry)
+	}
+	cl.lastBytes = bytes
+	cl.lastSample = now
+	return &ServerMessage{Type: MessageStats, Stats: stats}
+}
+
+// client is the state of a connection, only accessed by its serving goroutine except the reading
+type client struct {
+	conn   *websocket.Conn
+	admin  bool
+	rooms  ds.Set[int]
+	events ds.Set[notify.EventType]
+	stats  bool
+
+	lastBytes  map[int]uint64
+	lastSample time.Time
+}
+
+func newClient(conn *websocket.Conn, admin bool) *client {
+	return &client{
+		conn:       conn,
+		admin:      admin,
+		stats:      true,
+		lastBytes:  make(map[int]uint64),
+		lastSample: time.Now(),
+	}
+}
+
+func (cl *client) role() rest.Role {
+	if cl.admin {
+		return rest.RoleAdmin
+	}
+	return rest.RoleViewer
+}
+
+func (cl *client) subscribe(req ClientMessage) {
+	cl.rooms, cl.events = nil, nil
+	if len(req.Rooms) > 0 {
+		cl.rooms = ds.NewSet[int]()
+		for _, room := range req.Rooms {
+			cl.rooms.Add(room)
+		}
+	}
+	if len(req.Events) > 0 {
+		cl.events = ds.NewSet[notify.EventType]()
+		for _, event := range req.Events {
+			cl.events.Add(event)
+		}
+	}
+	cl.stats = req.Stats
+}
+
+// matches events without room such as disk_low are always sent
+func (cl *client) matches(evt *notify.Event) bool {
+	return (cl.events == nil || cl.events.Contains(evt.Type)) &&
+		(cl.rooms == nil || evt.RoomID == 0 || cl.rooms.Contains(evt.RoomID))
+}
+
+func (cl *client) write(msg *ServerMessage) bool {
+	msg.Timestamp = time.Now().Unix()
+	cl.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := cl.conn.WriteJSON(msg); err != nil {
+		logger.Debugf("cannot write websocket message: %v", err)
+		return false
+	}
+	return true
+}
+
+// read receives the client messages until the connection is closed
+func (cl *client) read(requests chan<- ClientMessage, done chan<- struct{}, stop <-chan struct{}) {
+	defer close(done)
+	cl.conn.SetReadLimit(maxReadSize)
+	cl.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	cl.conn.SetPongHandler(func(string) error {
+		return cl.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		_, data, err := cl.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		cl.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		var req ClientMessage
+		if err := json.Unmarshal(data, &req); err != nil {
+			req = ClientMessage{}
+		}
+		select {
+		case requests <- req:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -27,6 +27,7 @@ func (c *Client) loadCookiesOrLogin(ctx context.Context, cfg *config.Config) err
 
 		if acc, err := c.GetAccountInformation(); err == nil {
 			logger.Infof("loaded cookies for user: %s (mid: %d)", acc.Uname, acc.Mid)
+			c.mid = acc.Mid
 			return c.refreshCookiesIfRequired()
 		} else {
 			logger.Warnf("failed to get account information with loaded cookies: %v", err)
@@ -61,6 +62,7 @@ func (c *Client) loadCookiesOrLogin(ctx context.Context, cfg *config.Config) err
 		return fmt.Errorf("error getting account information after login: %v, please try again.", err)
 	} else {
 		logger.Infof("login successful. logged in as %s (mid: %d)", acc.Uname, acc.Mid)
+		c.mid = acc.Mid
 	}
 
 	if err := c.writeRefreshTokenToFile(result.RefreshToken); err != nil {
@@ -141,6 +143,11 @@ func (c *Client) loadOfflineCredentials() (cookie string, refreshToken string, e
 	return
 }
 
+// SetCookieRefreshFailedHandler sets the handler called when the periodic cookie refresh fails
+func (c *Client) SetCookieRefreshFailedHandler(handler func(error)) {
+	c.onRefreshFailed.Store(&handler)
+}
+
 func (c *Client) refreshCookiesPeriodically(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -149,6 +156,9 @@ func (c *Client) refreshCookiesPeriodically(ctx context.Context, interval time.D
 		case <-ticker.C:
 			if err := c.refreshCookiesIfRequired(); err != nil {
 				logger.Error(err)
+				if handler := c.onRefreshFailed.Load(); handler != nil {
+					(*handler)(err)
+				}
 			}
 		case <-ctx.Done():
 			return
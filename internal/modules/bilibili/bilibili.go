@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 
 	"go.uber.org/fx"
 
@@ -24,12 +25,15 @@ var (
 type Client struct {
 	*bili.Client
 	refreshToken     string
+	mid              int
 	wbi              *bili.WBI
 	liveClient       *resty.Client
 	liveStreamClient *resty.Client
 
 	cookiePath       string
 	refreshTokenPath string
+
+	onRefreshFailed atomic.Pointer[func(error)]
 }
 
 func provider(cfg *config.Config, ls fx.Lifecycle) *Client {
@@ -133,6 +133,25 @@ func TestGetStreamUrlsV2(t *testing.T) {
 	}
 }
 
+func TestGetDanmakuAuth(t *testing.T) {
+	var client *bilibili.Client
+	app := fxtest.New(t,
+		config.Module,
+		bilibili.Module,
+		fx.Populate(&client),
+		fx.StartTimeout(25*time.Second),
+	)
+	app.RequireStart()
+	defer app.RequireStop()
+	auth, err := client.GetDanmakuAuth(8222458)
+	if err != nil {
+		t.Fatal(err)
+	} else if auth.Token == "" {
+		t.Fatal("expected non-empty danmaku token")
+	}
+	t.Logf("Danmaku Hosts: %v", auth.Hosts)
+}
+
 func TestHeaders(t *testing.T) {
 	var client *bilibili.Client
 	app := fxtest.New(t,
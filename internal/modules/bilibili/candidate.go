@@ -0,0 +1,120 @@
+package bilibili
+
+import (
+	"slices"
+)
+
+const (
+	FormatFLV  = "flv"
+	FormatTS   = "ts"
+	FormatFMP4 = "fmp4"
+
+	CodecAVC  = "avc"
+	CodecHEVC = "hevc"
+
+	// QualityOriginal is the highest quality requested when no preference is set
+	QualityOriginal = 10000
+)
+
+// default ranking when the preference is not set:
+// flv and avc go first for the best compatibility
+var (
+	defaultFormatOrder = []string{FormatFLV, FormatTS, FormatFMP4}
+	defaultCodecOrder  = []string{CodecAVC, CodecHEVC}
+)
+
+// StreamPreference is the preferred stream of a room, empty fields mean no preference
+type StreamPreference struct {
+	Quality int
+	Codec   string
+	Format  string
+}
+
+type StreamCandidate struct {
+	URL      string
+	Protocol string
+	Format   string
+	Codec    string
+	Qn       int
+}
+
+// GetStreamCandidates returns every offered stream ranked by the preference,
+// ordered by format first, then codec, then the quality closest to the preferred one.
+// Unmatched candidates are kept at the end as fallback.
+func (c *Client) GetStreamCandidates(roomID int, pref StreamPreference) ([]*StreamCandidate, error) {
+	qn := pref.Quality
+	if qn <= 0 {
+		qn = QualityOriginal
+	}
+
+	playurl, err := c.getPlayurl(roomID, qn)
+	if err != nil {
+		return nil, err
+	} else if playurl == nil {
+		return []*StreamCandidate{}, nil
+	}
+
+	candidates := make([]*StreamCandidate, 0)
+	for _, stream := range playurl.Streams {
+		for _, format := range stream.Formats {
+			for _, codec := range format.Codecs {
+				for _, urlInfo := range codec.UrlInfos {
+					candidates = append(candidates, &StreamCandidate{
+						URL:      urlInfo.Host + codec.BaseUrl + urlInfo.Extra,
+						Protocol: stream.ProtocolName,
+						Format:   format.FormatName,
+						Codec:    codec.CodecName,
+						Qn:       codec.CurrentQn,
+					})
+				}
+			}
+		}
+	}
+
+	RankStreamCandidates(candidates, pref)
+	return candidates, nil
+}
+
+// RankStreamCandidates sorts the candidates in place by the preference, keeping the original
+// order of equally ranked candidates such as different hosts of the same stream.
+func RankStreamCandidates(candidates []*StreamCandidate, pref StreamPreference) {
+	formatRank := orderRank(pref.Format, defaultFormatOrder)
+	codecRank := orderRank(pref.Codec, defaultCodecOrder)
+	slices.SortStableFunc(candidates, func(a, b *StreamCandidate) int {
+		if d := formatRank(a.Format) - formatRank(b.Format); d != 0 {
+			return d
+		}
+		if d := codecRank(a.Codec) - codecRank(b.Codec); d != 0 {
+			return d
+		}
+		return qualityRank(a.Qn, pref.Quality) - qualityRank(b.Qn, pref.Quality)
+	})
+}
+
+// orderRank ranks the preferred value first, then follows the default order
+func orderRank(preferred string, defaults []string) func(string) int {
+	return func(value string) int {
+		if preferred != "" && value == preferred {
+			return 0
+		}
+		if i := slices.Index(defaults, value); i >= 0 {
+			return i + 1
+		}
+		return len(defaults) + 1
+	}
+}
+
+// qualityRank ranks the exact quality first, then the next best lower qualities,
+// then the higher ones. Without preference, higher quality always goes first.
+func qualityRank(qn, preferred int) int {
+	switch {
+	case preferred <= 0:
+		return -qn
+	case qn == preferred:
+		return -1 << 30
+	case qn < preferred:
+		return -qn
+	default:
+		return qn
+	}
+}
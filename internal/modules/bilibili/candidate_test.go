@@ -0,0 +1,59 @@
+package bilibili_test
+
+import (
+	"testing"
+
+	"github.com/eric2788/bilirec/internal/modules/bilibili"
+)
+
+func TestRankStreamCandidates(t *testing.T) {
+	newCandidates := func() []*bilibili.StreamCandidate {
+		return []*bilibili.StreamCandidate{
+			{URL: "ts-avc-10000", Format: "ts", Codec: "avc", Qn: 10000},
+			{URL: "flv-hevc-10000", Format: "flv", Codec: "hevc", Qn: 10000},
+			{URL: "flv-avc-250", Format: "flv", Codec: "avc", Qn: 250},
+			{URL: "flv-avc-400-a", Format: "flv", Codec: "avc", Qn: 400},
+			{URL: "flv-avc-400-b", Format: "flv", Codec: "avc", Qn: 400},
+			{URL: "flv-avc-10000", Format: "flv", Codec: "avc", Qn: 10000},
+			{URL: "fmp4-hevc-10000", Format: "fmp4", Codec: "hevc", Qn: 10000},
+		}
+	}
+
+	cases := []struct {
+		name string
+		pref bilibili.StreamPreference
+		want []string
+	}{
+		{
+			name: "no preference",
+			pref: bilibili.StreamPreference{},
+			want: []string{"flv-avc-10000", "flv-avc-400-a", "flv-avc-400-b", "flv-avc-250", "flv-hevc-10000", "ts-avc-10000", "fmp4-hevc-10000"},
+		},
+		{
+			name: "1080p avc falls back to next best lower quality",
+			pref: bilibili.StreamPreference{Quality: 401, Codec: "avc", Format: "flv"},
+			want: []string{"flv-avc-400-a", "flv-avc-400-b", "flv-avc-250", "flv-avc-10000", "flv-hevc-10000", "ts-avc-10000", "fmp4-hevc-10000"},
+		},
+		{
+			name: "hevc fmp4",
+			pref: bilibili.StreamPreference{Codec: "hevc", Format: "fmp4"},
+			want: []string{"fmp4-hevc-10000", "flv-hevc-10000", "flv-avc-10000", "flv-avc-400-a", "flv-avc-400-b", "flv-avc-250", "ts-avc-10000"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			candidates := newCandidates()
+			bilibili.RankStreamCandidates(candidates, c.pref)
+			for i, candidate := range candidates {
+				if candidate.URL != c.want[i] {
+					got := make([]string, len(candidates))
+					for j, cc := range candidates {
+						got[j] = cc.URL
+					}
+					t.Fatalf("unexpected order:\n got: %v\nwant: %v", got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/danmaku"
+	"github.com/eric2788/bilirec/pkg/fp"
+)
+
+type (
+	DanmuInfoResponse struct {
+		Code    int            `json:"code"`
+		Message string         `json:"message"`
+		TTL     int            `json:"ttl"`
+		Data    *DanmuInfoData `json:"data"`
+	}
+
+	DanmuInfoData struct {
+		Token    string          `json:"token"`
+		HostList []DanmuInfoHost `json:"host_list"`
+	}
+
+	DanmuInfoHost struct {
+		Host    string `json:"host"`
+		Port    int    `json:"port"`
+		WssPort int    `json:"wss_port"`
+		WsPort  int    `json:"ws_port"`
+	}
+)
+
+const danmuInfoAPI = "https://api.live.bilibili.com/xlive/web-room/v1/index/getDanmuInfo"
+
+// GetDanmakuAuth fetches the token and host list needed to join the danmaku
+// websocket of the given room, using the current login identity.
+func (c *Client) GetDanmakuAuth(roomID int) (*danmaku.AuthParams, error) {
+	client := c.liveClient.R()
+	client.SetCookies(c.GetCookies())
+	client.SetQueryParams(map[string]string{
+		"id":           fmt.Sprint(roomID),
+		"type":         "0",
+		"web_location": "444.8",
+	})
+	newQueryParam, err := c.wbi.SignQuery(client.QueryParam, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign wbi: %v", err)
+	}
+	client.QueryParam = newQueryParam
+
+	resp, err := client.Get(danmuInfoAPI)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode())
+	}
+
+	var dr DanmuInfoResponse
+	if err := json.Unmarshal(resp.Body(), &dr); err != nil {
+		return nil, err
+	} else if dr.Code != 0 {
+		return nil, fmt.Errorf("error getting danmu info: %s (code %d)", dr.Message, dr.Code)
+	} else if dr.Data == nil {
+		return nil, fmt.Errorf("error getting danmu info: empty data")
+	}
+
+	buvid := ""
+	for _, cookie := range c.GetCookies() {
+		if cookie.Name == "buvid3" {
+			buvid = cookie.Value
+			break
+		}
+	}
+
+	return &danmaku.AuthParams{
+		RoomID: roomID,
+		UID:    int64(c.mid),
+		Buvid:  buvid,
+		Token:  dr.Data.Token,
+		Hosts: fp.Map(dr.Data.HostList, func(h DanmuInfoHost) string {
+			return fmt.Sprintf("wss://%s:%d/sub", h.Host, h.WssPort)
+		}),
+	}, nil
+}
@@ -1,8 +1,10 @@
 package bilibili
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/eric2788/bilirec/pkg/fp"
@@ -121,10 +123,29 @@ func (c *Client) GetStreamURLs(roomID int) ([]string, error) {
 }
 
 func (c *Client) GetStreamURLsV2(roomID int) ([]string, error) {
+	playurl, err := c.getPlayurl(roomID, 10000)
+	if err != nil {
+		return nil, err
+	} else if playurl == nil {
+		return []string{}, nil
+	}
+
+	return fp.FlatMap(playurl.Streams, func(stream StreamItem) []string {
+		return fp.FlatMap(stream.Formats, func(format FormatItem) []string {
+			return fp.FlatMap(format.Codecs, func(codec CodecItem) []string {
+				return fp.Map(codec.UrlInfos, func(urlInfo UrlInfoItem) string {
+					return urlInfo.Host + codec.BaseUrl + urlInfo.Extra
+				})
+			})
+		})
+	}), nil
+}
+
+func (c *Client) getPlayurl(roomID int, qn int) (*Playurl, error) {
 	client := c.liveClient.R()
 	client.SetQueryParams(map[string]string{
 		"room_id":      fmt.Sprint(roomID),
-		"qn":           "10000",
+		"qn":           fmt.Sprint(qn),
 		"no_playurl":   "0",
 		"mask":         "1",
 		"platform":     "web",
@@ -159,19 +180,10 @@ func (c *Client) GetStreamURLsV2(roomID int) ([]string, error) {
 		return nil, fmt.Errorf("error getting stream url: %s (code %d)", sr.Message, sr.Code)
 	}
 
-	if sr.Data.PlayurlInfo == nil || sr.Data.PlayurlInfo.Playurl == nil {
-		return []string{}, nil
+	if sr.Data.PlayurlInfo == nil {
+		return nil, nil
 	}
-
-	return fp.FlatMap(sr.Data.PlayurlInfo.Playurl.Streams, func(stream StreamItem) []string {
-		return fp.FlatMap(stream.Formats, func(format FormatItem) []string {
-			return fp.FlatMap(format.Codecs, func(codec CodecItem) []string {
-				return fp.Map(codec.UrlInfos, func(urlInfo UrlInfoItem) string {
-					return urlInfo.Host + codec.BaseUrl + urlInfo.Extra
-				})
-			})
-		})
-	}), nil
+	return sr.Data.PlayurlInfo.Playurl, nil
 }
 
 func (c *Client) FetchLiveStreamUrl(url string) (*resty.Response, error) {
@@ -179,3 +191,17 @@ func (c *Client) FetchLiveStreamUrl(url string) (*resty.Response, error) {
 		return req.Get(url)
 	})
 }
+
+// FetchLiveStreamResource downloads a HLS playlist or segment with the live stream headers
+func (c *Client) FetchLiveStreamResource(ctx context.Context, url string) (io.ReadCloser, error) {
+	resp, err := c.DoLiveStream(func(req *resty.Request) (*resty.Response, error) {
+		return req.SetContext(ctx).Get(url)
+	})
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode() != 200 {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode())
+	}
+	return resp.RawBody(), nil
+}
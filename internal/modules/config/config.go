@@ -19,13 +19,25 @@ type Config struct {
 	MaxRecordingHours       int
 	MaxRecoveryAttempts     int
 	MaxRetryMinutes         int
+	StallTimeoutSeconds     int
 
-	OutputDir   string
-	SecretDir   string
-	DatabaseDir string
+	SegmentDurationMinutes int
+	SegmentSizeBytes       int64
+
+	OutputDir      string
+	NamingTemplate string
+	SecretDir      string
+	DatabaseDir    string
+
+	RecordDanmaku     bool
+	RecordLiveEvents  bool
+	InjectFlvMetadata bool
 
 	ConvertFLVToMp4       bool
 	DeleteFlvAfterConvert bool
+	// merge the segments of a session into one file once it is stopped
+	MergeSessionSegments  bool
+	DeleteMergedSegments  bool
 	CloudConvertThreshold int64
 	CloudConvertApiKey    string
 
@@ -41,6 +53,16 @@ type Config struct {
 	ProductionMode bool
 
 	MinDiskSpaceBytes int64
+	DiskLowActions    []string
+
+	RetentionIntervalMinutes  int
+	RetentionMaxAgeDays       int
+	RetentionMaxRoomBytes     int64
+	RetentionKeepSessions     int
+	RetentionDropConvertedFlv bool
+	RetentionArchiveDir       string
+
+	HooksFile string
 
 	// configurable global performances
 	uploadBufferSize           int
@@ -85,13 +107,22 @@ func provider() (*Config, error) {
 		MaxRecordingHours:       utils.MustAtoi(utils.EmptyOrElse(os.Getenv("MAX_RECORDING_HOURS"), "5")),
 		MaxRecoveryAttempts:     utils.MustAtoi(utils.EmptyOrElse(os.Getenv("MAX_RECOVERY_ATTEMPTS"), "5")),
 		MaxRetryMinutes:         utils.MustAtoi(utils.EmptyOrElse(os.Getenv("MAX_RETRY_MINUTES"), "10")),
+		StallTimeoutSeconds:     utils.MustAtoi(utils.EmptyOrElse(os.Getenv("STALL_TIMEOUT_SECONDS"), "30")),   // 0 to disable
+		SegmentDurationMinutes:  utils.MustAtoi(utils.EmptyOrElse(os.Getenv("SEGMENT_DURATION_MINUTES"), "0")), // 0 to disable
+		SegmentSizeBytes:        utils.MustAtoi64(utils.EmptyOrElse(os.Getenv("SEGMENT_SIZE_BYTES"), "0")),     // 0 to disable
 		OutputDir:               utils.EmptyOrElse(os.Getenv("OUTPUT_DIR"), "records"),
+		NamingTemplate:          os.Getenv("NAMING_TEMPLATE"), // empty to use the default layout
 		SecretDir:               utils.EmptyOrElse(os.Getenv("SECRET_DIR"), "secrets"),
 		DatabaseDir:             utils.EmptyOrElse(os.Getenv("DATABASE_DIR"), "database"),
 		CloudConvertThreshold:   utils.MustAtoi64(utils.EmptyOrElse(os.Getenv("CLOUDCONVERT_THRESHOLD"), "1073741824")), // 1 GB
 		CloudConvertApiKey:      os.Getenv("CLOUDCONVERT_API_KEY"),                                                      // empty to disable
+		RecordDanmaku:           os.Getenv("RECORD_DANMAKU") != "false",                                                 // enabled by default
+		RecordLiveEvents:        os.Getenv("RECORD_LIVE_EVENTS") != "false",                                             // enabled by default
+		InjectFlvMetadata:       os.Getenv("INJECT_FLV_METADATA") != "false",                                            // enabled by default
 		ConvertFLVToMp4:         os.Getenv("CONVERT_FLV_TO_MP4") == "true",
 		DeleteFlvAfterConvert:   os.Getenv("DELETE_FLV_AFTER_CONVERT") == "true",
+		MergeSessionSegments:    os.Getenv("MERGE_SESSION_SEGMENTS") == "true",
+		DeleteMergedSegments:    os.Getenv("DELETE_MERGED_SEGMENTS") == "true",
 		FrontendURL:             url,
 		BackendHost:             utils.EmptyOrElse(os.Getenv("BACKEND_HOST"), "localhost:8080"),
 		Username:                username,
@@ -102,6 +133,16 @@ func provider() (*Config, error) {
 		Debug:                   debug,
 		ProductionMode:          os.Getenv("PRODUCTION_MODE") == "true",
 		MinDiskSpaceBytes:       utils.MustAtoi64(utils.EmptyOrElse(os.Getenv("MIN_DISK_SPACE_BYTES"), "5368709120")), // 5GB
+		DiskLowActions:          utils.SplitAndTrim(utils.EmptyOrElse(os.Getenv("DISK_LOW_ACTIONS"), "warn"), ","),    // none to disable
+
+		RetentionIntervalMinutes:  utils.MustAtoi(utils.EmptyOrElse(os.Getenv("RETENTION_INTERVAL_MINUTES"), "60")),
+		RetentionMaxAgeDays:       utils.MustAtoi(utils.EmptyOrElse(os.Getenv("RETENTION_MAX_AGE_DAYS"), "0")),     // 0 to disable
+		RetentionMaxRoomBytes:     utils.MustAtoi64(utils.EmptyOrElse(os.Getenv("RETENTION_MAX_ROOM_BYTES"), "0")), // 0 to disable
+		RetentionKeepSessions:     utils.MustAtoi(utils.EmptyOrElse(os.Getenv("RETENTION_KEEP_SESSIONS"), "0")),    // 0 to disable
+		RetentionDropConvertedFlv: os.Getenv("RETENTION_DROP_CONVERTED_FLV") == "true",
+		RetentionArchiveDir:       os.Getenv("RETENTION_ARCHIVE_DIR"), // empty to delete instead of archive
+
+		HooksFile: os.Getenv("HOOKS_FILE"), // empty to disable hooks
 
 		// global performance configs
 		uploadBufferSize:           utils.MustAtoi(utils.EmptyOrElse(os.Getenv("UPLOAD_BUFFER_SIZE"), "5242880")),             // default 5MB
@@ -90,15 +90,7 @@ func provider(ls fx.Lifecycle, cfg *config.Config) *fiber.App {
 	}))
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: utils.Ternary(
-			cfg.ProductionMode,
-			[]string{cfg.FrontendURL.String()},
-			[]string{
-				cfg.FrontendURL.String(),
-				"http://localhost:3000",
-				"http://127.0.0.1:3000",
-			},
-		),
+		AllowOrigins:     AllowedOrigins(cfg),
 		AllowCredentials: true,
 	}))
 
@@ -107,7 +99,10 @@ func provider(ls fx.Lifecycle, cfg *config.Config) *fiber.App {
 
 		contentType := strings.ToLower(c.GetRespHeader(fiber.HeaderContentType))
 		if isMediaStreamContentType(contentType) {
-			c.Set(fiber.HeaderCacheControl, mediaStreamCacheControl)
+			// live streams set their own cache control
+			if c.GetRespHeader(fiber.HeaderCacheControl) == "" {
+				c.Set(fiber.HeaderCacheControl, mediaStreamCacheControl)
+			}
 			return err
 		}
 
@@ -178,6 +173,19 @@ func provider(ls fx.Lifecycle, cfg *config.Config) *fiber.App {
 	return app
 }
 
+// AllowedOrigins returns the origins of the frontend allowed to access the API with credentials
+func AllowedOrigins(cfg *config.Config) []string {
+	return utils.Ternary(
+		cfg.ProductionMode,
+		[]string{cfg.FrontendURL.String()},
+		[]string{
+			cfg.FrontendURL.String(),
+			"http://localhost:3000",
+			"http://127.0.0.1:3000",
+		},
+	)
+}
+
 func isMediaStreamContentType(contentType string) bool {
 	return strings.HasPrefix(contentType, "video/") ||
 		strings.HasPrefix(contentType, "audio/") ||
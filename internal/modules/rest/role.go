@@ -44,21 +44,32 @@ func RequiredRoles(roles ...Role) fiber.Handler {
 		allowedRoles.Add(role)
 	}
 	return func(c fiber.Ctx) error {
-
-		if !config.ReadOnly.RestAuthEnabled() {
-			return c.Next()
-		}
-
-		claims := utils.ToJwtClaims(c)
-		if claims == nil {
+		if !hasRole(c, allowedRoles) {
 			return fiber.NewError(403, "沒有權限")
 		}
+		return c.Next()
+	}
+}
 
-		role, ok := utils.GetClaimString(claims, "role")
-		if !ok || !allowedRoles.Contains(Role(role)) {
-			return fiber.NewError(403, "沒有權限")
-		}
+// IsAdmin checks the role of the logged in user, everyone is admin if the authentication is disabled
+func IsAdmin(c fiber.Ctx) bool {
+	return hasRole(c, adminRoles)
+}
 
-		return c.Next()
+var adminRoles = func() ds.Set[Role] {
+	roles := ds.NewSet[Role]()
+	roles.Add(RoleAdmin)
+	return roles
+}()
+
+func hasRole(c fiber.Ctx, allowedRoles ds.Set[Role]) bool {
+	if !config.ReadOnly.RestAuthEnabled() {
+		return true
+	}
+	claims := utils.ToJwtClaims(c)
+	if claims == nil {
+		return false
 	}
+	role, ok := utils.GetClaimString(claims, "role")
+	return ok && allowedRoles.Contains(Role(role))
 }
@@ -0,0 +1,261 @@
+package processors
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/pipeline"
+	"github.com/sirupsen/logrus"
+)
+
+type SegmentOptions struct {
+	// MaxDuration rolls over to a new file once the segment reaches this duration, 0 to disable
+	MaxDuration time.Duration
+	// MaxBytes rolls over to a new file once the segment reaches this size, 0 to disable
+	MaxBytes int64
+	// NextPath returns the output path of the next segment
+	NextPath func() (string, error)
+	// OnRotate is called after the previous segment is closed and the next one is opened
+	OnRotate func(prev, next string)
+}
+
+// FlvSegmentWriterProcessor writes a fixed FLV stream into files, rolling over to a new file
+// at the next video keyframe after the segment limit is reached.
+// Each new segment starts with its own FLV header, the cached metadata and sequence headers,
+// and its timestamps are rebased to start from zero.
+type FlvSegmentWriterProcessor struct {
+	opts       SegmentOptions
+	bufferSize int
+	writer     *BufferedStreamWriterProcessor
+	log        *logrus.Entry
+
+	pending       []byte
+	headerWritten bool
+
+	// cached complete tags (including previous tag size) replayed to every new segment
+	metadata    []byte
+	videoHeader []byte
+	audioHeader []byte
+
+	segmentBytes   int64
+	segmentStartTs int32
+	segmentBase    int32
+	started        bool
+	rotatePending  bool
+
+	// the rebased header of the tag being written, the data belongs to the caller
+	tagHeader [flv.TagHeaderSize]byte
+}
+
+func NewFlvSegmentWriter(path string, bufferSize int, opts SegmentOptions) *pipeline.ProcessorInfo[[]byte] {
+	return pipeline.NewProcessorInfo(
+		"flv-segment-writer",
+		&FlvSegmentWriterProcessor{
+			opts:       opts,
+			bufferSize: bufferSize,
+			writer: &BufferedStreamWriterProcessor{
+				path:       path,
+				bufferSize: bufferSize,
+			},
+		},
+		pipeline.WithTimeout[[]byte](30*time.Second),
+	)
+}
+
+func (w *FlvSegmentWriterProcessor) Open(ctx context.Context, log *logrus.Entry) error {
+	w.log = log
+	return w.writer.Open(ctx, log)
+}
+
+func (w *FlvSegmentWriterProcessor) Process(ctx context.Context, log *logrus.Entry, data []byte) ([]byte, error) {
+	buf := data
+	if len(w.pending) > 0 {
+		buf = append(w.pending, data...)
+		w.pending = nil
+	}
+
+	if !w.headerWritten {
+		if len(buf) < flv.FlvHeaderSize+flv.PrevTagSizeBytes {
+			w.pending = append([]byte(nil), buf...)
+			return data, nil
+		}
+		header := buf[:flv.FlvHeaderSize+flv.PrevTagSizeBytes]
+		if string(header[:3]) != "FLV" {
+			return data, flv.ErrNotFlvFile
+		}
+		if err := w.write(ctx, header); err != nil {
+			return data, err
+		}
+		w.headerWritten = true
+		buf = buf[len(header):]
+	}
+
+	for len(buf) >= flv.TagHeaderSize {
+		dataSize := int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3])
+		tagLen := flv.TagHeaderSize + dataSize + flv.PrevTagSizeBytes
+		if len(buf) < tagLen {
+			break
+		}
+		if err := w.processTag(ctx, buf[:tagLen]); err != nil {
+			return data, err
+		}
+		buf = buf[tagLen:]
+	}
+
+	if len(buf) > 0 {
+		w.pending = append([]byte(nil), buf...)
+	}
+	return data, nil
+}
+
+func (w *FlvSegmentWriterProcessor) processTag(ctx context.Context, tag []byte) error {
+	tagType := tag[0]
+	timestamp := readTimestamp(tag)
+	body := tag[flv.TagHeaderSize : len(tag)-flv.PrevTagSizeBytes]
+
+	isSequenceHeader := false
+	isKeyframe := false
+	switch tagType {
+	case flv.TagTypeScript:
+		if w.metadata == nil {
+			w.metadata = cloneTagAt(tag, 0)
+		}
+	case flv.TagTypeVideo:
+		if len(body) >= 2 {
+			isSequenceHeader = body[1] == 0x00
+			isKeyframe = (body[0] & 0xF0) == 0x10
+			if isSequenceHeader {
+				w.videoHeader = cloneTagAt(tag, 0)
+			}
+		}
+	case flv.TagTypeAudio:
+		if len(body) >= 2 && body[0]>>4 == 10 && body[1] == 0x00 {
+			isSequenceHeader = true
+			w.audioHeader = cloneTagAt(tag, 0)
+		}
+	}
+
+	if tagType != flv.TagTypeScript && !isSequenceHeader {
+		if !w.started {
+			w.started = true
+			w.segmentStartTs = timestamp
+		}
+
+		if !w.rotatePending && w.reachedLimit(timestamp) {
+			w.rotatePending = true
+		}
+
+		if w.rotatePending && tagType == flv.TagTypeVideo && isKeyframe {
+			if err := w.rotate(ctx, timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	if w.segmentBase == 0 {
+		return w.write(ctx, tag)
+	}
+	// rebase a copy of the header, the tag may still be passed to the next processors
+	copy(w.tagHeader[:], tag[:flv.TagHeaderSize])
+	writeTimestamp(w.tagHeader[:], max(timestamp-w.segmentBase, 0))
+	if err := w.write(ctx, w.tagHeader[:]); err != nil {
+		return err
+	}
+	return w.write(ctx, tag[flv.TagHeaderSize:])
+}
+
+func (w *FlvSegmentWriterProcessor) reachedLimit(timestamp int32) bool {
+	if w.opts.MaxBytes > 0 && w.segmentBytes >= w.opts.MaxBytes {
+		return true
+	}
+	if w.opts.MaxDuration > 0 && time.Duration(timestamp-w.segmentStartTs)*time.Millisecond >= w.opts.MaxDuration {
+		return true
+	}
+	return false
+}
+
+func (w *FlvSegmentWriterProcessor) rotate(ctx context.Context, keyframeTs int32) error {
+	w.rotatePending = false
+
+	next, err := w.opts.NextPath()
+	if err != nil {
+		// keep writing to the current segment and retry after another full segment
+		w.log.Errorf("cannot prepare next segment path, continue writing current segment: %v", err)
+		w.segmentStartTs = keyframeTs
+		w.segmentBytes = 0
+		return nil
+	}
+
+	prev := w.writer.path
+	if err := w.writer.Close(); err != nil {
+		w.log.Warnf("error closing segment %s: %v", prev, err)
+	}
+
+	w.writer = &BufferedStreamWriterProcessor{
+		path:       next,
+		bufferSize: w.bufferSize,
+	}
+	if err := w.writer.Open(ctx, w.log); err != nil {
+		return err
+	}
+
+	w.segmentBase = keyframeTs
+	w.segmentStartTs = keyframeTs
+	w.segmentBytes = 0
+
+	if err := w.write(ctx, flv.FlvHeader); err != nil {
+		return err
+	}
+	if err := w.write(ctx, []byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+	for _, cached := range [][]byte{w.metadata, w.videoHeader, w.audioHeader} {
+		if cached == nil {
+			continue
+		}
+		if err := w.write(ctx, cached); err != nil {
+			return err
+		}
+	}
+
+	w.log.Infof("rolled over to new segment: %s", next)
+	if w.opts.OnRotate != nil {
+		w.opts.OnRotate(prev, next)
+	}
+	return nil
+}
+
+func (w *FlvSegmentWriterProcessor) write(ctx context.Context, b []byte) error {
+	if _, err := w.writer.Process(ctx, w.log, b); err != nil {
+		return err
+	}
+	w.segmentBytes += int64(len(b))
+	return nil
+}
+
+func (w *FlvSegmentWriterProcessor) Close() error {
+	w.pending = nil
+	return w.writer.Close()
+}
+
+func readTimestamp(tag []byte) int32 {
+	return int32(tag[7])<<24 | int32(tag[4])<<16 | int32(tag[5])<<8 | int32(tag[6])
+}
+
+func writeTimestamp(tag []byte, timestamp int32) {
+	tag[4] = byte(timestamp >> 16)
+	tag[5] = byte(timestamp >> 8)
+	tag[6] = byte(timestamp)
+	tag[7] = byte(timestamp >> 24)
+}
+
+// cloneTagAt copies the complete tag and overrides its timestamp
+func cloneTagAt(tag []byte, timestamp int32) []byte {
+	c := make([]byte, len(tag))
+	copy(c, tag)
+	writeTimestamp(c, timestamp)
+	binary.BigEndian.PutUint32(c[len(c)-flv.PrevTagSizeBytes:], uint32(len(c)-flv.PrevTagSizeBytes))
+	return c
+}
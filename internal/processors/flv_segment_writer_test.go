@@ -0,0 +1,128 @@
+package processors_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/processors"
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/pipeline"
+)
+
+// parseTags returns type, timestamp and first two body bytes of every tag in a FLV file
+func parseTags(t *testing.T, data []byte) [][3]int32 {
+	if !bytes.HasPrefix(data, []byte("FLV")) {
+		t.Fatalf("file does not start with FLV header")
+	}
+	data = data[flv.FlvHeaderSize+flv.PrevTagSizeBytes:]
+	tags := make([][3]int32, 0)
+	for len(data) >= flv.TagHeaderSize {
+		size := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		ts := int32(data[7])<<24 | int32(data[4])<<16 | int32(data[5])<<8 | int32(data[6])
+		body := data[flv.TagHeaderSize : flv.TagHeaderSize+size]
+		prev := binary.BigEndian.Uint32(data[flv.TagHeaderSize+size:])
+		if int(prev) != flv.TagHeaderSize+size {
+			t.Fatalf("invalid previous tag size %d", prev)
+		}
+		tags = append(tags, [3]int32{int32(data[0]), ts, int32(body[0])<<8 | int32(body[1])})
+		data = data[flv.TagHeaderSize+size+flv.PrevTagSizeBytes:]
+	}
+	if len(data) != 0 {
+		t.Fatalf("trailing %d bytes in file", len(data))
+	}
+	return tags
+}
+
+func TestFlvSegmentWriter_RotatesAtKeyframe(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "seg-0.flv")
+
+	next := 0
+	rotated := make([]string, 0)
+	writer := processors.NewFlvSegmentWriter(first, 4096, processors.SegmentOptions{
+		MaxDuration: 2 * time.Second,
+		NextPath: func() (string, error) {
+			next++
+			return filepath.Join(dir, fmt.Sprintf("seg-%d.flv", next)), nil
+		},
+		OnRotate: func(prev, next string) {
+			rotated = append(rotated, next)
+		},
+	})
+	pipe := pipeline.New(writer)
+	ctx := context.Background()
+	if err := pipe.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := append([]byte{}, flv.FlvHeader...)
+	stream = append(stream, 0, 0, 0, 0)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeScript, 0, []byte{0x02, 0x00})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, 0, []byte{0x17, 0x00, 0x01})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12})...)
+	// 5 seconds of stream: keyframe every 1500ms, inter frame every 500ms, audio every 250ms
+	for ts := int32(0); ts < 5000; ts += 250 {
+		if ts%500 == 0 {
+			frameType := byte(0x27)
+			if ts%1500 == 0 {
+				frameType = 0x17
+			}
+			stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, ts, []byte{frameType, 0x01, 0xFF})...)
+		}
+		stream = append(stream, flv.MarshalTag(flv.TagTypeAudio, ts, []byte{0xAF, 0x01, 0xFF})...)
+	}
+
+	// feed in small uneven chunks to exercise partial tag handling
+	for len(stream) > 0 {
+		n := min(37, len(stream))
+		chunk := append([]byte{}, stream[:n]...)
+		if _, err := pipe.Process(ctx, chunk); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(chunk, stream[:n]) {
+			// the next processors receive the same data
+			t.Fatal("the input data should not be modified")
+		}
+		stream = stream[n:]
+	}
+	pipe.Close()
+
+	// rotation happens at the first keyframe at or after 2s, which is 3000ms
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotation, got %d: %v", len(rotated), rotated)
+	}
+
+	b, err := os.ReadFile(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := parseTags(t, b)
+	if len(tags) < 4 {
+		t.Fatalf("too few tags in new segment: %d", len(tags))
+	}
+	// metadata, video sequence header, audio sequence header, then keyframe at 0
+	if tags[0][0] != flv.TagTypeScript || tags[1] != [3]int32{flv.TagTypeVideo, 0, 0x1700} || tags[2] != [3]int32{flv.TagTypeAudio, 0, 0xAF00} {
+		t.Fatalf("new segment does not start with cached headers: %v", tags[:3])
+	}
+	if tags[3] != [3]int32{flv.TagTypeVideo, 0, 0x1701} {
+		t.Fatalf("new segment does not start with a keyframe at 0: %v", tags[3])
+	}
+	last := tags[len(tags)-1]
+	if last[1] != 4750-3000 {
+		t.Errorf("expected last timestamp rebased to 1750, got %d", last[1])
+	}
+
+	b, err = os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags = parseTags(t, b)
+	if last := tags[len(tags)-1]; last[1] >= 3000 {
+		t.Errorf("first segment contains tags after rotation: %v", last)
+	}
+}
@@ -16,9 +16,17 @@ type FlvStreamFixerProcessor struct {
 }
 
 func NewFlvStreamFixer() *pipeline.ProcessorInfo[[]byte] {
+	return NewFlvStreamFixerWithHealth(nil)
+}
+
+// NewFlvStreamFixerWithHealth reports the fixed tags to the health monitor, nil monitor is ignored
+func NewFlvStreamFixerWithHealth(health *flv.HealthMonitor) *pipeline.ProcessorInfo[[]byte] {
 	ffp := &FlvStreamFixerProcessor{
 		fixer: flv.NewRealtimeFixer(),
 	}
+	if health != nil {
+		ffp.fixer.SetHealthMonitor(health)
+	}
 	return pipeline.NewProcessorInfo(
 		"flv-fixer",
 		ffp,
@@ -0,0 +1,286 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/pipeline"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrTeeClosed = errors.New("flv tee is closed")
+
+const (
+	// DefaultTeeClientBuffer is the number of chunks buffered for each client before it is dropped
+	DefaultTeeClientBuffer = 512
+	// the cached GOP is discarded until the next keyframe once it grows beyond this size
+	maxTeeGopBytes = 16 * 1024 * 1024
+)
+
+// FlvTee copies a fixed FLV stream to live preview clients without blocking the next processors.
+// New clients receive a fresh FLV header, the cached metadata and sequence headers and the latest GOP,
+// then the live tags. A client which cannot keep up with the stream is dropped.
+type FlvTee struct {
+	mu      sync.Mutex
+	clients map[*teeClient]struct{}
+	closed  bool
+
+	// parsing state, only accessed by the pipeline
+	pending    []byte
+	skip       int
+	headerSeen bool
+
+	// cached complete tags
+	metadata    []byte
+	videoHeader []byte
+	audioHeader []byte
+	gop         [][]byte
+	gopBytes    int
+}
+
+type teeClient struct {
+	ch chan []byte
+	// skip tags until the next keyframe as the client joined without a cached GOP
+	waitKeyframe bool
+}
+
+type FlvTeeProcessor struct {
+	tee *FlvTee
+	log *logrus.Entry
+}
+
+func NewFlvTee() *FlvTee {
+	return &FlvTee{
+		clients: make(map[*teeClient]struct{}),
+	}
+}
+
+// NewFlvTeeProcessor feeds the passing stream to the tee and closes its clients when the pipeline closes
+func NewFlvTeeProcessor(tee *FlvTee) *pipeline.ProcessorInfo[[]byte] {
+	return pipeline.NewProcessorInfo(
+		"flv-tee",
+		&FlvTeeProcessor{tee: tee},
+	)
+}
+
+func (p *FlvTeeProcessor) Open(ctx context.Context, log *logrus.Entry) error {
+	p.log = log
+	return nil
+}
+
+func (p *FlvTeeProcessor) Process(ctx context.Context, log *logrus.Entry, data []byte) ([]byte, error) {
+	return data, p.tee.feed(data)
+}
+
+func (p *FlvTeeProcessor) Close() error {
+	p.tee.Close()
+	return nil
+}
+
+// Attach registers a new client with the given buffer size, the returned channel is closed
+// when the stream ends or the client falls behind. detach must be called once the client leaves.
+func (t *FlvTee) Attach(buffer int) (ch <-chan []byte, detach func(), err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, nil, ErrTeeClosed
+	}
+
+	c := &teeClient{
+		ch:           make(chan []byte, max(buffer, 1)),
+		waitKeyframe: len(t.gop) == 0,
+	}
+
+	// sequence headers share the timestamp of the first frame so players start without a gap
+	var startTs int32
+	if len(t.gop) > 0 {
+		startTs = readTimestamp(t.gop[0])
+	}
+	initial := make([]byte, 0, flv.FlvHeaderSize+flv.PrevTagSizeBytes+len(t.metadata)+len(t.videoHeader)+len(t.audioHeader)+t.gopBytes)
+	initial = append(initial, flv.FlvHeader...)
+	initial = append(initial, 0, 0, 0, 0)
+	if t.metadata != nil {
+		initial = append(initial, t.metadata...)
+	}
+	for _, cached := range [][]byte{t.videoHeader, t.audioHeader} {
+		if cached != nil {
+			initial = append(initial, cloneTagAt(cached, startTs)...)
+		}
+	}
+	for _, tag := range t.gop {
+		initial = append(initial, tag...)
+	}
+	c.ch <- initial
+	t.clients[c] = struct{}{}
+
+	return c.ch, func() { t.detach(c) }, nil
+}
+
+func (t *FlvTee) detach(c *teeClient) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[c]; ok {
+		delete(t.clients, c)
+		close(c.ch)
+	}
+}
+
+// Clients returns the number of attached clients
+func (t *FlvTee) Clients() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.clients)
+}
+
+// Close drops all clients and rejects new ones
+func (t *FlvTee) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for c := range t.clients {
+		delete(t.clients, c)
+		close(c.ch)
+	}
+	t.metadata, t.videoHeader, t.audioHeader = nil, nil, nil
+	t.gop, t.gopBytes = nil, 0
+	t.pending, t.skip = nil, 0
+}
+
+func (t *FlvTee) feed(data []byte) error {
+	if t.skip > 0 {
+		n := min(t.skip, len(data))
+		t.skip -= n
+		data = data[n:]
+	}
+	buf := data
+	if len(t.pending) > 0 {
+		buf = append(t.pending, data...)
+		t.pending = nil
+	}
+
+	if !t.headerSeen {
+		if len(buf) < flv.FlvHeaderSize+flv.PrevTagSizeBytes {
+			t.pending = append([]byte(nil), buf...)
+			return nil
+		}
+		if string(buf[:3]) != "FLV" {
+			return flv.ErrNotFlvFile
+		}
+		t.headerSeen = true
+		buf = buf[flv.FlvHeaderSize+flv.PrevTagSizeBytes:]
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	// without clients only the metadata and sequence headers are kept,
+	// a client attached later waits for the next keyframe as there is no cached GOP
+	idle := len(t.clients) == 0
+	if idle && len(t.gop) > 0 {
+		clear(t.gop)
+		t.gop, t.gopBytes = t.gop[:0], 0
+	}
+	for len(buf) >= flv.TagHeaderSize {
+		dataSize := int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3])
+		tagLen := flv.TagHeaderSize + dataSize + flv.PrevTagSizeBytes
+		if idle && len(buf) < tagLen && len(buf) >= flv.TagHeaderSize+2 && !isCachedTag(buf) {
+			// the rest of the tag is skipped in the next chunks instead of being buffered
+			t.skip = tagLen - len(buf)
+			buf = nil
+			break
+		} else if len(buf) < tagLen {
+			break
+		}
+		if !idle || isCachedTag(buf[:tagLen]) {
+			// the next processors may modify or recycle the buffer
+			t.processTag(append([]byte(nil), buf[:tagLen]...))
+		}
+		buf = buf[tagLen:]
+	}
+
+	if len(buf) > 0 {
+		t.pending = append([]byte(nil), buf...)
+	}
+	return nil
+}
+
+// isCachedTag reports whether the tag is the metadata or a sequence header, which every client starts with,
+// only the first two bytes of the body are needed.
+func isCachedTag(tag []byte) bool {
+	dataSize := int(tag[1])<<16 | int(tag[2])<<8 | int(tag[3])
+	body := tag[flv.TagHeaderSize:min(len(tag), flv.TagHeaderSize+dataSize)]
+	switch tag[0] {
+	case flv.TagTypeScript:
+		return true
+	case flv.TagTypeVideo:
+		return len(body) >= 2 && body[1] == 0x00
+	case flv.TagTypeAudio:
+		return len(body) >= 2 && body[0]>>4 == 10 && body[1] == 0x00
+	}
+	return false
+}
+
+func (t *FlvTee) processTag(tag []byte) {
+	body := tag[flv.TagHeaderSize : len(tag)-flv.PrevTagSizeBytes]
+
+	isKeyframe := false
+	switch tag[0] {
+	case flv.TagTypeScript:
+		t.metadata = cloneTagAt(tag, 0)
+		t.broadcast(tag, false, true)
+		return
+	case flv.TagTypeVideo:
+		if len(body) >= 2 && body[1] == 0x00 {
+			t.videoHeader = tag
+			t.broadcast(tag, false, true)
+			return
+		}
+		isKeyframe = len(body) >= 1 && (body[0]&0xF0) == 0x10
+	case flv.TagTypeAudio:
+		if len(body) >= 2 && body[0]>>4 == 10 && body[1] == 0x00 {
+			t.audioHeader = tag
+			t.broadcast(tag, false, true)
+			return
+		}
+	}
+
+	if isKeyframe {
+		clear(t.gop)
+		t.gop, t.gopBytes = t.gop[:0], 0
+	}
+	// drop the oversized GOP, clients joining now wait for the next keyframe instead
+	if (len(t.gop) > 0 || isKeyframe) && t.gopBytes+len(tag) <= maxTeeGopBytes {
+		t.gop = append(t.gop, tag)
+		t.gopBytes += len(tag)
+	} else if len(t.gop) > 0 {
+		clear(t.gop)
+		t.gop, t.gopBytes = t.gop[:0], 0
+	}
+
+	t.broadcast(tag, isKeyframe, false)
+}
+
+// broadcast sends the tag to every client without blocking, clients with a full buffer are dropped
+func (t *FlvTee) broadcast(tag []byte, isKeyframe, isHeader bool) {
+	for c := range t.clients {
+		if c.waitKeyframe && !isHeader {
+			if !isKeyframe {
+				continue
+			}
+			c.waitKeyframe = false
+		}
+		select {
+		case c.ch <- tag:
+		default:
+			delete(t.clients, c)
+			close(c.ch)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package processors_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eric2788/bilirec/internal/processors"
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/pipeline"
+)
+
+// drain reads every chunk already buffered for the client
+func drain(ch <-chan []byte) (data []byte, closed bool) {
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				return data, true
+			}
+			data = append(data, chunk...)
+		default:
+			return data, false
+		}
+	}
+}
+
+func TestFlvTee_LateClientStartsFromLatestGop(t *testing.T) {
+	tee := processors.NewFlvTee()
+	pipe := pipeline.New(processors.NewFlvTeeProcessor(tee))
+	ctx := context.Background()
+	if err := pipe.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	early, detachEarly, err := tee.Attach(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detachEarly()
+
+	stream := append([]byte{}, flv.FlvHeader...)
+	stream = append(stream, 0, 0, 0, 0)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeScript, 0, []byte{0x02, 0x00})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, 0, []byte{0x17, 0x00, 0x01})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, 1000, []byte{0x27, 0x01, 0x01})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, 1500, []byte{0x17, 0x01, 0x02})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeAudio, 1600, []byte{0xAF, 0x01, 0x03})...)
+
+	// feed in small chunks to split the tags across calls
+	for i := 0; i < len(stream); i += 7 {
+		chunk := append([]byte(nil), stream[i:min(i+7, len(stream))]...)
+		if _, err := pipe.Process(ctx, chunk); err != nil {
+			t.Fatal(err)
+		}
+		// the next processors may reuse the buffer
+		clear(chunk)
+	}
+
+	// the early client waits for the first keyframe, header tags are passed through
+	data, _ := drain(early)
+	tags := parseTags(t, data)
+	if len(tags) != 5 || tags[3] != [3]int32{flv.TagTypeVideo, 1500, 0x1701} || tags[4][1] != 1600 {
+		t.Fatalf("unexpected tags for early client: %v", tags)
+	}
+
+	late, detachLate, err := tee.Attach(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detachLate()
+	data, _ = drain(late)
+	tags = parseTags(t, data)
+	expected := [][3]int32{
+		{flv.TagTypeScript, 0, 0x0200},
+		{flv.TagTypeVideo, 1500, 0x1700},
+		{flv.TagTypeAudio, 1500, 0xAF00},
+		{flv.TagTypeVideo, 1500, 0x1701},
+		{flv.TagTypeAudio, 1600, 0xAF01},
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("unexpected tags for late client: %v", tags)
+	}
+	for i := range expected {
+		if tags[i] != expected[i] {
+			t.Errorf("tag %d: expected %v, got %v", i, expected[i], tags[i])
+		}
+	}
+}
+
+func TestFlvTee_SlowClientDropped(t *testing.T) {
+	tee := processors.NewFlvTee()
+	pipe := pipeline.New(processors.NewFlvTeeProcessor(tee))
+	ctx := context.Background()
+	if err := pipe.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	header := append(append([]byte{}, flv.FlvHeader...), 0, 0, 0, 0)
+	if _, err := pipe.Process(ctx, header); err != nil {
+		t.Fatal(err)
+	}
+	slow, detachSlow, _ := tee.Attach(2)
+	defer detachSlow()
+	fast, detachFast, _ := tee.Attach(64)
+	defer detachFast()
+
+	for i := range 10 {
+		tag := flv.MarshalTag(flv.TagTypeVideo, int32(i*100), []byte{0x17, 0x01, byte(i)})
+		if _, err := pipe.Process(ctx, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, closed := drain(slow); !closed {
+		t.Error("slow client should be dropped")
+	}
+	data, closed := drain(fast)
+	if closed || len(parseTags(t, data)) != 10 {
+		t.Errorf("fast client should receive every tag, closed=%v", closed)
+	}
+	if tee.Clients() != 1 {
+		t.Errorf("expected 1 client, got %d", tee.Clients())
+	}
+
+	pipe.Close()
+	if _, closed := drain(fast); !closed {
+		t.Error("clients should be closed with the pipeline")
+	}
+	if _, _, err := tee.Attach(1); !errors.Is(err, processors.ErrTeeClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+	if !bytes.HasPrefix(data, flv.FlvHeader) {
+		t.Error("client should start with FLV header")
+	}
+}
+
+func TestFlvTee_IdleKeepsOnlyHeaders(t *testing.T) {
+	tee := processors.NewFlvTee()
+	pipe := pipeline.New(processors.NewFlvTeeProcessor(tee))
+	ctx := context.Background()
+	if err := pipe.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	stream := append([]byte{}, flv.FlvHeader...)
+	stream = append(stream, 0, 0, 0, 0)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeScript, 0, []byte{0x02, 0x00})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, 0, []byte{0x17, 0x00, 0x01})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeVideo, 1000, []byte{0x17, 0x01, 0x01})...)
+	stream = append(stream, flv.MarshalTag(flv.TagTypeAudio, 1100, []byte{0xAF, 0x01, 0x02})...)
+	// split the tags so the partial ones are skipped or kept across calls
+	for i := 0; i < len(stream); i += 5 {
+		if _, err := pipe.Process(ctx, append([]byte(nil), stream[i:min(i+5, len(stream))]...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nothing is cached from the GOP fed without clients
+	client, detach, err := tee.Attach(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detach()
+	if _, err := pipe.Process(ctx, flv.MarshalTag(flv.TagTypeAudio, 1200, []byte{0xAF, 0x01, 0x03})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pipe.Process(ctx, flv.MarshalTag(flv.TagTypeVideo, 2000, []byte{0x17, 0x01, 0x04})); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := drain(client)
+	tags := parseTags(t, data)
+	expected := [][3]int32{
+		{flv.TagTypeScript, 0, 0x0200},
+		{flv.TagTypeVideo, 0, 0x1700},
+		{flv.TagTypeAudio, 0, 0xAF00},
+		{flv.TagTypeVideo, 2000, 0x1701},
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	for i := range expected {
+		if tags[i] != expected[i] {
+			t.Errorf("tag %d: expected %v, got %v", i, expected[i], tags[i])
+		}
+	}
+}
@@ -2,6 +2,7 @@ package convert
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -43,10 +44,12 @@ type cloudConvertManager struct {
 
 	presignedUrlPool *xsync.Map[string, string] // inputPath -> presignedURL
 
-	pathSvc *path.Service
+	pathSvc  *path.Service
+	paused   func() bool
+	finished FinishedListener
 }
 
-func newCloudConvertManager(client *cloudconvert.Client, pathSvc *path.Service) ConvertManager {
+func newCloudConvertManager(client *cloudconvert.Client, pathSvc *path.Service, paused func() bool, finished FinishedListener) ConvertManager {
 	return &cloudConvertManager{
 		logger:           logger.WithField("manager", "cloudconvert"),
 		client:           client,
@@ -56,6 +59,8 @@ func newCloudConvertManager(client *cloudconvert.Client, pathSvc *path.Service)
 		concurrent:       semaphore.NewWeighted(2),
 		presignedUrlPool: xsync.NewMap[string, string](),
 		pathSvc:          pathSvc,
+		paused:           paused,
+		finished:         finished,
 	}
 }
 
@@ -108,6 +113,7 @@ func (c *cloudConvertManager) Enqueue(inputPath, outputPath, format string, dele
 	exportTaskID := job.TaskID(exportTaskName)
 
 	queue := &TaskQueue{
+		Type:          TaskTypeConvert,
 		Provider:      ProviderCloudConvert,
 		TaskID:        exportTaskID,
 		ConvertTaskID: convertTaskID,
@@ -168,6 +174,11 @@ func (c *cloudConvertManager) checkTaskStatusPeriodically(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
+			if c.paused() {
+				// finished files are kept by cloudconvert, download them after resumed
+				c.logger.Debug("conversions paused, skipping task status check")
+				continue
+			}
 			c.logger.Debugf("checking task queue...")
 			if list, err := c.ListInProgress(); err != nil {
 				c.logger.Errorf("failed to list in-progress tasks: %v", err)
@@ -261,22 +272,30 @@ func (c *cloudConvertManager) onFinished(ctx context.Context, queue *TaskQueue,
 	})
 	if err != nil {
 		return err
-	} else if !queue.DeleteSource || queue.InputPath == queue.OutputPath {
-		return nil
+	} else if queue.DeleteSource && queue.InputPath != queue.OutputPath {
+		err = utils.WithRetry(3, c.logger, "delete source file", func() error {
+			if !utils.IsFileExists(queue.InputPath) {
+				c.logger.Debugf("source file %s does not exist, skipping delete", queue.InputPath)
+				return nil
+			}
+			return os.Remove(queue.InputPath)
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	return utils.WithRetry(3, c.logger, "delete source file", func() error {
-		if !utils.IsFileExists(queue.InputPath) {
-			c.logger.Debugf("source file %s does not exist, skipping delete", queue.InputPath)
-			return nil
-		}
-		return os.Remove(queue.InputPath)
-	})
+	c.finished(queue, nil)
+	return nil
 }
 
 func (c *cloudConvertManager) onFailed(queue *TaskQueue, info *cloudconvert.TaskData) error {
 	// print log and queue again
 	c.logger.Errorf("task %s failed with message: %s", queue.TaskID, *info.Message)
+	// the task is re-enqueued as a new one, only the first failure is reported
+	if queue.Attempts == 0 {
+		c.finished(queue, errors.New(*info.Message))
+	}
 	c.logger.Infof("re-enqueueing task %s", queue.TaskID)
 
 	deleteBucket := func() error {
@@ -299,6 +318,13 @@ func (c *cloudConvertManager) onFailed(queue *TaskQueue, info *cloudconvert.Task
 
 	c.logger.Infof("re-enqueued task %s as new task %s", queue.TaskID, newInfo.TaskID)
 
+	newInfo.Attempts = queue.Attempts + 1
+	if data, err := c.serializer.Serialize(newInfo); err != nil {
+		c.logger.Warnf("cannot save the failed attempts of task %s: %v", newInfo.TaskID, err)
+	} else if err := c.bucket.Put([]byte(newInfo.TaskID), data); err != nil {
+		c.logger.Warnf("cannot save the failed attempts of task %s: %v", newInfo.TaskID, err)
+	}
+
 	err = deleteBucket()
 
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/services/convert"
+	"github.com/eric2788/bilirec/internal/services/notify"
 	"github.com/eric2788/bilirec/utils"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxtest"
@@ -23,6 +24,7 @@ func TestCloudConvert(t *testing.T) {
 	var svc *convert.Service
 	app := fxtest.New(t,
 		config.Module,
+		fx.Provide(notify.NewService),
 		fx.Provide(convert.NewService),
 		fx.Populate(&svc),
 	)
@@ -45,6 +47,7 @@ func TestUntilCloudConvertCompleted(t *testing.T) {
 	var svc *convert.Service
 	app := fxtest.New(t,
 		config.Module,
+		fx.Provide(notify.NewService),
 		fx.Provide(convert.NewService),
 		fx.Populate(&svc),
 	)
@@ -83,6 +86,7 @@ func TestCancelUnExistingCloudConvertTask(t *testing.T) {
 
 	app := fxtest.New(t,
 		config.Module,
+		fx.Provide(notify.NewService),
 		fx.Provide(convert.NewService),
 		fx.Populate(&svc),
 	)
@@ -5,13 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/internal/services/notify"
 	"github.com/eric2788/bilirec/internal/services/path"
 	"github.com/eric2788/bilirec/pkg/cloudconvert"
 	"github.com/eric2788/bilirec/pkg/db"
+	"github.com/eric2788/bilirec/pkg/pool"
 	"github.com/eric2788/bilirec/utils"
 	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
 	"go.uber.org/fx"
 )
 
@@ -22,21 +30,30 @@ var (
 	ErrNoConvertManager          = errors.New("no convert manager available")
 	ErrFFmpegNotInstalled        = errors.New("ffmpeg is not installed or not found in PATH")
 	ErrCloudConvertNotConfigured = errors.New("cloudconvert client is not initialized")
+	ErrInvalidClipRange          = errors.New("invalid clip time range")
+	ErrOutputExists              = errors.New("output file already exists or is queued")
+	ErrInvalidMerge              = errors.New("at least two files of the same format are required to merge")
 )
 
 type Service struct {
 	cloudthreshold int64
 	managers       map[string]ConvertManager
+	notify         *notify.Service
 	ctx            context.Context
 	db             *db.Client
+	paused         atomic.Bool
+
+	listenersMu sync.RWMutex
+	listeners   []FinishedListener
 }
 
-func NewService(ls fx.Lifecycle, cfg *config.Config, pathSvc *path.Service) *Service {
+func NewService(ls fx.Lifecycle, cfg *config.Config, pathSvc *path.Service, ns *notify.Service) *Service {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	svc := &Service{
 		cloudthreshold: cfg.CloudConvertThreshold,
 		managers:       make(map[string]ConvertManager),
+		notify:         ns,
 		ctx:            ctx,
 	}
 
@@ -48,11 +65,15 @@ func NewService(ls fx.Lifecycle, cfg *config.Config, pathSvc *path.Service) *Ser
 				cloudconvert.WithUploadBufferSize(config.ReadOnly.UploadBufferSize()),
 			),
 			pathSvc,
+			svc.IsPaused,
+			svc.finished,
 		)
 	} else {
 		logger.Info("cloud convert api key not provided, cloud convert disabled")
 	}
 
+	svc.OnFinished(svc.publishFinished)
+
 	ls.Append(fx.StartStopHook(
 		func() error {
 			if err := os.MkdirAll(cfg.DatabaseDir, 0755); err != nil {
@@ -91,10 +112,90 @@ func (s *Service) Enqueue(path, format string, deleteSource bool) (*TaskQueue, e
 	var manager ConvertManager
 	if s.shoulduseCloudConvert(fileInfo.Size()) {
 		manager = s.managers["cloudconvert"]
-	} else {
-		manager = s.managers["ffmpeg"]
+	} else if manager = s.localManager(); manager == nil {
+		return nil, ErrNoConvertManager
+	}
+	queue, err := manager.Enqueue(path, outputFormat, format, deleteSource)
+	if err != nil {
+		return nil, err
+	}
+	s.notify.PublishConvertEnqueued(convertData(queue, nil))
+	return queue, nil
+}
+
+// Clip enqueues a task cutting the range from start to end out of the file at the full path,
+// the clip is written beside the source with the same format.
+func (s *Service) Clip(path string, start, end time.Duration) (*TaskQueue, error) {
+	if start < 0 || end <= start {
+		return nil, ErrInvalidClipRange
+	}
+	manager, ok := s.localManager().(ClipManager)
+	if !ok {
+		return nil, ErrNoConvertManager
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(path)
+	outputPath := fmt.Sprintf("%s_clip_%s-%s%s", strings.TrimSuffix(path, ext), start.Truncate(time.Millisecond), end.Truncate(time.Millisecond), ext)
+	if err := s.checkOutput(outputPath); err != nil {
+		return nil, err
+	}
+	queue, err := manager.EnqueueClip(path, outputPath, start, end)
+	if err != nil {
+		return nil, err
 	}
-	return manager.Enqueue(path, outputFormat, format, deleteSource)
+	s.notify.PublishConvertEnqueued(convertData(queue, nil))
+	return queue, nil
+}
+
+// Merge enqueues a task concatenating the files at the full paths in order into one file beside the first one,
+// an empty format keeps the format of the inputs.
+func (s *Service) Merge(paths []string, format string, deleteSource bool) (*TaskQueue, error) {
+	if len(paths) < 2 {
+		return nil, ErrInvalidMerge
+	}
+	inputFormat := utils.GetPathFormat(paths[0])
+	for _, path := range paths {
+		if utils.GetPathFormat(path) != inputFormat {
+			return nil, ErrInvalidMerge
+		} else if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	manager, ok := s.localManager().(MergeManager)
+	if !ok {
+		return nil, ErrNoConvertManager
+	}
+	format = utils.EmptyOrElse(format, inputFormat)
+	stem := strings.TrimSuffix(paths[0], filepath.Ext(paths[0]))
+	outputPath := stem + "_merged." + format
+	if err := s.checkOutput(outputPath); err != nil {
+		return nil, err
+	}
+	queue, err := manager.EnqueueMerge(paths, outputPath, format, deleteSource)
+	if err != nil {
+		return nil, err
+	}
+	s.notify.PublishConvertEnqueued(convertData(queue, nil))
+	return queue, nil
+}
+
+// checkOutput makes sure no task would write to an existing file, which would be skipped as already converted
+func (s *Service) checkOutput(outputPath string) error {
+	if utils.IsFileExists(outputPath) {
+		return ErrOutputExists
+	}
+	queues, err := s.ListInProgress()
+	if err != nil {
+		return err
+	}
+	for _, q := range queues {
+		if q.OutputPath == outputPath {
+			return ErrOutputExists
+		}
+	}
+	return nil
 }
 
 // IsInQueue checks if the given full path is already in the convert queue.
@@ -105,8 +206,11 @@ func (s *Service) IsInQueue(fullPath string) (bool, error) {
 		return false, err
 	}
 	for _, q := range queues {
-		if q.InputPath == fullPath {
-			return true, nil
+		// recordings are enqueued with paths under the output dir, which may be relative
+		for _, input := range q.sources() {
+			if abs, err := filepath.Abs(input); input == fullPath || (err == nil && abs == fullPath) {
+				return true, nil
+			}
 		}
 	}
 	return false, nil
@@ -136,19 +240,88 @@ func (s *Service) ListInProgress() ([]*TaskQueue, error) {
 		if err != nil {
 			return nil, err
 		}
+		for _, q := range queues {
+			if q.Type == "" {
+				q.Type = TaskTypeConvert
+			}
+		}
 		allQueues = append(allQueues, queues...)
 	}
 	return allQueues, nil
 }
 
+// Pause stops starting conversions and downloading converted files until resumed,
+// conversions already running are not interrupted.
+func (s *Service) Pause() {
+	if s.paused.CompareAndSwap(false, true) {
+		logger.Info("conversions paused")
+	}
+}
+
+func (s *Service) Resume() {
+	if s.paused.CompareAndSwap(true, false) {
+		logger.Info("conversions resumed")
+	}
+}
+
+func (s *Service) IsPaused() bool {
+	return s.paused.Load()
+}
+
+// OnFinished registers a listener called after a conversion is completed or failed,
+// the source file may have been deleted if requested.
+func (s *Service) OnFinished(listener FinishedListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *Service) finished(queue *TaskQueue, err error) {
+	s.listenersMu.RLock()
+	defer s.listenersMu.RUnlock()
+	for _, listener := range s.listeners {
+		listener(queue, err)
+	}
+}
+
+func (s *Service) publishFinished(queue *TaskQueue, err error) {
+	if err != nil {
+		s.notify.PublishConvertFailed(convertData(queue, err))
+	} else {
+		s.notify.PublishConvertCompleted(convertData(queue, nil))
+	}
+}
+
+func convertData(queue *TaskQueue, err error) notify.ConvertData {
+	data := notify.ConvertData{
+		TaskID:     queue.TaskID,
+		Provider:   string(queue.Provider),
+		InputPath:  queue.InputPath,
+		OutputPath: queue.OutputPath,
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	return data
+}
+
 func (s *Service) SetActiveRecordingsGetter(getter GetActiveRecordings) {
-	if _, ok := s.managers["ffmpeg"]; ok {
+	if s.localManager() != nil {
 		return
 	} else if utils.FFmpegAvailable() {
-		s.managers["ffmpeg"] = newFFmpegConvertManager(getter)
+		s.managers["ffmpeg"] = newFFmpegConvertManager(getter, s.IsPaused, s.finished)
 	} else {
-		logger.Warn("ffmpeg not available, ffmpeg convert manager not initialized")
+		logger.Info("ffmpeg not available, using native convert manager for flv to mp4")
+		s.managers["native"] = newNativeConvertManager(getter, s.IsPaused, s.finished)
+	}
+}
+
+// localManager returns the manager converting on this machine, ffmpeg is preferred over native
+func (s *Service) localManager() ConvertManager {
+	if manager, ok := s.managers["ffmpeg"]; ok {
+		return manager
 	}
+	return s.managers["native"]
 }
 
 func (s *Service) shoulduseCloudConvert(fileSize int64) bool {
@@ -162,3 +335,44 @@ func (s *Service) checkAvailableManagers() error {
 	}
 	return nil
 }
+
+// missingSource returns the first input of the task which no longer exists, empty if all of them exist
+func missingSource(queue *TaskQueue) string {
+	for _, path := range queue.sources() {
+		if !utils.IsFileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// countFailure saves the failed attempt of a task kept in the queue to be retried,
+// ErrTaskNotFound is returned if the task has been cancelled meanwhile.
+func countFailure(bucket *db.Bucket, serializer *pool.Serializer, queue *TaskQueue) error {
+	queue.Attempts++
+	data, err := serializer.Serialize(queue)
+	if err != nil {
+		return err
+	}
+	return bucket.Update(func(b *bbolt.Bucket) error {
+		if b.Get([]byte(queue.TaskID)) == nil {
+			return ErrTaskNotFound
+		}
+		return b.Put([]byte(queue.TaskID), data)
+	})
+}
+
+func removeSources(queue *TaskQueue, taskLog *logrus.Entry) error {
+	for _, path := range queue.sources() {
+		if err := utils.WithRetry(3, taskLog, "delete source file", func() error {
+			if !utils.IsFileExists(path) {
+				taskLog.Debugf("source file %s does not exist, skipping delete", path)
+				return nil
+			}
+			return os.Remove(path)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
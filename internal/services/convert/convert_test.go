@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/db"
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/mp4"
+	"github.com/eric2788/bilirec/pkg/pool"
+)
+
+func TestCountFailure(t *testing.T) {
+	client, err := db.Open(filepath.Join(t.TempDir(), "convert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	bucket, err := client.Bucket(ffmpegBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializer := pool.NewSerializer()
+
+	queue := &TaskQueue{TaskID: "task", InputPath: "a.flv", OutputPath: "a.mp4"}
+	data, err := serializer.Serialize(queue)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := bucket.Put([]byte(queue.TaskID), data); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := countFailure(bucket, serializer, queue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var saved TaskQueue
+	if err := bucket.GetFunc([]byte(queue.TaskID), func(v []byte) error {
+		return serializer.Deserialize(v, &saved)
+	}); err != nil {
+		t.Fatal(err)
+	} else if saved.Attempts != 2 {
+		t.Errorf("expected 2 attempts to be saved, got %d", saved.Attempts)
+	}
+
+	// a cancelled task is not put back into the queue
+	if err := bucket.Delete([]byte(queue.TaskID)); err != nil {
+		t.Fatal(err)
+	} else if err := countFailure(bucket, serializer, queue); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected task not found, got %v", err)
+	}
+	if exists, _ := bucket.Exists([]byte(queue.TaskID)); exists {
+		t.Error("cancelled task should not be saved again")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	_, openErr := os.Open(filepath.Join(t.TempDir(), "missing.flv"))
+	diskFull := &os.PathError{Op: "write", Path: "a.mp4", Err: errors.New("no space left on device")}
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{openErr, true},
+		{fmt.Errorf("write moov: %w", diskFull), true},
+		{fmt.Errorf("%w: sample at 10 is truncated", mp4.ErrSourceChanged), true},
+		{mp4.ErrNoTracks, false},
+		{flv.ErrUnsupportedCodec, false},
+		{io.ErrUnexpectedEOF, false},
+		{ErrUnsupportedFormat, false},
+	}
+	for _, c := range cases {
+		if got := isRetryable(c.err); got != c.expected {
+			t.Errorf("isRetryable(%v) = %v, expected %v", c.err, got, c.expected)
+		}
+	}
+}
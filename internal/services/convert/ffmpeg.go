@@ -2,9 +2,12 @@ package convert
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/eric2788/bilirec/pkg/db"
@@ -26,15 +29,19 @@ type ffmpegConvertManager struct {
 	logger     *logrus.Entry
 	serializer *pool.Serializer
 	getActives GetActiveRecordings
+	paused     func() bool
+	finished   FinishedListener
 
 	processing *xsync.Map[string, context.CancelFunc]
 }
 
-func newFFmpegConvertManager(getActives GetActiveRecordings) ConvertManager {
+func newFFmpegConvertManager(getActives GetActiveRecordings, paused func() bool, finished FinishedListener) ConvertManager {
 	return &ffmpegConvertManager{
 		logger:     logger.WithField("manager", "ffmpeg"),
 		serializer: pool.NewSerializer(),
 		getActives: getActives,
+		paused:     paused,
+		finished:   finished,
 		processing: xsync.NewMap[string, context.CancelFunc](),
 	}
 }
@@ -57,6 +64,7 @@ func (f *ffmpegConvertManager) Enqueue(inputPath, outputPath, format string, del
 		return nil, err
 	}
 	queue := &TaskQueue{
+		Type:         TaskTypeConvert,
 		Provider:     ProviderFFmpeg,
 		TaskID:       uuid,
 		InputPath:    inputPath,
@@ -73,6 +81,54 @@ func (f *ffmpegConvertManager) Enqueue(inputPath, outputPath, format string, del
 	return queue, err
 }
 
+func (f *ffmpegConvertManager) EnqueueClip(inputPath, outputPath string, start, end time.Duration) (*TaskQueue, error) {
+	uuid, err := utils.NewUUIDv4()
+	if err != nil {
+		return nil, err
+	}
+	queue := &TaskQueue{
+		Type:         TaskTypeClip,
+		Provider:     ProviderFFmpeg,
+		TaskID:       uuid,
+		InputPath:    inputPath,
+		OutputPath:   outputPath,
+		InputFormat:  utils.GetPathFormat(inputPath),
+		OutputFormat: utils.GetPathFormat(outputPath),
+		ClipStart:    start.Milliseconds(),
+		ClipEnd:      end.Milliseconds(),
+	}
+	data, err := f.serializer.Serialize(queue)
+	if err != nil {
+		return nil, err
+	}
+	err = f.bucket.Put([]byte(uuid), data)
+	return queue, err
+}
+
+func (f *ffmpegConvertManager) EnqueueMerge(inputPaths []string, outputPath, format string, deleteSource bool) (*TaskQueue, error) {
+	uuid, err := utils.NewUUIDv4()
+	if err != nil {
+		return nil, err
+	}
+	queue := &TaskQueue{
+		Type:         TaskTypeMerge,
+		Provider:     ProviderFFmpeg,
+		TaskID:       uuid,
+		InputPath:    inputPaths[0],
+		InputPaths:   inputPaths,
+		OutputPath:   outputPath,
+		InputFormat:  utils.GetPathFormat(inputPaths[0]),
+		OutputFormat: format,
+		DeleteSource: deleteSource,
+	}
+	data, err := f.serializer.Serialize(queue)
+	if err != nil {
+		return nil, err
+	}
+	err = f.bucket.Put([]byte(uuid), data)
+	return queue, err
+}
+
 func (f *ffmpegConvertManager) Cancel(taskID string) error {
 	if cancel, ok := f.processing.LoadAndDelete(taskID); ok {
 		cancel()
@@ -99,6 +155,10 @@ func (f *ffmpegConvertManager) runTaskPeriodically(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
+			if f.paused() {
+				f.logger.Debug("conversions paused, skipping ffmpeg tasks")
+				continue
+			}
 			actives := f.getActives()
 			if actives > 0 {
 				f.logger.Debugf("active recordings detected (%d), skipping ffmpeg tasks", actives)
@@ -130,8 +190,8 @@ func (f *ffmpegConvertManager) runTaskPeriodically(ctx context.Context) {
 
 			taskLog := f.logger.WithField("task_id", queue.TaskID)
 
-			if !utils.IsFileExists(queue.InputPath) {
-				taskLog.Warnf("input file %s no longer exists, cancelling task", queue.InputPath)
+			if missing := missingSource(queue); missing != "" {
+				taskLog.Warnf("input file %s no longer exists, cancelling task", missing)
 				if err := deleteBucket(); err != nil {
 					taskLog.Errorf("failed to remove ffmpeg task from queue: %v", err)
 				}
@@ -140,8 +200,17 @@ func (f *ffmpegConvertManager) runTaskPeriodically(ctx context.Context) {
 
 			taskLog.Infof("processing ffmpeg task input=%s output=%s", queue.InputPath, queue.OutputPath)
 
-			if err := f.processTask(ctx, queue, taskLog); err != nil {
+			if err := f.processTask(ctx, queue, taskLog); errors.Is(err, context.Canceled) {
+				taskLog.Info("ffmpeg task cancelled")
+				continue
+			} else if err != nil {
 				taskLog.Errorf("ffmpeg task failed: %v", err)
+				// the task is retried on the next tick, only the first failure is reported
+				if countErr := countFailure(f.bucket, f.serializer, queue); countErr != nil {
+					taskLog.Warnf("cannot save the failed attempt: %v", countErr)
+				} else if queue.Attempts == 1 {
+					f.finished(queue, err)
+				}
 				continue
 			}
 
@@ -151,6 +220,7 @@ func (f *ffmpegConvertManager) runTaskPeriodically(ctx context.Context) {
 			}
 
 			taskLog.Info("completed and removed from queue")
+			f.finished(queue, nil)
 		case <-ctx.Done():
 			return
 		}
@@ -172,8 +242,7 @@ func (f *ffmpegConvertManager) processTask(ctx context.Context, queue *TaskQueue
 		}
 	}()
 
-	cmd := exec.CommandContext(processCtx,
-		"ffmpeg",
+	args := []string{
 		"-hide_banner",
 		"-i",
 		queue.InputPath,
@@ -186,22 +255,96 @@ func (f *ffmpegConvertManager) processTask(ctx context.Context, queue *TaskQueue
 		"-c",
 		"copy",
 		queue.OutputPath,
-	)
+	}
+	switch queue.Type {
+	case TaskTypeClip:
+		args = clipArgs(queue)
+	case TaskTypeMerge:
+		list, err := writeConcatList(queue.InputPaths)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(list)
+		args = mergeArgs(list, queue)
+	}
+	cmd := exec.CommandContext(processCtx, "ffmpeg", args...)
 
 	cmd.Stdout = taskLog.Writer()
 	cmd.Stderr = taskLog.Writer()
 
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Run(); err != nil && processCtx.Err() != nil {
+		// ffmpeg is killed when the task is cancelled
+		return processCtx.Err()
+	} else if err != nil {
 		return err
 	} else if !queue.DeleteSource || queue.InputPath == queue.OutputPath {
 		return nil
 	}
 
-	return utils.WithRetry(3, taskLog, "delete source file", func() error {
-		if !utils.IsFileExists(queue.InputPath) {
-			taskLog.Debugf("source file %s does not exist, skipping delete", queue.InputPath)
-			return nil
+	return removeSources(queue, taskLog)
+}
+
+// clipArgs seeks the input before opening it, so the stream copy starts from the keyframe before the start
+func clipArgs(queue *TaskQueue) []string {
+	start, end := queue.clipRange()
+	args := []string{
+		"-hide_banner",
+		"-ss",
+		fmt.Sprintf("%.3f", start.Seconds()),
+		"-i",
+		queue.InputPath,
+		"-t",
+		fmt.Sprintf("%.3f", (end - start).Seconds()),
+		"-map",
+		"0",
+		"-c",
+		"copy",
+		"-avoid_negative_ts",
+		"make_zero",
+	}
+	if queue.OutputFormat == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, queue.OutputPath)
+}
+
+// writeConcatList writes the inputs as a playlist of the concat demuxer, which corrects the timestamps at each boundary
+func writeConcatList(inputPaths []string) (string, error) {
+	f, err := os.CreateTemp("", "bilirec-merge-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	for _, path := range inputPaths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			os.Remove(f.Name())
+			return "", err
 		}
-		return os.Remove(queue.InputPath)
-	})
+		if _, err := fmt.Fprintf(f, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`)); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
+
+func mergeArgs(list string, queue *TaskQueue) []string {
+	args := []string{
+		"-hide_banner",
+		"-f",
+		"concat",
+		"-safe",
+		"0",
+		"-i",
+		list,
+		"-map",
+		"0",
+		"-c",
+		"copy",
+	}
+	if queue.OutputFormat == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, queue.OutputPath)
 }
@@ -0,0 +1,376 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/db"
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/mp4"
+	"github.com/eric2788/bilirec/pkg/pool"
+	"github.com/eric2788/bilirec/utils"
+	"github.com/puzpuzpuz/xsync/v4"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	ProviderNative Provider = "native"
+
+	nativeBucket = "Queue_Native"
+)
+
+var ErrUnsupportedFormat = errors.New("native convert manager only supports flv files")
+
+// nativeConvertManager remuxes FLV into faststart MP4 in pure Go, used when ffmpeg is not installed
+type nativeConvertManager struct {
+	bucket     *db.Bucket
+	logger     *logrus.Entry
+	serializer *pool.Serializer
+	getActives GetActiveRecordings
+	paused     func() bool
+	finished   FinishedListener
+
+	processing *xsync.Map[string, context.CancelFunc]
+}
+
+func newNativeConvertManager(getActives GetActiveRecordings, paused func() bool, finished FinishedListener) ConvertManager {
+	return &nativeConvertManager{
+		logger:     logger.WithField("manager", "native"),
+		serializer: pool.NewSerializer(),
+		getActives: getActives,
+		paused:     paused,
+		finished:   finished,
+		processing: xsync.NewMap[string, context.CancelFunc](),
+	}
+}
+
+func (n *nativeConvertManager) StartWorker(ctx context.Context, db *db.Client) error {
+	bucket, err := db.Bucket(nativeBucket)
+	if err != nil {
+		return err
+	}
+	n.bucket = bucket
+	go n.runTaskPeriodically(ctx)
+	return nil
+}
+
+func (n *nativeConvertManager) Enqueue(inputPath, outputPath, format string, deleteSource bool) (*TaskQueue, error) {
+	if utils.GetPathFormat(inputPath) != "flv" || format != "mp4" {
+		return nil, ErrUnsupportedFormat
+	}
+	uuid, err := utils.NewUUIDv4()
+	if err != nil {
+		return nil, err
+	}
+	queue := &TaskQueue{
+		Type:         TaskTypeConvert,
+		Provider:     ProviderNative,
+		TaskID:       uuid,
+		InputPath:    inputPath,
+		OutputPath:   outputPath,
+		InputFormat:  utils.GetPathFormat(inputPath),
+		OutputFormat: format,
+		DeleteSource: deleteSource,
+	}
+	data, err := n.serializer.Serialize(queue)
+	if err != nil {
+		return nil, err
+	}
+	err = n.bucket.Put([]byte(uuid), data)
+	return queue, err
+}
+
+func (n *nativeConvertManager) EnqueueClip(inputPath, outputPath string, start, end time.Duration) (*TaskQueue, error) {
+	if utils.GetPathFormat(inputPath) != "flv" {
+		return nil, ErrUnsupportedFormat
+	}
+	uuid, err := utils.NewUUIDv4()
+	if err != nil {
+		return nil, err
+	}
+	queue := &TaskQueue{
+		Type:         TaskTypeClip,
+		Provider:     ProviderNative,
+		TaskID:       uuid,
+		InputPath:    inputPath,
+		OutputPath:   outputPath,
+		InputFormat:  "flv",
+		OutputFormat: "flv",
+		ClipStart:    start.Milliseconds(),
+		ClipEnd:      end.Milliseconds(),
+	}
+	data, err := n.serializer.Serialize(queue)
+	if err != nil {
+		return nil, err
+	}
+	err = n.bucket.Put([]byte(uuid), data)
+	return queue, err
+}
+
+func (n *nativeConvertManager) EnqueueMerge(inputPaths []string, outputPath, format string, deleteSource bool) (*TaskQueue, error) {
+	if format != "flv" && format != "mp4" {
+		return nil, ErrUnsupportedFormat
+	}
+	for _, path := range inputPaths {
+		if utils.GetPathFormat(path) != "flv" {
+			return nil, ErrUnsupportedFormat
+		}
+	}
+	uuid, err := utils.NewUUIDv4()
+	if err != nil {
+		return nil, err
+	}
+	queue := &TaskQueue{
+		Type:         TaskTypeMerge,
+		Provider:     ProviderNative,
+		TaskID:       uuid,
+		InputPath:    inputPaths[0],
+		InputPaths:   inputPaths,
+		OutputPath:   outputPath,
+		InputFormat:  "flv",
+		OutputFormat: format,
+		DeleteSource: deleteSource,
+	}
+	data, err := n.serializer.Serialize(queue)
+	if err != nil {
+		return nil, err
+	}
+	err = n.bucket.Put([]byte(uuid), data)
+	return queue, err
+}
+
+func (n *nativeConvertManager) Cancel(taskID string) error {
+	if cancel, ok := n.processing.LoadAndDelete(taskID); ok {
+		cancel()
+	}
+	return n.bucket.Delete([]byte(taskID))
+}
+
+func (n *nativeConvertManager) ListInProgress() ([]*TaskQueue, error) {
+	var queues []*TaskQueue
+	err := n.bucket.ForEach(func(k, v []byte) error {
+		var queue TaskQueue
+		if err := n.serializer.Deserialize(v, &queue); err != nil {
+			return fmt.Errorf("deserialize task %s: %w", string(k), err)
+		}
+		queues = append(queues, &queue)
+		return nil
+	})
+	return queues, err
+}
+
+func (n *nativeConvertManager) runTaskPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n.paused() {
+				n.logger.Debug("conversions paused, skipping native tasks")
+				continue
+			}
+			// remuxing is light on CPU but still competes with recordings for disk I/O
+			actives := n.getActives()
+			if actives > 0 {
+				n.logger.Debugf("active recordings detected (%d), skipping native tasks", actives)
+				continue
+			}
+			var queue *TaskQueue
+			if err := n.bucket.View(func(bucket *bbolt.Bucket) error {
+				k, v := bucket.Cursor().First()
+				if k == nil {
+					return nil
+				}
+				queue = &TaskQueue{}
+				if err := n.serializer.Deserialize(v, queue); err != nil {
+					return fmt.Errorf("deserialize task %s: %w", string(k), err)
+				}
+				return nil
+			}); err != nil {
+				n.logger.Errorf("reading native queue task failed: %v", err)
+				continue
+			} else if queue == nil {
+				continue
+			}
+
+			deleteBucket := func() error {
+				return utils.WithRetry(3, n.logger, "delete bucket", func() error {
+					return n.bucket.Delete([]byte(queue.TaskID))
+				})
+			}
+
+			taskLog := n.logger.WithField("task_id", queue.TaskID)
+
+			if missing := missingSource(queue); missing != "" {
+				taskLog.Warnf("input file %s no longer exists, cancelling task", missing)
+				if err := deleteBucket(); err != nil {
+					taskLog.Errorf("failed to remove native task from queue: %v", err)
+				}
+				continue
+			}
+
+			taskLog.Infof("processing native task input=%s output=%s", queue.InputPath, queue.OutputPath)
+
+			if err := n.processTask(ctx, queue, taskLog); errors.Is(err, context.Canceled) {
+				taskLog.Info("native task cancelled")
+				continue
+			} else if err != nil && !isRetryable(err) {
+				// the same file fails again, it would block the tasks after it
+				taskLog.Errorf("native task failed, removed from queue: %v", err)
+				if err := deleteBucket(); err != nil {
+					taskLog.Errorf("failed to remove native task from queue: %v", err)
+				}
+				if queue.Attempts == 0 {
+					n.finished(queue, err)
+				}
+				continue
+			} else if err != nil {
+				taskLog.Errorf("native task failed: %v", err)
+				// the task is retried on the next tick, only the first failure is reported
+				if countErr := countFailure(n.bucket, n.serializer, queue); countErr != nil {
+					taskLog.Warnf("cannot save the failed attempt: %v", countErr)
+				} else if queue.Attempts == 1 {
+					n.finished(queue, err)
+				}
+				continue
+			}
+
+			if err := deleteBucket(); err != nil {
+				taskLog.Errorf("failed to remove native task from queue: %v", err)
+				continue
+			}
+
+			taskLog.Info("completed and removed from queue")
+			n.finished(queue, nil)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *nativeConvertManager) processTask(ctx context.Context, queue *TaskQueue, taskLog *logrus.Entry) error {
+
+	if utils.IsFileExists(queue.OutputPath) {
+		taskLog.Warnf("output file %s already exists, skipping conversion", queue.OutputPath)
+		return nil
+	}
+
+	processCtx, cancel := context.WithCancel(ctx)
+	n.processing.Store(queue.TaskID, cancel)
+	defer func() {
+		if cancel, ok := n.processing.LoadAndDelete(queue.TaskID); ok {
+			cancel()
+		}
+	}()
+
+	var err error
+	switch queue.Type {
+	case TaskTypeClip:
+		start, end := queue.clipRange()
+		err = clipFile(processCtx, queue.InputPath, queue.OutputPath, start, end)
+	case TaskTypeMerge:
+		err = mergeFile(processCtx, queue.InputPaths, queue.OutputPath)
+	default:
+		err = remuxFile(processCtx, queue.InputPath, queue.OutputPath)
+	}
+	if err != nil && processCtx.Err() != nil {
+		// the task is cancelled
+		return processCtx.Err()
+	} else if err != nil {
+		return err
+	} else if !queue.DeleteSource || queue.InputPath == queue.OutputPath {
+		return nil
+	}
+
+	return removeSources(queue, taskLog)
+}
+
+// isRetryable reports whether a failed native task may succeed later, such as reading or writing
+// the files or the source being changed meanwhile. Other errors come from the content of the files,
+// such as no supported tracks, an unsupported codec or a corrupted or truncated FLV.
+func isRetryable(err error) bool {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	return errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.Is(err, mp4.ErrSourceChanged)
+}
+
+// remuxFile writes the MP4 into a temporary file first, so a cancelled or failed conversion
+// never leaves a partial output which would be skipped as already converted.
+func remuxFile(ctx context.Context, inputPath, outputPath string) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := outputPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := mp4.Remux(ctx, in, info.Size(), out); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	} else if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+// clipFile cuts the range from the keyframe before start, the metadata is rebuilt for the clip before it is renamed
+func clipFile(ctx context.Context, inputPath, outputPath string, start, end time.Duration) error {
+	tmpPath := outputPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := flv.Clip(ctx, inputPath, out, start, end); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	} else if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	} else if err := flv.InjectMetadata(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+// mergeFile concatenates the FLV files into a temporary FLV with its metadata rebuilt,
+// which is remuxed again if the output is MP4.
+func mergeFile(ctx context.Context, inputPaths []string, outputPath string) error {
+	tmpPath := outputPath + ".tmp"
+	if utils.GetPathFormat(outputPath) == "mp4" {
+		tmpPath = outputPath + ".flv.tmp"
+	}
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err := flv.Merge(ctx, inputPaths, out); err != nil {
+		out.Close()
+		return err
+	} else if err := out.Close(); err != nil {
+		return err
+	}
+	if utils.GetPathFormat(outputPath) == "mp4" {
+		return remuxFile(ctx, tmpPath, outputPath)
+	} else if err := flv.InjectMetadata(tmpPath); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
+}
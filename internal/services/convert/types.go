@@ -2,14 +2,27 @@ package convert
 
 import (
 	"context"
+	"time"
 
 	"github.com/eric2788/bilirec/pkg/db"
 )
 
 type Provider string
 
+type TaskType string
+
+const (
+	TaskTypeConvert TaskType = "convert"
+	TaskTypeClip    TaskType = "clip"
+	TaskTypeMerge   TaskType = "merge"
+)
+
 type GetActiveRecordings func() int
 
+// FinishedListener receives the error if the conversion failed, a failed task is reported once
+// even if it is retried later, cancelled tasks are not reported.
+type FinishedListener func(queue *TaskQueue, err error)
+
 type ConvertManager interface {
 	StartWorker(ctx context.Context, db *db.Client) error
 	Enqueue(inputPath, outputPath, format string, deleteSource bool) (*TaskQueue, error)
@@ -17,13 +30,42 @@ type ConvertManager interface {
 	ListInProgress() ([]*TaskQueue, error)
 }
 
+// ClipManager is implemented by the managers which can cut a time range out of a file
+type ClipManager interface {
+	EnqueueClip(inputPath, outputPath string, start, end time.Duration) (*TaskQueue, error)
+}
+
+// MergeManager is implemented by the managers which can concatenate files into one
+type MergeManager interface {
+	EnqueueMerge(inputPaths []string, outputPath, format string, deleteSource bool) (*TaskQueue, error)
+}
+
 type TaskQueue struct {
-	Provider      Provider `json:"provider"` // "ffmpeg" or "cloudconvert"
+	Type          TaskType `json:"type"`     // empty for the tasks queued before clipping was added, which are conversions
+	Provider      Provider `json:"provider"` // "ffmpeg", "native" or "cloudconvert"
 	TaskID        string   `json:"task_id"`
 	ConvertTaskID string   `json:"convert_task_id,omitempty"` // the real convert task id used by the cloudconvert only
 	InputPath     string   `json:"input_path"`
+	InputPaths    []string `json:"input_paths,omitempty"` // the files of a merge task in order, starting with the input path
 	OutputPath    string   `json:"output_path"`
 	InputFormat   string   `json:"input_format"`
 	OutputFormat  string   `json:"output_format"`
 	DeleteSource  bool     `json:"delete_source"`
+	// the time range of a clip task in milliseconds
+	ClipStart int64 `json:"clip_start,omitempty"`
+	ClipEnd   int64 `json:"clip_end,omitempty"`
+	// failed attempts of the task, the failure is only reported on the first one
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// sources returns every input file of the task
+func (q *TaskQueue) sources() []string {
+	if q.Type == TaskTypeMerge {
+		return q.InputPaths
+	}
+	return []string{q.InputPath}
+}
+
+func (q *TaskQueue) clipRange() (start, end time.Duration) {
+	return time.Duration(q.ClipStart) * time.Millisecond, time.Duration(q.ClipEnd) * time.Millisecond
 }
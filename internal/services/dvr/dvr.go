@@ -0,0 +1,242 @@
+package dvr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/eric2788/bilirec/internal/services/recorder"
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/mpegts"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("service", "dvr")
+
+// segments are cut at the first keyframe after this duration
+const segmentDurationMs = 6000
+
+var (
+	ErrSegmentNotFound = errors.New("dvr segment not found")
+	ErrNoFlvFiles      = errors.New("the recording has no FLV files")
+)
+
+// Service repackages the growing FLV files of ongoing recordings into HLS,
+// so the live can be watched from the beginning while it is still recording.
+type Service struct {
+	rec *recorder.Service
+
+	mu       sync.Mutex
+	sessions map[int]*sessionIndex
+}
+
+// sessionIndex caches the keyframe indexes of the files of a recording session
+type sessionIndex struct {
+	id      string
+	mu      sync.Mutex
+	indexes map[string]*flv.Index
+}
+
+// fileSegments is the segments of a FLV file
+type fileSegments struct {
+	path        string
+	spans       []span
+	videoHeader *flv.Tag
+	audioHeader *flv.Tag
+}
+
+// span is the byte range of a segment, starting from a keyframe
+type span struct {
+	start, end int64
+	durationMs int32
+}
+
+func NewService(rec *recorder.Service) *Service {
+	return &Service{
+		rec:      rec,
+		sessions: make(map[int]*sessionIndex),
+	}
+}
+
+// Playlist returns the EVENT playlist of the ongoing recording, which grows as the recording continues
+func (s *Service) Playlist(roomId int) (*Playlist, error) {
+	sessionId, files, err := s.segments(roomId)
+	if err != nil {
+		return nil, err
+	}
+	pl := &Playlist{SessionID: sessionId, TargetDuration: segmentDurationMs / 1000}
+	for i, f := range files {
+		for seq, sp := range f.spans {
+			pl.Segments = append(pl.Segments, Segment{
+				File:          i,
+				Sequence:      seq,
+				Duration:      float64(sp.durationMs) / 1000,
+				Discontinuity: seq == 0 && len(pl.Segments) > 0,
+			})
+			pl.TargetDuration = max(pl.TargetDuration, int(math.Ceil(float64(sp.durationMs)/1000)))
+		}
+	}
+	return pl, nil
+}
+
+// Segment returns the segment of the ongoing recording session, which is written as MPEG-TS
+func (s *Service) Segment(roomId int, sessionId string, file, seq int) (*SegmentSource, error) {
+	id, files, err := s.segments(roomId)
+	if err != nil {
+		return nil, err
+	}
+	if id != sessionId || file < 0 || file >= len(files) || seq < 0 || seq >= len(files[file].spans) {
+		return nil, ErrSegmentNotFound
+	}
+	f := files[file]
+	return &SegmentSource{
+		path:    f.path,
+		span:    f.spans[seq],
+		headers: []*flv.Tag{f.videoHeader, f.audioHeader},
+	}, nil
+}
+
+// segments updates the indexes of the FLV files of the recording session and splits them into segments
+func (s *Service) segments(roomId int) (string, []*fileSegments, error) {
+	rf, err := s.rec.GetRecordingFiles(roomId)
+	if err != nil {
+		return "", nil, err
+	}
+	idx := s.session(roomId, rf.SessionID)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	files := make([]*fileSegments, 0, len(rf.Files))
+	for _, path := range rf.Files {
+		if !strings.EqualFold(filepath.Ext(path), ".flv") {
+			continue
+		}
+		x, ok := idx.indexes[path]
+		if !ok {
+			x = flv.NewIndex(path)
+			idx.indexes[path] = x
+		}
+		if err := x.Update(); errors.Is(err, os.ErrNotExist) {
+			// removed or converted after the file is finalized
+			logger.Debugf("dvr file %s no longer exists, skipped", path)
+		} else if err != nil {
+			logger.Warnf("cannot index dvr file %s: %v", path, err)
+		}
+		// files are kept in place so the file numbers in the playlist stay the same
+		files = append(files, &fileSegments{
+			path:        path,
+			spans:       split(x, path != rf.Writing),
+			videoHeader: x.VideoHeader,
+			audioHeader: x.AudioHeader,
+		})
+	}
+	if len(files) == 0 {
+		return "", nil, ErrNoFlvFiles
+	}
+	return rf.SessionID, files, nil
+}
+
+// session returns the cached indexes of the recording session, the indexes of other sessions
+// and stopped recordings are released.
+func (s *Service) session(roomId int, sessionId string) *sessionIndex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for room, idx := range s.sessions {
+		if room != roomId && s.rec.GetStatus(room) == recorder.Idle {
+			delete(s.sessions, room)
+		} else if room == roomId && idx.id != sessionId {
+			delete(s.sessions, room)
+		}
+	}
+	idx, ok := s.sessions[roomId]
+	if !ok {
+		idx = &sessionIndex{id: sessionId, indexes: make(map[string]*flv.Index)}
+		s.sessions[roomId] = idx
+	}
+	return idx
+}
+
+// split cuts the file at the first keyframe after every segment duration.
+// The tail after the last cut is only a segment once the file is complete,
+// so the segments listed while the file grows never change.
+func split(x *flv.Index, complete bool) []span {
+	keyframes := x.Keyframes
+	if len(keyframes) == 0 {
+		return nil
+	}
+	spans := make([]span, 0, len(keyframes)/2)
+	start := 0
+	for i := 1; i < len(keyframes); i++ {
+		if duration := keyframes[i].Timestamp - keyframes[start].Timestamp; duration >= segmentDurationMs {
+			spans = append(spans, span{start: keyframes[start].Offset, end: keyframes[i].Offset, durationMs: duration})
+			start = i
+		}
+	}
+	if complete && x.End > keyframes[start].Offset {
+		spans = append(spans, span{
+			start:      keyframes[start].Offset,
+			end:        x.End,
+			durationMs: max(x.LastTimestamp-keyframes[start].Timestamp, 0),
+		})
+	}
+	return spans
+}
+
+// SegmentSource is a segment to be repackaged from the FLV file
+type SegmentSource struct {
+	path    string
+	span    span
+	headers []*flv.Tag
+}
+
+// WriteTo writes the segment as MPEG-TS, starting with the sequence headers of the file
+func (src *SegmentSource) WriteTo(w io.Writer) (int64, error) {
+	f, err := os.Open(src.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(src.span.start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	cw := &countingWriter{w: w}
+	muxer := mpegts.NewMuxer(cw)
+	for _, header := range src.headers {
+		if header == nil {
+			continue
+		}
+		if err := muxer.WriteTag(header); err != nil {
+			return cw.n, err
+		}
+	}
+	r := flv.NewTagReaderAt(io.LimitReader(f, src.span.end-src.span.start), src.span.start)
+	for r.Offset() < src.span.end {
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return cw.n, err
+		}
+		if err := muxer.WriteTag(tag); err != nil {
+			return cw.n, fmt.Errorf("cannot repackage tag at %d: %w", r.Offset(), err)
+		}
+	}
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
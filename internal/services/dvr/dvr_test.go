@@ -0,0 +1,104 @@
+package dvr
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/mpegts"
+)
+
+func TestSplit_StableWhileGrowing(t *testing.T) {
+	x := &flv.Index{End: 10000, LastTimestamp: 15500}
+	// keyframes every 2 seconds
+	for i := range 8 {
+		x.Keyframes = append(x.Keyframes, flv.Keyframe{Timestamp: int32(i * 2000), Offset: int64(100 + i*1000)})
+	}
+
+	growing := split(x, false)
+	if len(growing) != 2 || growing[0] != (span{start: 100, end: 3100, durationMs: 6000}) || growing[1].start != 3100 {
+		t.Fatalf("unexpected segments of growing file: %+v", growing)
+	}
+	complete := split(x, true)
+	if len(complete) != 3 || complete[2] != (span{start: 6100, end: 10000, durationMs: 3500}) {
+		t.Fatalf("unexpected segments of complete file: %+v", complete)
+	}
+	for i := range growing {
+		if growing[i] != complete[i] {
+			t.Errorf("segment %d changed after the file is complete", i)
+		}
+	}
+}
+
+func TestPlaylist_WriteTo(t *testing.T) {
+	pl := &Playlist{
+		SessionID:      "1_20250101_000000",
+		TargetDuration: 7,
+		Segments: []Segment{
+			{File: 0, Sequence: 0, Duration: 6.5},
+			{File: 1, Sequence: 0, Duration: 6, Discontinuity: true},
+		},
+	}
+	var b strings.Builder
+	if _, err := pl.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-TARGETDURATION:7\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXTINF:6.500,\n1_20250101_000000/0/0.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\n1_20250101_000000/1/0.ts\n"
+	if b.String() != expected {
+		t.Errorf("unexpected playlist:\n%s", b.String())
+	}
+	if strings.Contains(b.String(), "#EXT-X-ENDLIST") {
+		t.Error("playlist of ongoing recording must not end")
+	}
+}
+
+func TestSegmentSource_WriteTo(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	videoHeader := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1, 0x00, 0x02, 0x67, 0x64, 0x01, 0x00, 0x02, 0x68, 0xEE}
+	write(flv.TagTypeVideo, 0, videoHeader)
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	for i := range 20 {
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+		}
+		// a single NAL unit with 4 bytes length
+		write(flv.TagTypeVideo, int32(i*400), []byte{frameType, 0x01, 0, 0, 0, 0, 0, 0, 1, 0x41})
+		write(flv.TagTypeAudio, int32(i*400), []byte{0xAF, 0x01, 0x21})
+	}
+	path := filepath.Join(t.TempDir(), "record.flv")
+	if err := os.WriteFile(path, stream.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	x := flv.NewIndex(path)
+	if err := x.Update(); err != nil {
+		t.Fatal(err)
+	}
+	spans := split(x, true)
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 segments, got %+v", spans)
+	}
+	src := &SegmentSource{path: path, span: spans[1], headers: []*flv.Tag{x.VideoHeader, x.AudioHeader}}
+	var out bytes.Buffer
+	n, err := src.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(out.Len()) || out.Len()%mpegts.PacketSize != 0 || out.Len() == 0 {
+		t.Fatalf("unexpected segment size %d, written %d", out.Len(), n)
+	}
+	// program tables, then the keyframe with the random access indicator
+	if out.Bytes()[2*mpegts.PacketSize+5]&0x40 == 0 {
+		t.Error("segment should start with a keyframe")
+	}
+}
@@ -0,0 +1,47 @@
+package dvr
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Playlist is the segments of an ongoing recording session
+type Playlist struct {
+	SessionID      string
+	TargetDuration int
+	Segments       []Segment
+}
+
+// Segment is a segment of the playlist, identified by the file number in the session
+// and its sequence in the file
+type Segment struct {
+	File          int
+	Sequence      int
+	Duration      float64
+	Discontinuity bool
+}
+
+// URI returns the segment path relative to the playlist
+func (s Segment) URI(sessionId string) string {
+	return fmt.Sprintf("%s/%d/%d.ts", sessionId, s.File, s.Sequence)
+}
+
+// WriteTo writes the playlist as an EVENT m3u8, which has no end as the recording continues
+func (p *Playlist) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", p.TargetDuration)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for _, seg := range p.Segments {
+		if seg.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.Duration, seg.URI(p.SessionID))
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
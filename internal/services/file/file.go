@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/services/path"
@@ -23,6 +24,9 @@ type Service struct {
 	ctx context.Context
 
 	path *path.Service
+
+	remuxMu    sync.Mutex
+	remuxCache map[string]*remuxLayout
 }
 
 type Tree struct {
@@ -37,9 +41,10 @@ func NewService(ls fx.Lifecycle, cfg *config.Config, pathSvc *path.Service) *Ser
 	ctx, cancel := context.WithCancel(context.Background())
 
 	s := &Service{
-		cfg:  cfg,
-		ctx:  ctx,
-		path: pathSvc,
+		cfg:        cfg,
+		ctx:        ctx,
+		path:       pathSvc,
+		remuxCache: make(map[string]*remuxLayout),
 	}
 
 	ls.Append(fx.StopHook(cancel))
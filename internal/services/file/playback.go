@@ -2,14 +2,23 @@ package file
 
 import (
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/mp4"
 )
 
+// the layouts of recently played FLV files, which are scanned again only when the file changes
+const maxRemuxCache = 16
+
 var ErrUnsupportedPlaybackMedia = errors.New("unsupported playback media")
 
 // OpenForPlayback validates a relative path and returns an absolute path plus MIME type.
+// FLV files are played as MP4 through OpenRemuxed.
 func (s *Service) OpenForPlayback(relPath string) (string, string, error) {
 	fullPath, err := s.path.ValidatePath(relPath)
 	if err != nil {
@@ -32,10 +41,87 @@ func (s *Service) OpenForPlayback(relPath string) (string, string, error) {
 	return fullPath, mimeType, nil
 }
 
+// NeedsRemux reports whether the file is played through OpenRemuxed instead of being sent as is
+func NeedsRemux(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".flv"
+}
+
+// RemuxedMedia is a FLV file opened for playback as fragmented MP4
+type RemuxedMedia struct {
+	layout *mp4.FragmentedFile
+	file   *os.File
+}
+
+// Size returns the size of the MP4 output
+func (m *RemuxedMedia) Size() int64 {
+	return m.layout.Size()
+}
+
+// NewReader returns the MP4 output from the offset, which is closed with the media
+func (m *RemuxedMedia) NewReader(offset int64) io.Reader {
+	return m.layout.NewReader(m.file, offset)
+}
+
+func (m *RemuxedMedia) Close() error {
+	return m.file.Close()
+}
+
+type remuxLayout struct {
+	size    int64
+	modTime time.Time
+	layout  *mp4.FragmentedFile
+	used    time.Time
+}
+
+// OpenRemuxed opens a FLV file from OpenForPlayback for playback as fragmented MP4
+func (s *Service) OpenRemuxed(fullPath string) (*RemuxedMedia, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s.remuxMu.Lock()
+	defer s.remuxMu.Unlock()
+	cached, ok := s.remuxCache[fullPath]
+	if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+		layout, err := mp4.NewFragmentedFile(f, info.Size())
+		if err != nil {
+			f.Close()
+			if errors.Is(err, mp4.ErrNoTracks) || errors.Is(err, flv.ErrNotFlvFile) {
+				return nil, ErrUnsupportedPlaybackMedia
+			}
+			return nil, err
+		}
+		cached = &remuxLayout{size: info.Size(), modTime: info.ModTime(), layout: layout}
+		s.cacheRemux(fullPath, cached)
+	}
+	cached.used = time.Now()
+	return &RemuxedMedia{layout: cached.layout, file: f}, nil
+}
+
+// cacheRemux stores the layout and evicts the least recently used one when full
+func (s *Service) cacheRemux(fullPath string, layout *remuxLayout) {
+	if _, ok := s.remuxCache[fullPath]; !ok && len(s.remuxCache) >= maxRemuxCache {
+		var oldest string
+		for path, cached := range s.remuxCache {
+			if oldest == "" || cached.used.Before(s.remuxCache[oldest].used) {
+				oldest = path
+			}
+		}
+		delete(s.remuxCache, oldest)
+	}
+	s.remuxCache[fullPath] = layout
+}
+
 func inferPlaybackMIME(path string) (string, error) {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
-	case ".mp4":
+	case ".mp4", ".flv":
 		return "video/mp4", nil
 	default:
 		return "", ErrUnsupportedPlaybackMedia
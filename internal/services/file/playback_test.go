@@ -9,6 +9,7 @@ import (
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/services/file"
 	"github.com/eric2788/bilirec/internal/services/path"
+	"github.com/eric2788/bilirec/pkg/flv"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxtest"
 )
@@ -48,9 +49,9 @@ func TestOpenForPlaybackUnsupportedMedia(t *testing.T) {
 	tempDir := t.TempDir()
 	os.Setenv("OUTPUT_DIR", tempDir)
 
-	filePath := filepath.Join(tempDir, "test.flv")
+	filePath := filepath.Join(tempDir, "test.mkv")
 	if err := os.WriteFile(filePath, []byte("123"), 0644); err != nil {
-		t.Fatalf("failed to create flv test file: %v", err)
+		t.Fatalf("failed to create mkv test file: %v", err)
 	}
 
 	var svc *file.Service
@@ -63,12 +64,44 @@ func TestOpenForPlaybackUnsupportedMedia(t *testing.T) {
 	app.RequireStart()
 	defer app.RequireStop()
 
-	_, _, err := svc.OpenForPlayback("test.flv")
+	_, _, err := svc.OpenForPlayback("test.mkv")
 	if !errors.Is(err, file.ErrUnsupportedPlaybackMedia) {
 		t.Fatalf("expected ErrUnsupportedPlaybackMedia, got %v", err)
 	}
 }
 
+func TestOpenForPlaybackFLV(t *testing.T) {
+	tempDir := t.TempDir()
+	os.Setenv("OUTPUT_DIR", tempDir)
+
+	// a FLV file without any audio or video track
+	data := append(append([]byte{}, flv.FlvHeader...), 0, 0, 0, 0)
+	if err := os.WriteFile(filepath.Join(tempDir, "test.flv"), data, 0644); err != nil {
+		t.Fatalf("failed to create flv test file: %v", err)
+	}
+
+	var svc *file.Service
+	app := fxtest.New(t,
+		config.Module,
+		fx.Provide(path.NewService),
+		fx.Provide(file.NewService),
+		fx.Populate(&svc),
+	)
+	app.RequireStart()
+	defer app.RequireStop()
+
+	fullPath, mimeType, err := svc.OpenForPlayback("test.flv")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if mimeType != "video/mp4" || !file.NeedsRemux(fullPath) {
+		t.Fatalf("expected FLV to be remuxed into video/mp4, got %s", mimeType)
+	}
+	if _, err := svc.OpenRemuxed(fullPath); !errors.Is(err, file.ErrUnsupportedPlaybackMedia) {
+		t.Fatalf("expected ErrUnsupportedPlaybackMedia, got %v", err)
+	}
+}
+
 func TestOpenForPlaybackDirectory(t *testing.T) {
 	tempDir := t.TempDir()
 	os.Setenv("OUTPUT_DIR", tempDir)
@@ -0,0 +1,181 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eric2788/bilirec/utils"
+)
+
+// output of commands and response bodies kept in the delivery log
+const maxOutputLength = 1024
+
+// delay before the second attempt, doubled on every retry
+var retryBackoff = 5 * time.Second
+
+var httpClient = &http.Client{}
+
+// permanentError is not retried, such as rejected webhook requests
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Sign returns the X-Bilirec-Signature header value of the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) deliver(hook *Definition, payload *Payload) {
+	l := logger.WithField("hook", hook.Name).WithField("event", payload.Event)
+
+	id, err := utils.NewUUIDv4()
+	if err != nil {
+		l.Errorf("cannot generate delivery id: %v", err)
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		l.Errorf("cannot encode payload: %v", err)
+		return
+	}
+
+	delivery := &Delivery{
+		ID:        id,
+		Hook:      hook.Name,
+		Event:     payload.Event,
+		RoomID:    payload.RoomID,
+		StartTime: time.Now().Unix(),
+	}
+	backoff := retryBackoff
+	for attempt := 1; attempt <= hook.maxAttempts(); attempt++ {
+		delivery.Attempts = attempt
+		if len(hook.Command) > 0 {
+			err = s.runCommand(hook, id, payload, body, delivery)
+		} else {
+			err = s.postWebhook(hook, id, payload, body, delivery)
+		}
+		if err == nil {
+			delivery.Success = true
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		l.Warnf("attempt #%d failed: %v", attempt, err)
+		if _, ok := err.(*permanentError); ok || attempt == hook.maxAttempts() {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+			backoff *= 2
+			continue
+		case <-s.ctx.Done():
+			timer.Stop()
+		}
+		break
+	}
+	delivery.EndTime = time.Now().Unix()
+
+	if delivery.Success {
+		l.Debugf("delivered in %d attempts", delivery.Attempts)
+	} else {
+		l.Errorf("delivery failed after %d attempts: %s", delivery.Attempts, delivery.Error)
+	}
+	s.saveDelivery(delivery)
+}
+
+func (s *Service) runCommand(hook *Definition, id string, payload *Payload, body []byte, delivery *Delivery) error {
+	ctx, cancel := context.WithTimeout(s.ctx, hook.timeout())
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = append(os.Environ(), commandEnv(id, payload)...)
+
+	err := cmd.Run()
+	delivery.Output = utils.TruncateString(output.String(), maxOutputLength)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out after %v", hook.timeout())
+	}
+	return err
+}
+
+// commandEnv exposes the main fields of the payload for scripts which do not parse JSON
+func commandEnv(id string, payload *Payload) []string {
+	return []string{
+		"BILIREC_DELIVERY_ID=" + id,
+		"BILIREC_EVENT=" + string(payload.Event),
+		"BILIREC_TIMESTAMP=" + strconv.FormatInt(payload.Timestamp, 10),
+		"BILIREC_ROOM_ID=" + strconv.Itoa(payload.RoomID),
+		"BILIREC_SESSION_ID=" + payload.SessionID,
+		"BILIREC_UNAME=" + payload.Uname,
+		"BILIREC_TITLE=" + payload.Title,
+		"BILIREC_AREA=" + payload.Area,
+		"BILIREC_FILE_PATH=" + payload.FilePath,
+		"BILIREC_OUTPUT_PATH=" + payload.OutputPath,
+		"BILIREC_FILES=" + strings.Join(payload.Files, string(os.PathListSeparator)),
+		"BILIREC_STOP_REASON=" + payload.StopReason,
+	}
+}
+
+func (s *Service) postWebhook(hook *Definition, id string, payload *Payload, body []byte, delivery *Delivery) error {
+	ctx, cancel := context.WithTimeout(s.ctx, hook.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bilirec-hook")
+	req.Header.Set("X-Bilirec-Event", string(payload.Event))
+	req.Header.Set("X-Bilirec-Delivery", id)
+	if hook.Secret != "" {
+		req.Header.Set("X-Bilirec-Signature", Sign(hook.Secret, body))
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(res.Body, maxOutputLength*4))
+	delivery.StatusCode = res.StatusCode
+	delivery.Output = utils.TruncateString(string(response), maxOutputLength)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+	// client errors will not succeed by retrying, except timeouts and rate limits
+	if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
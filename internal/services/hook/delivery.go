@@ -0,0 +1,106 @@
+package hook
+
+import (
+	"fmt"
+
+	"github.com/eric2788/bilirec/pkg/pool"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	deliveryBucket = "Hook_Deliveries"
+	// older deliveries are removed once exceeded
+	maxDeliveries = 1000
+)
+
+// Delivery is the result of running a hook for an event, including all retries
+type Delivery struct {
+	ID       string `json:"id"`
+	Hook     string `json:"hook"`
+	Event    Event  `json:"event"`
+	RoomID   int    `json:"room_id,omitempty"`
+	Attempts int    `json:"attempts"`
+	Success  bool   `json:"success"`
+	// status code of the last webhook response
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// output of the command or the webhook response body, truncated
+	Output    string `json:"output,omitempty"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+}
+
+type DeliveryPage struct {
+	Entries []*Delivery `json:"entries"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	Size    int         `json:"size"`
+}
+
+var deliverySerializer = pool.NewSerializer()
+
+// keys are ordered by the end time, the id prevents collisions of concurrent deliveries
+func deliveryKey(d *Delivery) []byte {
+	return fmt.Appendf(nil, "%019d_%s", d.EndTime, d.ID)
+}
+
+func (s *Service) saveDelivery(d *Delivery) {
+	if s.deliveries == nil {
+		return
+	}
+	data, err := deliverySerializer.Serialize(d)
+	if err != nil {
+		logger.Errorf("cannot serialize hook delivery: %v", err)
+		return
+	}
+	err = s.deliveries.Update(func(bucket *bbolt.Bucket) error {
+		if err := bucket.Put(deliveryKey(d), data); err != nil {
+			return err
+		}
+		expired := make([][]byte, 0)
+		c := bucket.Cursor()
+		count := 0
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if count++; count > maxDeliveries {
+				expired = append(expired, k)
+			}
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("cannot save hook delivery: %v", err)
+	}
+}
+
+// ListDeliveries returns the deliveries from newest to oldest,
+// an empty hook name lists the deliveries of all hooks. page starts from 1.
+func (s *Service) ListDeliveries(hook string, page, size int) (*DeliveryPage, error) {
+	result := &DeliveryPage{Entries: make([]*Delivery, 0, size), Page: page, Size: size}
+	if s.deliveries == nil {
+		return result, nil
+	}
+	skip := (page - 1) * size
+	err := s.deliveries.View(func(bucket *bbolt.Bucket) error {
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var entry Delivery
+			if err := deliverySerializer.Deserialize(v, &entry); err != nil {
+				logger.Warnf("error scaning hook deliveries: %s: %v, ignored.", string(k), err)
+				continue
+			} else if hook != "" && entry.Hook != hook {
+				continue
+			}
+			if result.Total >= skip && len(result.Entries) < size {
+				result.Entries = append(result.Entries, &entry)
+			}
+			result.Total++
+		}
+		return nil
+	})
+	return result, err
+}
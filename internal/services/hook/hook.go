@@ -0,0 +1,288 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/internal/services/convert"
+	"github.com/eric2788/bilirec/pkg/db"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+var logger = logrus.WithField("service", "hook")
+
+var ErrInvalidHook = errors.New("invalid hook definition")
+
+type Event string
+
+const (
+	EventSegmentFinalized Event = "segment_finalized"
+	EventSessionFinalized Event = "session_finalized"
+	EventConvertFinished  Event = "convert_finished"
+	EventLiveStarted      Event = "live_started"
+	EventLiveEnded        Event = "live_ended"
+)
+
+var events = []Event{
+	EventSegmentFinalized,
+	EventSessionFinalized,
+	EventConvertFinished,
+	EventLiveStarted,
+	EventLiveEnded,
+}
+
+const (
+	defaultTimeout     = 30 * time.Second
+	defaultMaxAttempts = 3
+)
+
+// Definition is a hook of the hooks file, either a command or a webhook
+type Definition struct {
+	Name string `json:"name"`
+	// empty to receive all events
+	Events []Event `json:"events"`
+	// empty to receive the events of all rooms
+	Rooms []int `json:"rooms"`
+
+	// the command and its arguments, the payload is written to stdin
+	Command []string `json:"command,omitempty"`
+
+	// the url to post the payload to
+	URL     string            `json:"url,omitempty"`
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// attempts including the first one, 1 to disable retries
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// Info is a hook without its secret and headers
+type Info struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // "command" or "webhook"
+	Events  []Event  `json:"events"`
+	Rooms   []int    `json:"rooms"`
+	Command []string `json:"command,omitempty"`
+	URL     string   `json:"url,omitempty"`
+}
+
+// Payload is the JSON body sent to the hooks, fields are omitted if not related to the event
+type Payload struct {
+	Event     Event  `json:"event"`
+	Timestamp int64  `json:"timestamp"`
+	RoomID    int    `json:"room_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Uname     string `json:"uname,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Area      string `json:"area,omitempty"`
+	// the finalized segment or the source file of the conversion
+	FilePath   string `json:"file_path,omitempty"`
+	OutputPath string `json:"output_path,omitempty"`
+	// all segments of the finalized session
+	Files        []string `json:"files,omitempty"`
+	StartTime    int64    `json:"start_time,omitempty"`
+	EndTime      int64    `json:"end_time,omitempty"`
+	BytesWritten uint64   `json:"bytes_written,omitempty"`
+	StopReason   string   `json:"stop_reason,omitempty"`
+}
+
+type hooksFile struct {
+	Hooks []*Definition `json:"hooks"`
+}
+
+type Service struct {
+	hooks      []*Definition
+	deliveries *db.Bucket
+
+	mu     sync.RWMutex
+	closed bool
+	tasks  sync.WaitGroup
+
+	cfg    *config.Config
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(lc fx.Lifecycle, cfg *config.Config, cv *convert.Service) (*Service, error) {
+	hooks, err := LoadHooks(cfg.HooksFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load HOOKS_FILE: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		hooks:  hooks,
+		cfg:    cfg,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if len(hooks) == 0 {
+		logger.Debug("no hooks configured, hooks disabled")
+		return s, nil
+	}
+	logger.Infof("%d hooks loaded from %s", len(hooks), cfg.HooksFile)
+
+	cv.OnFinished(s.convertFinished)
+	lc.Append(fx.StartStopHook(s.openDatabase, s.stop))
+	return s, nil
+}
+
+// LoadHooks reads the hooks file, an empty path returns no hooks
+func LoadHooks(path string) ([]*Definition, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file hooksFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, hook := range file.Hooks {
+		if err := hook.validate(); err != nil {
+			return nil, fmt.Errorf("hook #%d: %w", i+1, err)
+		} else if names[hook.Name] {
+			return nil, fmt.Errorf("%w: duplicated name %q", ErrInvalidHook, hook.Name)
+		}
+		names[hook.Name] = true
+	}
+	return file.Hooks, nil
+}
+
+func (d *Definition) validate() error {
+	if d == nil || d.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidHook)
+	} else if (len(d.Command) == 0) == (d.URL == "") {
+		return fmt.Errorf("%w: %s must have either command or url", ErrInvalidHook, d.Name)
+	} else if d.TimeoutSeconds < 0 || d.MaxAttempts < 0 {
+		return fmt.Errorf("%w: %s has negative timeout or attempts", ErrInvalidHook, d.Name)
+	}
+	for _, event := range d.Events {
+		if !slices.Contains(events, event) {
+			return fmt.Errorf("%w: %s has unknown event %q", ErrInvalidHook, d.Name, event)
+		}
+	}
+	return nil
+}
+
+func (d *Definition) matches(payload *Payload) bool {
+	return (len(d.Events) == 0 || slices.Contains(d.Events, payload.Event)) &&
+		(len(d.Rooms) == 0 || slices.Contains(d.Rooms, payload.RoomID))
+}
+
+func (d *Definition) timeout() time.Duration {
+	if d.TimeoutSeconds == 0 {
+		return defaultTimeout
+	}
+	return time.Duration(d.TimeoutSeconds) * time.Second
+}
+
+func (d *Definition) maxAttempts() int {
+	if d.MaxAttempts == 0 {
+		return defaultMaxAttempts
+	}
+	return d.MaxAttempts
+}
+
+// Fire runs every hook subscribed to the event in background
+func (s *Service) Fire(payload *Payload) {
+	if s == nil || len(s.hooks) == 0 {
+		return
+	}
+	if payload.Timestamp == 0 {
+		payload.Timestamp = time.Now().Unix()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		logger.Warnf("service is stopping, %s hooks of room %d are skipped", payload.Event, payload.RoomID)
+		return
+	}
+	for _, hook := range s.hooks {
+		if hook.matches(payload) {
+			s.tasks.Go(func() { s.deliver(hook, payload) })
+		}
+	}
+}
+
+// ListHooks returns the configured hooks without their secrets
+func (s *Service) ListHooks() []*Info {
+	infos := make([]*Info, 0, len(s.hooks))
+	for _, hook := range s.hooks {
+		info := &Info{
+			Name:    hook.Name,
+			Type:    "webhook",
+			Events:  hook.Events,
+			Rooms:   hook.Rooms,
+			Command: hook.Command,
+			URL:     hook.URL,
+		}
+		if len(hook.Command) > 0 {
+			info.Type = "command"
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (s *Service) convertFinished(queue *convert.TaskQueue, err error) {
+	if err != nil {
+		return
+	}
+	s.Fire(&Payload{
+		Event:      EventConvertFinished,
+		FilePath:   queue.InputPath,
+		OutputPath: queue.OutputPath,
+	})
+}
+
+func (s *Service) openDatabase() error {
+	client, err := db.Open(s.cfg.DatabaseDir + string(os.PathSeparator) + "hooks.db")
+	if err != nil {
+		return err
+	}
+	if s.deliveries, err = client.Bucket(deliveryBucket); err != nil {
+		client.Close()
+		return err
+	}
+	return nil
+}
+
+// stop waits for the running hooks until the stop timeout, retries are aborted after that
+func (s *Service) stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("hooks are still running, aborting them")
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+	if s.deliveries == nil {
+		return nil
+	}
+	return s.deliveries.Close()
+}
@@ -0,0 +1,176 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+)
+
+func newServiceForTest(t *testing.T, hooks ...*Definition) *Service {
+	t.Helper()
+	retryBackoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		hooks:  hooks,
+		cfg:    &config.Config{DatabaseDir: t.TempDir()},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if err := s.openDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.stop(context.Background())
+	})
+	return s
+}
+
+// wait waits for the running hooks and returns the deliveries from newest to oldest
+func (s *Service) wait(t *testing.T) []*Delivery {
+	t.Helper()
+	s.tasks.Wait()
+	page, err := s.ListDeliveries("", 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page.Entries
+}
+
+func TestLoadHooks(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "hooks.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	hooks, err := LoadHooks(write(`{"hooks": [
+		{"name": "upload", "events": ["session_finalized"], "command": ["./upload.sh"]},
+		{"name": "notify", "url": "http://localhost/hook", "secret": "s3cret", "rooms": [545068]}
+	]}`))
+	if err != nil || len(hooks) != 2 {
+		t.Fatalf("unexpected hooks %v: %v", hooks, err)
+	}
+	if !hooks[1].matches(&Payload{Event: EventLiveEnded, RoomID: 545068}) || hooks[1].matches(&Payload{Event: EventLiveEnded, RoomID: 1}) {
+		t.Error("hook should only match the events of its rooms")
+	}
+	if hooks[0].matches(&Payload{Event: EventSegmentFinalized}) {
+		t.Error("hook should only match its events")
+	}
+
+	for _, content := range []string{
+		`{"hooks": [{"command": ["true"]}]}`,
+		`{"hooks": [{"name": "both", "command": ["true"], "url": "http://localhost"}]}`,
+		`{"hooks": [{"name": "none"}]}`,
+		`{"hooks": [{"name": "event", "url": "http://localhost", "events": ["unknown"]}]}`,
+		`{"hooks": [{"name": "dup", "url": "http://localhost"}, {"name": "dup", "command": ["true"]}]}`,
+	} {
+		if _, err := LoadHooks(write(content)); !errors.Is(err, ErrInvalidHook) {
+			t.Errorf("expected %s to be invalid, got %v", content, err)
+		}
+	}
+	if hooks, err := LoadHooks(""); err != nil || hooks != nil {
+		t.Errorf("expected no hooks without file, got %v: %v", hooks, err)
+	}
+}
+
+func TestWebhook_SignedAndRetried(t *testing.T) {
+	var requests atomic.Int32
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		if r.Header.Get("X-Bilirec-Event") != string(EventConvertFinished) || r.Header.Get("X-Bilirec-Delivery") == "" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if r.Header.Get("X-Bilirec-Signature") != Sign("s3cret", body) {
+			t.Errorf("unexpected signature %s", r.Header.Get("X-Bilirec-Signature"))
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	s := newServiceForTest(t, &Definition{Name: "webhook", URL: server.URL, Secret: "s3cret"})
+	s.Fire(&Payload{Event: EventConvertFinished, FilePath: "a.flv", OutputPath: "a.mp4"})
+	deliveries := s.wait(t)
+
+	if len(deliveries) != 1 || !deliveries[0].Success || deliveries[0].Attempts != 3 || deliveries[0].StatusCode != 200 {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.OutputPath != "a.mp4" || payload.Timestamp == 0 {
+		t.Errorf("unexpected payload %s: %v", body, err)
+	}
+}
+
+func TestWebhook_ClientErrorNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	s := newServiceForTest(t, &Definition{Name: "webhook", URL: server.URL, MaxAttempts: 5})
+	s.Fire(&Payload{Event: EventLiveStarted, RoomID: 1})
+	deliveries := s.wait(t)
+
+	if requests.Load() != 1 || len(deliveries) != 1 || deliveries[0].Success || deliveries[0].StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected deliveries %+v after %d requests", deliveries, requests.Load())
+	}
+}
+
+func TestCommand_ReceivesPayload(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "payload.json")
+	s := newServiceForTest(t,
+		&Definition{Name: "save", Command: []string{"sh", "-c", `cat > "$0"; echo "$BILIREC_EVENT $BILIREC_ROOM_ID"`, out}},
+		&Definition{Name: "fail", Command: []string{"sh", "-c", "exit 3"}, MaxAttempts: 2},
+		&Definition{Name: "skipped", Command: []string{"true"}, Events: []Event{EventLiveEnded}},
+	)
+	s.Fire(&Payload{Event: EventSessionFinalized, RoomID: 545068, Files: []string{"1.flv", "2.flv"}})
+	deliveries := s.wait(t)
+
+	if len(deliveries) != 2 {
+		t.Fatalf("expected 2 deliveries, got %+v", deliveries)
+	}
+	for _, d := range deliveries {
+		switch d.Hook {
+		case "save":
+			if !d.Success || strings.TrimSpace(d.Output) != "session_finalized 545068" {
+				t.Errorf("unexpected delivery %+v", d)
+			}
+		case "fail":
+			if d.Success || d.Attempts != 2 || d.Error == "" {
+				t.Errorf("unexpected delivery %+v", d)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload Payload
+	if err := json.Unmarshal(data, &payload); err != nil || len(payload.Files) != 2 || payload.RoomID != 545068 {
+		t.Errorf("unexpected payload %s: %v", data, err)
+	}
+
+	if page, _ := s.ListDeliveries("fail", 1, 10); page.Total != 1 || page.Entries[0].Hook != "fail" {
+		t.Errorf("unexpected filtered deliveries %+v", page)
+	}
+}
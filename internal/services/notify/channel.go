@@ -0,0 +1,342 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/pool"
+	"github.com/eric2788/bilirec/utils"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	notifyChannelBucket = "Notify_Channels"
+	channelSendTimeout  = 15 * time.Second
+
+	DefaultChannelTemplate = `{{.Message}}{{if .RoomID}}
+https://live.bilibili.com/{{.RoomID}}{{end}}`
+	DefaultChannelSubject = `[bilirec] {{.Type}}{{if .RoomID}} {{.RoomID}}{{end}}`
+)
+
+var (
+	ErrChannelNotFound = errors.New("notification channel not found")
+	ErrInvalidChannel  = errors.New("invalid notification channel")
+)
+
+type ChannelType string
+
+const (
+	ChannelWebhook  ChannelType = "webhook"
+	ChannelEmail    ChannelType = "email"
+	ChannelDiscord  ChannelType = "discord"
+	ChannelSlack    ChannelType = "slack"
+	ChannelTelegram ChannelType = "telegram"
+)
+
+// Channel is an outbound notification channel, fields not used by its type are ignored
+type Channel struct {
+	ID      string      `json:"id"`
+	Name    string      `json:"name"`
+	Type    ChannelType `json:"type" enums:"webhook,email,discord,slack,telegram"`
+	Enabled bool        `json:"enabled"`
+	// event types to send, empty for all
+	Events []EventType `json:"events"`
+	// rooms to send, empty for all. Events without room such as disk_low are always sent.
+	Rooms []int `json:"rooms"`
+	// text/template of the message executed with the event, empty for the default
+	Template string `json:"template"`
+
+	// webhook, discord and slack
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// email
+	SMTPHost     string   `json:"smtp_host,omitempty"`
+	SMTPPort     int      `json:"smtp_port,omitempty"`
+	SMTPUsername string   `json:"smtp_username,omitempty"`
+	SMTPPassword string   `json:"smtp_password,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+	// text/template of the email subject, empty for the default
+	Subject string `json:"subject,omitempty"`
+
+	// telegram
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+}
+
+// Redacted returns a copy of the channel without the credentials:
+// the SMTP password, bot token, header values and discord or slack webhook url.
+func (c *Channel) Redacted() *Channel {
+	redacted := *c
+	redacted.SMTPPassword = ""
+	redacted.BotToken = ""
+	// the webhook url of discord and slack is the credential itself
+	if c.Type == ChannelDiscord || c.Type == ChannelSlack {
+		redacted.URL = ""
+	}
+	// header names are kept to show which are set
+	if c.Headers != nil {
+		redacted.Headers = make(map[string]string, len(c.Headers))
+		for name := range c.Headers {
+			redacted.Headers[name] = ""
+		}
+	}
+	return &redacted
+}
+
+// outbound is an enabled channel ready to send
+type outbound struct {
+	channel  *Channel
+	notifier Notifier
+	text     *template.Template
+}
+
+var channelSerializer = pool.NewSerializer()
+
+var templateFuncs = template.FuncMap{
+	"formatTime": func(unix int64) string {
+		return time.Unix(unix, 0).Format(time.DateTime)
+	},
+}
+
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(utils.EmptyOrElse(text, fallback))
+}
+
+func render(tmpl *template.Template, event Event) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *Channel) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidChannel)
+	}
+	if _, err := parseTemplate("message", c.Template, DefaultChannelTemplate); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidChannel, err)
+	}
+	switch c.Type {
+	case ChannelWebhook, ChannelDiscord, ChannelSlack:
+		if c.URL == "" {
+			return fmt.Errorf("%w: url is required", ErrInvalidChannel)
+		}
+	case ChannelEmail:
+		if c.SMTPHost == "" || c.SMTPPort <= 0 || c.From == "" || len(c.To) == 0 {
+			return fmt.Errorf("%w: smtp host, port, from and to are required", ErrInvalidChannel)
+		} else if _, err := parseTemplate("subject", c.Subject, DefaultChannelSubject); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidChannel, err)
+		}
+	case ChannelTelegram:
+		if c.BotToken == "" || c.ChatID == "" {
+			return fmt.Errorf("%w: bot token and chat id are required", ErrInvalidChannel)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidChannel, c.Type)
+	}
+	return nil
+}
+
+func (c *Channel) matches(event Event) bool {
+	return (len(c.Events) == 0 || slices.Contains(c.Events, event.Type)) &&
+		(len(c.Rooms) == 0 || event.RoomID == 0 || slices.Contains(c.Rooms, event.RoomID))
+}
+
+func newOutbound(c *Channel) (*outbound, error) {
+	text, err := parseTemplate("message", c.Template, DefaultChannelTemplate)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := newNotifier(c)
+	if err != nil {
+		return nil, err
+	}
+	return &outbound{channel: c, notifier: notifier, text: text}, nil
+}
+
+func (o *outbound) send(ctx context.Context, event Event) error {
+	text, err := render(o.text, event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, channelSendTimeout)
+	defer cancel()
+	return o.notifier.Notify(ctx, event, text)
+}
+
+// dispatch sends the event to every enabled channel routed to it in background
+func (s *Service) dispatch(event Event) {
+	s.outboundsMu.RLock()
+	channels := s.outbounds
+	s.outboundsMu.RUnlock()
+
+	for _, o := range channels {
+		if !o.channel.matches(event) {
+			continue
+		}
+		go func() {
+			if err := o.send(s.ctx, event); err != nil {
+				logger.Warnf("cannot send %s notification to channel %s: %v", event.Type, o.channel.Name, err)
+			}
+		}()
+	}
+}
+
+// reloadChannels rebuilds the enabled channels after they are changed
+func (s *Service) reloadChannels() error {
+	channels, err := s.loadChannels()
+	if err != nil {
+		return err
+	}
+	outbounds := make([]*outbound, 0, len(channels))
+	for _, c := range channels {
+		if !c.Enabled {
+			continue
+		}
+		o, err := newOutbound(c)
+		if err != nil {
+			logger.Warnf("cannot load notification channel %s: %v, skipped", c.Name, err)
+			continue
+		}
+		outbounds = append(outbounds, o)
+	}
+	s.outboundsMu.Lock()
+	s.outbounds = outbounds
+	s.outboundsMu.Unlock()
+	return nil
+}
+
+// ListChannels returns the channels with their credentials redacted
+func (s *Service) ListChannels() ([]*Channel, error) {
+	channels, err := s.loadChannels()
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range channels {
+		channels[i] = c.Redacted()
+	}
+	return channels, nil
+}
+
+func (s *Service) loadChannels() ([]*Channel, error) {
+	channels := make([]*Channel, 0)
+	if s.channels == nil {
+		return channels, nil
+	}
+	err := s.channels.ForEach(func(k, v []byte) error {
+		var c Channel
+		if err := channelSerializer.Deserialize(v, &c); err != nil {
+			logger.Warnf("error scaning notification channel: %s: %v, ignored.", string(k), err)
+			return nil
+		}
+		channels = append(channels, &c)
+		return nil
+	})
+	return channels, err
+}
+
+// CreateChannel saves a new channel with a generated id
+func (s *Service) CreateChannel(c *Channel) error {
+	id, err := utils.NewUUIDv4()
+	if err != nil {
+		return err
+	}
+	c.ID = id
+	return s.putChannel(c, false)
+}
+
+// UpdateChannel replaces the channel, credentials left empty keep their saved values
+// as they are redacted when listed.
+func (s *Service) UpdateChannel(id string, c *Channel) error {
+	saved, err := s.getChannel(id)
+	if err != nil {
+		return err
+	}
+	c.ID = id
+	if c.SMTPPassword == "" {
+		c.SMTPPassword = saved.SMTPPassword
+	}
+	if c.BotToken == "" {
+		c.BotToken = saved.BotToken
+	}
+	if c.URL == "" {
+		c.URL = saved.URL
+	}
+	for name, value := range c.Headers {
+		if value == "" {
+			c.Headers[name] = saved.Headers[name]
+		}
+	}
+	return s.putChannel(c, true)
+}
+
+func (s *Service) getChannel(id string) (*Channel, error) {
+	var c Channel
+	err := s.channels.GetFunc([]byte(id), func(v []byte) error {
+		return channelSerializer.Deserialize(v, &c)
+	})
+	if err != nil {
+		return nil, err
+	} else if c.ID == "" {
+		return nil, ErrChannelNotFound
+	}
+	return &c, nil
+}
+
+func (s *Service) putChannel(c *Channel, exists bool) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	data, err := channelSerializer.Serialize(c)
+	if err != nil {
+		return err
+	}
+	err = s.channels.Update(func(bucket *bbolt.Bucket) error {
+		if exists && bucket.Get([]byte(c.ID)) == nil {
+			return ErrChannelNotFound
+		}
+		return bucket.Put([]byte(c.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+	return s.reloadChannels()
+}
+
+func (s *Service) DeleteChannel(id string) error {
+	err := s.channels.Update(func(bucket *bbolt.Bucket) error {
+		if bucket.Get([]byte(id)) == nil {
+			return ErrChannelNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	return s.reloadChannels()
+}
+
+// TestChannel sends a test notification through the channel even if it is disabled
+func (s *Service) TestChannel(ctx context.Context, id string) error {
+	c, err := s.getChannel(id)
+	if err != nil {
+		return err
+	}
+	o, err := newOutbound(c)
+	if err != nil {
+		return err
+	}
+	return o.send(ctx, Event{
+		Type:      "test",
+		Message:   "這是一則測試通知",
+		Timestamp: time.Now().Unix(),
+	})
+}
@@ -0,0 +1,201 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"go.uber.org/fx/fxtest"
+)
+
+func startServiceForTest(t *testing.T) *Service {
+	t.Helper()
+	lc := fxtest.NewLifecycle(t)
+	svc := NewService(lc, &config.Config{DatabaseDir: t.TempDir()})
+	lc.RequireStart()
+	t.Cleanup(lc.RequireStop)
+	return svc
+}
+
+// newRecorderServer records the JSON bodies posted to each path
+func newRecorderServer(t *testing.T) (*httptest.Server, chan map[string]any) {
+	t.Helper()
+	received := make(chan map[string]any, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{"_path": r.URL.Path}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("cannot decode body: %v", err)
+		}
+		received <- body
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func receive(t *testing.T, ch chan map[string]any) map[string]any {
+	t.Helper()
+	select {
+	case body := <-ch:
+		return body
+	case <-time.After(2 * time.Second):
+		t.Fatal("notification not received")
+		return nil
+	}
+}
+
+func TestChannels_RoutingAndTemplates(t *testing.T) {
+	server, received := newRecorderServer(t)
+	svc := startServiceForTest(t)
+
+	channels := []*Channel{
+		{Name: "discord", Type: ChannelDiscord, Enabled: true, URL: server.URL + "/discord", Rooms: []int{1}},
+		{Name: "slack", Type: ChannelSlack, Enabled: true, URL: server.URL + "/slack", Events: []EventType{EventDiskLow}, Template: "slack: {{.Message}}"},
+		{Name: "disabled", Type: ChannelWebhook, URL: server.URL + "/disabled"},
+	}
+	for _, c := range channels {
+		if err := svc.CreateChannel(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc.PublishLive(2, false)
+	svc.PublishLive(1, true)
+	body := receive(t, received)
+	if body["_path"] != "/discord" || body["content"] != "直播間已開播並已啟動自動錄製\nhttps://live.bilibili.com/1" {
+		t.Errorf("unexpected discord body %v", body)
+	}
+
+	svc.PublishDiskLow(1<<20, 2<<20)
+	got := map[any]map[string]any{}
+	for range 2 {
+		body := receive(t, received)
+		got[body["_path"]] = body
+	}
+	if got["/slack"]["text"] != "slack: 磁碟剩餘空間不足: 剩餘 1 MB, 低於 2 MB" || got["/discord"] == nil {
+		t.Errorf("unexpected notifications %v", got)
+	}
+
+	select {
+	case body := <-received:
+		t.Errorf("unexpected notification %v", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestChannels_CRUD(t *testing.T) {
+	server, received := newRecorderServer(t)
+	svc := startServiceForTest(t)
+
+	webhook := &Channel{Name: "webhook", Type: ChannelWebhook, URL: server.URL}
+	if err := svc.CreateChannel(webhook); err != nil || webhook.ID == "" {
+		t.Fatalf("cannot create channel: %v", err)
+	}
+
+	if err := svc.TestChannel(context.Background(), webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if body := receive(t, received); body["type"] != "test" || body["text"] != "這是一則測試通知" {
+		t.Errorf("unexpected webhook body %v", body)
+	}
+
+	for _, invalid := range []*Channel{
+		{Name: "no url", Type: ChannelWebhook},
+		{Name: "no chat", Type: ChannelTelegram, BotToken: "token"},
+		{Name: "no recipients", Type: ChannelEmail, SMTPHost: "localhost", SMTPPort: 25, From: "a@localhost"},
+		{Name: "bad template", Type: ChannelSlack, URL: server.URL, Template: "{{.Message"},
+		{Name: "unknown", Type: "sms"},
+		{Type: ChannelWebhook, URL: server.URL},
+	} {
+		if err := svc.CreateChannel(invalid); !errors.Is(err, ErrInvalidChannel) {
+			t.Errorf("expected %q to be invalid, got %v", invalid.Name, err)
+		}
+	}
+
+	webhook.Enabled = true
+	if err := svc.UpdateChannel(webhook.ID, webhook); err != nil {
+		t.Fatal(err)
+	} else if err := svc.UpdateChannel("missing", &Channel{Name: "missing", Type: ChannelWebhook, URL: server.URL}); !errors.Is(err, ErrChannelNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if channels, _ := svc.ListChannels(); len(channels) != 1 || !channels[0].Enabled || len(svc.outbounds) != 1 {
+		t.Errorf("unexpected channels %v", channels)
+	}
+
+	if err := svc.DeleteChannel(webhook.ID); err != nil {
+		t.Fatal(err)
+	} else if err := svc.DeleteChannel(webhook.ID); !errors.Is(err, ErrChannelNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if len(svc.outbounds) != 0 {
+		t.Error("deleted channel should not receive notifications")
+	}
+}
+
+func TestChannels_CredentialsRedacted(t *testing.T) {
+	svc := startServiceForTest(t)
+
+	telegram := &Channel{Name: "telegram", Type: ChannelTelegram, BotToken: "123:abc", ChatID: "-100"}
+	email := &Channel{Name: "email", Type: ChannelEmail, SMTPHost: "localhost", SMTPPort: 25, SMTPPassword: "secret", From: "a@localhost", To: []string{"b@localhost"}}
+	discord := &Channel{Name: "discord", Type: ChannelDiscord, URL: "https://discord.com/api/webhooks/1/token"}
+	webhook := &Channel{Name: "webhook", Type: ChannelWebhook, URL: "https://example.com/hook", Headers: map[string]string{"Authorization": "Bearer token"}}
+	for _, c := range []*Channel{telegram, email, discord, webhook} {
+		if err := svc.CreateChannel(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	channels, err := svc.ListChannels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range channels {
+		if c.BotToken != "" || c.SMTPPassword != "" || c.Headers["Authorization"] != "" {
+			t.Errorf("credentials of %s should be redacted", c.Name)
+		} else if c.Type == ChannelDiscord && c.URL != "" {
+			t.Errorf("discord webhook url should be redacted")
+		} else if c.Type == ChannelWebhook && (c.URL != webhook.URL || len(c.Headers) != 1) {
+			t.Errorf("webhook url and header names should be kept, got %v", c)
+		}
+	}
+
+	// updating with the listed channel keeps the saved credentials
+	for _, c := range channels {
+		c.Enabled = true
+		if err := svc.UpdateChannel(c.ID, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c, _ := svc.getChannel(telegram.ID); c.BotToken != "123:abc" {
+		t.Errorf("bot token should be kept, got %q", c.BotToken)
+	}
+	if c, _ := svc.getChannel(email.ID); c.SMTPPassword != "secret" {
+		t.Errorf("smtp password should be kept, got %q", c.SMTPPassword)
+	}
+	if c, _ := svc.getChannel(discord.ID); c.URL != discord.URL {
+		t.Errorf("discord webhook url should be kept, got %q", c.URL)
+	}
+	if c, _ := svc.getChannel(webhook.ID); c.Headers["Authorization"] != "Bearer token" {
+		t.Errorf("header values should be kept, got %v", c.Headers)
+	}
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	server, received := newRecorderServer(t)
+	telegramAPI = server.URL
+
+	notifier, err := newNotifier(&Channel{Type: ChannelTelegram, BotToken: "123:abc", ChatID: "-100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), Event{}, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if body := receive(t, received); body["_path"] != "/bot123:abc/sendMessage" || body["chat_id"] != "-100" || body["text"] != "hello" {
+		t.Errorf("unexpected telegram request %v", body)
+	}
+}
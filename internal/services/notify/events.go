@@ -0,0 +1,178 @@
+package notify
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventLiveDetected          EventType = "live_detected"
+	EventLiveAutoRecordStarted EventType = "live_auto_record_started"
+	EventLiveEnded             EventType = "live_ended"
+
+	EventRecordingStarted    EventType = "recording_started"
+	EventRecordingStopped    EventType = "recording_stopped"
+	EventRecordingRecovering EventType = "recording_recovering"
+	EventRecordingFailed     EventType = "recording_failed"
+	EventRecordingResumed    EventType = "recording_resumed"
+	EventSegmentFinalized    EventType = "segment_finalized"
+
+	EventConvertEnqueued  EventType = "convert_enqueued"
+	EventConvertCompleted EventType = "convert_completed"
+	EventConvertFailed    EventType = "convert_failed"
+
+	EventDiskLow             EventType = "disk_low"
+	EventCookieRefreshFailed EventType = "cookie_refresh_failed"
+	EventRoomRestricted      EventType = "room_restricted"
+)
+
+// LiveData is the payload of live_detected, live_auto_record_started and live_ended
+type LiveData struct {
+	AutoRecord bool `json:"auto_record"`
+}
+
+// RecordingData is the payload of recording_started and recording_resumed
+type RecordingData struct {
+	SessionID  string `json:"session_id"`
+	Title      string `json:"title"`
+	Uname      string `json:"uname"`
+	Area       string `json:"area"`
+	Origin     string `json:"origin"`
+	Format     string `json:"format"`
+	Codec      string `json:"codec"`
+	Quality    int    `json:"quality"`
+	OutputPath string `json:"output_path"`
+}
+
+// RecordingStoppedData is the payload of recording_stopped and recording_failed
+type RecordingStoppedData struct {
+	SessionID        string   `json:"session_id"`
+	Reason           string   `json:"reason"`
+	BytesWritten     uint64   `json:"bytes_written"`
+	DurationSeconds  int64    `json:"duration_seconds"`
+	RecoveryAttempts int      `json:"recovery_attempts"`
+	Segments         []string `json:"segments"`
+}
+
+// RecordingRecoveringData is the payload of recording_recovering
+type RecordingRecoveringData struct {
+	SessionID string `json:"session_id"`
+	// the file being written when the stream was interrupted
+	OutputPath string `json:"output_path"`
+}
+
+// SegmentData is the payload of segment_finalized
+type SegmentData struct {
+	SessionID string `json:"session_id"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+}
+
+// ConvertData is the payload of convert_enqueued, convert_completed and convert_failed
+type ConvertData struct {
+	TaskID     string `json:"task_id"`
+	Provider   string `json:"provider"`
+	InputPath  string `json:"input_path"`
+	OutputPath string `json:"output_path"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DiskLowData is the payload of disk_low
+type DiskLowData struct {
+	FreeBytes      uint64 `json:"free_bytes"`
+	ThresholdBytes uint64 `json:"threshold_bytes"`
+}
+
+// CookieRefreshFailedData is the payload of cookie_refresh_failed
+type CookieRefreshFailedData struct {
+	Error string `json:"error"`
+}
+
+// RoomRestrictedData is the payload of room_restricted
+type RoomRestrictedData struct {
+	Reason string `json:"reason" enums:"banned,encrypted"`
+}
+
+func (s *Service) publish(eventType EventType, roomID int, message string, data any) {
+	s.Publish(Event{
+		Type:      eventType,
+		RoomID:    roomID,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
+
+func (s *Service) PublishLive(roomID int, autoRecordStarted bool) {
+	if autoRecordStarted {
+		s.publish(EventLiveAutoRecordStarted, roomID, "直播間已開播並已啟動自動錄製", LiveData{AutoRecord: true})
+	} else {
+		s.publish(EventLiveDetected, roomID, "直播間已開播", LiveData{})
+	}
+}
+
+func (s *Service) PublishLiveEnded(roomID int, autoRecord bool) {
+	s.publish(EventLiveEnded, roomID, "直播間已下播", LiveData{AutoRecord: autoRecord})
+}
+
+func (s *Service) PublishRecordingStarted(roomID int, data RecordingData) {
+	s.publish(EventRecordingStarted, roomID, fmt.Sprintf("已開始錄製: %s", data.Title), data)
+}
+
+func (s *Service) PublishRecordingResumed(roomID int, data RecordingData) {
+	s.publish(EventRecordingResumed, roomID, "程序重啟後已恢復錄製", data)
+}
+
+// PublishRecordingStopped publishes recording_failed instead if the recording is stopped by errors
+func (s *Service) PublishRecordingStopped(roomID int, failed bool, data RecordingStoppedData) {
+	if failed {
+		s.publish(EventRecordingFailed, roomID, fmt.Sprintf("錄製失敗: %s", data.Reason), data)
+	} else {
+		s.publish(EventRecordingStopped, roomID, fmt.Sprintf("錄製已停止: %s", data.Reason), data)
+	}
+}
+
+func (s *Service) PublishRecordingRecovering(roomID int, data RecordingRecoveringData) {
+	s.publish(EventRecordingRecovering, roomID, "直播流中斷，正在嘗試恢復錄製", data)
+}
+
+func (s *Service) PublishSegmentFinalized(roomID int, data SegmentData) {
+	s.publish(EventSegmentFinalized, roomID, fmt.Sprintf("錄製檔案已完成: %s", filepath.Base(data.Path)), data)
+}
+
+func (s *Service) PublishConvertEnqueued(data ConvertData) {
+	s.publish(EventConvertEnqueued, 0, fmt.Sprintf("已加入轉換隊列: %s", filepath.Base(data.InputPath)), data)
+}
+
+func (s *Service) PublishConvertCompleted(data ConvertData) {
+	s.publish(EventConvertCompleted, 0, fmt.Sprintf("轉換完成: %s", filepath.Base(data.OutputPath)), data)
+}
+
+func (s *Service) PublishConvertFailed(data ConvertData) {
+	s.publish(EventConvertFailed, 0, fmt.Sprintf("轉換失敗: %s: %s", filepath.Base(data.InputPath), data.Error), data)
+}
+
+func (s *Service) PublishDiskLow(free, threshold uint64) {
+	s.publish(EventDiskLow, 0, fmt.Sprintf("磁碟剩餘空間不足: 剩餘 %d MB, 低於 %d MB", free/1024/1024, threshold/1024/1024), DiskLowData{
+		FreeBytes:      free,
+		ThresholdBytes: threshold,
+	})
+}
+
+func (s *Service) PublishCookieRefreshFailed(err error) {
+	s.publish(EventCookieRefreshFailed, 0, "Bilibili Cookie 刷新失敗，請檢查登入狀態", CookieRefreshFailedData{Error: err.Error()})
+}
+
+// PublishRoomRestricted reason is either banned or encrypted
+func (s *Service) PublishRoomRestricted(roomID int, reason string) {
+	message := "直播間已被封禁"
+	if reason == "encrypted" {
+		message = "直播間已被上鎖"
+	}
+	s.publish(EventRoomRestricted, roomID, message, RoomRestrictedData{Reason: reason})
+}
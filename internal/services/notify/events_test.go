@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvents_StructuredPayload(t *testing.T) {
+	svc := newServiceForTest(t)
+	_, ch, unsubscribe := svc.Subscribe(4)
+	defer unsubscribe()
+
+	svc.PublishConvertFailed(ConvertData{TaskID: "task", InputPath: "records/1/a.flv", Error: "exit status 1"})
+	svc.PublishRoomRestricted(1, "encrypted")
+
+	var events []Event
+	for range 2 {
+		select {
+		case event := <-ch:
+			events = append(events, event)
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("did not receive published event in time")
+		}
+	}
+
+	if data, ok := events[0].Data.(ConvertData); events[0].Type != EventConvertFailed || !ok || data.TaskID != "task" {
+		t.Errorf("unexpected convert failed event %+v", events[0])
+	} else if events[0].Message != "轉換失敗: a.flv: exit status 1" {
+		t.Errorf("unexpected message %q", events[0].Message)
+	}
+	if data, ok := events[1].Data.(RoomRestrictedData); events[1].Type != EventRoomRestricted || !ok || data.Reason != "encrypted" || events[1].RoomID != 1 {
+		t.Errorf("unexpected room restricted event %+v", events[1])
+	}
+
+	body, err := json.Marshal(events[0])
+	if err != nil || !strings.Contains(string(body), `"data":{"task_id":"task"`) {
+		t.Errorf("unexpected json %s: %v", body, err)
+	}
+}
+
+func TestEvents_CookieRefreshFailed(t *testing.T) {
+	svc := newServiceForTest(t)
+	_, ch, unsubscribe := svc.Subscribe(1)
+	defer unsubscribe()
+
+	svc.PublishCookieRefreshFailed(errors.New("refresh token expired"))
+	select {
+	case event := <-ch:
+		if data, ok := event.Data.(CookieRefreshFailedData); event.Type != EventCookieRefreshFailed || !ok || data.Error != "refresh token expired" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("did not receive published event in time")
+	}
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"slices"
+
+	"github.com/eric2788/bilirec/pkg/pool"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	notifyEventBucket = "Notify_Events"
+	// older events are removed once exceeded
+	maxHistoryEvents = 1000
+)
+
+// HistoryFilter filters the event history, zero values match all
+type HistoryFilter struct {
+	RoomID int
+	Types  []EventType
+}
+
+func (f HistoryFilter) matches(event *Event) bool {
+	return (f.RoomID == 0 || event.RoomID == f.RoomID) &&
+		(len(f.Types) == 0 || slices.Contains(f.Types, event.Type))
+}
+
+type EventPage struct {
+	Entries []*Event `json:"entries"`
+	Total   int      `json:"total"`
+	Page    int      `json:"page"`
+	Size    int      `json:"size"`
+}
+
+var eventSerializer = pool.NewSerializer()
+
+func init() {
+	// the payloads are stored as interface values
+	gob.Register(LiveData{})
+	gob.Register(RecordingData{})
+	gob.Register(RecordingStoppedData{})
+	gob.Register(RecordingRecoveringData{})
+	gob.Register(SegmentData{})
+	gob.Register(ConvertData{})
+	gob.Register(DiskLowData{})
+	gob.Register(CookieRefreshFailedData{})
+	gob.Register(RoomRestrictedData{})
+}
+
+func eventKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// persist assigns the next id to the event and saves it into the ring log,
+// the event keeps zero id if the log is not opened or cannot be written.
+func (s *Service) persist(event *Event) {
+	if s.events == nil {
+		return
+	}
+	err := s.events.Update(func(bucket *bbolt.Bucket) error {
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.ID = id
+		data, err := eventSerializer.Serialize(event)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(eventKey(id), data); err != nil {
+			return err
+		}
+		if id <= maxHistoryEvents {
+			return nil
+		}
+		// ids are sequential, so the expired events are the first ones
+		oldest := eventKey(id - maxHistoryEvents)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) <= 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		event.ID = 0
+		logger.Errorf("cannot save %s event into history: %v", event.Type, err)
+	}
+}
+
+// EventsSince returns at most limit events with id greater than the given id, from oldest to newest
+func (s *Service) EventsSince(id uint64, limit int) ([]*Event, error) {
+	events := make([]*Event, 0)
+	if s.events == nil {
+		return events, nil
+	}
+	err := s.events.View(func(bucket *bbolt.Bucket) error {
+		c := bucket.Cursor()
+		for k, v := c.Seek(eventKey(id + 1)); k != nil && len(events) < limit; k, v = c.Next() {
+			var event Event
+			if err := eventSerializer.Deserialize(v, &event); err != nil {
+				logger.Warnf("error scaning event history: %d: %v, ignored.", binary.BigEndian.Uint64(k), err)
+				continue
+			}
+			events = append(events, &event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// ListHistory returns the events matching the filter from newest to oldest. page starts from 1.
+func (s *Service) ListHistory(filter HistoryFilter, page, size int) (*EventPage, error) {
+	result := &EventPage{Entries: make([]*Event, 0, size), Page: page, Size: size}
+	if s.events == nil {
+		return result, nil
+	}
+	skip := (page - 1) * size
+	err := s.events.View(func(bucket *bbolt.Bucket) error {
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var event Event
+			if err := eventSerializer.Deserialize(v, &event); err != nil {
+				logger.Warnf("error scaning event history: %d: %v, ignored.", binary.BigEndian.Uint64(k), err)
+				continue
+			} else if !filter.matches(&event) {
+				continue
+			}
+			if result.Total >= skip && len(result.Entries) < size {
+				result.Entries = append(result.Entries, &event)
+			}
+			result.Total++
+		}
+		return nil
+	})
+	return result, err
+}
+
+// LastEventID returns the id of the latest event in the history
+func (s *Service) LastEventID() uint64 {
+	if s.events == nil {
+		return 0
+	}
+	var id uint64
+	s.events.View(func(bucket *bbolt.Bucket) error {
+		id = bucket.Sequence()
+		return nil
+	})
+	return id
+}
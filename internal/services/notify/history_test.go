@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestHistory_ReplayAndFilter(t *testing.T) {
+	svc := startServiceForTest(t)
+
+	svc.PublishLive(1, false)
+	svc.PublishDiskLow(1<<20, 2<<20)
+	svc.PublishRoomRestricted(2, "banned")
+	svc.PublishLiveEnded(1, true)
+
+	events, err := svc.EventsSince(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].ID != 2 || events[2].ID != 4 || svc.LastEventID() != 4 {
+		t.Fatalf("unexpected events since 1: %+v", events)
+	}
+	if data, ok := events[1].Data.(RoomRestrictedData); !ok || data.Reason != "banned" {
+		t.Errorf("payload should be restored from history, got %#v", events[1].Data)
+	}
+
+	page, err := svc.ListHistory(HistoryFilter{RoomID: 1}, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Entries) != 1 || page.Entries[0].Type != EventLiveEnded {
+		t.Errorf("unexpected room history %+v", page)
+	}
+	page, _ = svc.ListHistory(HistoryFilter{Types: []EventType{EventDiskLow, EventRoomRestricted}}, 2, 1)
+	if page.Total != 2 || len(page.Entries) != 1 || page.Entries[0].Type != EventDiskLow {
+		t.Errorf("unexpected typed history %+v", page)
+	}
+}
+
+func TestHistory_RingLog(t *testing.T) {
+	cfg := &config.Config{DatabaseDir: t.TempDir()}
+	lc := fxtest.NewLifecycle(t)
+	svc := NewService(lc, cfg)
+	lc.RequireStart()
+	for range maxHistoryEvents + 5 {
+		svc.PublishLive(1, false)
+	}
+	lc.RequireStop()
+
+	// ids keep increasing after restart
+	lc = fxtest.NewLifecycle(t)
+	svc = NewService(lc, cfg)
+	lc.RequireStart()
+	defer lc.RequireStop()
+	svc.PublishLive(1, true)
+
+	page, err := svc.ListHistory(HistoryFilter{}, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != maxHistoryEvents || page.Entries[0].ID != maxHistoryEvents+6 {
+		t.Errorf("unexpected history total %d, latest %+v", page.Total, page.Entries[0])
+	}
+	if events, _ := svc.EventsSince(0, 1); len(events) != 1 || events[0].ID != 7 {
+		t.Errorf("oldest events should be removed, got %+v", events)
+	}
+}
@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notifier sends the rendered message of an event to an outbound channel
+type Notifier interface {
+	Notify(ctx context.Context, event Event, text string) error
+}
+
+// base url of the Telegram Bot API
+var telegramAPI = "https://api.telegram.org"
+
+var httpClient = &http.Client{}
+
+func newNotifier(c *Channel) (Notifier, error) {
+	switch c.Type {
+	case ChannelWebhook:
+		return &webhookNotifier{url: c.URL, headers: c.Headers}, nil
+	case ChannelDiscord:
+		return &jsonNotifier{url: c.URL, headers: c.Headers, field: "content"}, nil
+	case ChannelSlack:
+		return &jsonNotifier{url: c.URL, headers: c.Headers, field: "text"}, nil
+	case ChannelTelegram:
+		return &telegramNotifier{token: c.BotToken, chatID: c.ChatID}, nil
+	case ChannelEmail:
+		subject, err := parseTemplate("subject", c.Subject, DefaultChannelSubject)
+		if err != nil {
+			return nil, err
+		}
+		return &emailNotifier{channel: c, subject: subject}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidChannel, c.Type)
+}
+
+func postJSON(ctx context.Context, url string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		response, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, response)
+	}
+	return nil
+}
+
+// webhookNotifier posts the event along with the rendered message
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, event Event, text string) error {
+	return postJSON(ctx, w.url, w.headers, struct {
+		Event
+		Text string `json:"text"`
+	}{event, text})
+}
+
+// jsonNotifier posts the message in a single field, which Discord and Slack webhooks accept
+type jsonNotifier struct {
+	url     string
+	headers map[string]string
+	field   string
+}
+
+func (j *jsonNotifier) Notify(ctx context.Context, event Event, text string) error {
+	return postJSON(ctx, j.url, j.headers, map[string]string{j.field: text})
+}
+
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (t *telegramNotifier) Notify(ctx context.Context, event Event, text string) error {
+	url := fmt.Sprintf("%s/bot%s/sendMessage", telegramAPI, t.token)
+	err := postJSON(ctx, url, nil, map[string]string{"chat_id": t.chatID, "text": text})
+	if err != nil {
+		// the token is part of the url, which must not be logged
+		return fmt.Errorf("telegram: %s", strings.ReplaceAll(err.Error(), t.token, "***"))
+	}
+	return nil
+}
+
+// emailNotifier sends a plain text email, port 465 uses implicit TLS while others upgrade with STARTTLS if supported
+type emailNotifier struct {
+	channel *Channel
+	subject *template.Template
+}
+
+func (e *emailNotifier) Notify(ctx context.Context, event Event, text string) error {
+	subject, err := render(e.subject, event)
+	if err != nil {
+		return err
+	}
+	c := e.channel
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(c.SMTPHost, strconv.Itoa(c.SMTPPort))
+	var conn net.Conn
+	dialer := &net.Dialer{}
+	if c.SMTPPort == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.SMTPHost}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.SMTPHost)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && c.SMTPPort != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: c.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if c.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.SMTPUsername, c.SMTPPassword, c.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
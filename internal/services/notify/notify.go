@@ -1,27 +1,77 @@
 package notify
 
 import (
+	"context"
+	"os"
 	"sync"
-	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/pkg/db"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
 )
 
+var logger = logrus.WithField("service", "notify")
+
 type Event struct {
-	Type      string `json:"type"`
-	RoomID    int    `json:"room_id"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
+	// increasing id in the event history, zero if not saved
+	ID        uint64    `json:"id"`
+	Type      EventType `json:"type"`
+	RoomID    int       `json:"room_id"`
+	Message   string    `json:"message"`
+	Timestamp int64     `json:"timestamp"`
+	// structured payload of the event type, see the *Data types
+	Data any `json:"data,omitempty"`
 }
 
 type Service struct {
 	mu          sync.RWMutex
 	subscribers map[int]chan Event
 	nextID      int
+
+	// keeps the persisted order of events the same as the published order
+	publishMu sync.Mutex
+	events    *db.Bucket
+
+	channels    *db.Bucket
+	outboundsMu sync.RWMutex
+	outbounds   []*outbound
+
+	ctx context.Context
 }
 
-func NewService() *Service {
-	return &Service{
+func NewService(lc fx.Lifecycle, cfg *config.Config) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
 		subscribers: make(map[int]chan Event),
+		ctx:         ctx,
 	}
+
+	lc.Append(fx.StartStopHook(
+		func() error {
+			client, err := db.Open(cfg.DatabaseDir + string(os.PathSeparator) + "notify.db")
+			if err != nil {
+				return err
+			}
+			if s.channels, err = client.Bucket(notifyChannelBucket); err != nil {
+				client.Close()
+				return err
+			}
+			if s.events, err = client.Bucket(notifyEventBucket); err != nil {
+				client.Close()
+				return err
+			}
+			return s.reloadChannels()
+		},
+		func() error {
+			cancel()
+			if s.channels == nil {
+				return nil
+			}
+			return s.channels.Close()
+		},
+	))
+	return s
 }
 
 func (s *Service) Subscribe(buffer int) (int, <-chan Event, func()) {
@@ -48,10 +98,15 @@ func (s *Service) Subscribe(buffer int) (int, <-chan Event, func()) {
 	return id, ch, unsubscribe
 }
 
+// Publish saves the event into the history and sends it to the subscribers and channels.
+// Subscribers too slow miss the event, which can be replayed from the history by its id.
 func (s *Service) Publish(event Event) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.persist(&event)
 
+	s.mu.RLock()
 	for _, ch := range s.subscribers {
 		select {
 		case ch <- event:
@@ -59,20 +114,7 @@ func (s *Service) Publish(event Event) {
 			// Drop when subscriber is too slow.
 		}
 	}
-}
-
-func (s *Service) PublishLive(roomID int, autoRecordStarted bool) {
-	message := "直播間已開播"
-	eventType := "live_detected"
-	if autoRecordStarted {
-		message = "直播間已開播並已啟動自動錄製"
-		eventType = "live_auto_record_started"
-	}
+	s.mu.RUnlock()
 
-	s.Publish(Event{
-		Type:      eventType,
-		RoomID:    roomID,
-		Message:   message,
-		Timestamp: time.Now().Unix(),
-	})
+	s.dispatch(event)
 }
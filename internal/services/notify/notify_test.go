@@ -4,10 +4,18 @@ import (
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"go.uber.org/fx/fxtest"
 )
 
+// newServiceForTest creates a service without opening the channels database
+func newServiceForTest(tb testing.TB) *Service {
+	return NewService(fxtest.NewLifecycle(tb), &config.Config{DatabaseDir: tb.TempDir()})
+}
+
 func TestService_SubscribePublishUnsubscribe(t *testing.T) {
-	svc := NewService()
+	svc := newServiceForTest(t)
 
 	id, ch, unsubscribe := svc.Subscribe(4)
 	if id <= 0 {
@@ -48,7 +56,7 @@ func TestService_SubscribePublishUnsubscribe(t *testing.T) {
 }
 
 func TestService_Subscribe_DefaultBuffer(t *testing.T) {
-	svc := NewService()
+	svc := newServiceForTest(t)
 
 	_, ch, unsubscribe := svc.Subscribe(0)
 	defer unsubscribe()
@@ -59,7 +67,7 @@ func TestService_Subscribe_DefaultBuffer(t *testing.T) {
 }
 
 func TestService_Publish_MultipleSubscribers(t *testing.T) {
-	svc := NewService()
+	svc := newServiceForTest(t)
 
 	_, ch1, unsub1 := svc.Subscribe(2)
 	defer unsub1()
@@ -91,7 +99,7 @@ func TestService_MemoryRisk_SubscribeUnsubscribeCycle(t *testing.T) {
 		t.Skip("skipping memory risk test in short mode")
 	}
 
-	svc := NewService()
+	svc := newServiceForTest(t)
 
 	var before, after runtime.MemStats
 
@@ -126,7 +134,7 @@ func TestService_MemoryRisk_SubscribeUnsubscribeCycle(t *testing.T) {
 }
 
 func BenchmarkService_SubscribeUnsubscribe(b *testing.B) {
-	svc := NewService()
+	svc := newServiceForTest(b)
 	b.ReportAllocs()
 	b.ResetTimer()
 
@@ -137,7 +145,7 @@ func BenchmarkService_SubscribeUnsubscribe(b *testing.B) {
 }
 
 func BenchmarkService_Publish_WithSubscribers(b *testing.B) {
-	svc := NewService()
+	svc := newServiceForTest(b)
 
 	_, ch1, unsub1 := svc.Subscribe(64)
 	defer unsub1()
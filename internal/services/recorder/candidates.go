@@ -0,0 +1,73 @@
+package recorder
+
+import (
+	"context"
+	"slices"
+
+	"github.com/eric2788/bilirec/internal/modules/bilibili"
+	"github.com/eric2788/bilirec/internal/services/subscribe"
+	"github.com/eric2788/bilirec/pkg/fp"
+	"github.com/eric2788/bilirec/pkg/hls"
+)
+
+// formats that can be consumed by the recorder
+var recordableFormats = []string{bilibili.FormatFLV, bilibili.FormatTS, bilibili.FormatFMP4}
+
+// file extensions of the recorded stream formats,
+// fMP4 segments are concatenated into a fragmented mp4 file.
+var fileFormats = map[string]string{
+	bilibili.FormatFLV:  "flv",
+	bilibili.FormatTS:   "ts",
+	bilibili.FormatFMP4: "mp4",
+}
+
+// streamPreference returns the preferred stream of the room,
+// rooms without subscription have no preference.
+func (r *Service) streamPreference(roomId int) bilibili.StreamPreference {
+	cfg, err := r.sub.GetConfig(roomId)
+	if err != nil {
+		if err != subscribe.ErrRoomNotSubscribed {
+			logger.WithField("room", roomId).Warnf("cannot get room config, using default stream preference: %v", err)
+		}
+		return bilibili.StreamPreference{}
+	}
+	return bilibili.StreamPreference{
+		Quality: cfg.Quality,
+		Codec:   cfg.Codec,
+		Format:  cfg.Format,
+	}
+}
+
+// resolveStreams returns the recordable stream candidates ranked by the room preference
+func (r *Service) resolveStreams(roomId int) ([]*bilibili.StreamCandidate, error) {
+	pref := r.streamPreference(roomId)
+	if pref.Format != "" && !slices.Contains(recordableFormats, pref.Format) {
+		logger.WithField("room", roomId).Warnf("preferred format %s is not recordable, falling back to %v", pref.Format, recordableFormats)
+	}
+
+	candidates, err := r.bilic.GetStreamCandidates(roomId, pref)
+	if err != nil {
+		return nil, err
+	}
+	return fp.Filter(candidates, func(c *bilibili.StreamCandidate) bool {
+		return slices.Contains(recordableFormats, c.Format)
+	}), nil
+}
+
+// openStream starts reading the candidate stream, HTTP-FLV streams are read directly
+// while HLS streams are polled and their segments are concatenated.
+func (r *Service) openStream(ctx context.Context, candidate *bilibili.StreamCandidate) (<-chan []byte, error) {
+	if candidate.Format == bilibili.FormatFLV {
+		resp, err := r.bilic.FetchLiveStreamUrl(candidate.URL)
+		if err != nil {
+			return nil, err
+		}
+		return r.st.ReadStream(resp, ctx)
+	}
+
+	poller, err := hls.NewPoller(ctx, candidate.URL, r.bilic.FetchLiveStreamResource)
+	if err != nil {
+		return nil, err
+	}
+	return r.st.ReadStreamFrom(poller.Reader(ctx), ctx)
+}
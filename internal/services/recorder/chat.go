@@ -0,0 +1,94 @@
+package recorder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/bilibili"
+	"github.com/eric2788/bilirec/pkg/danmaku"
+	"github.com/eric2788/bilirec/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// chatRecorder writes danmaku of a session into XML files,
+// the file is rotated together with each FLV file.
+type chatRecorder struct {
+	mu     sync.Mutex
+	writer *danmaku.XMLWriter
+	l      *logrus.Entry
+}
+
+func newChatRecorder(ctx context.Context, l *logrus.Entry) *chatRecorder {
+	c := &chatRecorder{l: l}
+	go c.flushPeriodically(ctx)
+	return c
+}
+
+func (c *chatRecorder) handle(msg *danmaku.Message) {
+	d, err := danmaku.ParseDanmaku(msg)
+	if err != nil {
+		c.l.Debugf("cannot parse danmaku: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	w := c.writer
+	c.mu.Unlock()
+	if w == nil {
+		return
+	}
+	if err := w.WriteDanmaku(d); err != nil {
+		c.l.Warnf("cannot write danmaku: %v", err)
+	}
+}
+
+// rotate creates a new danmaku file paired with the given FLV output path,
+// the previous file keeps open until it is released by finalize.
+func (c *chatRecorder) rotate(flvPath string, start time.Time, room *bilibili.LiveRoomInfoDetail) (*danmaku.XMLWriter, error) {
+	w, err := danmaku.NewXMLWriter(utils.ChangePathFormat(flvPath, "xml"), start, danmaku.XMLMeta{
+		RoomID: int(room.RoomID),
+		Uname:  room.Uname,
+		Title:  room.Title,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.writer = w
+	c.mu.Unlock()
+	return w, nil
+}
+
+// release closes the danmaku file, and detaches it if it is still the current one
+func (c *chatRecorder) release(w *danmaku.XMLWriter) {
+	c.mu.Lock()
+	if c.writer == w {
+		c.writer = nil
+	}
+	c.mu.Unlock()
+	if err := w.Close(); err != nil {
+		c.l.Warnf("cannot close danmaku file %s: %v", w.Path(), err)
+	}
+}
+
+func (c *chatRecorder) flushPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			w := c.writer
+			c.mu.Unlock()
+			if w == nil {
+				continue
+			}
+			if err := w.Flush(); err != nil {
+				c.l.Warnf("cannot flush danmaku file: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package recorder
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/eric2788/bilirec/utils"
+)
+
+const (
+	DiskLowWarn          = "warn" // only the disk_low event is published
+	DiskLowStopRecording = "stop_recording"
+	DiskLowDeleteOldest  = "delete_oldest"
+	DiskLowPauseConvert  = "pause_convert"
+	DiskLowNone          = "none"
+
+	diskCheckInterval = 30 * time.Second
+)
+
+// SpaceFreer deletes finished recordings until the required bytes are freed, returns the bytes freed
+type SpaceFreer func(required uint64) uint64
+
+// guardDiskSpace checks the free space of the output volume while recording,
+// the configured actions are taken when it drops below MinDiskSpaceBytes.
+func (r *Service) guardDiskSpace(ctx context.Context) {
+	for _, action := range r.cfg.DiskLowActions {
+		if !slices.Contains([]string{DiskLowWarn, DiskLowStopRecording, DiskLowDeleteOldest, DiskLowPauseConvert, DiskLowNone}, action) {
+			logger.Warnf("unknown disk low action %q, ignored", action)
+		}
+	}
+	if slices.Contains(r.cfg.DiskLowActions, DiskLowNone) {
+		return
+	}
+
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	low := false
+	for {
+		select {
+		case <-ticker.C:
+			if r.recording.Size() == 0 && !low {
+				continue
+			}
+			diskSpace, err := utils.GetDiskSpace(r.cfg.OutputDir)
+			if err != nil {
+				logger.Warnf("cannot check disk space: %v", err)
+				continue
+			}
+
+			threshold := uint64(r.cfg.MinDiskSpaceBytes)
+			if diskSpace.Free >= threshold {
+				if low {
+					logger.Infof("disk space recovered: %d MB free", diskSpace.Free/1024/1024)
+					if slices.Contains(r.cfg.DiskLowActions, DiskLowPauseConvert) {
+						r.cv.Resume()
+					}
+					low = false
+				}
+				continue
+			}
+
+			if !low {
+				logger.Warnf("disk space is low: %d MB free, below %d MB", diskSpace.Free/1024/1024, threshold/1024/1024)
+				r.notify.PublishDiskLow(diskSpace.Free, threshold)
+				low = true
+			}
+			r.onDiskLow(threshold - diskSpace.Free)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Service) onDiskLow(required uint64) {
+	for _, action := range r.cfg.DiskLowActions {
+		switch action {
+		case DiskLowPauseConvert:
+			r.cv.Pause()
+		case DiskLowDeleteOldest:
+			if r.freeSpace == nil {
+				logger.Warn("no recordings can be deleted to free disk space")
+				continue
+			}
+			freed := r.freeSpace(required)
+			if freed >= required {
+				// no need to stop recordings if enough space is freed
+				return
+			}
+			required -= freed
+		case DiskLowStopRecording:
+			r.stopLowestPriority()
+		}
+	}
+}
+
+// stopLowestPriority stops one recording every check until the space is enough,
+// auto-record recordings go before manual ones, then the most recently started.
+func (r *Service) stopLowestPriority() {
+	var lowest *Recorder
+	lowestRoom := 0
+	r.recording.Range(func(roomId int, info *Recorder) bool {
+		if lowest == nil || lowerPriority(info, lowest) {
+			lowest, lowestRoom = info, roomId
+		}
+		return true
+	})
+	if lowest == nil {
+		return
+	}
+	logger.WithField("room", lowestRoom).Warnf("stopping %s recording due to low disk space", lowest.origin)
+	r.stop(lowestRoom, StopDiskFull)
+}
+
+func lowerPriority(a, b *Recorder) bool {
+	if a.origin != b.origin {
+		return a.origin == OriginAutoRecord
+	}
+	return a.startTime.After(b.startTime)
+}
+
+// SetSpaceFreer sets how the oldest recordings are deleted when the disk is low,
+// which is provided by the retention service so sessions are deleted as a whole.
+func (r *Service) SetSpaceFreer(freer SpaceFreer) {
+	r.freeSpace = freer
+}
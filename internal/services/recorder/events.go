@@ -0,0 +1,220 @@
+package recorder
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/eric2788/bilirec/pkg/danmaku"
+	"github.com/sirupsen/logrus"
+)
+
+const topSendersLimit = 10
+
+type (
+	SessionSummary struct {
+		SessionID      string          `json:"session_id,omitempty"`
+		EventCount     int             `json:"event_count"`
+		GiftCount      int             `json:"gift_count"`
+		GiftValue      float64         `json:"gift_value"`
+		SuperChatCount int             `json:"super_chat_count"`
+		SuperChatValue float64         `json:"super_chat_value"`
+		GuardCount     int             `json:"guard_count"`
+		GuardValue     float64         `json:"guard_value"`
+		EntryCount     int             `json:"entry_count"`
+		LikeCount      int             `json:"like_count"`
+		TotalValue     float64         `json:"total_value"` // in CNY
+		TopSenders     []SenderSummary `json:"top_senders"`
+	}
+
+	SenderSummary struct {
+		UID   int64   `json:"uid"`
+		Uname string  `json:"uname"`
+		Value float64 `json:"value"`
+		Count int     `json:"count"`
+	}
+)
+
+type summaryBuilder struct {
+	summary SessionSummary
+	senders map[int64]*SenderSummary
+}
+
+func newSummaryBuilder(sessionId string) *summaryBuilder {
+	return &summaryBuilder{
+		summary: SessionSummary{SessionID: sessionId},
+		senders: make(map[int64]*SenderSummary),
+	}
+}
+
+func (b *summaryBuilder) add(e *danmaku.Event) {
+	b.summary.EventCount++
+	switch e.Type {
+	case danmaku.EventGift:
+		b.summary.GiftCount += e.Num
+		b.summary.GiftValue += e.Value
+	case danmaku.EventSuperChat:
+		b.summary.SuperChatCount++
+		b.summary.SuperChatValue += e.Value
+	case danmaku.EventGuardBuy:
+		b.summary.GuardCount += e.Num
+		b.summary.GuardValue += e.Value
+	case danmaku.EventEntry:
+		b.summary.EntryCount++
+		return
+	case danmaku.EventLike:
+		b.summary.LikeCount++
+		return
+	}
+
+	b.summary.TotalValue += e.Value
+	if e.Value <= 0 {
+		return
+	}
+	sender, ok := b.senders[e.UID]
+	if !ok {
+		sender = &SenderSummary{UID: e.UID}
+		b.senders[e.UID] = sender
+	}
+	sender.Uname = e.Uname
+	sender.Value += e.Value
+	sender.Count++
+}
+
+func (b *summaryBuilder) build() *SessionSummary {
+	s := b.summary
+	s.TopSenders = make([]SenderSummary, 0, len(b.senders))
+	for _, sender := range b.senders {
+		s.TopSenders = append(s.TopSenders, *sender)
+	}
+	slices.SortFunc(s.TopSenders, func(a, b SenderSummary) int {
+		if a.Value > b.Value {
+			return -1
+		} else if a.Value < b.Value {
+			return 1
+		}
+		return cmp.Compare(a.UID, b.UID)
+	})
+	if len(s.TopSenders) > topSendersLimit {
+		s.TopSenders = s.TopSenders[:topSendersLimit]
+	}
+	return &s
+}
+
+// eventLog appends typed live events of a session to a JSONL file
+// and keeps a running summary of them.
+type eventLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	summary *summaryBuilder
+	closed  bool
+	l       *logrus.Entry
+}
+
+func newEventLog(path, sessionId string, l *logrus.Entry) (*eventLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{
+		path:    path,
+		file:    f,
+		w:       bufio.NewWriterSize(f, 32*1024),
+		summary: newSummaryBuilder(sessionId),
+		l:       l,
+	}, nil
+}
+
+func (e *eventLog) handle(msg *danmaku.Message) {
+	event, err := danmaku.ParseEvent(msg)
+	if errors.Is(err, danmaku.ErrUnsupportedEvent) {
+		return
+	} else if err != nil {
+		e.l.Debugf("cannot parse %s event: %v", msg.Cmd, err)
+		return
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		e.l.Debugf("cannot encode %s event: %v", msg.Cmd, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.summary.add(event)
+	e.w.Write(b)
+	if err := e.w.WriteByte('\n'); err != nil {
+		e.l.Warnf("cannot write live event: %v", err)
+	}
+}
+
+func (e *eventLog) summarize() *SessionSummary {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.summary.build()
+}
+
+func (e *eventLog) flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	return e.w.Flush()
+}
+
+func (e *eventLog) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	flushErr := e.w.Flush()
+	closeErr := e.file.Close()
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// SummarizeEventsFile builds the summary of a finished session from its JSONL events file
+func SummarizeEventsFile(path string) (*SessionSummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := newSummaryBuilder("")
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var event danmaku.Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			// the last line may be truncated if the process was killed
+			logger.Debugf("skipping malformed event line in %s: %v", path, err)
+			continue
+		}
+		b.add(&event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.build(), nil
+}
@@ -0,0 +1,44 @@
+package recorder_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eric2788/bilirec/internal/services/recorder"
+)
+
+func TestSummarizeEventsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.events.jsonl")
+	content := `{"type":"gift","timestamp":1,"uid":1,"uname":"a","name":"小花花","num":10,"value":1}
+{"type":"super_chat","timestamp":2,"uid":2,"uname":"b","num":1,"value":30,"message":"hi"}
+{"type":"guard_buy","timestamp":3,"uid":1,"uname":"a","name":"舰长","num":1,"value":198,"guard_level":3}
+{"type":"entry","timestamp":4,"uid":3,"uname":"c"}
+{"type":"like","timestamp":5,"uid":3,"uname":"c","num":1}
+{"type":"gift","timestamp":6,"uid":3,"uname":"c","name":"辣条","num":5}
+{"type":"gift","timest`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := recorder.SummarizeEventsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.EventCount != 6 {
+		t.Errorf("expected 6 events, got %d", summary.EventCount)
+	}
+	if summary.GiftCount != 15 || summary.GiftValue != 1 {
+		t.Errorf("unexpected gift summary: %d / %v", summary.GiftCount, summary.GiftValue)
+	}
+	if summary.SuperChatCount != 1 || summary.GuardCount != 1 || summary.EntryCount != 1 || summary.LikeCount != 1 {
+		t.Errorf("unexpected counts: %+v", summary)
+	}
+	if summary.TotalValue != 229 {
+		t.Errorf("expected total value 229, got %v", summary.TotalValue)
+	}
+	if len(summary.TopSenders) != 2 || summary.TopSenders[0].UID != 1 || summary.TopSenders[0].Value != 199 {
+		t.Errorf("unexpected top senders: %+v", summary.TopSenders)
+	}
+}
@@ -0,0 +1,167 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/pool"
+	"go.etcd.io/bbolt"
+)
+
+const recordHistoryBucket = "Record_History"
+
+var ErrSessionNotFound = errors.New("recording session not found")
+
+type StopReason string
+
+const (
+	StopManual      StopReason = "manual"
+	StopMaxHours    StopReason = "max_hours"
+	StopStreamEnded StopReason = "stream_ended"
+	StopBanned      StopReason = "banned"
+	StopDiskFull    StopReason = "disk_full"
+	StopError       StopReason = "error"
+	StopInterrupted StopReason = "interrupted"
+)
+
+// HistoryEntry is a finished recording session
+type HistoryEntry struct {
+	SessionID        string     `json:"session_id"`
+	RoomID           int        `json:"room_id"`
+	Title            string     `json:"title"`
+	Area             string     `json:"area"`
+	StartTime        int64      `json:"start_time"`
+	EndTime          int64      `json:"end_time"`
+	BytesWritten     uint64     `json:"bytes_written"`
+	Segments         []string   `json:"segments"`
+	RecoveryAttempts int        `json:"recovery_attempts"`
+	Origin           Origin     `json:"origin"`
+	StopReason       StopReason `json:"stop_reason"`
+}
+
+type HistoryPage struct {
+	Entries []*HistoryEntry `json:"entries"`
+	Total   int             `json:"total"`
+	Page    int             `json:"page"`
+	Size    int             `json:"size"`
+}
+
+var historySerializer = pool.NewSerializer()
+
+// keys are ordered by start time, so the newest sessions can be listed from the end
+func historyKey(start time.Time, roomId int) []byte {
+	return fmt.Appendf(nil, "%019d_%d", start.UnixNano(), roomId)
+}
+
+// saveHistory persists the session once it is stopped
+func (r *Service) saveHistory(s *session, reason StopReason) {
+	l := logger.WithField("room", s.roomId)
+	if r.history == nil {
+		l.Warn("record history is not available, session will not be saved")
+		return
+	}
+	entry := &HistoryEntry{
+		SessionID:        s.id,
+		RoomID:           s.roomId,
+		Title:            s.title,
+		Area:             s.area,
+		StartTime:        s.startTime.Unix(),
+		EndTime:          time.Now().Unix(),
+		BytesWritten:     s.bytesWritten.Load(),
+		Segments:         s.listSegments(),
+		RecoveryAttempts: int(s.recoveryAttempts.Load()),
+		Origin:           s.origin,
+		StopReason:       reason,
+	}
+	data, err := historySerializer.Serialize(entry)
+	if err != nil {
+		l.Errorf("cannot serialize record history: %v", err)
+		return
+	}
+	if err := r.history.Put(historyKey(s.startTime, s.roomId), data); err != nil {
+		l.Errorf("cannot save record history: %v", err)
+		return
+	}
+	l.Infof("recording session %s ended: %s", s.id, reason)
+}
+
+// ListHistory returns the finished sessions from newest to oldest,
+// roomId 0 lists the sessions of all rooms. page starts from 1.
+func (r *Service) ListHistory(roomId, page, size int) (*HistoryPage, error) {
+	result := &HistoryPage{Entries: make([]*HistoryEntry, 0, size), Page: page, Size: size}
+	if r.history == nil {
+		return result, nil
+	}
+	skip := (page - 1) * size
+	err := r.history.View(func(bucket *bbolt.Bucket) error {
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var entry HistoryEntry
+			if err := historySerializer.Deserialize(v, &entry); err != nil {
+				logger.Warnf("error scaning record history: %s: %v, ignored.", string(k), err)
+				continue
+			} else if roomId != 0 && entry.RoomID != roomId {
+				continue
+			}
+			if result.Total >= skip && len(result.Entries) < size {
+				result.Entries = append(result.Entries, &entry)
+			}
+			result.Total++
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SessionSegments are the segment files of a recording session with its room
+type SessionSegments struct {
+	RoomID   int
+	Segments []string
+}
+
+// HistorySegments returns the segment files of every finished session by session id in a single scan
+func (r *Service) HistorySegments() (map[string]SessionSegments, error) {
+	segments := make(map[string]SessionSegments)
+	if r.history == nil {
+		return segments, nil
+	}
+	err := r.history.ForEach(func(k, v []byte) error {
+		var entry HistoryEntry
+		if err := historySerializer.Deserialize(v, &entry); err != nil {
+			logger.Warnf("error scaning record history: %s: %v, ignored.", string(k), err)
+			return nil
+		}
+		segments[entry.SessionID] = SessionSegments{RoomID: entry.RoomID, Segments: entry.Segments}
+		return nil
+	})
+	return segments, err
+}
+
+// findHistory looks up a finished session by its id, the keys are ordered by time so all entries are scanned
+func (r *Service) findHistory(sessionId string) (*HistoryEntry, error) {
+	if r.history == nil {
+		return nil, ErrSessionNotFound
+	}
+	var found *HistoryEntry
+	err := r.history.View(func(bucket *bbolt.Bucket) error {
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var entry HistoryEntry
+			if err := historySerializer.Deserialize(v, &entry); err != nil {
+				logger.Warnf("error scaning record history: %s: %v, ignored.", string(k), err)
+				continue
+			} else if entry.SessionID == sessionId {
+				found = &entry
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if found == nil {
+		return nil, ErrSessionNotFound
+	}
+	return found, nil
+}
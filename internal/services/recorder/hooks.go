@@ -0,0 +1,47 @@
+package recorder
+
+import (
+	"slices"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/services/hook"
+	"github.com/eric2788/bilirec/utils"
+)
+
+func (r *Service) fireSegmentHook(roomId int, sess *session, seg *segment) {
+	payload := &hook.Payload{
+		Event:     hook.EventSegmentFinalized,
+		RoomID:    roomId,
+		FilePath:  seg.path,
+		StartTime: seg.startTime.Unix(),
+		EndTime:   time.Now().Unix(),
+	}
+	if sess != nil {
+		payload.SessionID = sess.id
+		payload.Uname = sess.uname
+		payload.Title = sess.title
+		payload.Area = sess.area
+	}
+	r.hooks.Fire(payload)
+}
+
+// fireSessionHook waits until every segment of the stopped session is finalized,
+// so the hooks receive complete files with their metadata written.
+func (r *Service) fireSessionHook(sess *session, reason StopReason) {
+	endTime := time.Now().Unix()
+	sess.finalizing.Wait()
+	r.hooks.Fire(&hook.Payload{
+		Event:     hook.EventSessionFinalized,
+		RoomID:    sess.roomId,
+		SessionID: sess.id,
+		Uname:     sess.uname,
+		Title:     sess.title,
+		Area:      sess.area,
+		// files too small are removed on finalize
+		Files:        slices.DeleteFunc(sess.listSegments(), func(path string) bool { return !utils.IsFileExists(path) }),
+		StartTime:    sess.startTime.Unix(),
+		EndTime:      endTime,
+		BytesWritten: sess.bytesWritten.Load(),
+		StopReason:   string(reason),
+	})
+}
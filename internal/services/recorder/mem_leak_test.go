@@ -9,9 +9,13 @@ import (
 	"github.com/eric2788/bilirec/internal/modules/bilibili"
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/services/convert"
+	"github.com/eric2788/bilirec/internal/services/hook"
+	"github.com/eric2788/bilirec/internal/services/notify"
 	"github.com/eric2788/bilirec/internal/services/path"
 	"github.com/eric2788/bilirec/internal/services/recorder"
+	"github.com/eric2788/bilirec/internal/services/room"
 	"github.com/eric2788/bilirec/internal/services/stream"
+	"github.com/eric2788/bilirec/internal/services/subscribe"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxtest"
 )
@@ -31,6 +35,10 @@ func TestRecorder_MemoryLeak_SingleSession(t *testing.T) {
 		fx.Provide(path.NewService),
 		fx.Provide(stream.NewService),
 		fx.Provide(convert.NewService),
+		fx.Provide(room.NewService),
+		fx.Provide(subscribe.NewService),
+		fx.Provide(notify.NewService),
+		fx.Provide(hook.NewService),
 		fx.Provide(recorder.NewService),
 		fx.Populate(&recorderService),
 	)
@@ -139,6 +147,10 @@ func TestRecorder_MemoryLeak_MultipleStartStop(t *testing.T) {
 		fx.Provide(path.NewService),
 		fx.Provide(stream.NewService),
 		fx.Provide(convert.NewService),
+		fx.Provide(room.NewService),
+		fx.Provide(subscribe.NewService),
+		fx.Provide(notify.NewService),
+		fx.Provide(hook.NewService),
 		fx.Provide(recorder.NewService),
 		fx.Populate(&recorderService),
 	)
@@ -231,6 +243,10 @@ func TestRecorder_MemoryLeak_ProcessedDataCleanup(t *testing.T) {
 		fx.Provide(path.NewService),
 		fx.Provide(stream.NewService),
 		fx.Provide(convert.NewService),
+		fx.Provide(room.NewService),
+		fx.Provide(subscribe.NewService),
+		fx.Provide(notify.NewService),
+		fx.Provide(hook.NewService),
 		fx.Provide(recorder.NewService),
 		fx.Populate(&recorderService),
 	)
@@ -301,6 +317,10 @@ func TestRecorder_MemoryLeak_ConcurrentRecordings(t *testing.T) {
 		fx.Provide(path.NewService),
 		fx.Provide(stream.NewService),
 		fx.Provide(convert.NewService),
+		fx.Provide(room.NewService),
+		fx.Provide(subscribe.NewService),
+		fx.Provide(notify.NewService),
+		fx.Provide(hook.NewService),
 		fx.Provide(recorder.NewService),
 		fx.Populate(&recorderService),
 	)
@@ -385,6 +405,10 @@ func TestRecorder_Goroutine_Leak(t *testing.T) {
 		fx.Provide(path.NewService),
 		fx.Provide(stream.NewService),
 		fx.Provide(convert.NewService),
+		fx.Provide(room.NewService),
+		fx.Provide(subscribe.NewService),
+		fx.Provide(notify.NewService),
+		fx.Provide(hook.NewService),
 		fx.Provide(recorder.NewService),
 		fx.Populate(&recorderService),
 	)
@@ -0,0 +1,42 @@
+package recorder
+
+import (
+	"slices"
+
+	"github.com/eric2788/bilirec/utils"
+)
+
+// mergeSession waits until every segment of the stopped session is finalized, then merges them into one file,
+// converted to MP4 if enabled. A session with a single segment, or one that cannot be merged, is converted per segment.
+func (r *Service) mergeSession(sess *session) {
+	if !r.cfg.MergeSessionSegments {
+		return
+	}
+	sess.finalizing.Wait()
+	l := logger.WithField("room", sess.roomId)
+
+	// files too small are removed on finalize
+	files := slices.DeleteFunc(sess.listSegments(), func(path string) bool { return !utils.IsFileExists(path) })
+	if len(files) > 1 {
+		format := utils.Ternary(r.cfg.ConvertFLVToMp4, "mp4", "")
+		queue, err := r.cv.Merge(files, format, r.cfg.DeleteMergedSegments)
+		if err == nil {
+			l.Infof("enqueued merging %d segments of session %s: %s", len(files), sess.id, queue.TaskID)
+			l.Infof("the output path will be: %s", queue.OutputPath)
+			return
+		}
+		l.Warnf("cannot merge segments of session %s, converting them separately: %v", sess.id, err)
+	}
+	for _, path := range files {
+		r.enqueueConvert(sess.roomId, path)
+	}
+}
+
+// SessionSegments returns the files of a finished session which still exist
+func (r *Service) SessionSegments(sessionId string) ([]string, error) {
+	entry, err := r.findHistory(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entry.Segments, func(path string) bool { return !utils.IsFileExists(path) }), nil
+}
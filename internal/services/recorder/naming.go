@@ -0,0 +1,185 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/bilibili"
+	"github.com/eric2788/bilirec/internal/services/subscribe"
+	"github.com/eric2788/bilirec/utils"
+)
+
+// DefaultNamingTemplate keeps the layout of {OutputDir}/{uname}-{roomID}/{title[:20]}-{yyyyMMdd_HHmmss}
+const DefaultNamingTemplate = "{uname}-{room_id}/{title:20}-{date}_{time}"
+
+var ErrInvalidNamingTemplate = errors.New("invalid naming template")
+
+var namingVariables = []string{
+	"room_id", "short_id", "uname", "uid", "title", "area", "parent_area",
+	"yyyy", "MM", "dd", "HH", "mm", "ss", "date", "time", "segment",
+}
+
+// NamingTemplate renders the path of a recording file relative to the output directory without format.
+// Variables are written as {name}, or {name:N} to keep the first N characters,
+// and "/" in the template separates the directories.
+type NamingTemplate struct {
+	raw   string
+	parts []namingPart
+}
+
+type namingPart struct {
+	literal  string
+	variable string
+	maxLen   int
+}
+
+func ParseNamingTemplate(raw string) (*NamingTemplate, error) {
+	t := &NamingTemplate{raw: raw}
+	rest := raw
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, namingPart{literal: rest})
+			break
+		} else if open > 0 {
+			t.parts = append(t.parts, namingPart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unclosed variable", ErrInvalidNamingTemplate)
+		}
+		part, err := parseNamingVariable(rest[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[open+end+1:]
+	}
+
+	for _, part := range t.parts {
+		if strings.ContainsAny(part.literal, "{}\\") {
+			return nil, fmt.Errorf("%w: unexpected character in %q", ErrInvalidNamingTemplate, part.literal)
+		}
+	}
+	if strings.HasPrefix(raw, "/") || strings.HasSuffix(raw, "/") {
+		return nil, fmt.Errorf("%w: must be a relative file path", ErrInvalidNamingTemplate)
+	}
+	for dir := range strings.SplitSeq(raw, "/") {
+		if dir == "" || dir == "." || dir == ".." {
+			return nil, fmt.Errorf("%w: invalid path element %q", ErrInvalidNamingTemplate, dir)
+		}
+	}
+	return t, nil
+}
+
+func parseNamingVariable(expr string) (namingPart, error) {
+	name, length, hasLength := strings.Cut(expr, ":")
+	if !slices.Contains(namingVariables, name) {
+		return namingPart{}, fmt.Errorf("%w: unknown variable %q", ErrInvalidNamingTemplate, name)
+	}
+	part := namingPart{variable: name}
+	if hasLength {
+		maxLen, err := strconv.Atoi(length)
+		if err != nil || maxLen <= 0 {
+			return namingPart{}, fmt.Errorf("%w: invalid length of %q", ErrInvalidNamingTemplate, name)
+		}
+		part.maxLen = maxLen
+	}
+	return part, nil
+}
+
+// Render fills the template with the variables, values are sanitized so they cannot create directories
+func (t *NamingTemplate) Render(vars map[string]string) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.variable == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value := utils.SanitizeFilename(vars[part.variable])
+		if part.maxLen > 0 {
+			value = utils.TruncateString(value, part.maxLen)
+		}
+		b.WriteString(value)
+	}
+	return b.String()
+}
+
+func (t *NamingTemplate) String() string {
+	return t.raw
+}
+
+// NamingVariables returns the template variables of a recording file,
+// segment is the index of the file in the session starting from 1.
+func NamingVariables(info *bilibili.LiveRoomInfoDetail, start time.Time, segment int) map[string]string {
+	return map[string]string{
+		"room_id":     strconv.FormatInt(info.RoomID, 10),
+		"short_id":    strconv.FormatInt(info.ShortID, 10),
+		"uname":       info.Uname,
+		"uid":         strconv.FormatInt(info.UID, 10),
+		"title":       info.Title,
+		"area":        info.AreaName,
+		"parent_area": info.ParentAreaName,
+		"yyyy":        start.Format("2006"),
+		"MM":          start.Format("01"),
+		"dd":          start.Format("02"),
+		"HH":          start.Format("15"),
+		"mm":          start.Format("04"),
+		"ss":          start.Format("05"),
+		"date":        start.Format("20060102"),
+		"time":        start.Format("150405"),
+		"segment":     strconv.Itoa(segment),
+	}
+}
+
+// namingTemplate returns the template of the room config, or the global one if not set
+func (r *Service) namingTemplate(roomId int) *NamingTemplate {
+	cfg, err := r.sub.GetConfig(roomId)
+	if err != nil {
+		if err != subscribe.ErrRoomNotSubscribed {
+			logger.WithField("room", roomId).Warnf("cannot get room config, using global naming template: %v", err)
+		}
+		return r.naming
+	} else if cfg.NamingTemplate == "" {
+		return r.naming
+	}
+	t, err := ParseNamingTemplate(cfg.NamingTemplate)
+	if err != nil {
+		logger.WithField("room", roomId).Warnf("%v, using global naming template", err)
+		return r.naming
+	}
+	return t
+}
+
+// prepareFilePath renders the naming template of the room and creates its directories,
+// a number is appended if a recording with the same name already exists.
+// the time should be the time you start the record, not live start
+func (r *Service) prepareFilePath(info *bilibili.LiveRoomInfoDetail, start time.Time, format string, segment int) (string, error) {
+	rel := r.namingTemplate(int(info.RoomID)).Render(NamingVariables(info, start, segment))
+	base := filepath.Join(r.cfg.OutputDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return "", err
+	}
+	stem := base
+	for i := 2; r.isNameTaken(stem); i++ {
+		stem = fmt.Sprintf("%s_%d", base, i)
+	}
+	return stem + "." + format, nil
+}
+
+// the converted mp4 and danmaku file share the name of the recording
+func (r *Service) isNameTaken(stem string) bool {
+	for _, format := range []string{"flv", "ts", "mp4", "xml"} {
+		path := stem + "." + format
+		if utils.IsFileExists(path) || r.IsRecording(path) {
+			return true
+		}
+	}
+	return false
+}
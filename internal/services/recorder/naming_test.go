@@ -0,0 +1,58 @@
+package recorder_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/bilibili"
+	"github.com/eric2788/bilirec/internal/services/recorder"
+)
+
+func TestNamingTemplate_Render(t *testing.T) {
+	info := &bilibili.LiveRoomInfoDetail{
+		RoomID:         545068,
+		ShortID:        24,
+		UID:            1234,
+		Uname:          "主播",
+		Title:          "今天的直播/雜談",
+		AreaName:       "虛擬主播",
+		ParentAreaName: "虛擬",
+	}
+	start := time.Date(2024, 3, 9, 8, 5, 1, 0, time.Local)
+
+	cases := map[string]string{
+		recorder.DefaultNamingTemplate:            "主播-545068/今天的直播_雜談-20240309_080501",
+		"{parent_area}/{area}/{uid}_{short_id}":   "虛擬/虛擬主播/1234_24",
+		"{yyyy}/{MM}/{dd}/{HH}{mm}{ss}-{segment}": "2024/03/09/080501-3",
+		"{uname:1}-{title:5}":                     "主-今天的直播",
+	}
+	for raw, expected := range cases {
+		tmpl, err := recorder.ParseNamingTemplate(raw)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %v", raw, err)
+		}
+		if got := tmpl.Render(recorder.NamingVariables(info, start, 3)); got != expected {
+			t.Errorf("%q rendered %q, expected %q", raw, got, expected)
+		}
+	}
+}
+
+func TestParseNamingTemplate_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"{unknown}",
+		"{title",
+		"{title:0}",
+		"{title:abc}",
+		"/{title}",
+		"{title}/",
+		"../{title}",
+		"a//{title}",
+		"a\\{title}",
+	} {
+		if _, err := recorder.ParseNamingTemplate(raw); !errors.Is(err, recorder.ErrInvalidNamingTemplate) {
+			t.Errorf("expected %q to be invalid, got %v", raw, err)
+		}
+	}
+}
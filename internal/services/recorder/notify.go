@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"time"
+
+	"github.com/eric2788/bilirec/internal/services/notify"
+)
+
+func recordingData(info *Recorder) notify.RecordingData {
+	data := notify.RecordingData{
+		Title:  info.room.Title,
+		Uname:  info.room.Uname,
+		Area:   info.room.AreaName,
+		Origin: string(info.origin),
+	}
+	if info.session != nil {
+		data.SessionID = info.session.id
+	}
+	if info.stream != nil {
+		data.Format = info.stream.Format
+		data.Codec = info.stream.Codec
+		data.Quality = info.stream.Qn
+	}
+	if seg := info.segment.Load(); seg != nil {
+		data.OutputPath = seg.path
+	}
+	return data
+}
+
+// publishRecordingStopped reports stopping by errors, disk full or room restriction as failures
+func (r *Service) publishRecordingStopped(sess *session, reason StopReason) {
+	failed := reason == StopError || reason == StopDiskFull || reason == StopBanned
+	r.notify.PublishRecordingStopped(sess.roomId, failed, notify.RecordingStoppedData{
+		SessionID:        sess.id,
+		Reason:           string(reason),
+		BytesWritten:     sess.bytesWritten.Load(),
+		DurationSeconds:  int64(time.Since(sess.startTime).Seconds()),
+		RecoveryAttempts: int(sess.recoveryAttempts.Load()),
+		Segments:         sess.listSegments(),
+	})
+}
+
+func (r *Service) publishSegmentFinalized(roomId int, sess *session, seg *segment, size int64) {
+	data := notify.SegmentData{
+		Path:      seg.path,
+		Size:      size,
+		StartTime: seg.startTime.Unix(),
+		EndTime:   time.Now().Unix(),
+	}
+	if sess != nil {
+		data.SessionID = sess.id
+	}
+	r.notify.PublishSegmentFinalized(roomId, data)
+}
+
+// publishRoomRestricted publishes only once until the restriction of the room changes
+func (r *Service) publishRoomRestricted(roomId int, reason string) {
+	if previous, loaded := r.restricted.LoadAndStore(roomId, reason); !loaded || previous != reason {
+		r.notify.PublishRoomRestricted(roomId, reason)
+	}
+}
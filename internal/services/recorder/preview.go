@@ -0,0 +1,49 @@
+package recorder
+
+import (
+	"errors"
+
+	"github.com/eric2788/bilirec/internal/processors"
+)
+
+var ErrNotRecording = errors.New("the room is not recording")
+var ErrPreviewUnsupported = errors.New("live preview is only available for FLV streams")
+
+// AttachPreview attaches a live preview client to the recording FLV stream.
+// The channel is closed once the recording stops, recovers or the client falls behind,
+// detach must be called when the client leaves.
+func (r *Service) AttachPreview(roomId int) (<-chan []byte, func(), error) {
+	info, ok := r.recording.Load(roomId)
+	if !ok || info.status.Load() != recordingPtr {
+		return nil, nil, ErrNotRecording
+	} else if info.preview == nil {
+		return nil, nil, ErrPreviewUnsupported
+	}
+	ch, detach, err := info.preview.Attach(processors.DefaultTeeClientBuffer)
+	if errors.Is(err, processors.ErrTeeClosed) {
+		return nil, nil, ErrNotRecording
+	}
+	return ch, detach, err
+}
+
+// RecordingFiles is the files of an ongoing recording session
+type RecordingFiles struct {
+	SessionID string
+	// all files of the session in order, including those of the previous recovery attempts
+	Files []string
+	// the file being written
+	Writing string
+}
+
+// GetRecordingFiles returns the files of the ongoing recording session of the room
+func (r *Service) GetRecordingFiles(roomId int) (*RecordingFiles, error) {
+	info, ok := r.recording.Load(roomId)
+	if !ok || info.session == nil {
+		return nil, ErrNotRecording
+	}
+	return &RecordingFiles{
+		SessionID: info.session.id,
+		Files:     info.session.listSegments(),
+		Writing:   info.segment.Load().path,
+	}, nil
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -15,8 +16,13 @@ import (
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/processors"
 	"github.com/eric2788/bilirec/internal/services/convert"
+	"github.com/eric2788/bilirec/internal/services/hook"
+	"github.com/eric2788/bilirec/internal/services/notify"
 	"github.com/eric2788/bilirec/internal/services/stream"
+	"github.com/eric2788/bilirec/internal/services/subscribe"
+	"github.com/eric2788/bilirec/pkg/db"
 	"github.com/eric2788/bilirec/pkg/ds"
+	"github.com/eric2788/bilirec/pkg/flv"
 	"github.com/eric2788/bilirec/pkg/pipeline"
 	"github.com/eric2788/bilirec/utils"
 	"github.com/puzpuzpuz/xsync/v4"
@@ -46,10 +52,17 @@ var ErrRoomEncrypted = errors.New("the room is encrypted")
 var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
 
 type Recorder struct {
-	status     atomic.Pointer[RecordStatus]
-	bytesRead  atomic.Uint64
-	startTime  time.Time
-	outputPath string
+	status    atomic.Pointer[RecordStatus]
+	bytesRead atomic.Uint64
+	startTime time.Time
+	segment   atomic.Pointer[segment]
+	room      *bilibili.LiveRoomInfoDetail
+	stream    *bilibili.StreamCandidate
+	origin    Origin
+	resumed   bool
+	session   *session
+	health    *flv.HealthMonitor
+	preview   *processors.FlvTee
 
 	cancel context.CancelFunc
 }
@@ -58,12 +71,30 @@ type Service struct {
 	st            *stream.Service
 	cv            *convert.Service
 	bilic         *bilibili.Client
+	sub           *subscribe.Service
+	notify        *notify.Service
+	hooks         *hook.Service
+	freeSpace     SpaceFreer
 	recording     *xsync.Map[int, *Recorder]
 	writtingFiles ds.Set[string]
 	pipes         *xsync.Map[int, *pipeline.Pipe[[]byte]]
+	db            *db.Client
+	history       *db.Bucket
+	active        *db.Bucket
+	reports       *db.Bucket
 
-	cfg *config.Config
-	ctx context.Context
+	// receiving, finalizing and events file goroutines to drain on shutdown
+	tasks sync.WaitGroup
+
+	// banned or encrypted rooms with the notified reason
+	restricted *xsync.Map[int, string]
+
+	// global naming template of the recording files
+	naming *NamingTemplate
+
+	cfg    *config.Config
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewService(
@@ -71,32 +102,73 @@ func NewService(
 	st *stream.Service,
 	cv *convert.Service,
 	bilic *bilibili.Client,
+	sub *subscribe.Service,
+	ns *notify.Service,
+	hs *hook.Service,
 	cfg *config.Config,
 ) *Service {
 
+	naming, err := ParseNamingTemplate(utils.EmptyOrElse(cfg.NamingTemplate, DefaultNamingTemplate))
+	if err != nil {
+		logger.Fatalf("cannot parse NAMING_TEMPLATE: %v", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	s := &Service{
 		st:            st,
 		cv:            cv,
 		bilic:         bilic,
+		sub:           sub,
+		notify:        ns,
+		hooks:         hs,
 		recording:     xsync.NewMap[int, *Recorder](),
 		writtingFiles: ds.NewSyncedSet[string](),
 		pipes:         xsync.NewMap[int, *pipeline.Pipe[[]byte]](),
+		restricted:    xsync.NewMap[int, string](),
+		naming:        naming,
 		cfg:           cfg,
 		ctx:           ctx,
+		cancel:        cancel,
 	}
 
 	cv.SetActiveRecordingsGetter(s.recording.Size)
+	bilic.SetCookieRefreshFailedHandler(ns.PublishCookieRefreshFailed)
 
 	go s.backgroundMaintenance(ctx)
+	go s.guardDiskSpace(ctx)
 	go initOutputDir(cfg)
 
-	lc.Append(fx.StopHook(cancel))
+	lc.Append(fx.StartStopHook(
+		func() error {
+			if err := s.openDatabase(); err != nil {
+				return err
+			}
+			if report, err := s.GetShutdownReport(); err != nil {
+				logger.Warnf("cannot read last shutdown report: %v", err)
+			} else if report != nil && len(report.Interrupted) > 0 {
+				logger.Infof("%d recordings were interrupted by the last shutdown", len(report.Interrupted))
+			}
+			go s.resumeRecordings()
+			return nil
+		},
+		s.shutdown,
+	))
 	return s
 }
 
+// Start starts the recording manually
 func (r *Service) Start(roomId int) error {
+	return r.start(roomId, OriginManual, false)
+}
+
+// StartAutoRecord starts the recording for the auto-record of subscribed rooms
+func (r *Service) StartAutoRecord(roomId int) error {
+	return r.start(roomId, OriginAutoRecord, false)
+}
+
+// start opens the stream of the room, resumed is set when the session is resumed after a restart
+func (r *Service) start(roomId int, origin Origin, resumed bool) error {
 
 	l := logger.WithField("room", roomId)
 
@@ -124,49 +196,54 @@ func (r *Service) Start(roomId int) error {
 	if err != nil {
 		return err
 	} else if roomInfo.IsEncrypted {
+		r.publishRoomRestricted(roomId, "encrypted")
 		return ErrRoomEncrypted
 	} else if roomInfo.LockStatus != 0 {
+		r.publishRoomRestricted(roomId, "banned")
 		return ErrRoomBanned
-	} else if roomInfo.LiveStatus != 1 {
+	}
+	r.restricted.Delete(roomId)
+	if roomInfo.LiveStatus != 1 {
 		return ErrStreamNotLive
 	}
 
-	urls, err := r.bilic.GetStreamURLsV2(roomId)
+	candidates, err := r.resolveStreams(roomId)
 	if err != nil {
 		return err
-	} else if len(urls) == 0 {
+	} else if len(candidates) == 0 {
 		return ErrEmptyStreamURLs
 	}
 
 	now := time.Now()
 
-	outputPath, err := r.prepareFilePath(roomInfo, now)
-	if err != nil {
-		return fmt.Errorf("cannot prepare file path: %v", err)
-	}
-
 	ctx, cancel := context.WithCancel(r.ctx)
 
-	// retry mechanism
-	for _, url := range urls {
-		resp, err := r.bilic.FetchLiveStreamUrl(url)
+	// retry mechanism, candidates are ranked by the room preference
+	for _, candidate := range candidates {
+		ch, err := r.openStream(ctx, candidate)
 		if err != nil {
-			l.Errorf("cannot fetch url: %v, will try next url", err)
+			l.Errorf("cannot capture %s stream: %v, will try next url", candidate.Format, err)
 			continue
 		}
-		ch, err := r.st.ReadStream(resp, ctx)
+
+		outputPath, err := r.prepareFilePath(roomInfo, now, fileFormats[candidate.Format], r.nextSegmentIndex(roomId))
 		if err != nil {
-			l.Errorf("cannot capture url stream: %v, will try next url", err)
-			continue
+			cancel()
+			return fmt.Errorf("cannot prepare file path: %v", err)
 		}
 
 		// initialize Recorder info
 		info := &Recorder{
-			cancel:     cancel,
-			startTime:  now,
-			outputPath: outputPath,
+			cancel:    cancel,
+			startTime: now,
+			room:      roomInfo,
+			stream:    candidate,
+			origin:    origin,
+			resumed:   resumed,
 		}
 		info.status.Store(recordingPtr)
+		info.segment.Store(&segment{path: outputPath, startTime: now})
+		l.Infof("recording stream: %s/%s qn=%d", candidate.Format, candidate.Codec, candidate.Qn)
 		r.writtingFiles.Add(filepath.Base(outputPath))
 
 		return r.prepare(roomId, ch, ctx, info)
@@ -176,13 +253,26 @@ func (r *Service) Start(roomId int) error {
 	return ErrStreamURLsUnreachable
 }
 
+// Stop stops the recording manually
 func (r *Service) Stop(roomId int) bool {
+	return r.stop(roomId, StopManual)
+}
+
+func (r *Service) stop(roomId int, reason StopReason) bool {
 
 	info, hasRecording := r.recording.LoadAndDelete(roomId)
 	pipe, hasPipe := r.pipes.LoadAndDelete(roomId)
 
 	if hasRecording {
 		info.cancel()
+		if info.session != nil {
+			info.session.close()
+			r.saveHistory(info.session, reason)
+			r.removeActive(roomId)
+			r.publishRecordingStopped(info.session, reason)
+			r.tasks.Go(func() { r.fireSessionHook(info.session, reason) })
+			r.tasks.Go(func() { r.mergeSession(info.session) })
+		}
 	} else {
 		logger.Warnf("recording for room %d not found", roomId)
 	}
@@ -197,13 +287,24 @@ func (r *Service) Stop(roomId int) bool {
 
 func (r *Service) prepare(roomId int, ch <-chan []byte, ctx context.Context, info *Recorder) error {
 
-	pipe := pipeline.New(
-		// fix FLV stream
-		processors.NewFlvStreamFixer(),
-		// write to file with buffered writer
-		// flushes every 5 seconds then writes to disk
-		processors.NewBufferedStreamWriter(info.outputPath, config.ReadOnly.LiveStreamWriterBufferSize()),
-	)
+	var pipe *pipeline.Pipe[[]byte]
+	if info.stream.Format == bilibili.FormatFLV {
+		info.health = flv.NewHealthMonitor()
+		info.preview = processors.NewFlvTee()
+		pipe = pipeline.New(
+			// fix FLV stream and monitor its health
+			processors.NewFlvStreamFixerWithHealth(info.health),
+			// copy the fixed stream to live preview clients
+			processors.NewFlvTeeProcessor(info.preview),
+			// write to file with buffered writer
+			// flushes every 5 seconds then writes to disk
+			// rolls over to new segment files if segmenting is enabled
+			r.newStreamWriter(roomId, info),
+		)
+	} else {
+		// HLS segments are saved as is
+		pipe = pipeline.New(r.newStreamWriter(roomId, info))
+	}
 
 	startCtx, startCancel := context.WithTimeout(ctx, 10*time.Second)
 	if err := pipe.Open(startCtx); err != nil {
@@ -212,24 +313,44 @@ func (r *Service) prepare(roomId int, ch <-chan []byte, ctx context.Context, inf
 	}
 	startCancel()
 
+	r.attachSession(roomId, info)
+	info.session.addSegment(info.segment.Load().path)
+	if info.session.chat != nil {
+		r.rotateChat(roomId, info, info.segment.Load())
+	}
+
 	r.recording.Store(roomId, info)
 	r.pipes.Store(roomId, pipe)
 
-	go r.rev(roomId, ch, info, pipe)
+	r.tasks.Go(func() { r.rev(roomId, ch, info, pipe) })
 	go r.checkRecordingDurationPeriodically(roomId, ctx)
+	go r.watchStall(roomId, ctx, info)
 	return nil
 }
 
+// rotateChat starts a new danmaku file along with the new FLV file
+func (r *Service) rotateChat(roomId int, info *Recorder, seg *segment) {
+	w, err := info.session.chat.rotate(seg.path, seg.startTime, info.room)
+	if err != nil {
+		logger.WithField("room", roomId).Warnf("cannot create danmaku file, danmaku will not be recorded: %v", err)
+		return
+	}
+	seg.chatWriter = w
+	r.writtingFiles.Add(filepath.Base(w.Path()))
+}
+
 func (r *Service) rev(roomId int, ch <-chan []byte, info *Recorder, pipe *pipeline.Pipe[[]byte]) {
 	l := logger.WithField("room", roomId)
 	defer r.recover(roomId)
 	defer func() {
 		pipe.Close()
-		go r.finalize(roomId, info)
+		seg := info.segment.Load()
+		r.tasks.Go(func() { r.finalize(roomId, info.session, seg) })
 	}()
 	for data := range ch {
 
 		info.bytesRead.Add(uint64(len(data)))
+		info.session.bytesWritten.Add(uint64(len(data)))
 		result, err := pipe.Process(r.ctx, data)
 		r.st.Flush(data)
 		r.st.Flush(result)
@@ -267,7 +388,7 @@ func (r *Service) checkRecordingDurationPeriodically(roomId int, ctx context.Con
 			elapsed := time.Since(info.startTime)
 			if elapsed >= maxDuration {
 				log.Infof("maximum recording hours reached (%v), stopping", elapsed.Round(time.Minute))
-				r.Stop(roomId)
+				r.stop(roomId, StopMaxHours)
 				return
 			}
 
@@ -287,6 +408,11 @@ func (r *Service) checkRecordingDurationPeriodically(roomId int, ctx context.Con
 // rather than appending to the same file. Multiple files per session is expected.
 func (r *Service) recover(roomId int) {
 	l := logger.WithField("room", roomId)
+	if r.ctx.Err() != nil {
+		// keep the recording as active so it can be resumed after restart
+		l.Infof("service is stopping, skipped recovery.")
+		return
+	}
 	l.Infof("trying to recover stream capture...")
 	info, ok := r.recording.Load(roomId)
 	if !ok {
@@ -298,10 +424,19 @@ func (r *Service) recover(roomId int) {
 	}
 
 	info.status.Store(recoveringPtr)
+	if info.session != nil {
+		r.notify.PublishRecordingRecovering(roomId, notify.RecordingRecoveringData{
+			SessionID:  info.session.id,
+			OutputPath: info.segment.Load().path,
+		})
+	}
 	attempt := 1
 	retryStart := time.Now()
 	for {
-		err := r.Start(roomId)
+		if info.session != nil {
+			info.session.recoveryAttempts.Add(1)
+		}
+		err := r.start(roomId, info.origin, false)
 		if err == nil {
 			l.Info("start live stream recovery: success")
 			return
@@ -310,11 +445,11 @@ func (r *Service) recover(roomId int) {
 		switch err {
 		case ErrMaxConcurrentRecordingsReached:
 			l.Infof("stop recovery due to: %v", err)
-			r.Stop(roomId)
+			r.stop(roomId, StopError)
 			return
 		case ErrRoomEncrypted, ErrRoomBanned:
 			l.Infof("stream is banned or premium, will not recover.")
-			r.Stop(roomId)
+			r.stop(roomId, StopBanned)
 			return
 		default:
 
@@ -329,18 +464,18 @@ func (r *Service) recover(roomId int) {
 				// use r.cfg.MaxRetryMinutes to limit the total retry duration, instead of max attempts, since the stream may be live again after some time
 				if time.Since(retryStart) >= time.Duration(r.cfg.MaxRetryMinutes)*time.Minute {
 					l.Infof("stop recovery after retrying for %d minutes", r.cfg.MaxRetryMinutes)
-					r.Stop(roomId)
+					r.stop(roomId, StopStreamEnded)
 					return
 				}
 			} else if attempt >= r.cfg.MaxRecoveryAttempts {
 				l.Infof("maximum recovery attempts reached (%d), will not recover", r.cfg.MaxRecoveryAttempts)
-				r.Stop(roomId)
+				r.stop(roomId, utils.Ternary(err == ErrInsufficientDiskSpace, StopDiskFull, StopError))
 				return
 			}
 
 			l.Infof("will retry stream recovery in 15 seconds...")
 			timer := time.NewTimer(15 * time.Second)
-			
+
 			select {
 			case <-timer.C:
 				attempt++
@@ -355,33 +490,64 @@ func (r *Service) recover(roomId int) {
 	}
 }
 
-func (r *Service) finalize(roomId int, info *Recorder) {
-	if info == nil {
+func (r *Service) finalize(roomId int, sess *session, seg *segment) {
+	if sess != nil {
+		defer sess.finalizing.Done()
+	}
+	if seg == nil {
 		logger.Warnf("skipping finalize for room %d: no recording info", roomId)
 		return
 	}
 
-	defer r.writtingFiles.Remove(filepath.Base(info.outputPath))
+	defer r.writtingFiles.Remove(filepath.Base(seg.path))
+
+	if seg.chatWriter != nil {
+		sess.chat.release(seg.chatWriter)
+		defer r.writtingFiles.Remove(filepath.Base(seg.chatWriter.Path()))
+	}
 
-	fileInfo, err := os.Stat(info.outputPath)
+	fileInfo, err := os.Stat(seg.path)
 	if err != nil {
 		logger.Errorf("failed to stat recorded file for room %d: %v", roomId, err)
 		return
 	} else if fileInfo.Size() < 1024 { // less than 1KB
 		logger.Warnf("recorded file for room %d is too small (%d bytes), skipping finallization and removing file", roomId, fileInfo.Size())
-		if err := os.Remove(info.outputPath); err != nil {
-			logger.Errorf("failed to remove empty file %s: %v", info.outputPath, err)
+		if err := os.Remove(seg.path); err != nil {
+			logger.Errorf("failed to remove empty file %s: %v", seg.path, err)
+		}
+		if seg.chatWriter != nil {
+			if err := os.Remove(seg.chatWriter.Path()); err != nil {
+				logger.Errorf("failed to remove danmaku file %s: %v", seg.chatWriter.Path(), err)
+			}
 		}
 		return
 	}
 
+	if r.cfg.InjectFlvMetadata && utils.GetPathFormat(seg.path) == "flv" {
+		r.injectMetadata(roomId, sess, seg)
+	}
+
+	r.fireSegmentHook(roomId, sess, seg)
+	r.publishSegmentFinalized(roomId, sess, seg, fileInfo.Size())
+
+	if r.cfg.MergeSessionSegments && sess != nil {
+		logger.Debugf("segment %s will be converted together when the session is merged", seg.path)
+		return
+	}
+	r.enqueueConvert(roomId, seg.path)
+}
+
+func (r *Service) enqueueConvert(roomId int, path string) {
 	if !r.cfg.ConvertFLVToMp4 {
 		logger.Debug("no need to convert flv to mp4, skipped")
 		return
+	} else if utils.GetPathFormat(path) == "mp4" {
+		logger.Debug("recorded fmp4 stream is already mp4, skipped")
+		return
 	}
 
 	// process finalization via convert service
-	if queue, err := r.cv.Enqueue(info.outputPath, "mp4", r.cfg.DeleteFlvAfterConvert); err != nil {
+	if queue, err := r.cv.Enqueue(path, "mp4", r.cfg.DeleteFlvAfterConvert); err != nil {
 		logger.Errorf("failed to enqueue conversion for room %d: %v", roomId, err)
 		logger.Warnf("you may need to convert mp4 manually for room: %d", roomId)
 	} else {
@@ -390,6 +556,29 @@ func (r *Service) finalize(roomId int, info *Recorder) {
 	}
 }
 
+// injectMetadata writes the duration, keyframes index and the recording info into the onMetaData of the file
+func (r *Service) injectMetadata(roomId int, sess *session, seg *segment) {
+	l := logger.WithField("room", roomId)
+	custom := []flv.AMFProperty{
+		{Key: "room_id", Value: roomId},
+		{Key: "start_time", Value: seg.startTime.Format(time.RFC3339)},
+	}
+	if sess != nil {
+		custom = append(custom,
+			flv.AMFProperty{Key: "session_id", Value: sess.id},
+			flv.AMFProperty{Key: "uname", Value: sess.uname},
+			flv.AMFProperty{Key: "title", Value: sess.title},
+			flv.AMFProperty{Key: "area", Value: sess.area},
+		)
+	}
+	start := time.Now()
+	if err := flv.InjectMetadata(seg.path, custom...); err != nil {
+		l.Warnf("cannot write metadata into %s, the file may not be seekable: %v", seg.path, err)
+		return
+	}
+	l.Debugf("metadata written into %s in %v", seg.path, time.Since(start))
+}
+
 func (r *Service) backgroundMaintenance(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
@@ -430,14 +619,30 @@ func (r *Service) backgroundMaintenance(ctx context.Context) {
 	}
 }
 
-// the time should be the time you start the record, not live start
-func (r *Service) prepareFilePath(info *bilibili.LiveRoomInfoDetail, start time.Time) (string, error) {
-	dirPath := fmt.Sprintf("%s/%s-%d", r.cfg.OutputDir, info.Uname, info.RoomID)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return "", err
+// records.db keeps the record history, the active recordings to resume after restart
+// and the report of the last shutdown
+func (r *Service) openDatabase() error {
+	if err := os.MkdirAll(r.cfg.DatabaseDir, 0755); err != nil {
+		return err
+	}
+	client, err := db.Open(r.cfg.DatabaseDir + string(os.PathSeparator) + "records.db")
+	if err != nil {
+		return err
+	}
+	if r.history, err = client.Bucket(recordHistoryBucket); err != nil {
+		client.Close()
+		return err
+	}
+	if r.active, err = client.Bucket(activeRecordingBucket); err != nil {
+		client.Close()
+		return err
+	}
+	if r.reports, err = client.Bucket(shutdownReportBucket); err != nil {
+		client.Close()
+		return err
 	}
-	safeTitle := utils.TruncateString(utils.SanitizeFilename(info.Title), 20)
-	return fmt.Sprintf("%s/%s-%s.flv", dirPath, safeTitle, start.Format("20060102_150405")), nil
+	r.db = client
+	return nil
 }
 
 func initOutputDir(cfg *config.Config) {
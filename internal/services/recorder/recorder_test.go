@@ -9,9 +9,13 @@ import (
 	"github.com/eric2788/bilirec/internal/modules/bilibili"
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/services/convert"
+	"github.com/eric2788/bilirec/internal/services/hook"
+	"github.com/eric2788/bilirec/internal/services/notify"
 	"github.com/eric2788/bilirec/internal/services/path"
 	"github.com/eric2788/bilirec/internal/services/recorder"
+	ro "github.com/eric2788/bilirec/internal/services/room"
 	"github.com/eric2788/bilirec/internal/services/stream"
+	"github.com/eric2788/bilirec/internal/services/subscribe"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxtest"
 )
@@ -28,6 +32,10 @@ func TestFlvRecord(t *testing.T) {
 		fx.Provide(path.NewService),
 		fx.Provide(stream.NewService),
 		fx.Provide(convert.NewService),
+		fx.Provide(ro.NewService),
+		fx.Provide(subscribe.NewService),
+		fx.Provide(notify.NewService),
+		fx.Provide(hook.NewService),
 		fx.Provide(recorder.NewService),
 		fx.Populate(&recorderService),
 	)
@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"fmt"
+
+	"github.com/eric2788/bilirec/pkg/pool"
+)
+
+const activeRecordingBucket = "Active_Recordings"
+
+// Origin is how a recording was started
+type Origin string
+
+const (
+	OriginManual     Origin = "manual"
+	OriginAutoRecord Origin = "auto_record"
+)
+
+// activeRecording is persisted while the session is ongoing,
+// the entry is left behind if the process exits without stopping the recording.
+type activeRecording struct {
+	RoomID    int
+	Origin    Origin
+	SessionID string
+}
+
+var activeRecordingSerializer = pool.NewSerializer()
+
+func (r *Service) saveActive(s *session) {
+	if r.active == nil {
+		return
+	}
+	data, err := activeRecordingSerializer.Serialize(&activeRecording{
+		RoomID:    s.roomId,
+		Origin:    s.origin,
+		SessionID: s.id,
+	})
+	if err == nil {
+		err = r.active.Put(fmt.Append(nil, s.roomId), data)
+	}
+	if err != nil {
+		logger.WithField("room", s.roomId).Warnf("cannot persist active recording, it will not be resumed after restart: %v", err)
+	}
+}
+
+func (r *Service) removeActive(roomId int) {
+	if r.active == nil {
+		return
+	}
+	if err := r.active.Delete(fmt.Append(nil, roomId)); err != nil {
+		logger.WithField("room", roomId).Warnf("cannot remove active recording: %v", err)
+	}
+}
+
+func (r *Service) listActive() ([]*activeRecording, error) {
+	recordings := make([]*activeRecording, 0)
+	err := r.active.ForEach(func(k, v []byte) error {
+		var rec activeRecording
+		if err := activeRecordingSerializer.Deserialize(v, &rec); err != nil {
+			logger.Warnf("error scaning active recording: %s: %v, ignored.", string(k), err)
+			return nil
+		}
+		recordings = append(recordings, &rec)
+		return nil
+	})
+	return recordings, err
+}
+
+// resumeRecordings restarts the recordings that were still ongoing when the process exited,
+// rooms no longer live are dropped. Resumed recordings start new segment files.
+func (r *Service) resumeRecordings() {
+	recordings, err := r.listActive()
+	if err != nil {
+		logger.Errorf("cannot list active recordings to resume: %v", err)
+		return
+	}
+	for _, rec := range recordings {
+		l := logger.WithField("room", rec.RoomID)
+		err := r.start(rec.RoomID, rec.Origin, true)
+		switch err {
+		case nil:
+			l.Infof("resumed %s recording of session %s", rec.Origin, rec.SessionID)
+		case ErrRecordingStarted:
+			l.Debug("recording already started, skipped resuming")
+		default:
+			l.Infof("cannot resume recording of session %s: %v", rec.SessionID, err)
+			r.removeActive(rec.RoomID)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package recorder
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/bilibili"
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/internal/processors"
+	"github.com/eric2788/bilirec/pkg/danmaku"
+	"github.com/eric2788/bilirec/pkg/pipeline"
+)
+
+// segment is a single output file of a recording,
+// a recording produces multiple segments when segmenting is enabled.
+type segment struct {
+	path       string
+	startTime  time.Time
+	chatWriter *danmaku.XMLWriter
+}
+
+func (r *Service) segmentingEnabled() bool {
+	return r.cfg.SegmentDurationMinutes > 0 || r.cfg.SegmentSizeBytes > 0
+}
+
+func (r *Service) newStreamWriter(roomId int, info *Recorder) *pipeline.ProcessorInfo[[]byte] {
+	path := info.segment.Load().path
+	bufferSize := config.ReadOnly.LiveStreamWriterBufferSize()
+
+	if !r.segmentingEnabled() {
+		return processors.NewBufferedStreamWriter(path, bufferSize)
+	} else if info.stream.Format != bilibili.FormatFLV {
+		// segments can only be split at FLV keyframes for now
+		logger.WithField("room", roomId).Debugf("segmenting is not supported for %s stream, recording into a single file", info.stream.Format)
+		return processors.NewBufferedStreamWriter(path, bufferSize)
+	}
+
+	return processors.NewFlvSegmentWriter(path, bufferSize, processors.SegmentOptions{
+		MaxDuration: time.Duration(r.cfg.SegmentDurationMinutes) * time.Minute,
+		MaxBytes:    r.cfg.SegmentSizeBytes,
+		NextPath: func() (string, error) {
+			next, err := r.prepareFilePath(info.room, time.Now(), fileFormats[bilibili.FormatFLV], len(info.session.listSegments())+1)
+			if err != nil {
+				return "", err
+			}
+			r.writtingFiles.Add(filepath.Base(next))
+			return next, nil
+		},
+		OnRotate: func(prev, next string) {
+			r.rotateSegment(roomId, info, next)
+		},
+	})
+}
+
+// nextSegmentIndex returns the index of the next file of the room session,
+// recovering recordings continue the index of their session.
+func (r *Service) nextSegmentIndex(roomId int) int {
+	if existing, ok := r.recording.Load(roomId); ok && existing.session != nil {
+		return len(existing.session.listSegments()) + 1
+	}
+	return 1
+}
+
+// rotateSegment switches the recording to the next segment file
+// and finalizes the previous one in background.
+func (r *Service) rotateSegment(roomId int, info *Recorder, next string) {
+	prev := info.segment.Load()
+	seg := &segment{path: next, startTime: time.Now()}
+	info.session.addSegment(next)
+	if info.session.chat != nil {
+		r.rotateChat(roomId, info, seg)
+	}
+	info.segment.Store(seg)
+	r.tasks.Go(func() { r.finalize(roomId, info.session, prev) })
+}
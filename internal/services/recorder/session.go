@@ -0,0 +1,153 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/danmaku"
+	"github.com/eric2788/bilirec/utils"
+)
+
+const eventsFileFormat = "events.jsonl"
+
+// session spans from the first start of a recording until it is stopped,
+// including all recovery attempts and the segment files they produce.
+type session struct {
+	id        string
+	roomId    int
+	startTime time.Time
+	title     string
+	uname     string
+	area      string
+	origin    Origin
+
+	bytesWritten     atomic.Uint64
+	recoveryAttempts atomic.Int32
+
+	mu       sync.Mutex
+	segments []string
+	// segments not finalized yet
+	finalizing sync.WaitGroup
+
+	chat   *chatRecorder
+	events *eventLog
+
+	cancel context.CancelFunc
+}
+
+// attachSession reuses the session of a recovering recording, or opens a new one
+func (r *Service) attachSession(roomId int, info *Recorder) {
+	if existing, ok := r.recording.Load(roomId); ok && existing.session != nil {
+		info.session = existing.session
+		return
+	}
+	info.session = r.newSession(roomId, info)
+	r.saveActive(info.session)
+	// a resumed session is not started again for the subscribers
+	if info.resumed {
+		r.notify.PublishRecordingResumed(roomId, recordingData(info))
+	} else {
+		r.notify.PublishRecordingStarted(roomId, recordingData(info))
+	}
+}
+
+func (r *Service) newSession(roomId int, info *Recorder) *session {
+	l := logger.WithField("room", roomId)
+	ctx, cancel := context.WithCancel(r.ctx)
+
+	s := &session{
+		id:        fmt.Sprintf("%d_%s", roomId, info.startTime.Format("20060102_150405")),
+		roomId:    roomId,
+		startTime: info.startTime,
+		title:     info.room.Title,
+		uname:     info.room.Uname,
+		area:      info.room.AreaName,
+		origin:    info.origin,
+		cancel:    cancel,
+	}
+
+	if r.cfg.RecordDanmaku {
+		s.chat = newChatRecorder(ctx, l)
+	}
+
+	if r.cfg.RecordLiveEvents {
+		path := utils.ChangePathFormat(info.segment.Load().path, eventsFileFormat)
+		if events, err := newEventLog(path, s.id, l); err != nil {
+			l.Warnf("cannot create live events file, live events will not be recorded: %v", err)
+		} else {
+			s.events = events
+			r.writtingFiles.Add(filepath.Base(path))
+			r.tasks.Go(func() { r.flushEventsPeriodically(ctx, events) })
+		}
+	}
+
+	if s.chat != nil || s.events != nil {
+		client := danmaku.NewClient(func() (*danmaku.AuthParams, error) {
+			return r.bilic.GetDanmakuAuth(roomId)
+		}, s.handle)
+		go client.Run(ctx)
+	}
+
+	return s
+}
+
+func (s *session) addSegment(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segments = append(s.segments, path)
+	s.finalizing.Add(1)
+}
+
+func (s *session) listSegments() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.segments)
+}
+
+func (s *session) handle(msg *danmaku.Message) {
+	if msg.Cmd == danmaku.CmdDanmaku {
+		if s.chat != nil {
+			s.chat.handle(msg)
+		}
+	} else if s.events != nil {
+		s.events.handle(msg)
+	}
+}
+
+// summary returns nil if live events are not recorded in this session
+func (s *session) summary() *SessionSummary {
+	if s.events == nil {
+		return nil
+	}
+	return s.events.summarize()
+}
+
+func (s *session) close() {
+	s.cancel()
+}
+
+// the events file is closed once the session context is done,
+// either by stopping the recording or shutting down the service.
+func (r *Service) flushEventsPeriodically(ctx context.Context, events *eventLog) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := events.flush(); err != nil {
+				events.l.Warnf("cannot flush live events file: %v", err)
+			}
+		case <-ctx.Done():
+			if err := events.close(); err != nil {
+				events.l.Warnf("cannot close live events file: %v", err)
+			}
+			r.writtingFiles.Remove(filepath.Base(events.path))
+			return
+		}
+	}
+}
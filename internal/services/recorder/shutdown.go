@@ -0,0 +1,120 @@
+package recorder
+
+import (
+	"context"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/pool"
+)
+
+const (
+	shutdownReportBucket = "Shutdown_Report"
+	shutdownReportKey    = "last"
+)
+
+// ShutdownReport lists the recordings interrupted by the last shutdown
+type ShutdownReport struct {
+	Time int64 `json:"time"`
+	// Drained is false if the shutdown timed out before all files were finalized
+	Drained     bool                    `json:"drained"`
+	Interrupted []*InterruptedRecording `json:"interrupted"`
+}
+
+type InterruptedRecording struct {
+	RoomID       int      `json:"room_id"`
+	SessionID    string   `json:"session_id"`
+	Title        string   `json:"title"`
+	StartTime    int64    `json:"start_time"`
+	BytesWritten uint64   `json:"bytes_written"`
+	Segments     []string `json:"segments"`
+}
+
+var shutdownReportSerializer = pool.NewSerializer()
+
+// shutdown drains every active recording before the database is closed:
+// streams stop being read, writers are flushed and closed by their pipeline,
+// then each file is finalized so conversions are enqueued before the convert service stops.
+// Interrupted recordings are kept as active so they are resumed after restart.
+func (r *Service) shutdown(ctx context.Context) error {
+	sessions := make([]*session, 0)
+	r.recording.Range(func(roomId int, info *Recorder) bool {
+		if info.session != nil {
+			sessions = append(sessions, info.session)
+		}
+		return true
+	})
+
+	if len(sessions) > 0 {
+		logger.Infof("draining %d active recordings before shutdown...", len(sessions))
+	}
+	r.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		r.tasks.Wait()
+		close(drained)
+	}()
+
+	report := &ShutdownReport{Drained: true, Interrupted: make([]*InterruptedRecording, 0, len(sessions))}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		logger.Warnf("shutdown timed out, some recordings may not be finalized")
+		report.Drained = false
+	}
+	report.Time = time.Now().Unix()
+
+	for _, s := range sessions {
+		logger.WithField("room", s.roomId).Infof("recording session %s interrupted by shutdown", s.id)
+		r.saveHistory(s, StopInterrupted)
+		// resumed recordings start a new session, so the files of this one are merged now
+		if report.Drained {
+			r.mergeSession(s)
+		}
+		report.Interrupted = append(report.Interrupted, &InterruptedRecording{
+			RoomID:       s.roomId,
+			SessionID:    s.id,
+			Title:        s.title,
+			StartTime:    s.startTime.Unix(),
+			BytesWritten: s.bytesWritten.Load(),
+			Segments:     s.listSegments(),
+		})
+	}
+	r.saveShutdownReport(report)
+
+	if r.db == nil {
+		return nil
+	}
+	return r.db.Close()
+}
+
+func (r *Service) saveShutdownReport(report *ShutdownReport) {
+	if r.reports == nil {
+		return
+	}
+	data, err := shutdownReportSerializer.Serialize(report)
+	if err == nil {
+		err = r.reports.Put([]byte(shutdownReportKey), data)
+	}
+	if err != nil {
+		logger.Errorf("cannot save shutdown report: %v", err)
+	}
+}
+
+// GetShutdownReport returns the report of the last shutdown, nil if there is none
+func (r *Service) GetShutdownReport() (*ShutdownReport, error) {
+	if r.reports == nil {
+		return nil, nil
+	}
+	var report *ShutdownReport
+	err := r.reports.GetFunc([]byte(shutdownReportKey), func(b []byte) error {
+		report = &ShutdownReport{}
+		if err := shutdownReportSerializer.Deserialize(b, report); err != nil {
+			return err
+		} else if report.Interrupted == nil {
+			report.Interrupted = make([]*InterruptedRecording, 0)
+		}
+		return nil
+	})
+	return report, err
+}
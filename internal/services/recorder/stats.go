@@ -3,9 +3,10 @@ package recorder
 import (
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/eric2788/bilirec/pkg/flv"
 )
 
 type Stats struct {
@@ -14,6 +15,13 @@ type Stats struct {
 	StartTime      int64        `json:"start_time"`
 	ElapsedSeconds int64        `json:"elapsed_seconds"`
 	OutputPath     string       `json:"output_path"`
+	SessionID      string       `json:"session_id,omitempty"`
+	Quality        int          `json:"quality"`
+	Codec          string       `json:"codec"`
+	Format         string       `json:"format"`
+	Origin         Origin       `json:"origin"`
+	// only available for FLV streams
+	Health *flv.StreamHealth `json:"health,omitempty"`
 }
 
 func (r *Service) GetStatus(roomId int) RecordStatus {
@@ -42,44 +50,77 @@ func (r *Service) GetStats(roomId int) (*Stats, bool) {
 		return nil, false
 	}
 	status := r.GetStatus(roomId)
+	sessionId := ""
+	if info.session != nil {
+		sessionId = info.session.id
+	}
+	var health *flv.StreamHealth
+	if info.health != nil {
+		snapshot := info.health.Snapshot()
+		health = &snapshot
+	}
 	return &Stats{
 		BytesWritten:   info.bytesRead.Load(),
 		Status:         status,
 		StartTime:      info.startTime.Unix(),
 		ElapsedSeconds: int64(time.Since(info.startTime).Seconds()),
-		OutputPath:     info.outputPath,
+		OutputPath:     info.segment.Load().path,
+		SessionID:      sessionId,
+		Quality:        info.stream.Qn,
+		Codec:          info.stream.Codec,
+		Format:         info.stream.Format,
+		Origin:         info.origin,
+		Health:         health,
 	}, true
 }
 
+// GetSessionSummary returns the live events summary of the ongoing session of the room,
+// returns false if the room is not recording or live events are not recorded.
+func (r *Service) GetSessionSummary(roomId int) (*SessionSummary, bool) {
+	info, ok := r.recording.Load(roomId)
+	if !ok || info.session == nil {
+		return nil, false
+	}
+	summary := info.session.summary()
+	return summary, summary != nil
+}
+
 func (r *Service) IsRecording(path string) bool {
 	return r.writtingFiles.Contains(filepath.Base(path))
 }
 
+// ListActiveSegments returns the segment files of every ongoing session by session id,
+// finished segments are included since the sidecar files of the session may still be written.
+func (r *Service) ListActiveSegments() map[string]SessionSegments {
+	segments := make(map[string]SessionSegments)
+	r.recording.Range(func(roomId int, info *Recorder) bool {
+		if info.session != nil {
+			segments[info.session.id] = SessionSegments{RoomID: roomId, Segments: info.session.listSegments()}
+		}
+		return true
+	})
+	return segments
+}
+
 // IsRecordingUnder checks if any recordings are happening under the given relative path.
-// The path format is {username}-{roomID} or {username}-{roomID}/{subpath}
+// The directories depend on the naming template, so the current file of every recording is compared.
 func (r *Service) IsRecordingUnder(relPath string) bool {
-	// Normalize the path
-	cleanPath := filepath.Clean(relPath)
-	parts := strings.SplitN(cleanPath, string(os.PathSeparator), 2)
-
-	if len(parts) == 0 {
-		return false
-	}
-
-	// Extract room ID from first segment: {username}-{roomID}
-	dirName := parts[0]
-	segments := strings.Split(dirName, "-")
-	if len(segments) < 2 {
-		return false
-	}
-
-	// Room ID should be the last segment after the last dash
-	roomIdStr := segments[len(segments)-1]
-	roomId, err := strconv.Atoi(roomIdStr)
+	base, err := filepath.Abs(r.cfg.OutputDir)
 	if err != nil {
 		return false
 	}
+	// Normalize the path, it cannot go above the output directory
+	dir := filepath.Join(base, filepath.Clean(string(os.PathSeparator)+relPath))
 
-	_, ok := r.recording.Load(roomId)
-	return ok
+	found := false
+	r.recording.Range(func(roomId int, info *Recorder) bool {
+		seg := info.segment.Load()
+		if seg == nil {
+			return true
+		}
+		path, err := filepath.Abs(seg.path)
+		found = err == nil && strings.HasPrefix(path, dir+string(os.PathSeparator))
+		return !found
+	})
+	return found
 }
@@ -0,0 +1,44 @@
+package recorder
+
+import (
+	"context"
+	"time"
+)
+
+// watchStall closes the stream connection if no data arrives within the stall timeout,
+// the recording then goes through recover and reconnects with a fresh stream url.
+func (r *Service) watchStall(roomId int, ctx context.Context, info *Recorder) {
+	timeout := time.Duration(r.cfg.StallTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(timeout/5, time.Second))
+	defer ticker.Stop()
+
+	lastProgress := info.progress()
+	lastProgressAt := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			if progress := info.progress(); progress != lastProgress {
+				lastProgress = progress
+				lastProgressAt = time.Now()
+			} else if time.Since(lastProgressAt) >= timeout {
+				logger.WithField("room", roomId).Warnf("stream stalled for %v, reconnecting", timeout)
+				info.cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// progress grows as the stream is delivered, FLV streams count the video frames
+// so a connection that keeps sending audio only is also treated as stalled.
+func (info *Recorder) progress() uint64 {
+	if info.health != nil {
+		return uint64(info.health.VideoFrames())
+	}
+	return info.bytesRead.Load()
+}
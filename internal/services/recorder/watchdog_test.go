@@ -0,0 +1,55 @@
+package recorder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/internal/processors"
+	"github.com/eric2788/bilirec/pkg/flv"
+	"github.com/eric2788/bilirec/pkg/pipeline"
+)
+
+func TestWatchStall_AudioOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recordCtx, recordCancel := context.WithCancel(ctx)
+	info := &Recorder{health: flv.NewHealthMonitor(), cancel: recordCancel}
+	pipe := pipeline.New(processors.NewFlvStreamFixerWithHealth(info.health))
+	if err := pipe.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	feed := func(data []byte) {
+		if _, err := pipe.Process(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+		info.bytesRead.Add(uint64(len(data)))
+	}
+	feed(append(append([]byte{}, flv.FlvHeader...), 0, 0, 0, 0))
+	feed(flv.MarshalTag(flv.TagTypeVideo, 0, []byte{0x17, 0x01, 0x01}))
+
+	r := &Service{cfg: &config.Config{StallTimeoutSeconds: 1}}
+	go r.watchStall(1, ctx, info)
+
+	// audio keeps arriving while the video stopped
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(5 * time.Second)
+	for ts := int32(100); ; ts += 100 {
+		select {
+		case <-ticker.C:
+			feed(flv.MarshalTag(flv.TagTypeAudio, ts, []byte{0xAF, 0x01, 0x02}))
+		case <-recordCtx.Done():
+			if info.health.VideoFrames() != 1 {
+				t.Errorf("expected 1 video frame, got %d", info.health.VideoFrames())
+			}
+			return
+		case <-deadline:
+			t.Fatal("recording should be reconnected when only audio is received")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package retention
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordings of files without a known session and not in a {uname}-{room_id} directory
+const unknownRoom = 0
+
+// directories of the default naming template end with the room id
+var roomDirPattern = regexp.MustCompile(`-(\d+)$`)
+
+// recording groups the files of one session of a room:
+// the media segments with their danmaku, live events and converted files.
+type recording struct {
+	key     string
+	roomId  int
+	files   []string
+	size    int64
+	modTime time.Time // latest modification of the files
+	// protected recordings are being recorded or converted and must not be touched
+	protected bool
+}
+
+// sessionRef is the recording session and room of a file stem
+type sessionRef struct {
+	id     string
+	roomId int
+}
+
+type policy struct {
+	maxAge       time.Duration
+	maxRoomBytes int64
+	keepSessions int
+}
+
+func (p *policy) enabled() bool {
+	return p.maxAge > 0 || p.maxRoomBytes > 0 || p.keepSessions > 0
+}
+
+// expired returns the recordings of a room to be removed, protected recordings are never returned.
+// Protected recordings still count as sessions and take up the room size.
+func (p *policy) expired(recordings []*recording, now time.Time) []*recording {
+	sorted := slices.Clone(recordings)
+	slices.SortFunc(sorted, func(a, b *recording) int {
+		return b.modTime.Compare(a.modTime)
+	})
+
+	removed := make([]*recording, 0)
+	kept := make([]*recording, 0, len(sorted))
+	var total int64
+	for i, rec := range sorted {
+		tooOld := p.maxAge > 0 && now.Sub(rec.modTime) > p.maxAge
+		tooMany := p.keepSessions > 0 && i >= p.keepSessions
+		if !rec.protected && (tooOld || tooMany) {
+			removed = append(removed, rec)
+			continue
+		}
+		kept = append(kept, rec)
+		total += rec.size
+	}
+
+	// drop the oldest until the room fits in the size limit
+	for i := len(kept) - 1; i >= 0 && p.maxRoomBytes > 0 && total > p.maxRoomBytes; i-- {
+		if kept[i].protected {
+			continue
+		}
+		removed = append(removed, kept[i])
+		total -= kept[i].size
+	}
+	return removed
+}
+
+// deletable returns the recordings of a room which can be removed to free disk space from the oldest,
+// the newest sessions kept by the rules and protected recordings are left.
+func (p *policy) deletable(recordings []*recording) []*recording {
+	sorted := slices.Clone(recordings)
+	slices.SortFunc(sorted, func(a, b *recording) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	if p.keepSessions > 0 {
+		sorted = sorted[:max(len(sorted)-p.keepSessions, 0)]
+	}
+	return slices.DeleteFunc(sorted, func(rec *recording) bool { return rec.protected })
+}
+
+// convertedFlv returns the flv files of the recording which have been converted to mp4
+func convertedFlv(files []string) []string {
+	converted := make([]string, 0)
+	for _, file := range files {
+		if filepath.Ext(file) != ".flv" {
+			continue
+		} else if slices.Contains(files, strings.TrimSuffix(file, ".flv")+".mp4") {
+			converted = append(converted, file)
+		}
+	}
+	return converted
+}
+
+// stemOf strips the format from a file name, so the sidecar files share the stem of their segment
+func stemOf(name string) string {
+	if stem, ok := strings.CutSuffix(name, eventsSuffix); ok {
+		return stem
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// roomOfPath finds the room of a file not recorded in the history by its directories from the nearest,
+// which are named {uname}-{room_id} by the default naming template and the versions before it.
+func roomOfPath(rel string) int {
+	for dir := filepath.Dir(rel); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if match := roomDirPattern.FindStringSubmatch(filepath.Base(dir)); match != nil {
+			if roomId, err := strconv.Atoi(match[1]); err == nil {
+				return roomId
+			}
+		}
+	}
+	return unknownRoom
+}
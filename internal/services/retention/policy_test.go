@@ -0,0 +1,198 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func keysOf(recordings []*recording) []string {
+	keys := make([]string, 0, len(recordings))
+	for _, rec := range recordings {
+		keys = append(keys, rec.key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestPolicy_Expired(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	recordings := []*recording{
+		{key: "a", size: 100, modTime: now.Add(-10 * day)},
+		{key: "b", size: 100, modTime: now.Add(-5 * day)},
+		{key: "c", size: 100, modTime: now.Add(-2 * day)},
+		{key: "d", size: 100, modTime: now, protected: true},
+	}
+
+	cases := []struct {
+		name     string
+		policy   policy
+		expected []string
+	}{
+		{"max age", policy{maxAge: 7 * day}, []string{"a"}},
+		{"keep sessions", policy{keepSessions: 2}, []string{"a", "b"}},
+		{"max room size", policy{maxRoomBytes: 250}, []string{"a", "b"}},
+		{"combined", policy{maxAge: 7 * day, maxRoomBytes: 300}, []string{"a"}},
+		{"protected only", policy{keepSessions: 1, maxRoomBytes: 50}, []string{"a", "b", "c"}},
+		{"disabled", policy{}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := keysOf(c.policy.expired(recordings, now))
+			if !slices.Equal(got, c.expected) {
+				t.Errorf("expected %v to expire, got %v", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestPolicy_Deletable(t *testing.T) {
+	now := time.Now()
+	recordings := []*recording{
+		{key: "b", modTime: now.Add(-2 * time.Hour)},
+		{key: "a", modTime: now.Add(-3 * time.Hour), protected: true},
+		{key: "c", modTime: now.Add(-time.Hour)},
+		{key: "d", modTime: now},
+	}
+	p := policy{keepSessions: 2}
+	got := p.deletable(recordings)
+	if len(got) != 1 || got[0].key != "b" {
+		t.Errorf("expected only b to be deletable, got %v", keysOf(got))
+	}
+	p = policy{}
+	if got := p.deletable(recordings); len(got) != 3 || got[0].key != "b" || got[2].key != "d" {
+		t.Errorf("expected unprotected recordings from the oldest, got %v", keysOf(got))
+	}
+}
+
+func TestConvertedFlv(t *testing.T) {
+	files := []string{"a/x.flv", "a/x.mp4", "a/x.xml", "a/y.flv", "a/y.xml"}
+	if got := convertedFlv(files); !slices.Equal(got, []string{"a/x.flv"}) {
+		t.Errorf("unexpected converted flv: %v", got)
+	}
+}
+
+func TestStemOf(t *testing.T) {
+	for name, expected := range map[string]string{
+		"title-20240101_120000.flv":          "title-20240101_120000",
+		"title-20240101_120000.events.jsonl": "title-20240101_120000",
+		"title-20240101_120000.xml":          "title-20240101_120000",
+		"noext":                              "noext",
+	} {
+		if got := stemOf(name); got != expected {
+			t.Errorf("stemOf(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestGroupFiles_SkipsTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"foo.flv", "foo.xml", "foo.flv.tmp", "bar.flv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordings, err := groupFiles(dir, map[string]sessionRef{"foo": {id: "room_session", roomId: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keysOf(recordings); !slices.Equal(got, []string{filepath.Join(dir, "bar"), "room_session"}) {
+		t.Fatalf("unexpected recordings %v", got)
+	}
+	for _, rec := range recordings {
+		for _, file := range rec.files {
+			if filepath.Ext(file) == ".tmp" {
+				t.Errorf("temporary file %s should not belong to a recording", file)
+			}
+		}
+	}
+}
+
+func TestGroupFiles_ByRoom(t *testing.T) {
+	now := time.Now()
+	sessions := map[string]sessionRef{
+		"a1-20240101_120000": {id: "a1", roomId: 1},
+		"a2-20240102_120000": {id: "a2", roomId: 1},
+		"b1-20240101_130000": {id: "b1", roomId: 2},
+		"b2-20240102_130000": {id: "b2", roomId: 2},
+	}
+	cases := map[string]map[string]time.Duration{
+		// {yyyy}/{uname}-{room_id}/{title}-{date}_{time}
+		"nested": {
+			"2024/alice-1/a1-20240101_120000.flv":   -4 * time.Hour,
+			"2024/alice-1/a1-20240101_120000.xml":   -4 * time.Hour,
+			"2024/alice-1/a2-20240102_120000.flv":   -2 * time.Hour,
+			"2024/bob-2/b1-20240101_130000.flv":     -3 * time.Hour,
+			"2024/bob-2/b2-20240102_130000.flv":     -time.Hour,
+			"2024/carol-3/c1-20240101_140000.flv":   -5 * time.Hour,
+			"2024/carol-3/c2-20240102_140000.flv":   -time.Hour,
+			"2024/misc/unknown-20240101_150000.flv": -time.Hour,
+		},
+		// {title}-{date}_{time} directly under the output directory
+		"flat": {
+			"a1-20240101_120000.flv":          -4 * time.Hour,
+			"a1-20240101_120000.events.jsonl": -4 * time.Hour,
+			"a2-20240102_120000.flv":          -2 * time.Hour,
+			"b1-20240101_130000.flv":          -3 * time.Hour,
+			"b2-20240102_130000.flv":          -time.Hour,
+		},
+	}
+	expectedRooms := map[string]map[int]int{
+		"nested": {1: 2, 2: 2, 3: 2, unknownRoom: 1},
+		"flat":   {1: 2, 2: 2},
+	}
+	// the first file of the expired recordings
+	expectedExpired := map[string][]string{
+		"nested": {"2024/alice-1/a1-20240101_120000.flv", "2024/bob-2/b1-20240101_130000.flv", "2024/carol-3/c1-20240101_140000.flv"},
+		"flat":   {"a1-20240101_120000.events.jsonl", "b1-20240101_130000.flv"},
+	}
+
+	for name, files := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, age := range files {
+				path := filepath.Join(dir, filepath.FromSlash(file))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				} else if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+					t.Fatal(err)
+				} else if err := os.Chtimes(path, now.Add(age), now.Add(age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			recordings, err := groupFiles(dir, sessions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rooms := make(map[int][]*recording)
+			for _, rec := range recordings {
+				rooms[rec.roomId] = append(rooms[rec.roomId], rec)
+			}
+			for room, count := range expectedRooms[name] {
+				if len(rooms[room]) != count {
+					t.Errorf("expected %d recordings of room %d, got %v", count, room, keysOf(rooms[room]))
+				}
+			}
+
+			// only the newest session of every room is kept
+			p := policy{keepSessions: 1}
+			expired := make([]string, 0)
+			for room, recordings := range rooms {
+				if room == unknownRoom {
+					continue
+				}
+				for _, rec := range p.expired(recordings, now) {
+					rel, _ := filepath.Rel(dir, rec.files[0])
+					expired = append(expired, filepath.ToSlash(rel))
+				}
+			}
+			slices.Sort(expired)
+			if !slices.Equal(expired, expectedExpired[name]) {
+				t.Errorf("expected %v to expire, got %v", expectedExpired[name], expired)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/modules/config"
+	"github.com/eric2788/bilirec/internal/services/convert"
+	"github.com/eric2788/bilirec/internal/services/recorder"
+	"github.com/eric2788/bilirec/pkg/ds"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+var logger = logrus.WithField("service", "retention")
+
+const (
+	eventsSuffix = ".events.jsonl"
+	tmpSuffix    = ".tmp"
+)
+
+// Report lists the files handled by a retention run
+type Report struct {
+	Removed    []string `json:"removed"`
+	Archived   []string `json:"archived"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+type Service struct {
+	cfg    *config.Config
+	recSvc *recorder.Service
+	cvSvc  *convert.Service
+	policy *policy
+
+	// only one run at a time
+	mu sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(lc fx.Lifecycle, cfg *config.Config, recSvc *recorder.Service, cvSvc *convert.Service) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		cfg:    cfg,
+		recSvc: recSvc,
+		cvSvc:  cvSvc,
+		policy: &policy{
+			maxAge:       time.Duration(cfg.RetentionMaxAgeDays) * 24 * time.Hour,
+			maxRoomBytes: cfg.RetentionMaxRoomBytes,
+			keepSessions: cfg.RetentionKeepSessions,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	recSvc.SetSpaceFreer(s.FreeSpace)
+	lc.Append(fx.StartStopHook(s.start, s.stop))
+	return s
+}
+
+func (s *Service) enabled() bool {
+	return s.policy.enabled() || s.cfg.RetentionDropConvertedFlv
+}
+
+func (s *Service) start() error {
+	if !s.enabled() {
+		logger.Info("no retention rules configured, retention disabled")
+		return nil
+	}
+	go s.loop()
+	return nil
+}
+
+func (s *Service) stop() error {
+	s.cancel()
+	return nil
+}
+
+func (s *Service) loop() {
+	ticker := time.NewTicker(time.Duration(max(s.cfg.RetentionIntervalMinutes, 1)) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := s.Run()
+			if err != nil {
+				logger.Warnf("retention run failed: %v", err)
+			} else if len(report.Removed) > 0 || len(report.Archived) > 0 {
+				logger.Infof("retention removed %d files and archived %d files, %d MB freed", len(report.Removed), len(report.Archived), report.FreedBytes/1024/1024)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Run applies the retention rules to the recordings of every room under the output directory.
+// Recordings are removed as a whole, or moved to the archive directory if configured.
+func (s *Service) Run() (*Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms, err := s.scanRooms()
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Removed: make([]string, 0), Archived: make([]string, 0)}
+	now := time.Now()
+	for room, recordings := range rooms {
+		if s.cfg.RetentionDropConvertedFlv {
+			for _, rec := range recordings {
+				s.dropConvertedFlv(rec, report)
+			}
+		}
+		for _, rec := range s.policyOf(room).expired(recordings, now) {
+			logger.Debugf("recording %s of room %d expired", rec.key, room)
+			s.discard(rec, report)
+		}
+	}
+	return report, nil
+}
+
+// FreeSpace discards the oldest recordings of all rooms until the required bytes are freed, used when the disk is low.
+// Sessions are discarded as a whole like expired ones, the newest sessions kept by the rules are left.
+func (s *Service) FreeSpace(required uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms, err := s.scanRooms()
+	if err != nil {
+		logger.Warnf("cannot list recordings to free disk space: %v", err)
+		return 0
+	}
+	candidates := make([]*recording, 0)
+	for room, recordings := range rooms {
+		candidates = append(candidates, s.policyOf(room).deletable(recordings)...)
+	}
+	slices.SortFunc(candidates, func(a, b *recording) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	report := &Report{Removed: make([]string, 0), Archived: make([]string, 0)}
+	for _, rec := range candidates {
+		if uint64(report.FreedBytes) >= required {
+			break
+		}
+		freed := report.FreedBytes
+		s.discard(rec, report)
+		logger.Warnf("discarded recording %s (%d MB) to free disk space", rec.key, (report.FreedBytes-freed)/1024/1024)
+	}
+	return uint64(report.FreedBytes)
+}
+
+// policyOf returns the rules of a room, the per-room limits cannot be applied
+// to the recordings of unknown rooms so only their age is checked.
+func (s *Service) policyOf(room int) *policy {
+	if room == unknownRoom {
+		return &policy{maxAge: s.policy.maxAge}
+	}
+	return s.policy
+}
+
+// scanRooms groups the recordings under the output directory by their room,
+// the naming template decides the layout so the directories cannot tell the room.
+func (s *Service) scanRooms() (map[int][]*recording, error) {
+	// nothing can be protected from conversion if the queue is unknown
+	queued, err := s.queuedFiles()
+	if err != nil {
+		return nil, err
+	}
+	sessions, active := s.indexSessions()
+
+	// the archive and database directories may be placed under the output directory
+	excluded := make([]string, 0, 2)
+	for _, dir := range []string{s.cfg.RetentionArchiveDir, s.cfg.DatabaseDir} {
+		if abs, err := filepath.Abs(dir); dir != "" && err == nil {
+			excluded = append(excluded, abs)
+		}
+	}
+	recordings, err := groupFiles(s.cfg.OutputDir, sessions, excluded...)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make(map[int][]*recording)
+	for _, rec := range recordings {
+		rec.protected = active.Contains(rec.key) || slices.ContainsFunc(rec.files, func(path string) bool {
+			abs, err := filepath.Abs(path)
+			return s.recSvc.IsRecording(path) || err != nil || queued.Contains(abs)
+		})
+		rooms[rec.roomId] = append(rooms[rec.roomId], rec)
+	}
+	return rooms, nil
+}
+
+// indexSessions maps the file stems to their session, so segments of one session are kept or removed together
+func (s *Service) indexSessions() (map[string]sessionRef, ds.Set[string]) {
+	sessions := make(map[string]sessionRef)
+	active := ds.NewSet[string]()
+
+	index := func(id string, session recorder.SessionSegments) {
+		for _, segment := range session.Segments {
+			sessions[stemOf(filepath.Base(segment))] = sessionRef{id: id, roomId: session.RoomID}
+		}
+	}
+	if history, err := s.recSvc.HistorySegments(); err != nil {
+		logger.Warnf("cannot list record history, segments are handled separately: %v", err)
+	} else {
+		for id, session := range history {
+			index(id, session)
+		}
+	}
+	for id, session := range s.recSvc.ListActiveSegments() {
+		active.Add(id)
+		index(id, session)
+	}
+	return sessions, active
+}
+
+// queuedFiles returns the absolute paths of the inputs and outputs in the convert queue
+func (s *Service) queuedFiles() (ds.Set[string], error) {
+	queued := ds.NewSet[string]()
+	queues, err := s.cvSvc.ListInProgress()
+	if errors.Is(err, convert.ErrNoConvertManager) {
+		return queued, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, q := range queues {
+		for _, path := range append([]string{q.InputPath, q.OutputPath}, q.InputPaths...) {
+			if abs, err := filepath.Abs(path); err == nil {
+				queued.Add(abs)
+			}
+		}
+	}
+	return queued, nil
+}
+
+// groupFiles groups the files under a directory by their session or stem with the room they belong to,
+// the naming template may place them in nested directories or directly under the directory.
+func groupFiles(dir string, sessions map[string]sessionRef, excluded ...string) ([]*recording, error) {
+	grouped := make(map[string]*recording)
+	recordings := make([]*recording, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if entry.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil && slices.Contains(excluded, abs) {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if filepath.Ext(entry.Name()) == tmpSuffix {
+			// written by metadata injection or a conversion in progress, renamed once done
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		stem := stemOf(entry.Name())
+		ref, ok := sessions[stem]
+		if !ok {
+			rel, _ := filepath.Rel(dir, path)
+			ref = sessionRef{id: filepath.Join(filepath.Dir(path), stem), roomId: roomOfPath(rel)}
+		}
+		rec, ok := grouped[ref.id]
+		if !ok {
+			rec = &recording{key: ref.id, roomId: ref.roomId}
+			grouped[ref.id] = rec
+			recordings = append(recordings, rec)
+		}
+
+		rec.files = append(rec.files, path)
+		rec.size += info.Size()
+		if info.ModTime().After(rec.modTime) {
+			rec.modTime = info.ModTime()
+		}
+		return nil
+	})
+	return recordings, err
+}
+
+func (s *Service) dropConvertedFlv(rec *recording, report *Report) {
+	if rec.protected {
+		return
+	}
+	for _, path := range convertedFlv(rec.files) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Warnf("cannot remove converted flv %s: %v", path, err)
+			continue
+		}
+		logger.Infof("removed %s as it has been converted to mp4", path)
+		report.Removed = append(report.Removed, path)
+		report.FreedBytes += info.Size()
+		rec.size -= info.Size()
+		rec.files = slices.DeleteFunc(rec.files, func(file string) bool { return file == path })
+	}
+}
+
+// discard removes the files of a recording, or moves them to the archive directory
+func (s *Service) discard(rec *recording, report *Report) {
+	for _, path := range rec.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if s.cfg.RetentionArchiveDir == "" {
+			if err := os.Remove(path); err != nil {
+				logger.Warnf("cannot remove %s: %v", path, err)
+				continue
+			}
+			report.Removed = append(report.Removed, path)
+		} else {
+			// keep the layout under the output directory
+			rel, err := filepath.Rel(s.cfg.OutputDir, path)
+			if err != nil {
+				logger.Warnf("cannot archive %s: %v", path, err)
+				continue
+			}
+			dest := filepath.Join(s.cfg.RetentionArchiveDir, rel)
+			if err := moveFile(path, dest); err != nil {
+				logger.Warnf("cannot archive %s: %v", path, err)
+				continue
+			}
+			report.Archived = append(report.Archived, dest)
+		}
+		report.FreedBytes += info.Size()
+	}
+}
+
+// moveFile renames the file, or copies it when the archive directory is on another volume
+func moveFile(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return os.Remove(src)
+}
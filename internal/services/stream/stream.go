@@ -21,8 +21,13 @@ func NewService() *Service {
 }
 
 func (r *Service) ReadStream(resp *resty.Response, ctx context.Context) (<-chan []byte, error) {
+	return r.ReadStreamFrom(resp.RawBody(), ctx)
+}
+
+// ReadStreamFrom reads any stream source such as a HLS poller, the stream is closed when it ends
+func (r *Service) ReadStreamFrom(stream io.ReadCloser, ctx context.Context) (<-chan []byte, error) {
 	ch := make(chan []byte, 10) // 10 MB buffer
-	go r.read(ch, resp.RawBody(), ctx)
+	go r.read(ch, stream, ctx)
 	return ch, nil
 }
 
@@ -35,6 +40,9 @@ func (r *Service) Flush(buf []byte) {
 func (r *Service) read(ch chan<- []byte, stream io.ReadCloser, ctx context.Context) {
 	defer stream.Close()
 	defer close(ch)
+	// unblock a pending read on hanging connections once the context is done
+	stop := context.AfterFunc(ctx, func() { stream.Close() })
+	defer stop()
 	for {
 		select {
 		case <-ctx.Done():
@@ -46,6 +54,10 @@ func (r *Service) read(ch chan<- []byte, stream io.ReadCloser, ctx context.Conte
 				logger.Info("stream ended")
 				r.Flush(buf)
 				return
+			} else if err != nil && ctx.Err() != nil {
+				logger.Info("stream closed")
+				r.Flush(buf)
+				return
 			} else if err != nil {
 				logger.Errorf("error reading stream: %v", err)
 				r.Flush(buf)
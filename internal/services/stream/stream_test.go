@@ -0,0 +1,44 @@
+package stream_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/internal/services/stream"
+)
+
+func TestReadStreamFrom_ClosesHangingStream(t *testing.T) {
+	svc := stream.NewService()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := svc.ReadStreamFrom(pr, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go pw.Write([]byte("data"))
+	select {
+	case data := <-ch:
+		if string(data) != "data" {
+			t.Fatalf("unexpected data: %q", data)
+		}
+		svc.Flush(data)
+	case <-time.After(time.Second):
+		t.Fatal("did not receive data")
+	}
+
+	// no more data is written, the read blocks until the context is cancelled
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hanging stream was not closed after cancel")
+	}
+}
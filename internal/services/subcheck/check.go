@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/eric2788/bilirec/internal/services/hook"
 	"github.com/eric2788/bilirec/internal/services/notify"
 	"github.com/eric2788/bilirec/internal/services/recorder"
 	"github.com/eric2788/bilirec/internal/services/room"
@@ -26,20 +27,25 @@ type Service struct {
 	roomSvc   *room.Service
 	recSvc    *recorder.Service
 	notifySvc *notify.Service
+	hookSvc   *hook.Service
 	notified  ds.Set[int]
+	// rooms seen live by the last check, to fire live started and ended hooks
+	live ds.Set[int]
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func NewService(lc fx.Lifecycle, subSvc *subscribe.Service, roomSvc *room.Service, recSvc *recorder.Service, notifySvc *notify.Service) *Service {
+func NewService(lc fx.Lifecycle, subSvc *subscribe.Service, roomSvc *room.Service, recSvc *recorder.Service, notifySvc *notify.Service, hookSvc *hook.Service) *Service {
 	ctx, cancel := context.WithCancel(context.Background())
 	s := &Service{
 		subSvc:    subSvc,
 		roomSvc:   roomSvc,
 		recSvc:    recSvc,
 		notifySvc: notifySvc,
+		hookSvc:   hookSvc,
 		notified:  ds.NewSet[int](),
+		live:      ds.NewSet[int](),
 		ctx:       ctx,
 		cancel:    cancel,
 	}
@@ -89,6 +95,7 @@ func (s *Service) tryStartAllAutoRecordRooms() {
 	notifyLiveState := s.getLiveRoomStates(slices.Collect(maps.Keys(liveCheckRooms)))
 
 	s.invalidateNotified(rooms)
+	s.updateLiveStates(liveCheckRooms, notifyLiveState)
 
 	for roomID, cfg := range liveCheckRooms {
 
@@ -107,7 +114,7 @@ func (s *Service) tryStartAllAutoRecordRooms() {
 				continue
 			}
 
-			err := s.recSvc.Start(roomID)
+			err := s.recSvc.StartAutoRecord(roomID)
 			if err == nil {
 				logger.Infof("started recording for room %d from auto-record", roomID)
 				if cfg.Notify {
@@ -150,6 +157,32 @@ func (s *Service) publishLiveOnce(roomID int, autoRecordStarted bool) {
 	s.notified.Add(roomID)
 }
 
+// updateLiveStates fires the hooks and the live ended notifications of rooms which started or ended streaming since the last check,
+// rooms live on startup are reported as started.
+func (s *Service) updateLiveStates(rooms map[int]*subscribe.RoomConfig, states map[int]bool) {
+	for _, roomID := range s.live.ToSlice() {
+		if _, ok := rooms[roomID]; !ok {
+			s.live.Remove(roomID)
+		}
+	}
+	for roomID, isLive := range states {
+		if isLive == s.live.Contains(roomID) {
+			continue
+		}
+		event := hook.EventLiveEnded
+		if isLive {
+			event = hook.EventLiveStarted
+			s.live.Add(roomID)
+		} else {
+			s.live.Remove(roomID)
+			if cfg := rooms[roomID]; cfg != nil && cfg.Notify {
+				s.notifySvc.PublishLiveEnded(roomID, cfg.AutoRecord)
+			}
+		}
+		s.hookSvc.Fire(&hook.Payload{Event: event, RoomID: roomID})
+	}
+}
+
 func (s *Service) invalidateNotified(rooms map[int]*subscribe.RoomConfig) {
 	for _, roomID := range s.notified.ToSlice() {
 		cfg, ok := rooms[roomID]
@@ -52,7 +52,7 @@ func newSubcheckServiceForTest(tb testing.TB) (*Service, *testLifecycle) {
 	subLifecycle.Start(tb)
 
 	mainLifecycle := &testLifecycle{}
-	svc := NewService(mainLifecycle, subSvc, nil, nil, nil)
+	svc := NewService(mainLifecycle, subSvc, nil, nil, nil, nil)
 
 	tb.Cleanup(func() {
 		svc.stop()
@@ -11,6 +11,14 @@ import (
 type RoomConfig struct {
 	AutoRecord bool
 	Notify     bool
+
+	// preferred stream, empty values mean no preference
+	Quality int
+	Codec   string
+	Format  string
+
+	// naming template of the recording files, empty to use the global one
+	NamingTemplate string
 }
 
 var roomConfigSerializer = pool.NewSerializer()
@@ -6,17 +6,22 @@ import (
 
 	"github.com/eric2788/bilirec/internal/controllers/convert"
 	"github.com/eric2788/bilirec/internal/controllers/file"
+	hc "github.com/eric2788/bilirec/internal/controllers/hook"
 	nc "github.com/eric2788/bilirec/internal/controllers/notify"
 	"github.com/eric2788/bilirec/internal/controllers/record"
 	"github.com/eric2788/bilirec/internal/controllers/room"
+	"github.com/eric2788/bilirec/internal/controllers/ws"
 	"github.com/eric2788/bilirec/internal/modules/bilibili"
 	"github.com/eric2788/bilirec/internal/modules/config"
 	"github.com/eric2788/bilirec/internal/modules/rest"
 	co "github.com/eric2788/bilirec/internal/services/convert"
+	dv "github.com/eric2788/bilirec/internal/services/dvr"
 	fi "github.com/eric2788/bilirec/internal/services/file"
+	ho "github.com/eric2788/bilirec/internal/services/hook"
 	no "github.com/eric2788/bilirec/internal/services/notify"
 	pa "github.com/eric2788/bilirec/internal/services/path"
 	re "github.com/eric2788/bilirec/internal/services/recorder"
+	rt "github.com/eric2788/bilirec/internal/services/retention"
 	ro "github.com/eric2788/bilirec/internal/services/room"
 	st "github.com/eric2788/bilirec/internal/services/stream"
 	sc "github.com/eric2788/bilirec/internal/services/subcheck"
@@ -34,18 +39,26 @@ func MainModule() fx.Option {
 		fx.Provide(pa.NewService),
 		fx.Provide(co.NewService),
 		fx.Provide(st.NewService),
+		fx.Provide(ho.NewService),
 		fx.Provide(re.NewService),
 		fx.Provide(ro.NewService),
 		fx.Provide(su.NewService),
 		fx.Provide(no.NewService),
 		fx.Provide(sc.NewService),
 		fx.Provide(fi.NewService),
+		fx.Provide(rt.NewService),
+		fx.Provide(dv.NewService),
 
 		fx.Invoke(room.NewController),
 		fx.Invoke(nc.NewController),
 		fx.Invoke(record.NewController),
 		fx.Invoke(file.NewController),
 		fx.Invoke(convert.NewController),
+		fx.Invoke(hc.NewController),
+		fx.Invoke(ws.NewController),
+
+		// nothing depends on retention, it has to be invoked for its loop to start
+		fx.Invoke(func(*rt.Service) {}),
 	)
 }
 
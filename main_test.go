@@ -6,6 +6,8 @@ import (
 	"time"
 
 	main "github.com/eric2788/bilirec"
+	rt "github.com/eric2788/bilirec/internal/services/retention"
+	"go.uber.org/fx"
 	"go.uber.org/fx/fxtest"
 )
 
@@ -17,6 +19,23 @@ func TestAppLaunch(t *testing.T) {
 	t.Log("REST app started successfully")
 }
 
+func TestRetentionStarts(t *testing.T) {
+	var built *rt.Service
+	app := fxtest.New(t,
+		main.MainModule(),
+		// decorators only run when the service is built, populating it would build it regardless
+		fx.Decorate(func(s *rt.Service) *rt.Service {
+			built = s
+			return s
+		}),
+	)
+	app.RequireStart()
+	defer app.RequireStop()
+	if built == nil {
+		t.Fatal("retention service is not started with the app")
+	}
+}
+
 func init() {
 	os.Setenv("ANONYMOUS_LOGIN", "true")
 }
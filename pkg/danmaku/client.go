@@ -0,0 +1,213 @@
+package danmaku
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("pkg", "danmaku")
+
+const (
+	DefaultHost = "wss://broadcastlv.chat.bilibili.com/sub"
+
+	heartbeatInterval = 30 * time.Second
+	readTimeout       = 70 * time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var ErrAuthRejected = errors.New("danmaku server rejected authentication")
+
+// AuthParams carries everything required to join a room's danmaku server
+type AuthParams struct {
+	RoomID int
+	UID    int64
+	Buvid  string
+	Token  string
+	Hosts  []string
+}
+
+// AuthProvider is called before every connection attempt, so tokens are always fresh
+type AuthProvider func() (*AuthParams, error)
+
+// Handler receives every decoded command message
+type Handler func(msg *Message)
+
+type Client struct {
+	auth    AuthProvider
+	handler Handler
+	dialer  *websocket.Dialer
+	header  http.Header
+}
+
+func NewClient(auth AuthProvider, handler Handler) *Client {
+	header := http.Header{}
+	header.Set("Origin", "https://live.bilibili.com")
+	header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0")
+	return &Client{
+		auth:    auth,
+		handler: handler,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 15 * time.Second,
+		},
+		header: header,
+	}
+}
+
+// Run keeps the client connected until ctx is cancelled,
+// reconnecting with backoff and rotating over the provided hosts.
+func (c *Client) Run(ctx context.Context) {
+	delay := time.Second
+	hostIndex := 0
+	for ctx.Err() == nil {
+		params, err := c.auth()
+		if err != nil {
+			logger.Warnf("failed to get danmaku auth: %v", err)
+		} else {
+			hosts := params.Hosts
+			if len(hosts) == 0 {
+				hosts = []string{DefaultHost}
+			}
+			host := hosts[hostIndex%len(hosts)]
+			connectedAt := time.Now()
+			err = c.serve(ctx, host, params)
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Debugf("danmaku connection to %s for room %d closed: %v", host, params.RoomID, err)
+			hostIndex++
+			// connection had been healthy for a while, reset backoff
+			if time.Since(connectedAt) > time.Minute {
+				delay = time.Second
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (c *Client) serve(ctx context.Context, host string, params *AuthParams) error {
+	conn, _, err := c.dialer.DialContext(ctx, host, c.header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// unblock ReadMessage on cancellation
+	go func() {
+		<-connCtx.Done()
+		conn.Close()
+	}()
+
+	if err := c.authenticate(conn, params); err != nil {
+		return err
+	}
+
+	go c.heartbeat(connCtx, conn)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		c.dispatch(data)
+	}
+}
+
+func (c *Client) authenticate(conn *websocket.Conn, params *AuthParams) error {
+	body, err := json.Marshal(map[string]any{
+		"uid":      params.UID,
+		"roomid":   params.RoomID,
+		"protover": ProtoZlib,
+		"buvid":    params.Buvid,
+		"platform": "web",
+		"type":     2,
+		"key":      params.Token,
+	})
+	if err != nil {
+		return err
+	}
+
+	auth := &Packet{Protover: ProtoHeartbeat, Operation: OpAuth, Sequence: 1, Body: body}
+	if err := conn.WriteMessage(websocket.BinaryMessage, auth.Encode()); err != nil {
+		return err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	packets, err := DecodePackets(data)
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		if p.Operation != OpAuthReply {
+			continue
+		}
+		var reply struct {
+			Code int `json:"code"`
+		}
+		if err := json.Unmarshal(p.Body, &reply); err != nil {
+			return err
+		} else if reply.Code != 0 {
+			return fmt.Errorf("%w (code %d)", ErrAuthRejected, reply.Code)
+		}
+		return nil
+	}
+	return ErrAuthRejected
+}
+
+func (c *Client) heartbeat(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	hb := (&Packet{Protover: ProtoHeartbeat, Operation: OpHeartbeat, Sequence: 1}).Encode()
+	for {
+		if err := conn.WriteMessage(websocket.BinaryMessage, hb); err != nil {
+			logger.Debugf("danmaku heartbeat failed: %v", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) dispatch(data []byte) {
+	packets, err := DecodePackets(data)
+	if err != nil {
+		logger.Debugf("failed to decode danmaku packets: %v", err)
+	}
+	now := time.Now()
+	for _, p := range packets {
+		if p.Operation != OpMessage {
+			continue
+		}
+		msg, err := parseMessage(p.Body, now)
+		if err != nil {
+			logger.Debugf("failed to parse danmaku message: %v", err)
+			continue
+		}
+		c.handler(msg)
+	}
+}
@@ -0,0 +1,131 @@
+package danmaku_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/danmaku"
+)
+
+const danmuMsg = `{"cmd":"DANMU_MSG:4:0:2:2:2:0","info":[[0,1,25,16777215,1700000000123,0,0,"",0],"hello <world>",[12345,"tester",0]]}`
+
+func TestDecodeZlibPackets(t *testing.T) {
+	inner := append(
+		(&danmaku.Packet{Protover: danmaku.ProtoJSON, Operation: danmaku.OpMessage, Body: []byte(danmuMsg)}).Encode(),
+		(&danmaku.Packet{Protover: danmaku.ProtoJSON, Operation: danmaku.OpMessage, Body: []byte(`{"cmd":"LIKE_INFO_V3_CLICK"}`)}).Encode()...,
+	)
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(inner)
+	zw.Close()
+
+	frame := (&danmaku.Packet{Protover: danmaku.ProtoZlib, Operation: danmaku.OpMessage, Body: compressed.Bytes()}).Encode()
+	frame = append(frame, (&danmaku.Packet{Protover: danmaku.ProtoHeartbeat, Operation: danmaku.OpHeartbeatReply, Body: []byte{0, 0, 0, 1}}).Encode()...)
+
+	packets, err := danmaku.DecodePackets(frame)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("expected 3 packets, got %d", len(packets))
+	}
+	if string(packets[0].Body) != danmuMsg {
+		t.Errorf("unexpected first body: %s", packets[0].Body)
+	}
+	if packets[2].Operation != danmaku.OpHeartbeatReply {
+		t.Errorf("expected heartbeat reply, got op %d", packets[2].Operation)
+	}
+}
+
+func TestDecodeTruncatedPacket(t *testing.T) {
+	frame := (&danmaku.Packet{Operation: danmaku.OpMessage, Body: []byte(danmuMsg)}).Encode()
+	if _, err := danmaku.DecodePackets(frame[:len(frame)-5]); err == nil {
+		t.Fatal("expected error for truncated packet")
+	}
+}
+
+func TestParseDanmakuAndWriteXML(t *testing.T) {
+	d, err := danmaku.ParseDanmaku(&danmaku.Message{Cmd: danmaku.CmdDanmaku, Raw: []byte(danmuMsg), ReceivedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if d.Text != "hello <world>" || d.UID != 12345 || d.Uname != "tester" || d.Color != 16777215 {
+		t.Fatalf("unexpected danmaku: %+v", d)
+	}
+	if d.Timestamp.UnixMilli() != 1700000000123 {
+		t.Fatalf("unexpected timestamp: %d", d.Timestamp.UnixMilli())
+	}
+
+	path := filepath.Join(t.TempDir(), "test.xml")
+	w, err := danmaku.NewXMLWriter(path, time.UnixMilli(1700000000000), danmaku.XMLMeta{RoomID: 1, Uname: "up", Title: "t&t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteDanmaku(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(b)
+	for _, want := range []string{
+		`<d p="0.123,1,25,16777215,1700000000123,0,12345,0" user="tester">hello &lt;world&gt;</d>`,
+		`title="t&amp;t"`,
+		"</i>",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("xml missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		raw   string
+		want  danmaku.EventType
+		value float64
+		uname string
+	}{
+		{`{"cmd":"SEND_GIFT","data":{"uid":1,"uname":"a","giftName":"小花花","num":10,"total_coin":1000,"coin_type":"gold"}}`, danmaku.EventGift, 1, "a"},
+		{`{"cmd":"SEND_GIFT","data":{"uid":1,"uname":"a","giftName":"辣条","num":10,"total_coin":1000,"coin_type":"silver"}}`, danmaku.EventGift, 0, "a"},
+		{`{"cmd":"SUPER_CHAT_MESSAGE","data":{"uid":2,"price":30,"message":"hi","user_info":{"uname":"b"}}}`, danmaku.EventSuperChat, 30, "b"},
+		{`{"cmd":"GUARD_BUY","data":{"uid":3,"username":"c","guard_level":3,"num":1,"price":198000,"gift_name":"舰长"}}`, danmaku.EventGuardBuy, 198, "c"},
+		{`{"cmd":"INTERACT_WORD","data":{"uid":4,"uname":"d","msg_type":1}}`, danmaku.EventEntry, 0, "d"},
+		{`{"cmd":"LIKE_INFO_V3_CLICK","data":{"uid":5,"uname":"e"}}`, danmaku.EventLike, 0, "e"},
+	}
+
+	for _, c := range cases {
+		var cmd struct {
+			Cmd string `json:"cmd"`
+		}
+		json.Unmarshal([]byte(c.raw), &cmd)
+		e, err := danmaku.ParseEvent(&danmaku.Message{Cmd: cmd.Cmd, Raw: []byte(c.raw), ReceivedAt: now})
+		if err != nil {
+			t.Fatalf("parse %s failed: %v", cmd.Cmd, err)
+		}
+		if e.Type != c.want || e.Value != c.value || e.Uname != c.uname {
+			t.Errorf("unexpected event for %s: %+v", cmd.Cmd, e)
+		}
+	}
+
+	follow := `{"cmd":"INTERACT_WORD","data":{"uid":4,"uname":"d","msg_type":2}}`
+	if _, err := danmaku.ParseEvent(&danmaku.Message{Cmd: "INTERACT_WORD", Raw: []byte(follow)}); err != danmaku.ErrUnsupportedEvent {
+		t.Errorf("expected unsupported event for follow, got %v", err)
+	}
+	if _, err := danmaku.ParseEvent(&danmaku.Message{Cmd: "ONLINE_RANK_COUNT", Raw: []byte(`{}`)}); err != danmaku.ErrUnsupportedEvent {
+		t.Errorf("expected unsupported event, got %v", err)
+	}
+}
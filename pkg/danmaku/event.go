@@ -0,0 +1,139 @@
+package danmaku
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type EventType string
+
+const (
+	EventGift      EventType = "gift"
+	EventSuperChat EventType = "super_chat"
+	EventGuardBuy  EventType = "guard_buy"
+	EventEntry     EventType = "entry"
+	EventLike      EventType = "like"
+)
+
+const (
+	CmdSendGift     = "SEND_GIFT"
+	CmdSuperChat    = "SUPER_CHAT_MESSAGE"
+	CmdGuardBuy     = "GUARD_BUY"
+	CmdInteractWord = "INTERACT_WORD"
+	CmdLikeClick    = "LIKE_INFO_V3_CLICK"
+)
+
+// interact word message type for entering the room
+const interactEntry = 1
+
+var ErrUnsupportedEvent = errors.New("unsupported live event")
+
+// Event is a typed live event, with its value converted to CNY
+type Event struct {
+	Type       EventType `json:"type"`
+	Timestamp  int64     `json:"timestamp"` // unix milliseconds
+	UID        int64     `json:"uid"`
+	Uname      string    `json:"uname"`
+	Name       string    `json:"name,omitempty"` // gift or guard name
+	Num        int       `json:"num,omitempty"`
+	Value      float64   `json:"value,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	GuardLevel int       `json:"guard_level,omitempty"`
+}
+
+// ParseEvent converts a supported command message into a typed event,
+// returns ErrUnsupportedEvent for any other command.
+func ParseEvent(msg *Message) (*Event, error) {
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	switch msg.Cmd {
+	case CmdSendGift, CmdSuperChat, CmdGuardBuy, CmdInteractWord, CmdLikeClick:
+		if err := json.Unmarshal(msg.Raw, &body); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+
+	e := &Event{Timestamp: msg.ReceivedAt.UnixMilli()}
+
+	switch msg.Cmd {
+	case CmdSendGift:
+		var d struct {
+			UID       int64  `json:"uid"`
+			Uname     string `json:"uname"`
+			GiftName  string `json:"giftName"`
+			Num       int    `json:"num"`
+			TotalCoin int64  `json:"total_coin"`
+			CoinType  string `json:"coin_type"`
+		}
+		if err := json.Unmarshal(body.Data, &d); err != nil {
+			return nil, err
+		}
+		e.Type = EventGift
+		e.UID, e.Uname, e.Name, e.Num = d.UID, d.Uname, d.GiftName, d.Num
+		// silver coin gifts are free
+		if d.CoinType == "gold" {
+			e.Value = float64(d.TotalCoin) / 1000
+		}
+
+	case CmdSuperChat:
+		var d struct {
+			UID      int64   `json:"uid"`
+			Price    float64 `json:"price"`
+			Message  string  `json:"message"`
+			UserInfo struct {
+				Uname string `json:"uname"`
+			} `json:"user_info"`
+		}
+		if err := json.Unmarshal(body.Data, &d); err != nil {
+			return nil, err
+		}
+		e.Type = EventSuperChat
+		e.UID, e.Uname, e.Value, e.Message, e.Num = d.UID, d.UserInfo.Uname, d.Price, d.Message, 1
+
+	case CmdGuardBuy:
+		var d struct {
+			UID        int64  `json:"uid"`
+			Username   string `json:"username"`
+			GuardLevel int    `json:"guard_level"`
+			Num        int    `json:"num"`
+			Price      int64  `json:"price"`
+			GiftName   string `json:"gift_name"`
+		}
+		if err := json.Unmarshal(body.Data, &d); err != nil {
+			return nil, err
+		}
+		e.Type = EventGuardBuy
+		e.UID, e.Uname, e.Name, e.Num, e.GuardLevel = d.UID, d.Username, d.GiftName, d.Num, d.GuardLevel
+		e.Value = float64(d.Price) * float64(max(d.Num, 1)) / 1000
+
+	case CmdInteractWord:
+		var d struct {
+			UID     int64  `json:"uid"`
+			Uname   string `json:"uname"`
+			MsgType int    `json:"msg_type"`
+		}
+		if err := json.Unmarshal(body.Data, &d); err != nil {
+			return nil, err
+		} else if d.MsgType != interactEntry {
+			return nil, ErrUnsupportedEvent
+		}
+		e.Type = EventEntry
+		e.UID, e.Uname = d.UID, d.Uname
+
+	case CmdLikeClick:
+		var d struct {
+			UID   int64  `json:"uid"`
+			Uname string `json:"uname"`
+		}
+		if err := json.Unmarshal(body.Data, &d); err != nil {
+			return nil, err
+		}
+		e.Type = EventLike
+		e.UID, e.Uname, e.Num = d.UID, d.Uname, 1
+	}
+
+	return e, nil
+}
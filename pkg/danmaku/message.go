@@ -0,0 +1,109 @@
+package danmaku
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const CmdDanmaku = "DANMU_MSG"
+
+var ErrNotDanmaku = errors.New("message is not a danmaku")
+
+// Message is a decoded command pushed by the danmaku server
+type Message struct {
+	Cmd        string
+	Raw        json.RawMessage
+	ReceivedAt time.Time
+}
+
+// Danmaku is a parsed DANMU_MSG
+type Danmaku struct {
+	Timestamp time.Time
+	Mode      int
+	FontSize  int
+	Color     int
+	UID       int64
+	Uname     string
+	Text      string
+}
+
+func parseMessage(body []byte, receivedAt time.Time) (*Message, error) {
+	var head struct {
+		Cmd string `json:"cmd"`
+	}
+	if err := json.Unmarshal(body, &head); err != nil {
+		return nil, err
+	}
+	// some commands come with suffixes such as DANMU_MSG:4:0:2:2:2:0
+	cmd, _, _ := strings.Cut(head.Cmd, ":")
+	raw := make(json.RawMessage, len(body))
+	copy(raw, body)
+	return &Message{Cmd: cmd, Raw: raw, ReceivedAt: receivedAt}, nil
+}
+
+// ParseDanmaku extracts the danmaku fields from a DANMU_MSG message
+func ParseDanmaku(msg *Message) (*Danmaku, error) {
+	if msg.Cmd != CmdDanmaku {
+		return nil, ErrNotDanmaku
+	}
+
+	var body struct {
+		Info []json.RawMessage `json:"info"`
+	}
+	if err := json.Unmarshal(msg.Raw, &body); err != nil {
+		return nil, err
+	} else if len(body.Info) < 3 {
+		return nil, fmt.Errorf("malformed danmaku info: %d fields", len(body.Info))
+	}
+
+	var props []json.RawMessage
+	if err := json.Unmarshal(body.Info[0], &props); err != nil {
+		return nil, fmt.Errorf("malformed danmaku properties: %w", err)
+	}
+
+	var text string
+	if err := json.Unmarshal(body.Info[1], &text); err != nil {
+		return nil, fmt.Errorf("malformed danmaku text: %w", err)
+	}
+
+	var user []json.RawMessage
+	if err := json.Unmarshal(body.Info[2], &user); err != nil {
+		return nil, fmt.Errorf("malformed danmaku user: %w", err)
+	}
+
+	d := &Danmaku{
+		Timestamp: msg.ReceivedAt,
+		Mode:      1,
+		FontSize:  25,
+		Color:     0xffffff,
+		Text:      text,
+	}
+
+	propInt := func(i int, dst *int) {
+		if i < len(props) {
+			_ = json.Unmarshal(props[i], dst)
+		}
+	}
+	propInt(1, &d.Mode)
+	propInt(2, &d.FontSize)
+	propInt(3, &d.Color)
+
+	if len(props) > 4 {
+		var ts int64
+		if err := json.Unmarshal(props[4], &ts); err == nil && ts > 0 {
+			d.Timestamp = time.UnixMilli(ts)
+		}
+	}
+
+	if len(user) > 0 {
+		_ = json.Unmarshal(user[0], &d.UID)
+	}
+	if len(user) > 1 {
+		_ = json.Unmarshal(user[1], &d.Uname)
+	}
+
+	return d, nil
+}
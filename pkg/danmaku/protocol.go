@@ -0,0 +1,106 @@
+package danmaku
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	headerLength = 16
+
+	ProtoJSON      uint16 = 0
+	ProtoHeartbeat uint16 = 1
+	ProtoZlib      uint16 = 2
+	ProtoBrotli    uint16 = 3
+
+	OpHeartbeat      uint32 = 2
+	OpHeartbeatReply uint32 = 3
+	OpMessage        uint32 = 5
+	OpAuth           uint32 = 7
+	OpAuthReply      uint32 = 8
+)
+
+var (
+	ErrPacketTooShort      = errors.New("danmaku packet too short")
+	ErrUnsupportedProtover = errors.New("unsupported danmaku protocol version")
+)
+
+// Packet is a single frame of the bilibili live danmaku protocol
+type Packet struct {
+	Protover  uint16
+	Operation uint32
+	Sequence  uint32
+	Body      []byte
+}
+
+// Encode serializes the packet with its 16 byte header
+func (p *Packet) Encode() []byte {
+	buf := make([]byte, headerLength+len(p.Body))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.BigEndian.PutUint16(buf[4:6], headerLength)
+	binary.BigEndian.PutUint16(buf[6:8], p.Protover)
+	binary.BigEndian.PutUint32(buf[8:12], p.Operation)
+	binary.BigEndian.PutUint32(buf[12:16], p.Sequence)
+	copy(buf[headerLength:], p.Body)
+	return buf
+}
+
+// DecodePackets splits a websocket frame into packets,
+// transparently inflating zlib compressed bodies.
+func DecodePackets(data []byte) ([]*Packet, error) {
+	packets := make([]*Packet, 0, 1)
+	for len(data) > 0 {
+		if len(data) < headerLength {
+			return packets, ErrPacketTooShort
+		}
+		packetLen := int(binary.BigEndian.Uint32(data[0:4]))
+		headerLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if packetLen < headerLen || headerLen < headerLength || packetLen > len(data) {
+			return packets, ErrPacketTooShort
+		}
+
+		p := &Packet{
+			Protover:  binary.BigEndian.Uint16(data[6:8]),
+			Operation: binary.BigEndian.Uint32(data[8:12]),
+			Sequence:  binary.BigEndian.Uint32(data[12:16]),
+			Body:      data[headerLen:packetLen],
+		}
+		data = data[packetLen:]
+
+		if p.Operation != OpMessage {
+			packets = append(packets, p)
+			continue
+		}
+
+		switch p.Protover {
+		case ProtoJSON, ProtoHeartbeat:
+			packets = append(packets, p)
+		case ProtoZlib:
+			inflated, err := inflate(p.Body)
+			if err != nil {
+				return packets, fmt.Errorf("inflate zlib body: %w", err)
+			}
+			nested, err := DecodePackets(inflated)
+			packets = append(packets, nested...)
+			if err != nil {
+				return packets, err
+			}
+		default:
+			return packets, fmt.Errorf("%w: %d", ErrUnsupportedProtover, p.Protover)
+		}
+	}
+	return packets, nil
+}
+
+func inflate(body []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
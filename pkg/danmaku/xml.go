@@ -0,0 +1,109 @@
+package danmaku
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XMLMeta describes the recording a danmaku file belongs to
+type XMLMeta struct {
+	RoomID int
+	Uname  string
+	Title  string
+}
+
+// XMLWriter writes danmaku in the bilibili <i><d p="..."> format,
+// with offsets relative to the start time of the paired video file.
+type XMLWriter struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	w      *bufio.Writer
+	start  time.Time
+	count  int
+	closed bool
+}
+
+func NewXMLWriter(path string, start time.Time, meta XMLMeta) (*XMLWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &XMLWriter{
+		path:  path,
+		file:  f,
+		w:     bufio.NewWriterSize(f, 32*1024),
+		start: start,
+	}
+
+	fmt.Fprint(w.w, xml.Header)
+	fmt.Fprint(w.w, "<i>\n")
+	fmt.Fprint(w.w, "  <chatserver>chat.bilibili.com</chatserver>\n")
+	fmt.Fprint(w.w, "  <chatid>0</chatid>\n")
+	fmt.Fprintf(w.w, "  <BililiveRecorderRecordInfo roomid=\"%d\" name=\"%s\" title=\"%s\" start_time=\"%s\"></BililiveRecorderRecordInfo>\n",
+		meta.RoomID, escape(meta.Uname), escape(meta.Title), start.Format(time.RFC3339))
+	return w, nil
+}
+
+func (w *XMLWriter) Path() string {
+	return w.path
+}
+
+// Count returns the number of danmaku written so far
+func (w *XMLWriter) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *XMLWriter) WriteDanmaku(d *Danmaku) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	offset := max(d.Timestamp.Sub(w.start).Seconds(), 0)
+	_, err := fmt.Fprintf(w.w, "  <d p=\"%.3f,%d,%d,%d,%d,0,%d,0\" user=\"%s\">%s</d>\n",
+		offset, d.Mode, d.FontSize, d.Color, d.Timestamp.UnixMilli(), d.UID, escape(d.Uname), escape(d.Text))
+	if err == nil {
+		w.count++
+	}
+	return err
+}
+
+func (w *XMLWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	return w.w.Flush()
+}
+
+func (w *XMLWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	fmt.Fprint(w.w, "</i>\n")
+	flushErr := w.w.Flush()
+	closeErr := w.file.Close()
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,272 @@
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// AMF0 type markers used by script tags
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+)
+
+var (
+	ErrUnsupportedAMFValue = errors.New("unsupported AMF0 value")
+	ErrInvalidAMF          = errors.New("invalid AMF0 data")
+)
+
+// AMFProperty is a named value of an AMF0 object or ECMA array, the order is kept when encoded
+type AMFProperty struct {
+	Key   string
+	Value any
+}
+
+// AMFObject encodes as an anonymous AMF0 object
+type AMFObject []AMFProperty
+
+// AMFECMAArray encodes as an AMF0 ECMA array, which onMetaData is written as
+type AMFECMAArray []AMFProperty
+
+// Get returns the value of the first property with the key
+func (a AMFECMAArray) Get(key string) (any, bool) {
+	return AMFObject(a).Get(key)
+}
+
+// Get returns the value of the first property with the key
+func (o AMFObject) Get(key string) (any, bool) {
+	for _, prop := range o {
+		if prop.Key == key {
+			return prop.Value, true
+		}
+	}
+	return nil, false
+}
+
+// AppendAMF0 appends the AMF0 encoding of the value to buf.
+// Numbers are encoded as float64, slices as strict arrays and nil as null.
+func AppendAMF0(buf []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, amf0Null), nil
+	case bool:
+		if v {
+			return append(buf, amf0Boolean, 1), nil
+		}
+		return append(buf, amf0Boolean, 0), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, amf0Number), math.Float64bits(v)), nil
+	case int:
+		return AppendAMF0(buf, float64(v))
+	case int32:
+		return AppendAMF0(buf, float64(v))
+	case int64:
+		return AppendAMF0(buf, float64(v))
+	case uint32:
+		return AppendAMF0(buf, float64(v))
+	case uint64:
+		return AppendAMF0(buf, float64(v))
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf = binary.BigEndian.AppendUint32(append(buf, amf0LongString), uint32(len(v)))
+			return append(buf, v...), nil
+		}
+		return appendAMF0Key(append(buf, amf0String), v), nil
+	case AMFObject:
+		return appendAMF0Properties(append(buf, amf0Object), v)
+	case AMFECMAArray:
+		buf = binary.BigEndian.AppendUint32(append(buf, amf0ECMAArray), uint32(len(v)))
+		return appendAMF0Properties(buf, v)
+	case []float64:
+		buf = binary.BigEndian.AppendUint32(append(buf, amf0StrictArray), uint32(len(v)))
+		for _, n := range v {
+			buf, _ = AppendAMF0(buf, n)
+		}
+		return buf, nil
+	case []any:
+		buf = binary.BigEndian.AppendUint32(append(buf, amf0StrictArray), uint32(len(v)))
+		var err error
+		for _, item := range v {
+			if buf, err = AppendAMF0(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedAMFValue, value)
+}
+
+func appendAMF0Key(buf []byte, key string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(key)))
+	return append(buf, key...)
+}
+
+func appendAMF0Properties(buf []byte, props []AMFProperty) ([]byte, error) {
+	var err error
+	for _, prop := range props {
+		buf = appendAMF0Key(buf, prop.Key)
+		if buf, err = AppendAMF0(buf, prop.Value); err != nil {
+			return nil, err
+		}
+	}
+	// empty key followed by the object end marker
+	return append(buf, 0x00, 0x00, amf0ObjectEnd), nil
+}
+
+// NewScriptTag builds a script tag body of the given name and value, such as onMetaData
+func NewScriptTag(name string, value any) ([]byte, error) {
+	body, err := AppendAMF0(nil, name)
+	if err != nil {
+		return nil, err
+	}
+	return AppendAMF0(body, value)
+}
+
+// ScriptTagName returns the name of a script tag body, empty if it does not start with an AMF0 string
+func ScriptTagName(body []byte) string {
+	if len(body) < 3 || body[0] != amf0String {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint16(body[1:3]))
+	if len(body) < 3+size {
+		return ""
+	}
+	return string(body[3 : 3+size])
+}
+
+// ParseScriptTag decodes the name and value of a script tag body.
+// Numbers are decoded as float64, strict arrays as []any and null or undefined as nil.
+func ParseScriptTag(body []byte) (string, any, error) {
+	d := &amfDecoder{data: body}
+	name, err := d.value()
+	if err != nil {
+		return "", nil, err
+	}
+	str, ok := name.(string)
+	if !ok {
+		return "", nil, ErrInvalidAMF
+	}
+	value, err := d.value()
+	return str, value, err
+}
+
+type amfDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *amfDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, ErrInvalidAMF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *amfDecoder) key() (string, error) {
+	size, err := d.next(2)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(int(binary.BigEndian.Uint16(size)))
+	return string(b), err
+}
+
+func (d *amfDecoder) value() (any, error) {
+	marker, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch marker[0] {
+	case amf0Number:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amf0Boolean:
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case amf0String:
+		return d.key()
+	case amf0LongString:
+		size, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(binary.BigEndian.Uint32(size)))
+		return string(b), err
+	case amf0Null, amf0Undefined:
+		return nil, nil
+	case amf0Object:
+		props, err := d.properties()
+		return AMFObject(props), err
+	case amf0ECMAArray:
+		// the count is only a hint, properties end with the object end marker
+		if _, err := d.next(4); err != nil {
+			return nil, err
+		}
+		props, err := d.properties()
+		return AMFECMAArray(props), err
+	case amf0StrictArray:
+		size, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		count := int(binary.BigEndian.Uint32(size))
+		if count > len(d.data)-d.pos {
+			return nil, ErrInvalidAMF
+		}
+		items := make([]any, 0, count)
+		for range count {
+			item, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case amf0Date:
+		// milliseconds followed by a time zone which is unused
+		b, err := d.next(10)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("%w: marker 0x%02x", ErrUnsupportedAMFValue, marker[0])
+}
+
+func (d *amfDecoder) properties() ([]AMFProperty, error) {
+	props := make([]AMFProperty, 0)
+	for {
+		key, err := d.key()
+		if err != nil {
+			return nil, err
+		}
+		if key == "" && d.pos < len(d.data) && d.data[d.pos] == amf0ObjectEnd {
+			d.pos++
+			return props, nil
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, AMFProperty{Key: key, Value: value})
+	}
+}
@@ -0,0 +1,77 @@
+package flv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// check for cancellation once every this many tags when copying
+const cancelCheckTags = 256
+
+var ErrNoKeyframe = errors.New("no keyframe in the clip range")
+
+// Clip writes the tags of a FLV file from the keyframe at or before start until end into w.
+// The clip begins with the sequence headers and its timestamps start from zero, so it plays on its own,
+// the old metadata is dropped as it describes the whole file.
+func Clip(ctx context.Context, path string, w io.Writer, start, end time.Duration) error {
+	x := NewIndex(path)
+	if err := x.Update(); err != nil {
+		return err
+	}
+	endMs := end.Milliseconds()
+	kf, ok := x.Seek(int32(start.Milliseconds()))
+	if !ok || int64(kf.Timestamp) > endMs || start.Milliseconds() > int64(x.LastTimestamp) {
+		return ErrNoKeyframe
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(kf.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := NewTagReaderAt(f, kf.Offset)
+
+	bw := bufio.NewWriterSize(w, readerBufferSize)
+	if _, err := bw.Write(append(append([]byte(nil), FlvHeader...), 0, 0, 0, 0)); err != nil {
+		return err
+	}
+	for _, header := range []*Tag{x.VideoHeader, x.AudioHeader} {
+		if header == nil {
+			continue
+		}
+		if err := WriteTag(bw, &Tag{Type: header.Type, DataSize: header.DataSize, Data: header.Data}); err != nil {
+			return err
+		}
+	}
+	for n := 0; ; n++ {
+		if n%cancelCheckTags == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if int64(tag.Timestamp) > endMs {
+			break
+		} else if tag.Type == TagTypeScript || tag.Timestamp < kf.Timestamp {
+			// audio interleaved right after the keyframe may be slightly earlier than it
+			continue
+		}
+		tag.Timestamp -= kf.Timestamp
+		if err := WriteTag(bw, tag); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,74 @@
+package flv_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+func TestClip_FromKeyframeBeforeStart(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	write(flv.TagTypeScript, 0, []byte{0x02, 0x00, 0x0A, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a'})
+	write(flv.TagTypeVideo, 0, avcSequenceHeader(buildAVCSPS()))
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	// keyframes every 200 ms
+	for i := range 20 {
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+		}
+		write(flv.TagTypeVideo, int32(i*40), []byte{frameType, 0x01, 0x00, 0x00, 0x00, byte(i)})
+		write(flv.TagTypeAudio, int32(i*40), []byte{0xAF, 0x01, byte(i)})
+	}
+	path := filepath.Join(t.TempDir(), "record.flv")
+	if err := os.WriteFile(path, stream.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := flv.Clip(context.Background(), path, &out, 250*time.Millisecond, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	r, err := flv.NewTagReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []*flv.Tag
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+	// sequence headers, then frames 5 to 12 with their audio
+	if len(tags) != 2+8*2 {
+		t.Fatalf("expected 18 tags, got %d", len(tags))
+	}
+	if !tags[0].IsHeader || !tags[1].IsHeader {
+		t.Error("clip should start with the sequence headers")
+	}
+	if !tags[2].IsKeyframe || tags[2].Timestamp != 0 || tags[2].Data[5] != 5 {
+		t.Errorf("clip should start from the keyframe at 200 ms, got %+v", tags[2])
+	}
+	if last := tags[len(tags)-1]; last.Timestamp != 280 {
+		t.Errorf("expected the last tag at 280 ms, got %d", last.Timestamp)
+	}
+
+	if err := flv.Clip(context.Background(), path, io.Discard, time.Second, 2*time.Second); err != flv.ErrNoKeyframe {
+		t.Errorf("expected no keyframe for clip after the end, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	VideoCodecAVC  = "avc"
+	VideoCodecHEVC = "hevc"
+	VideoCodecAV1  = "av1"
+
+	AudioCodecAAC = "aac"
+	AudioCodecMP3 = "mp3"
+
+	// codec ids of the legacy video tag header
+	codecIdAVC  = 7
+	codecIdHEVC = 12
+
+	// enhanced RTMP video tag header
+	exVideoHeaderFlag     = 0x80
+	exPacketSequenceStart = 0
+
+	hevcNalSPS = 33
+)
+
+var ErrInvalidSequenceHeader = errors.New("invalid video sequence header")
+
+// VideoInfo is the video format parsed from a sequence header
+type VideoInfo struct {
+	Codec  string
+	Width  int
+	Height int
+}
+
+// VideoCodec returns the codec of a video tag body, empty if unknown
+func VideoCodec(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if data[0]&exVideoHeaderFlag != 0 {
+		if len(data) < 5 {
+			return ""
+		}
+		switch string(data[1:5]) {
+		case "avc1":
+			return VideoCodecAVC
+		case "hvc1":
+			return VideoCodecHEVC
+		case "av01":
+			return VideoCodecAV1
+		}
+		return ""
+	}
+	switch data[0] & 0x0F {
+	case codecIdAVC:
+		return VideoCodecAVC
+	case codecIdHEVC:
+		return VideoCodecHEVC
+	}
+	return ""
+}
+
+// AudioCodec returns the codec of an audio tag body, empty if unknown
+func AudioCodec(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	switch data[0] >> 4 {
+	case 10:
+		return AudioCodecAAC
+	case 2, 14:
+		return AudioCodecMP3
+	}
+	return ""
+}
+
+// IsVideoSequenceHeader reports whether a video tag body carries the decoder configuration
+func IsVideoSequenceHeader(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	} else if data[0]&exVideoHeaderFlag != 0 {
+		return data[0]&0x0F == exPacketSequenceStart
+	}
+	return data[1] == 0x00
+}
+
+// ParseVideoSequenceHeader parses the codec and resolution from a video sequence header tag body,
+// both AVC and HEVC in legacy and enhanced RTMP headers are supported.
+func ParseVideoSequenceHeader(data []byte) (*VideoInfo, error) {
+	if !IsVideoSequenceHeader(data) {
+		return nil, ErrInvalidSequenceHeader
+	}
+	codec := VideoCodec(data)
+	if len(data) < 5 {
+		return nil, ErrInvalidSequenceHeader
+	}
+	// the record follows the flags and fourcc in enhanced RTMP,
+	// or the flags, packet type and composition time in legacy header
+	record := data[5:]
+
+	info := &VideoInfo{Codec: codec}
+	var err error
+	switch codec {
+	case VideoCodecAVC:
+		info.Width, info.Height, err = parseAVCDecoderConfig(record)
+	case VideoCodecHEVC:
+		info.Width, info.Height, err = parseHEVCDecoderConfig(record)
+	case "":
+		return nil, ErrInvalidSequenceHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func parseAVCDecoderConfig(record []byte) (int, int, error) {
+	// version, profile, compatibility, level, length size, sps count
+	if len(record) < 8 || record[5]&0x1F == 0 {
+		return 0, 0, ErrInvalidSequenceHeader
+	}
+	size := int(binary.BigEndian.Uint16(record[6:8]))
+	if len(record) < 8+size || size < 4 {
+		return 0, 0, ErrInvalidSequenceHeader
+	}
+	// skip the NAL unit header
+	return parseAVCSPS(unescapeRBSP(record[9 : 8+size]))
+}
+
+func parseAVCSPS(sps []byte) (int, int, error) {
+	r := &bitReader{data: sps}
+	profile := r.bits(8)
+	r.skip(16) // constraint flags and level
+	r.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.skip(1) // separate_colour_plane_flag
+		}
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.skip(1) // qpprime_y_zero_transform_bypass_flag
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := range lists {
+				if r.bits(1) == 0 {
+					continue
+				} else if i < 6 {
+					r.skipScalingList(16)
+				} else {
+					r.skipScalingList(64)
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.skip(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		for range r.ue() {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.skip(1) // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs := r.ue() + 1
+	heightMaps := r.ue() + 1
+	frameMbsOnly := r.bits(1)
+	if frameMbsOnly == 0 {
+		r.skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.skip(1) // direct_8x8_inference_flag
+
+	width := int(widthMbs * 16)
+	height := int((2 - frameMbsOnly) * heightMaps * 16)
+	if r.bits(1) == 1 {
+		left, right, top, bottom := r.ue(), r.ue(), r.ue(), r.ue()
+		cropX, cropY := uint(1), 2-frameMbsOnly
+		switch chromaFormat {
+		case 1:
+			cropX, cropY = 2, 2*(2-frameMbsOnly)
+		case 2:
+			cropX = 2
+		}
+		width -= int((left + right) * cropX)
+		height -= int((top + bottom) * cropY)
+	}
+	if r.err || width <= 0 || height <= 0 {
+		return 0, 0, ErrInvalidSequenceHeader
+	}
+	return width, height, nil
+}
+
+func parseHEVCDecoderConfig(record []byte) (int, int, error) {
+	// fixed 22 bytes of the configuration before the NAL unit arrays
+	if len(record) < 23 {
+		return 0, 0, ErrInvalidSequenceHeader
+	}
+	arrays := int(record[22])
+	pos := 23
+	for range arrays {
+		if len(record) < pos+3 {
+			break
+		}
+		nalType := record[pos] & 0x3F
+		count := int(binary.BigEndian.Uint16(record[pos+1:]))
+		pos += 3
+		for range count {
+			if len(record) < pos+2 {
+				return 0, 0, ErrInvalidSequenceHeader
+			}
+			size := int(binary.BigEndian.Uint16(record[pos:]))
+			pos += 2
+			if len(record) < pos+size {
+				return 0, 0, ErrInvalidSequenceHeader
+			}
+			if nalType == hevcNalSPS && size > 2 {
+				// skip the 2 bytes NAL unit header
+				return parseHEVCSPS(unescapeRBSP(record[pos+2 : pos+size]))
+			}
+			pos += size
+		}
+	}
+	return 0, 0, ErrInvalidSequenceHeader
+}
+
+func parseHEVCSPS(sps []byte) (int, int, error) {
+	r := &bitReader{data: sps}
+	r.skip(4) // sps_video_parameter_set_id
+	maxSubLayersMinus1 := r.bits(3)
+	r.skip(1) // sps_temporal_id_nesting_flag
+
+	// profile_tier_level: general profile (88 bits) and level (8 bits)
+	r.skip(96)
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := range maxSubLayersMinus1 {
+		profilePresent[i] = r.bits(1) == 1
+		levelPresent[i] = r.bits(1) == 1
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skip(int(8-maxSubLayersMinus1) * 2)
+	}
+	for i := range maxSubLayersMinus1 {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+
+	r.ue() // sps_seq_parameter_set_id
+	chromaFormat := r.ue()
+	if chromaFormat == 3 {
+		r.skip(1) // separate_colour_plane_flag
+	}
+	width := int(r.ue())
+	height := int(r.ue())
+	if r.bits(1) == 1 {
+		left, right, top, bottom := r.ue(), r.ue(), r.ue(), r.ue()
+		subWidth, subHeight := uint(1), uint(1)
+		switch chromaFormat {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+		width -= int((left + right) * subWidth)
+		height -= int((top + bottom) * subHeight)
+	}
+	if r.err || width <= 0 || height <= 0 {
+		return 0, 0, ErrInvalidSequenceHeader
+	}
+	return width, height, nil
+}
+
+// unescapeRBSP removes the emulation prevention bytes (0x000003) of a NAL unit
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// bitReader reads exp-Golomb coded fields, reading past the end sets err instead of panicking
+type bitReader struct {
+	data []byte
+	pos  int
+	err  bool
+}
+
+func (r *bitReader) bits(n int) uint {
+	var v uint
+	for range n {
+		if r.pos >= len(r.data)*8 {
+			r.err = true
+			return 0
+		}
+		bit := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+		v = v<<1 | uint(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos > len(r.data)*8 {
+		r.err = true
+	}
+}
+
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err || zeros > 31 {
+			r.err = true
+			return 0
+		}
+		zeros++
+	}
+	return (1 << zeros) - 1 + r.bits(zeros)
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v&1 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := 8, 8
+	for range size {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package flv
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"hash"
@@ -309,6 +310,14 @@ func WriteTag(w io.Writer, tag *Tag) error {
 	return writeTagOptimized(w, tag)
 }
 
+// MarshalTag encodes a complete tag with its previous tag size
+func MarshalTag(tagType byte, timestamp int32, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(TagHeaderSize + len(data) + PrevTagSizeBytes)
+	WriteTag(&buf, &Tag{Type: tagType, DataSize: uint32(len(data)), Timestamp: timestamp, Data: data})
+	return buf.Bytes()
+}
+
 // =====================================================
 // Advanced: Group-based Timestamp Calculation
 // =====================================================
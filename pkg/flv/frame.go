@@ -0,0 +1,119 @@
+package flv
+
+import "errors"
+
+const (
+	exPacketCodedFrames  = 1
+	exPacketCodedFramesX = 3
+
+	aacSampleRateIndexes = 13
+)
+
+var (
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrInvalidAACConfig = errors.New("invalid AAC audio specific config")
+)
+
+// AACSampleRates are the sampling frequencies indexed by the AudioSpecificConfig
+var AACSampleRates = [aacSampleRateIndexes]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// VideoFrame is an AVC or HEVC video tag body split into its fields
+type VideoFrame struct {
+	Codec          string
+	Keyframe       bool
+	SequenceHeader bool
+	// presentation time minus decoding time in milliseconds
+	CompositionTime int32
+	// the decoder configuration record of sequence headers, or length-prefixed NAL units of frames.
+	// empty for the end of sequence.
+	Payload []byte
+}
+
+// AudioFrame is an AAC audio tag body split into its fields
+type AudioFrame struct {
+	SequenceHeader bool
+	// the AudioSpecificConfig of sequence headers, or a raw AAC frame
+	Payload []byte
+}
+
+// AACConfig is the format parsed from an AudioSpecificConfig
+type AACConfig struct {
+	ObjectType      int
+	SampleRateIndex int
+	SampleRate      int
+	Channels        int
+}
+
+// ParseVideoFrame splits a video tag body in legacy or enhanced RTMP header,
+// the payload shares the memory of data.
+func ParseVideoFrame(data []byte) (*VideoFrame, error) {
+	codec := VideoCodec(data)
+	if codec != VideoCodecAVC && codec != VideoCodecHEVC {
+		return nil, ErrUnsupportedCodec
+	}
+	frame := &VideoFrame{Codec: codec}
+	if data[0]&exVideoHeaderFlag != 0 {
+		frame.Keyframe = (data[0]>>4)&0x07 == 1
+		switch data[0] & 0x0F {
+		case exPacketSequenceStart:
+			frame.SequenceHeader = true
+			frame.Payload = data[5:]
+		case exPacketCodedFrames:
+			if len(data) < 8 {
+				return nil, ErrInvalidTag
+			}
+			frame.CompositionTime = readInt24(data[5:8])
+			frame.Payload = data[8:]
+		case exPacketCodedFramesX:
+			frame.Payload = data[5:]
+		}
+		// the payload of other packet types such as the end of sequence stays empty
+		return frame, nil
+	}
+
+	if len(data) < 5 {
+		return nil, ErrInvalidTag
+	}
+	frame.Keyframe = data[0]>>4 == 1
+	switch data[1] {
+	case 0:
+		frame.SequenceHeader = true
+		frame.Payload = data[5:]
+	case 1:
+		frame.CompositionTime = readInt24(data[2:5])
+		frame.Payload = data[5:]
+	}
+	return frame, nil
+}
+
+// ParseAudioFrame splits an AAC audio tag body, the payload shares the memory of data
+func ParseAudioFrame(data []byte) (*AudioFrame, error) {
+	if AudioCodec(data) != AudioCodecAAC {
+		return nil, ErrUnsupportedCodec
+	} else if len(data) < 2 {
+		return nil, ErrInvalidTag
+	}
+	return &AudioFrame{SequenceHeader: data[1] == 0, Payload: data[2:]}, nil
+}
+
+// ParseAACConfig parses the object type, sample rate and channels of an AudioSpecificConfig
+func ParseAACConfig(asc []byte) (*AACConfig, error) {
+	if len(asc) < 2 {
+		return nil, ErrInvalidAACConfig
+	}
+	config := &AACConfig{
+		ObjectType:      int(asc[0] >> 3),
+		SampleRateIndex: int(asc[0]&0x07)<<1 | int(asc[1]>>7),
+		Channels:        int(asc[1]>>3) & 0x0F,
+	}
+	if config.ObjectType == 0 || config.SampleRateIndex >= aacSampleRateIndexes {
+		return nil, ErrInvalidAACConfig
+	}
+	config.SampleRate = AACSampleRates[config.SampleRateIndex]
+	return config, nil
+}
+
+// readInt24 reads a signed 24 bits big endian integer
+func readInt24(b []byte) int32 {
+	return int32(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8) >> 8
+}
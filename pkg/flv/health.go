@@ -0,0 +1,133 @@
+package flv
+
+import (
+	"sync"
+)
+
+// window of stream time to compute the current bitrate and fps
+const healthWindowMs = 2000
+
+// StreamHealth is a snapshot of the stream quality observed by a HealthMonitor
+type StreamHealth struct {
+	CurrentBitrate     int64   `json:"current_bitrate"` // bits per second
+	AverageBitrate     int64   `json:"average_bitrate"` // bits per second
+	FPS                float64 `json:"fps"`
+	KeyframeIntervalMs int32   `json:"keyframe_interval_ms"`
+	Width              int     `json:"width"`
+	Height             int     `json:"height"`
+	VideoCodec         string  `json:"video_codec"`
+	AudioCodec         string  `json:"audio_codec"`
+	DuplicateTags      int64   `json:"duplicate_tags"`
+	TimestampJumps     int64   `json:"timestamp_jumps"`
+}
+
+// HealthMonitor computes the stream health from the tags passing through a RealtimeFixer,
+// timestamps are taken after fixing so the stream time is continuous.
+type HealthMonitor struct {
+	mu     sync.Mutex
+	health StreamHealth
+
+	started      bool
+	firstTs      int32
+	lastTs       int32
+	totalBytes   int64
+	lastKeyframe int32
+	hasKeyframe  bool
+
+	windowStart  int32
+	windowBytes  int64
+	windowFrames int
+	videoFrames  int64
+}
+
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{}
+}
+
+// Snapshot returns the current stream health
+func (m *HealthMonitor) Snapshot() StreamHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := m.health
+	if duration := m.lastTs - m.firstTs; duration > 0 {
+		health.AverageBitrate = m.totalBytes * 8 * 1000 / int64(duration)
+	}
+	return health
+}
+
+// VideoFrames returns the number of video frames observed, sequence headers excluded
+func (m *HealthMonitor) VideoFrames() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.videoFrames
+}
+
+func (m *HealthMonitor) observe(tag *Tag) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		m.started = true
+		m.firstTs = tag.Timestamp
+		m.windowStart = tag.Timestamp
+	}
+	size := int64(TagHeaderSize) + int64(tag.DataSize)
+	m.totalBytes += size
+	m.windowBytes += size
+	m.lastTs = max(m.lastTs, tag.Timestamp)
+
+	switch tag.Type {
+	case TagTypeVideo:
+		m.observeVideo(tag)
+	case TagTypeAudio:
+		if codec := AudioCodec(tag.Data); codec != "" {
+			m.health.AudioCodec = codec
+		}
+	}
+
+	if elapsed := tag.Timestamp - m.windowStart; elapsed >= healthWindowMs {
+		m.health.CurrentBitrate = m.windowBytes * 8 * 1000 / int64(elapsed)
+		m.health.FPS = float64(m.windowFrames) * 1000 / float64(elapsed)
+		m.windowStart = tag.Timestamp
+		m.windowBytes = 0
+		m.windowFrames = 0
+	} else if elapsed < 0 {
+		// timestamps went backwards, restart the window
+		m.windowStart = tag.Timestamp
+		m.windowBytes = 0
+		m.windowFrames = 0
+	}
+}
+
+func (m *HealthMonitor) observeVideo(tag *Tag) {
+	if IsVideoSequenceHeader(tag.Data) {
+		if info, err := ParseVideoSequenceHeader(tag.Data); err == nil {
+			m.health.VideoCodec = info.Codec
+			m.health.Width = info.Width
+			m.health.Height = info.Height
+		}
+		return
+	}
+	m.windowFrames++
+	m.videoFrames++
+	if !tag.IsKeyframe {
+		return
+	}
+	if m.hasKeyframe && tag.Timestamp > m.lastKeyframe {
+		m.health.KeyframeIntervalMs = tag.Timestamp - m.lastKeyframe
+	}
+	m.lastKeyframe = tag.Timestamp
+	m.hasKeyframe = true
+}
+
+func (m *HealthMonitor) addDuplicate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.health.DuplicateTags++
+}
+
+func (m *HealthMonitor) addTimestampJump() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.health.TimestampJumps++
+}
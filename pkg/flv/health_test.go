@@ -0,0 +1,151 @@
+package flv_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+// bitWriter encodes exp-Golomb fields to build a SPS for testing
+type bitWriter struct {
+	buf  []byte
+	bits int
+}
+
+func (w *bitWriter) u(n int, v uint) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if (v>>i)&1 == 1 {
+			w.buf[len(w.buf)-1] |= 1 << (7 - w.bits%8)
+		}
+		w.bits++
+	}
+}
+
+func (w *bitWriter) ue(v uint) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.u(n, 0)
+	w.u(n+1, v)
+}
+
+// 1920x1088 high profile SPS cropped to 1080 lines
+func buildAVCSPS() []byte {
+	w := &bitWriter{}
+	w.u(8, 100) // profile_idc
+	w.u(8, 0)   // constraint flags
+	w.u(8, 40)  // level_idc
+	w.ue(0)     // seq_parameter_set_id
+	w.ue(1)     // chroma_format_idc
+	w.ue(0)     // bit_depth_luma_minus8
+	w.ue(0)     // bit_depth_chroma_minus8
+	w.u(1, 0)   // qpprime_y_zero_transform_bypass_flag
+	w.u(1, 0)   // seq_scaling_matrix_present_flag
+	w.ue(0)     // log2_max_frame_num_minus4
+	w.ue(0)     // pic_order_cnt_type
+	w.ue(2)     // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(4)     // max_num_ref_frames
+	w.u(1, 0)   // gaps_in_frame_num_value_allowed_flag
+	w.ue(119)   // pic_width_in_mbs_minus1
+	w.ue(67)    // pic_height_in_map_units_minus1
+	w.u(1, 1)   // frame_mbs_only_flag
+	w.u(1, 1)   // direct_8x8_inference_flag
+	w.u(1, 1)   // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)   // crop bottom 4 * 2 lines
+	w.u(1, 0) // vui_parameters_present_flag
+	w.u(1, 1) // rbsp stop bit
+	return append([]byte{0x67}, w.buf...)
+}
+
+func avcSequenceHeader(sps []byte) []byte {
+	body := []byte{0x17, 0x00, 0x00, 0x00, 0x00}
+	body = append(body, 0x01, sps[1], sps[2], sps[3], 0xFF, 0xE1)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(sps)))
+	body = append(body, sps...)
+	return append(body, 0x01, 0x00, 0x04, 0x68, 0xEE, 0x3C, 0x80)
+}
+
+func TestParseVideoSequenceHeader_AVC(t *testing.T) {
+	info, err := flv.ParseVideoSequenceHeader(avcSequenceHeader(buildAVCSPS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec != flv.VideoCodecAVC || info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("unexpected video info: %+v", info)
+	}
+}
+
+func TestParseVideoSequenceHeader_Invalid(t *testing.T) {
+	if _, err := flv.ParseVideoSequenceHeader([]byte{0x17, 0x01, 0x00}); err == nil {
+		t.Error("expected error for non sequence header")
+	}
+	if _, err := flv.ParseVideoSequenceHeader([]byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}); err == nil {
+		t.Error("expected error for truncated record")
+	}
+}
+
+func TestHealthMonitor_ObservesFixedTags(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	write(flv.TagTypeVideo, 0, avcSequenceHeader(buildAVCSPS()))
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	// 4 seconds at 25 fps with a keyframe every 2 seconds
+	for i := range 100 {
+		ts := int32(i * 40)
+		frameType := byte(0x27)
+		if i%50 == 0 {
+			frameType = 0x17
+		}
+		write(flv.TagTypeVideo, ts, []byte{frameType, 0x01, 0x00, 0x00, 0x00, byte(i)})
+		if i == 60 {
+			// duplicated tag should be dropped
+			write(flv.TagTypeVideo, ts, []byte{frameType, 0x01, 0x00, 0x00, 0x00, byte(i)})
+		}
+	}
+
+	monitor := flv.NewHealthMonitor()
+	fixer := flv.NewRealtimeFixer()
+	defer fixer.Close()
+	fixer.SetHealthMonitor(monitor)
+	if _, err := fixer.Fix(stream.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	health := monitor.Snapshot()
+	if health.Width != 1920 || health.Height != 1080 || health.VideoCodec != flv.VideoCodecAVC {
+		t.Errorf("unexpected video format: %+v", health)
+	}
+	if health.AudioCodec != flv.AudioCodecAAC {
+		t.Errorf("unexpected audio codec: %s", health.AudioCodec)
+	}
+	if health.FPS < 24 || health.FPS > 26 {
+		t.Errorf("expected about 25 fps, got %.2f", health.FPS)
+	}
+	if health.KeyframeIntervalMs != 2000 {
+		t.Errorf("expected keyframe interval 2000ms, got %d", health.KeyframeIntervalMs)
+	}
+	if health.DuplicateTags != 1 {
+		t.Errorf("expected 1 duplicate tag, got %d", health.DuplicateTags)
+	}
+	if health.TimestampJumps != 0 {
+		t.Errorf("expected no timestamp jump, got %d", health.TimestampJumps)
+	}
+	if health.CurrentBitrate <= 0 || health.AverageBitrate <= 0 {
+		t.Errorf("expected bitrate to be computed: %+v", health)
+	}
+}
@@ -0,0 +1,149 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+)
+
+// Keyframe is the position of a video keyframe tag in a FLV file
+type Keyframe struct {
+	Timestamp int32
+	Offset    int64
+}
+
+// Index locates the keyframes and sequence headers of a FLV file. It can be updated while the file
+// is still being written, only the tags appended since the last update are scanned.
+// Index is not safe for concurrent use.
+type Index struct {
+	path string
+	file os.FileInfo
+
+	Keyframes []Keyframe
+	// the first sequence headers of the file
+	VideoHeader *Tag
+	AudioHeader *Tag
+	// the largest timestamp of the scanned tags
+	LastTimestamp int32
+	// offset of the first tag
+	Start int64
+	// end of the last complete tag
+	End int64
+}
+
+func NewIndex(path string) *Index {
+	return &Index{path: path}
+}
+
+// Update scans the complete tags appended since the last update.
+// The index is rebuilt if the file has been replaced, such as after injecting metadata.
+func (x *Index) Update() error {
+	f, err := os.Open(x.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if x.file != nil && !os.SameFile(x.file, stat) {
+		*x = Index{path: x.path}
+	}
+	x.file = stat
+
+	size := stat.Size()
+	if x.End == 0 {
+		if size < FlvHeaderSize+PrevTagSizeBytes {
+			// nothing written yet
+			return nil
+		}
+		header := make([]byte, FlvHeaderSize)
+		if _, err := io.ReadFull(f, header); err != nil || string(header[:3]) != "FLV" {
+			return ErrNotFlvFile
+		}
+		x.Start = int64(binary.BigEndian.Uint32(header[5:9])) + PrevTagSizeBytes
+		x.End = x.Start
+	}
+	if _, err := f.Seek(x.End, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(f, readerBufferSize)
+	header := make([]byte, TagHeaderSize)
+	for x.End+TagHeaderSize <= size {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return eofNil(err)
+		}
+		dataSize := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		tagSize := int64(TagHeaderSize + dataSize + PrevTagSizeBytes)
+		if x.End+tagSize > size {
+			break
+		}
+		timestamp := int32(header[7])<<24 | int32(header[4])<<16 | int32(header[5])<<8 | int32(header[6])
+
+		peeked, _ := r.Peek(min(dataSize, 16))
+		skip := dataSize + PrevTagSizeBytes
+		switch header[0] {
+		case TagTypeVideo:
+			if IsVideoSequenceHeader(peeked) {
+				if x.VideoHeader == nil {
+					if x.VideoHeader, err = readTagBody(r, header, timestamp, dataSize); err != nil {
+						return eofNil(err)
+					}
+					skip = PrevTagSizeBytes
+				}
+			} else if len(peeked) > 0 && (peeked[0]>>4)&0x07 == 1 {
+				x.Keyframes = append(x.Keyframes, Keyframe{Timestamp: timestamp, Offset: x.End})
+			}
+		case TagTypeAudio:
+			if x.AudioHeader == nil && len(peeked) >= 2 && AudioCodec(peeked) == AudioCodecAAC && peeked[1] == 0 {
+				if x.AudioHeader, err = readTagBody(r, header, timestamp, dataSize); err != nil {
+					return eofNil(err)
+				}
+				skip = PrevTagSizeBytes
+			}
+		}
+		if _, err := r.Discard(skip); err != nil {
+			return eofNil(err)
+		}
+		x.End += tagSize
+		x.LastTimestamp = max(x.LastTimestamp, timestamp)
+	}
+	return nil
+}
+
+// Seek returns the last keyframe at or before the timestamp, or the first keyframe
+func (x *Index) Seek(timestamp int32) (Keyframe, bool) {
+	if len(x.Keyframes) == 0 {
+		return Keyframe{}, false
+	}
+	i := sort.Search(len(x.Keyframes), func(i int) bool {
+		return x.Keyframes[i].Timestamp > timestamp
+	})
+	return x.Keyframes[max(i-1, 0)], true
+}
+
+func readTagBody(r io.Reader, header []byte, timestamp int32, dataSize int) (*Tag, error) {
+	tag := &Tag{
+		Type:      header[0],
+		DataSize:  uint32(dataSize),
+		Timestamp: timestamp,
+		Data:      make([]byte, dataSize),
+		IsHeader:  true,
+	}
+	if _, err := io.ReadFull(r, tag.Data); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// eofNil stops scanning at the end of a file which is still being written
+func eofNil(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package flv_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+func TestIndex_UpdatesWhileGrowing(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	write(flv.TagTypeVideo, 0, avcSequenceHeader(buildAVCSPS()))
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	offsets := make([]int, 0)
+	for i := range 10 {
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+			offsets = append(offsets, stream.Len())
+		}
+		write(flv.TagTypeVideo, int32(i*40), []byte{frameType, 0x01, 0x00, 0x00, 0x00, byte(i)})
+	}
+	data := stream.Bytes()
+
+	path := filepath.Join(t.TempDir(), "record.flv")
+	// the second keyframe is only half written
+	if err := os.WriteFile(path, data[:offsets[1]+8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	x := flv.NewIndex(path)
+	if err := x.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Keyframes) != 1 || x.End != int64(offsets[1]) || x.VideoHeader == nil || x.AudioHeader == nil {
+		t.Fatalf("unexpected index of partial file: %+v", x)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Keyframes) != 2 || x.Keyframes[1] != (flv.Keyframe{Timestamp: 200, Offset: int64(offsets[1])}) || x.End != int64(len(data)) || x.LastTimestamp != 360 {
+		t.Fatalf("unexpected index after the file grows: %+v", x)
+	}
+	if kf, _ := x.Seek(300); kf.Timestamp != 200 {
+		t.Errorf("expected seek to keyframe at 200, got %d", kf.Timestamp)
+	}
+
+	// read the tags of the second GOP from its keyframe
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Seek(int64(offsets[1]), io.SeekStart)
+	r := flv.NewTagReaderAt(f, int64(offsets[1]))
+	count := 0
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 && (!tag.IsKeyframe || tag.Timestamp != 200) {
+			t.Errorf("expected keyframe at 200, got %+v", tag)
+		}
+		count++
+	}
+	if count != 5 || r.Offset() != int64(len(data)) {
+		t.Errorf("expected 5 tags until %d, got %d until %d", len(data), count, r.Offset())
+	}
+}
+
+func TestParseVideoFrame(t *testing.T) {
+	frame, err := flv.ParseVideoFrame([]byte{0x17, 0x01, 0xFF, 0xFF, 0xF6, 0xAA})
+	if err != nil || !frame.Keyframe || frame.SequenceHeader || frame.CompositionTime != -10 || !bytes.Equal(frame.Payload, []byte{0xAA}) {
+		t.Errorf("unexpected legacy frame %+v: %v", frame, err)
+	}
+	// enhanced RTMP HEVC coded frames without composition time
+	frame, err = flv.ParseVideoFrame([]byte{0x93, 'h', 'v', 'c', '1', 0xBB})
+	if err != nil || !frame.Keyframe || frame.Codec != flv.VideoCodecHEVC || !bytes.Equal(frame.Payload, []byte{0xBB}) {
+		t.Errorf("unexpected enhanced frame %+v: %v", frame, err)
+	}
+	if _, err := flv.ParseVideoFrame([]byte{0x12, 0x00}); err != flv.ErrUnsupportedCodec {
+		t.Errorf("expected unsupported codec, got %v", err)
+	}
+
+	config, err := flv.ParseAACConfig([]byte{0x12, 0x10})
+	if err != nil || config.ObjectType != 2 || config.SampleRate != 44100 || config.Channels != 2 {
+		t.Errorf("unexpected AAC config %+v: %v", config, err)
+	}
+}
@@ -0,0 +1,116 @@
+package flv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// gap between two files if the frame rate is unknown
+const defaultFrameInterval = 33
+
+var ErrIncompatibleFiles = errors.New("files are encoded with different codecs")
+
+// merger concatenates the tags of FLV files with continuous timestamps
+type merger struct {
+	w io.Writer
+	// the sequence headers written last, repeated only when they change
+	videoHeader []byte
+	audioHeader []byte
+	// where the next file starts on the merged timeline
+	offset   int32
+	last     int32
+	interval int32
+}
+
+// Merge writes the FLV files one after another into w as a single FLV.
+// Each file is shifted to continue from the last frame of the previous one, sequence headers are kept
+// only when they change, and the metadata is dropped as it describes the files separately.
+func Merge(ctx context.Context, paths []string, w io.Writer) error {
+	bw := bufio.NewWriterSize(w, readerBufferSize)
+	if _, err := bw.Write(append(append([]byte(nil), FlvHeader...), 0, 0, 0, 0)); err != nil {
+		return err
+	}
+	m := &merger{w: bw, interval: defaultFrameInterval}
+	for i, path := range paths {
+		if err := m.append(ctx, path); err != nil {
+			return err
+		}
+		if i < len(paths)-1 {
+			m.offset = m.last + m.interval
+		}
+	}
+	return bw.Flush()
+}
+
+func (m *merger) append(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := NewTagReader(f)
+	if err != nil {
+		return err
+	}
+
+	first, started := int32(-1), false
+	var lastVideo int32
+	for n := 0; ; n++ {
+		if n%cancelCheckTags == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		tag, err := r.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if tag.Type == TagTypeScript {
+			continue
+		} else if first < 0 {
+			first = tag.Timestamp
+		}
+		tag.Timestamp = m.offset + max(tag.Timestamp-first, 0)
+
+		if tag.IsHeader {
+			if err := m.header(tag); err != nil {
+				return err
+			}
+			continue
+		} else if tag.Type == TagTypeVideo {
+			// frames before the first keyframe of a file cannot be decoded
+			if !started && !tag.IsKeyframe {
+				continue
+			}
+			if started && tag.Timestamp > lastVideo && tag.Timestamp-lastVideo < 1000 {
+				m.interval = tag.Timestamp - lastVideo
+			}
+			started, lastVideo = true, tag.Timestamp
+		}
+		if err := WriteTag(m.w, tag); err != nil {
+			return err
+		}
+		m.last = max(m.last, tag.Timestamp)
+	}
+}
+
+// header writes a sequence header which differs from the previous one, the codec must stay the same
+func (m *merger) header(tag *Tag) error {
+	previous, codec := &m.audioHeader, AudioCodec
+	if tag.Type == TagTypeVideo {
+		previous, codec = &m.videoHeader, VideoCodec
+	}
+	if bytes.Equal(*previous, tag.Data) {
+		return nil
+	} else if *previous != nil && codec(*previous) != codec(tag.Data) {
+		return ErrIncompatibleFiles
+	}
+	*previous = tag.Data
+	return WriteTag(m.w, tag)
+}
@@ -0,0 +1,103 @@
+package flv_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+// writeSegment writes a recording of frames every 40 ms starting from start, with a keyframe every 5 frames
+func writeSegment(t *testing.T, name string, start int32, frames int, videoHeader []byte) string {
+	t.Helper()
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	write(flv.TagTypeScript, 0, []byte{0x02, 0x00, 0x0A, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a'})
+	write(flv.TagTypeVideo, start, videoHeader)
+	write(flv.TagTypeAudio, start, []byte{0xAF, 0x00, 0x12, 0x10})
+	for i := range frames {
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+		}
+		write(flv.TagTypeVideo, start+int32(i*40), []byte{frameType, 0x01, 0x00, 0x00, 0x00, byte(i)})
+		write(flv.TagTypeAudio, start+int32(i*40), []byte{0xAF, 0x01, byte(i)})
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, stream.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readTags(t *testing.T, data []byte) []*flv.Tag {
+	t.Helper()
+	r, err := flv.NewTagReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []*flv.Tag
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			return tags
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+}
+
+func TestMerge_ContinuousTimestamps(t *testing.T) {
+	header := avcSequenceHeader(buildAVCSPS())
+	// the second segment starts after a recovery with its own timeline
+	paths := []string{
+		writeSegment(t, "part1.flv", 0, 10, header),
+		writeSegment(t, "part2.flv", 5000, 10, header),
+	}
+	var out bytes.Buffer
+	if err := flv.Merge(context.Background(), paths, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := readTags(t, out.Bytes())
+	// the unchanged sequence headers of the second segment are not repeated
+	if len(tags) != 2+20*2 {
+		t.Fatalf("expected 42 tags, got %d", len(tags))
+	}
+	second := tags[2+10*2]
+	if !second.IsKeyframe || second.Timestamp != 400 {
+		t.Errorf("second segment should continue at 400 ms, got %+v", second)
+	}
+	var last int32
+	for _, tag := range tags {
+		if tag.Type == flv.TagTypeScript {
+			t.Error("metadata of the segments should be dropped")
+		} else if tag.Timestamp < last {
+			t.Errorf("timestamp goes back from %d to %d", last, tag.Timestamp)
+		}
+		last = tag.Timestamp
+	}
+	if last != 760 {
+		t.Errorf("expected the last tag at 760 ms, got %d", last)
+	}
+}
+
+func TestMerge_IncompatibleCodecs(t *testing.T) {
+	hevc := []byte{0x1C, 0x00, 0x00, 0x00, 0x00, 0x01}
+	paths := []string{
+		writeSegment(t, "avc.flv", 0, 5, avcSequenceHeader(buildAVCSPS())),
+		writeSegment(t, "hevc.flv", 0, 5, hevc),
+	}
+	if err := flv.Merge(context.Background(), paths, io.Discard); err != flv.ErrIncompatibleFiles {
+		t.Errorf("expected incompatible files, got %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	metadataCreator = "bilirec"
+	// sequence headers larger than the scan buffer are not parsed
+	metadataScanBufferSize = 64 * 1024
+)
+
+var audioSampleRates = []float64{5512, 11025, 22050, 44100}
+
+type keyframeEntry struct {
+	timestamp int32
+	offset    int64
+}
+
+// byteRange is a tag in the source file, including its previous tag size
+type byteRange struct {
+	offset int64
+	size   int64
+}
+
+// fileScan is the information collected from the tag headers of a FLV file
+type fileScan struct {
+	header     []byte
+	dataStart  int64 // offset of the first tag
+	dataEnd    int64 // end of the last complete tag
+	lastTs     int32
+	videoBytes int64
+	audioBytes int64
+	frames     int
+
+	hasVideo     bool
+	hasAudio     bool
+	videoCodecId int
+	audioCodecId int
+	sampleRate   float64
+	stereo       bool
+	width        int
+	height       int
+
+	keyframes []keyframeEntry
+	// onMetaData tags of the live stream, replaced by the rewritten one
+	dropped []byteRange
+}
+
+// InjectMetadata rewrites the onMetaData of a FLV file with the duration, file size, video format,
+// data rates and a keyframes index so players can seek the file. Custom properties are appended to the metadata.
+// The file is streamed into a temporary file next to it, which replaces the original when completed.
+func InjectMetadata(path string, custom ...AMFProperty) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	scan, err := scanFile(src)
+	if err != nil {
+		return err
+	}
+	body, err := scan.metadata(custom)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := scan.rewrite(src, tmpPath, body); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	src.Close()
+	return os.Rename(tmpPath, path)
+}
+
+// ReadMetadata returns the onMetaData of a FLV file, nil if the first tag is not onMetaData
+func ReadMetadata(path string) (AMFECMAArray, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	header := make([]byte, FlvHeaderSize+PrevTagSizeBytes+TagHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "FLV" {
+		return nil, ErrNotFlvFile
+	}
+	tagHeader := header[FlvHeaderSize+PrevTagSizeBytes:]
+	if tagHeader[0] != TagTypeScript {
+		return nil, nil
+	}
+	body := make([]byte, int(tagHeader[1])<<16|int(tagHeader[2])<<8|int(tagHeader[3]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	name, value, err := ParseScriptTag(body)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case AMFECMAArray:
+		if name == "onMetaData" {
+			return v, nil
+		}
+	case AMFObject:
+		if name == "onMetaData" {
+			return AMFECMAArray(v), nil
+		}
+	}
+	return nil, nil
+}
+
+func scanFile(src io.Reader) (*fileScan, error) {
+	r := bufio.NewReaderSize(src, metadataScanBufferSize)
+	header := make([]byte, FlvHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "FLV" {
+		return nil, ErrNotFlvFile
+	}
+	dataOffset := int64(binary.BigEndian.Uint32(header[5:9]))
+	if dataOffset < FlvHeaderSize {
+		return nil, ErrNotFlvFile
+	}
+	// skip the rest of the header and the first previous tag size
+	if _, err := r.Discard(int(dataOffset) - FlvHeaderSize + PrevTagSizeBytes); err != nil {
+		return nil, ErrNotFlvFile
+	}
+
+	scan := &fileScan{header: header, dataStart: dataOffset + PrevTagSizeBytes}
+	scan.dataEnd = scan.dataStart
+	tagHeader := make([]byte, TagHeaderSize)
+	scratch := make([]byte, 0, metadataScanBufferSize)
+	offset := scan.dataStart
+	for {
+		if _, err := io.ReadFull(r, tagHeader); err != nil {
+			// a truncated tag at the end is dropped
+			break
+		}
+		tagType := tagHeader[0]
+		dataSize := int(tagHeader[1])<<16 | int(tagHeader[2])<<8 | int(tagHeader[3])
+		timestamp := int32(tagHeader[7])<<24 | int32(tagHeader[4])<<16 | int32(tagHeader[5])<<8 | int32(tagHeader[6])
+
+		// keep the whole body of script tags and sequence headers, only the leading bytes of frames
+		peeked, _ := r.Peek(min(dataSize, metadataScanBufferSize))
+		keep := min(len(peeked), 16)
+		if tagType == TagTypeScript || (tagType == TagTypeVideo && IsVideoSequenceHeader(peeked)) {
+			keep = len(peeked)
+		}
+		body := append(scratch[:0], peeked[:keep]...)
+
+		tagSize := TagHeaderSize + dataSize + PrevTagSizeBytes
+		if _, err := r.Discard(dataSize + PrevTagSizeBytes); err != nil {
+			break
+		}
+		scan.observe(tagType, timestamp, offset, dataSize, body)
+		offset += int64(tagSize)
+		scan.dataEnd = offset
+		scan.lastTs = max(scan.lastTs, timestamp)
+	}
+	return scan, nil
+}
+
+func (s *fileScan) observe(tagType byte, timestamp int32, offset int64, dataSize int, body []byte) {
+	switch tagType {
+	case TagTypeScript:
+		if ScriptTagName(body) == "onMetaData" {
+			s.dropped = append(s.dropped, byteRange{offset: offset, size: int64(TagHeaderSize + dataSize + PrevTagSizeBytes)})
+		}
+	case TagTypeVideo:
+		if len(body) == 0 {
+			return
+		}
+		s.hasVideo = true
+		s.videoBytes += int64(dataSize)
+		switch VideoCodec(body) {
+		case VideoCodecAVC:
+			s.videoCodecId = codecIdAVC
+		case VideoCodecHEVC:
+			s.videoCodecId = codecIdHEVC
+		}
+		if IsVideoSequenceHeader(body) {
+			if info, err := ParseVideoSequenceHeader(body); err == nil {
+				s.width, s.height = info.Width, info.Height
+			}
+			return
+		}
+		s.frames++
+		// frame type is the upper 4 bits, or 3 bits for enhanced RTMP header
+		if (body[0]>>4)&0x07 == 1 {
+			s.keyframes = append(s.keyframes, keyframeEntry{timestamp: timestamp, offset: offset})
+		}
+	case TagTypeAudio:
+		if len(body) == 0 {
+			return
+		}
+		s.hasAudio = true
+		s.audioBytes += int64(dataSize)
+		s.audioCodecId = int(body[0] >> 4)
+		s.sampleRate = audioSampleRates[(body[0]>>2)&0x03]
+		s.stereo = body[0]&0x01 == 1
+	}
+}
+
+// newOffset maps an offset of the source file to the rewritten file
+func (s *fileScan) newOffset(offset int64, metadataSize int) int64 {
+	dropped := int64(0)
+	for _, r := range s.dropped {
+		if r.offset < offset {
+			dropped += r.size
+		}
+	}
+	return FlvHeaderSize + PrevTagSizeBytes + int64(TagHeaderSize+metadataSize+PrevTagSizeBytes) + offset - s.dataStart - dropped
+}
+
+// metadata encodes the onMetaData body, numbers are fixed 8 bytes in AMF0,
+// so the size computed with placeholder offsets stays the same with the actual ones.
+func (s *fileScan) metadata(custom []AMFProperty) ([]byte, error) {
+	placeholder, err := NewScriptTag("onMetaData", s.metadataArray(custom, 0))
+	if err != nil {
+		return nil, err
+	}
+	body, err := NewScriptTag("onMetaData", s.metadataArray(custom, len(placeholder)))
+	if err != nil {
+		return nil, err
+	} else if len(body) != len(placeholder) {
+		return nil, fmt.Errorf("unexpected metadata size %d, expected %d", len(body), len(placeholder))
+	}
+	return body, nil
+}
+
+func (s *fileScan) metadataArray(custom []AMFProperty, size int) AMFECMAArray {
+	duration := float64(s.lastTs) / 1000
+	rate := func(bytes int64) float64 {
+		if duration <= 0 {
+			return 0
+		}
+		return float64(bytes) * 8 / 1000 / duration // kbps
+	}
+	framerate := 0.0
+	if duration > 0 {
+		framerate = float64(s.frames) / duration
+	}
+
+	times := make([]float64, 0, len(s.keyframes))
+	positions := make([]float64, 0, len(s.keyframes))
+	for _, kf := range s.keyframes {
+		times = append(times, float64(kf.timestamp)/1000)
+		positions = append(positions, float64(s.newOffset(kf.offset, size)))
+	}
+	lastKeyframeTs, lastKeyframePos := 0.0, 0.0
+	if len(times) > 0 {
+		lastKeyframeTs, lastKeyframePos = times[len(times)-1], positions[len(positions)-1]
+	}
+
+	dropped := int64(0)
+	for _, r := range s.dropped {
+		dropped += r.size
+	}
+	fileSize := FlvHeaderSize + PrevTagSizeBytes + int64(TagHeaderSize+size+PrevTagSizeBytes) + s.dataEnd - s.dataStart - dropped
+
+	array := AMFECMAArray{
+		{"duration", duration},
+		{"width", float64(s.width)},
+		{"height", float64(s.height)},
+		{"videodatarate", rate(s.videoBytes)},
+		{"framerate", framerate},
+		{"videocodecid", float64(s.videoCodecId)},
+		{"audiodatarate", rate(s.audioBytes)},
+		{"audiosamplerate", s.sampleRate},
+		{"stereo", s.stereo},
+		{"audiocodecid", float64(s.audioCodecId)},
+		{"filesize", float64(fileSize)},
+		{"lasttimestamp", duration},
+		{"lastkeyframetimestamp", lastKeyframeTs},
+		{"lastkeyframelocation", lastKeyframePos},
+		{"hasVideo", s.hasVideo},
+		{"hasAudio", s.hasAudio},
+		{"hasMetadata", true},
+		{"hasKeyframes", len(times) > 0},
+		{"canSeekToEnd", len(times) > 0 && s.keyframes[len(s.keyframes)-1].timestamp == s.lastTs},
+		{"metadatacreator", metadataCreator},
+	}
+	array = append(array, custom...)
+	return append(array, AMFProperty{"keyframes", AMFObject{
+		{"times", times},
+		{"filepositions", positions},
+	}})
+}
+
+// rewrite writes the header, the new metadata and every tag except the dropped ones into dest
+func (s *fileScan) rewrite(src io.ReaderAt, dest string, metadata []byte) error {
+	if len(metadata) >= 1<<24 {
+		return errors.New("metadata too large for a script tag")
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriterSize(f, metadataScanBufferSize)
+
+	header := append([]byte(nil), s.header[:5]...)
+	header = binary.BigEndian.AppendUint32(header, FlvHeaderSize)
+	if _, err := w.Write(append(header, 0, 0, 0, 0)); err != nil {
+		return err
+	}
+	if err := WriteTag(w, &Tag{Type: TagTypeScript, DataSize: uint32(len(metadata)), Data: metadata}); err != nil {
+		return err
+	}
+
+	pos := s.dataStart
+	for _, r := range append(s.dropped, byteRange{offset: s.dataEnd}) {
+		if _, err := io.Copy(w, io.NewSectionReader(src, pos, r.offset-pos)); err != nil {
+			return err
+		}
+		pos = r.offset + r.size
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
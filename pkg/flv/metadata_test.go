@@ -0,0 +1,123 @@
+package flv_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+func TestAMF0_RoundTrip(t *testing.T) {
+	value := flv.AMFECMAArray{
+		{Key: "duration", Value: 12.5},
+		{Key: "hasVideo", Value: true},
+		{Key: "title", Value: "直播標題"},
+		{Key: "nothing", Value: nil},
+		{Key: "keyframes", Value: flv.AMFObject{
+			{Key: "times", Value: []float64{0, 2}},
+		}},
+	}
+	body, err := flv.NewScriptTag("onMetaData", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := flv.ScriptTagName(body); name != "onMetaData" {
+		t.Fatalf("unexpected script tag name %q", name)
+	}
+	name, decoded, err := flv.ParseScriptTag(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	array, ok := decoded.(flv.AMFECMAArray)
+	if name != "onMetaData" || !ok || len(array) != len(value) {
+		t.Fatalf("unexpected decoded value %q: %#v", name, decoded)
+	}
+	if title, _ := array.Get("title"); title != "直播標題" {
+		t.Errorf("unexpected title %v", title)
+	}
+	keyframes, _ := array.Get("keyframes")
+	times, _ := keyframes.(flv.AMFObject).Get("times")
+	if items := times.([]any); len(items) != 2 || items[1] != 2.0 {
+		t.Errorf("unexpected keyframe times %v", times)
+	}
+	if _, _, err := flv.ParseScriptTag(body[:len(body)-5]); err == nil {
+		t.Error("expected error for truncated script tag")
+	}
+}
+
+func TestInjectMetadata(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	live, _ := flv.NewScriptTag("onMetaData", flv.AMFECMAArray{{Key: "encoder", Value: "live"}})
+	write(flv.TagTypeScript, 0, live)
+	write(flv.TagTypeVideo, 0, avcSequenceHeader(buildAVCSPS()))
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	// 4 seconds at 25 fps with a keyframe every 2 seconds
+	for i := range 100 {
+		frameType := byte(0x27)
+		if i%50 == 0 {
+			frameType = 0x17
+		}
+		write(flv.TagTypeVideo, int32(i*40), append([]byte{frameType, 0x01, 0x00, 0x00, 0x00}, bytes.Repeat([]byte{byte(i)}, 500)...))
+		write(flv.TagTypeAudio, int32(i*40), []byte{0xAF, 0x01, 0x21, 0x00})
+	}
+	// truncated tag at the end of an interrupted recording
+	stream.Write([]byte{flv.TagTypeVideo, 0x00, 0x10})
+
+	path := filepath.Join(t.TempDir(), "record.flv")
+	if err := os.WriteFile(path, stream.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := flv.InjectMetadata(path, flv.AMFProperty{Key: "room_id", Value: 545068}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := flv.ReadMetadata(path)
+	if err != nil || meta == nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	number := func(key string) float64 {
+		value, _ := meta.Get(key)
+		n, _ := value.(float64)
+		return n
+	}
+	if number("duration") != 3.96 || number("width") != 1920 || number("height") != 1080 {
+		t.Errorf("unexpected video metadata: %v", meta)
+	}
+	if number("videocodecid") != 7 || number("audiocodecid") != 10 || number("audiosamplerate") != 44100 {
+		t.Errorf("unexpected codec metadata: %v", meta)
+	}
+	if number("filesize") != float64(len(data)) {
+		t.Errorf("expected filesize %d, got %v", len(data), number("filesize"))
+	}
+	if number("room_id") != 545068 {
+		t.Errorf("expected custom room_id, got %v", number("room_id"))
+	}
+	if encoder, ok := meta.Get("encoder"); ok {
+		t.Errorf("live onMetaData should be replaced, got encoder %v", encoder)
+	}
+
+	keyframes, _ := meta.Get("keyframes")
+	times, _ := keyframes.(flv.AMFObject).Get("times")
+	positions, _ := keyframes.(flv.AMFObject).Get("filepositions")
+	if len(times.([]any)) != 2 || times.([]any)[1] != 2.0 {
+		t.Fatalf("unexpected keyframe times: %v", times)
+	}
+	for _, pos := range positions.([]any) {
+		offset := int(pos.(float64))
+		if data[offset] != flv.TagTypeVideo || data[offset+flv.TagHeaderSize]>>4 != 1 {
+			t.Errorf("file position %d is not a keyframe", offset)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const readerBufferSize = 64 * 1024
+
+// TagReader reads complete tags from a FLV stream
+type TagReader struct {
+	r      *bufio.Reader
+	offset int64
+	header [TagHeaderSize]byte
+}
+
+// NewTagReader validates the FLV header and reads from the first tag
+func NewTagReader(r io.Reader) (*TagReader, error) {
+	br := bufio.NewReaderSize(r, readerBufferSize)
+	header := make([]byte, FlvHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:3]) != "FLV" {
+		return nil, ErrNotFlvFile
+	}
+	dataOffset := int64(binary.BigEndian.Uint32(header[5:9]))
+	if dataOffset < FlvHeaderSize {
+		return nil, ErrNotFlvFile
+	}
+	if _, err := br.Discard(int(dataOffset) - FlvHeaderSize + PrevTagSizeBytes); err != nil {
+		return nil, ErrNotFlvFile
+	}
+	return &TagReader{r: br, offset: dataOffset + PrevTagSizeBytes}, nil
+}
+
+// NewTagReaderAt reads from a tag which starts at the current position of r,
+// offset is the position of that tag in the file.
+func NewTagReaderAt(r io.Reader, offset int64) *TagReader {
+	return &TagReader{r: bufio.NewReaderSize(r, readerBufferSize), offset: offset}
+}
+
+// Offset returns the position of the next tag
+func (t *TagReader) Offset() int64 {
+	return t.offset
+}
+
+// Next reads the next tag into a new Tag, io.EOF is returned when no complete tag is left
+func (t *TagReader) Next() (*Tag, error) {
+	if _, err := io.ReadFull(t.r, t.header[:]); err != nil {
+		return nil, eof(err)
+	}
+	tag := &Tag{
+		Type:      t.header[0],
+		DataSize:  uint32(t.header[1])<<16 | uint32(t.header[2])<<8 | uint32(t.header[3]),
+		Timestamp: int32(t.header[7])<<24 | int32(t.header[4])<<16 | int32(t.header[5])<<8 | int32(t.header[6]),
+		StreamID:  [3]byte(t.header[8:11]),
+	}
+	tag.Data = make([]byte, tag.DataSize)
+	if _, err := io.ReadFull(t.r, tag.Data); err != nil {
+		return nil, eof(err)
+	}
+	if _, err := t.r.Discard(PrevTagSizeBytes); err != nil {
+		return nil, eof(err)
+	}
+
+	switch tag.Type {
+	case TagTypeVideo:
+		tag.IsHeader = IsVideoSequenceHeader(tag.Data)
+		tag.IsKeyframe = !tag.IsHeader && len(tag.Data) > 0 && (tag.Data[0]>>4)&0x07 == 1
+	case TagTypeAudio:
+		tag.IsHeader = AudioCodec(tag.Data) == AudioCodecAAC && len(tag.Data) >= 2 && tag.Data[1] == 0
+	}
+	t.offset += int64(TagHeaderSize) + int64(tag.DataSize) + PrevTagSizeBytes
+	return tag, nil
+}
+
+// eof treats a truncated tag at the end of an interrupted recording as the end of the file
+func eof(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return err
+}
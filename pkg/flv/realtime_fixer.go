@@ -18,6 +18,7 @@ type RealtimeFixer struct {
 	dedupCache     *DedupCache // 🔥 新增:  去重緩存
 	dupCount       int64       // 🔥 新增: 重複計數
 	lastDedupClean int32       // timestamp of last dedup clean
+	health         *HealthMonitor
 }
 
 func NewRealtimeFixer() *RealtimeFixer {
@@ -40,6 +41,13 @@ func (rf *RealtimeFixer) GetDedupStats() (duplicates int64, cacheSize int, cache
 	return rf.dupCount, size, capacity
 }
 
+// SetHealthMonitor reports every written tag, dropped duplicate and corrected timestamp jump to the monitor
+func (rf *RealtimeFixer) SetHealthMonitor(m *HealthMonitor) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.health = m
+}
+
 // Fix processes incoming bytes and returns fixed FLV data
 func (rf *RealtimeFixer) Fix(input []byte) ([]byte, error) {
 	rf.mu.Lock()
@@ -148,6 +156,9 @@ func (rf *RealtimeFixer) Fix(input []byte) ([]byte, error) {
 		// 🔥 新增: 去重檢查 (在修復時間戳之前)
 		if rf.dedupCache.IsDuplicate(tag) {
 			rf.dupCount++
+			if rf.health != nil {
+				rf.health.addDuplicate()
+			}
 			tag.Reset() // clear Data and other fields before pooling
 			tagPool.Put(tag)
 			continue // 跳過重複的 tag
@@ -160,6 +171,9 @@ func (rf *RealtimeFixer) Fix(input []byte) ([]byte, error) {
 		if err := writeTagOptimized(output, tag); err != nil {
 			return nil, err
 		}
+		if rf.health != nil {
+			rf.health.observe(tag)
+		}
 
 		// 🔥 優化:  返還 tag 到 pool (但保留 Data 因為已經寫入)
 		tag.Reset()
@@ -237,6 +251,7 @@ func (rf *RealtimeFixer) fixTimestamp(tag *Tag) {
 	currentTimestamp := tag.Timestamp
 
 	// First chunk special handling
+	firstChunk := ts.FirstChunk
 	if ts.FirstChunk {
 		ts.FirstChunk = false
 		ts.CurrentOffset = currentTimestamp
@@ -245,10 +260,12 @@ func (rf *RealtimeFixer) fixTimestamp(tag *Tag) {
 	diff := currentTimestamp - ts.LastOriginal
 
 	// Detect timestamp jump
-	if diff < -JumpThreshold || (ts.LastOriginal == 0 && diff < 0) {
-		ts.CurrentOffset = currentTimestamp - ts.NextTimestampTarget
-	} else if diff > JumpThreshold {
+	if diff < -JumpThreshold || (ts.LastOriginal == 0 && diff < 0) || diff > JumpThreshold {
 		ts.CurrentOffset = currentTimestamp - ts.NextTimestampTarget
+		// the offset of the first tag is not a jump
+		if rf.health != nil && !firstChunk {
+			rf.health.addTimestampJump()
+		}
 	}
 
 	ts.LastOriginal = currentTimestamp
@@ -0,0 +1,153 @@
+package hls_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/hls"
+)
+
+const mediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:1
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-MAP:URI="h1700000000.m4s"
+#EXTINF:1.00,
+100.m4s
+#EXTINF:1.00,
+101.m4s
+`
+
+func TestParse_MediaPlaylist(t *testing.T) {
+	pl, err := hls.Parse(strings.NewReader(mediaPlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.TargetDuration != 1 || pl.MediaSequence != 100 {
+		t.Errorf("unexpected header: %+v", pl)
+	}
+	if pl.InitURI != "h1700000000.m4s" {
+		t.Errorf("unexpected init uri: %s", pl.InitURI)
+	}
+	if len(pl.Segments) != 2 || pl.Segments[1].Sequence != 101 || pl.Segments[1].URI != "101.m4s" {
+		t.Errorf("unexpected segments: %+v", pl.Segments)
+	}
+	if pl.Ended {
+		t.Error("live playlist should not be ended")
+	}
+}
+
+func TestParse_MasterPlaylist(t *testing.T) {
+	pl, err := hls.Parse(strings.NewReader("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS=\"avc1.64001f,mp4a.40.2\"\nlive/index.m3u8?token=abc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl.Variants) != 1 || pl.Variants[0] != "live/index.m3u8?token=abc" || len(pl.Segments) != 0 {
+		t.Errorf("unexpected master playlist: %+v", pl)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	if _, err := hls.Parse(strings.NewReader("<html></html>")); !errors.Is(err, hls.ErrInvalidPlaylist) {
+		t.Errorf("expected ErrInvalidPlaylist, got %v", err)
+	}
+}
+
+func TestResolveURI_KeepsQuery(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/live/index.m3u8?expires=1&sign=x")
+	got, err := hls.ResolveURI(base, "100.m4s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "https://cdn.example.com/live/100.m4s?expires=1&sign=x" {
+		t.Errorf("unexpected resolved uri: %s", got)
+	}
+}
+
+// fakeServer serves a sliding live playlist, every playlist request advances it by one segment
+type fakeServer struct {
+	mu        sync.Mutex
+	first     int
+	last      int
+	end       int
+	downloads map[string]int
+}
+
+func (f *fakeServer) fetch(_ context.Context, u string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parsed, _ := url.Parse(u)
+	name := strings.TrimPrefix(parsed.Path, "/live/")
+	if f.downloads == nil {
+		f.downloads = make(map[string]int)
+	}
+	f.downloads[name]++
+
+	if name != "index.m3u8" {
+		return io.NopCloser(strings.NewReader("[" + name + "]")), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-TARGETDURATION:0\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-MAP:URI=\"init.m4s\"\n", f.first)
+	for i := f.first; i <= f.last; i++ {
+		fmt.Fprintf(&b, "#EXTINF:1.0,\n%d.m4s\n", i)
+	}
+	if f.last >= f.end {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	} else {
+		f.last++
+		f.first = max(f.first, f.last-2)
+	}
+	return io.NopCloser(strings.NewReader(b.String())), nil
+}
+
+func TestPoller_DedupAndInit(t *testing.T) {
+	server := &fakeServer{first: 0, last: 1, end: 4}
+	poller, err := hls.NewPoller(context.Background(), "https://cdn.example.com/live/index.m3u8", server.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := poller.Run(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[init.m4s][0.m4s][1.m4s][2.m4s][3.m4s][4.m4s]"
+	if out.String() != expected {
+		t.Errorf("expected %s, got %s", expected, out.String())
+	}
+	for name, count := range server.downloads {
+		if name != "index.m3u8" && count != 1 {
+			t.Errorf("%s downloaded %d times", name, count)
+		}
+	}
+}
+
+func TestPoller_FollowsVariant(t *testing.T) {
+	server := &fakeServer{first: 0, last: 0, end: 0}
+	fetch := func(ctx context.Context, u string) (io.ReadCloser, error) {
+		if strings.HasSuffix(u, "/master.m3u8") {
+			return io.NopCloser(strings.NewReader("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\nlive/index.m3u8\n")), nil
+		}
+		return server.fetch(ctx, u)
+	}
+	poller, err := hls.NewPoller(context.Background(), "https://cdn.example.com/master.m3u8", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(poller.Reader(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[init.m4s][0.m4s]" {
+		t.Errorf("unexpected stream: %s", data)
+	}
+}
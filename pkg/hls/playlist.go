@@ -0,0 +1,134 @@
+package hls
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPlaylist = errors.New("invalid m3u8 playlist")
+
+type Segment struct {
+	Sequence uint64
+	URI      string
+	Duration float64
+}
+
+// Playlist is a parsed HLS playlist, either a media playlist with segments
+// or a master playlist with variants only.
+type Playlist struct {
+	TargetDuration float64
+	MediaSequence  uint64
+	InitURI        string
+	Segments       []Segment
+	Variants       []string
+	Ended          bool
+}
+
+// Parse parses a m3u8 playlist, only the tags needed for live recording are handled
+func Parse(r io.Reader) (*Playlist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	p := &Playlist{}
+	headerSeen := false
+	nextIsVariant := false
+	var duration float64
+	var index uint64
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !headerSeen {
+			if line != "#EXTM3U" {
+				return nil, ErrInvalidPlaylist
+			}
+			headerSeen = true
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			p.TargetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			p.MediaSequence, _ = strconv.ParseUint(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			p.InitURI = attribute(strings.TrimPrefix(line, "#EXT-X-MAP:"), "URI")
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			nextIsVariant = true
+		case line == "#EXT-X-ENDLIST":
+			p.Ended = true
+		case strings.HasPrefix(line, "#"):
+			// unsupported tags are ignored
+		case nextIsVariant:
+			p.Variants = append(p.Variants, line)
+			nextIsVariant = false
+		default:
+			p.Segments = append(p.Segments, Segment{
+				Sequence: p.MediaSequence + index,
+				URI:      line,
+				Duration: duration,
+			})
+			index++
+			duration = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if !headerSeen {
+		return nil, ErrInvalidPlaylist
+	}
+	return p, nil
+}
+
+// attribute extracts the value of a key from an attribute list such as URI="init.mp4",BYTERANGE="..."
+func attribute(list, key string) string {
+	for _, part := range splitAttributes(list) {
+		k, v, ok := strings.Cut(part, "=")
+		if ok && strings.TrimSpace(k) == key {
+			return strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return ""
+}
+
+func splitAttributes(list string) []string {
+	parts := make([]string, 0)
+	quoted := false
+	start := 0
+	for i, c := range list {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, list[start:])
+}
+
+// ResolveURI resolves a playlist reference against the playlist url,
+// query parameters of the playlist are kept when the reference has none
+// since some CDNs authorize segments with the same token.
+func ResolveURI(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	resolved := base.ResolveReference(u)
+	if resolved.RawQuery == "" && !u.IsAbs() {
+		resolved.RawQuery = base.RawQuery
+	}
+	return resolved.String(), nil
+}
@@ -0,0 +1,200 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("pkg", "hls")
+
+var (
+	ErrEmptyPlaylist  = errors.New("no variant or segment in playlist")
+	ErrStalled        = errors.New("no new segment in playlist")
+	ErrInitChanged    = errors.New("init segment changed")
+	ErrSequenceReset  = errors.New("media sequence reset")
+	ErrTooManyNesting = errors.New("too many nested master playlists")
+)
+
+const (
+	minRefreshInterval = 1 * time.Second
+	minStallTimeout    = 30 * time.Second
+	maxMasterNesting   = 3
+)
+
+// Fetcher downloads a playlist or segment, the caller closes the returned body
+type Fetcher func(ctx context.Context, url string) (io.ReadCloser, error)
+
+// Poller follows a live media playlist and writes the init segment
+// and every new media segment in order, as one continuous stream.
+type Poller struct {
+	playlistURL *url.URL
+	fetch       Fetcher
+	current     *Playlist
+
+	initURI string
+	lastSeq uint64
+	started bool
+}
+
+// NewPoller fetches the playlist once so an unreachable stream fails early,
+// the first variant is followed if it is a master playlist.
+func NewPoller(ctx context.Context, playlistURL string, fetch Fetcher) (*Poller, error) {
+	u, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{playlistURL: u, fetch: fetch}
+
+	for range maxMasterNesting {
+		pl, err := p.fetchPlaylist(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(pl.Segments) > 0 || pl.InitURI != "" {
+			p.current = pl
+			return p, nil
+		} else if len(pl.Variants) == 0 {
+			return nil, ErrEmptyPlaylist
+		}
+		variant, err := ResolveURI(p.playlistURL, pl.Variants[0])
+		if err != nil {
+			return nil, err
+		}
+		if p.playlistURL, err = url.Parse(variant); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrTooManyNesting
+}
+
+// Run writes the stream to w until the playlist ends, stalls, or the context is done.
+// A nil error is returned when the playlist ends with EXT-X-ENDLIST.
+// Changes of the init segment and media sequence resets end the stream as well,
+// since the following segments cannot be appended to the same file.
+func (p *Poller) Run(ctx context.Context, w io.Writer) error {
+	lastProgress := time.Now()
+	for {
+		pl := p.current
+		progressed, err := p.writeNew(ctx, pl, w)
+		if err != nil {
+			return err
+		} else if progressed {
+			lastProgress = time.Now()
+		}
+
+		if pl.Ended {
+			return nil
+		} else if time.Since(lastProgress) > stallTimeout(pl) {
+			return ErrStalled
+		}
+
+		timer := time.NewTimer(refreshInterval(pl))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		next, err := p.fetchPlaylist(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// keep the last playlist and retry until stalled
+			logger.Warnf("cannot refresh playlist: %v", err)
+			continue
+		}
+		p.current = next
+	}
+}
+
+// Reader runs the poller in background and returns the stream as a reader,
+// the reader returns io.EOF when the playlist ends.
+func (p *Poller) Reader(ctx context.Context) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.Run(ctx, pw))
+	}()
+	return pr
+}
+
+// writeNew writes the segments not yet written, a failed download stops
+// the round and the segment is retried on the next refresh.
+func (p *Poller) writeNew(ctx context.Context, pl *Playlist, w io.Writer) (bool, error) {
+	if pl.InitURI != "" && pl.InitURI != p.initURI {
+		if p.initURI != "" {
+			return false, ErrInitChanged
+		}
+		data, err := p.download(ctx, pl.InitURI)
+		if err != nil {
+			return false, err
+		} else if _, err := w.Write(data); err != nil {
+			return false, err
+		}
+		p.initURI = pl.InitURI
+	}
+
+	if p.started && len(pl.Segments) > 0 && pl.Segments[len(pl.Segments)-1].Sequence < p.lastSeq {
+		return false, ErrSequenceReset
+	}
+
+	progressed := false
+	for _, seg := range pl.Segments {
+		if p.started && seg.Sequence <= p.lastSeq {
+			continue
+		}
+		data, err := p.download(ctx, seg.URI)
+		if err != nil {
+			if ctx.Err() != nil {
+				return progressed, ctx.Err()
+			}
+			logger.Warnf("cannot download segment %d: %v", seg.Sequence, err)
+			break
+		}
+		if _, err := w.Write(data); err != nil {
+			return progressed, err
+		}
+		p.lastSeq = seg.Sequence
+		p.started = true
+		progressed = true
+	}
+	return progressed, nil
+}
+
+// download reads a whole segment before it is written,
+// so a broken download does not leave a partial segment in the output
+func (p *Poller) download(ctx context.Context, ref string) ([]byte, error) {
+	u, err := ResolveURI(p.playlistURL, ref)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.fetch(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (p *Poller) fetchPlaylist(ctx context.Context) (*Playlist, error) {
+	body, err := p.fetch(ctx, p.playlistURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return Parse(body)
+}
+
+func refreshInterval(pl *Playlist) time.Duration {
+	return max(time.Duration(pl.TargetDuration*float64(time.Second))/2, minRefreshInterval)
+}
+
+func stallTimeout(pl *Playlist) time.Duration {
+	return max(time.Duration(pl.TargetDuration*float64(time.Second))*3, minStallTimeout)
+}
@@ -0,0 +1,60 @@
+package mp4
+
+import "encoding/binary"
+
+// boxWriter builds nested boxes in memory, the size of a box is filled when it ends
+type boxWriter struct {
+	buf []byte
+}
+
+// start writes the header of a box and returns its position for end
+func (w *boxWriter) start(typ string) int {
+	pos := len(w.buf)
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	w.buf = append(w.buf, typ...)
+	return pos
+}
+
+// startFull writes the header of a full box with its version and flags
+func (w *boxWriter) startFull(typ string, version byte, flags uint32) int {
+	pos := w.start(typ)
+	w.u32(uint32(version)<<24 | flags&0xFFFFFF)
+	return pos
+}
+
+func (w *boxWriter) end(pos int) {
+	binary.BigEndian.PutUint32(w.buf[pos:], uint32(len(w.buf)-pos))
+}
+
+func (w *boxWriter) u8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *boxWriter) u16(v uint16) {
+	w.buf = binary.BigEndian.AppendUint16(w.buf, v)
+}
+
+func (w *boxWriter) u32(v uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *boxWriter) u64(v uint64) {
+	w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+}
+
+func (w *boxWriter) bytes(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func (w *boxWriter) zeros(n int) {
+	for range n {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// matrix writes the unity transformation matrix of mvhd and tkhd
+func (w *boxWriter) matrix() {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
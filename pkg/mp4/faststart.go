@@ -0,0 +1,281 @@
+package mp4
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const writerBufferSize = 256 * 1024
+
+// sampleTable is the samples of a track in a regular MP4, which are stored in a chunk per GOP
+type sampleTable struct {
+	sizes []uint32
+	// run-length decoding time deltas and composition offsets
+	stts []tableRun
+	ctts []tableRun
+	// 1-based numbers of the sync samples, all samples are sync without it
+	sync  []uint32
+	video bool
+
+	// the chunks with their positions relative to the mdat data
+	chunkOffsets []int64
+	chunkSamples []uint32
+	dataStart    int64
+	co64         bool
+
+	firstDts int64
+	firstCts int32
+	lastDts  int64
+	previous int64
+	// duration of the samples and the position of the first one on the movie timeline
+	duration int64
+	start    int64
+}
+
+type tableRun struct {
+	count uint32
+	value int32
+}
+
+func appendRun(runs []tableRun, value int32) []tableRun {
+	if n := len(runs); n > 0 && runs[n-1].value == value {
+		runs[n-1].count++
+		return runs
+	}
+	return append(runs, tableRun{count: 1, value: value})
+}
+
+func (t *sampleTable) add(s sample) {
+	if len(t.sizes) == 0 {
+		t.firstDts, t.firstCts = s.dts, s.cts
+	} else {
+		t.addDuration(s.dts - t.lastDts)
+	}
+	t.lastDts = s.dts
+	t.sizes = append(t.sizes, s.size)
+	if t.video {
+		t.ctts = appendRun(t.ctts, s.cts)
+		if s.keyframe {
+			t.sync = append(t.sync, uint32(len(t.sizes)))
+		}
+	}
+}
+
+func (t *sampleTable) addDuration(duration int64) {
+	duration = max(duration, 0)
+	t.stts = appendRun(t.stts, int32(duration))
+	t.duration += duration
+	t.previous = duration
+}
+
+// finish counts the duration of the last sample and the start of the track from the first sample of the movie
+func (t *sampleTable) finish(base int64) {
+	if len(t.sizes) > 0 {
+		t.addDuration(lastDuration(t.video, t.previous))
+	}
+	t.start = max(t.firstDts-base, 0)
+}
+
+func (t *sampleTable) presentationDuration() int64 {
+	return t.start + t.duration - int64(t.firstCts)
+}
+
+// writeEdts writes the edit list which delays the track to its start and skips the composition offset
+// of the first frame, so the tracks stay in sync as they are in the FLV file.
+func (t *sampleTable) writeEdts(w *boxWriter) {
+	if t.start == 0 && t.firstCts == 0 {
+		return
+	}
+	edts := w.start("edts")
+	entries := uint32(1)
+	if t.start > 0 {
+		entries++
+	}
+	elst := w.startFull("elst", 1, 0)
+	w.u32(entries)
+	if t.start > 0 {
+		w.u64(uint64(t.start))
+		w.u64(math.MaxUint64) // empty edit
+		w.u32(0x00010000)
+	}
+	w.u64(uint64(t.duration - int64(t.firstCts)))
+	w.u64(uint64(t.firstCts))
+	w.u32(0x00010000)
+	w.end(elst)
+	w.end(edts)
+}
+
+func (t *sampleTable) writeTables(w *boxWriter) {
+	stts := w.startFull("stts", 0, 0)
+	w.u32(uint32(len(t.stts)))
+	for _, run := range t.stts {
+		w.u32(run.count)
+		w.u32(uint32(run.value))
+	}
+	w.end(stts)
+
+	if len(t.ctts) > 1 || len(t.ctts) == 1 && t.ctts[0].value != 0 {
+		version := byte(0)
+		for _, run := range t.ctts {
+			if run.value < 0 {
+				version = 1
+			}
+		}
+		ctts := w.startFull("ctts", version, 0)
+		w.u32(uint32(len(t.ctts)))
+		for _, run := range t.ctts {
+			w.u32(run.count)
+			w.u32(uint32(run.value))
+		}
+		w.end(ctts)
+	}
+
+	if t.video {
+		stss := w.startFull("stss", 0, 0)
+		w.u32(uint32(len(t.sync)))
+		for _, n := range t.sync {
+			w.u32(n)
+		}
+		w.end(stss)
+	}
+
+	stsc := w.startFull("stsc", 0, 0)
+	var entries []int
+	for i, n := range t.chunkSamples {
+		if i == 0 || t.chunkSamples[i-1] != n {
+			entries = append(entries, i)
+		}
+	}
+	w.u32(uint32(len(entries)))
+	for _, i := range entries {
+		w.u32(uint32(i + 1))
+		w.u32(t.chunkSamples[i])
+		w.u32(1) // sample description index
+	}
+	w.end(stsc)
+
+	stsz := w.startFull("stsz", 0, 0)
+	w.u32(0)
+	w.u32(uint32(len(t.sizes)))
+	for _, size := range t.sizes {
+		w.u32(size)
+	}
+	w.end(stsz)
+
+	if t.co64 {
+		co64 := w.startFull("co64", 0, 0)
+		w.u32(uint32(len(t.chunkOffsets)))
+		for _, offset := range t.chunkOffsets {
+			w.u64(uint64(t.dataStart + offset))
+		}
+		w.end(co64)
+	} else {
+		stco := w.startFull("stco", 0, 0)
+		w.u32(uint32(len(t.chunkOffsets)))
+		for _, offset := range t.chunkOffsets {
+			w.u32(uint32(t.dataStart + offset))
+		}
+		w.end(stco)
+	}
+}
+
+// Remux writes a FLV file of the given size as a regular MP4 with the moov before mdat (faststart).
+// The tag headers are scanned for the sample tables first, then the frames are copied from src GOP by GOP,
+// so only the tables are kept in memory however large the file is.
+func Remux(ctx context.Context, src io.ReaderAt, size int64, w io.Writer) error {
+	video := &sampleTable{video: true}
+	audio := &sampleTable{}
+	p, err := scanPlan(src, size, func(s sample) {
+		if s.video {
+			video.add(s)
+		} else {
+			audio.add(s)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	tables := make(map[uint32]*sampleTable)
+	if p.video != nil && len(video.sizes) > 0 {
+		tables[p.video.ID] = video
+	}
+	if p.audio != nil && len(audio.sizes) > 0 {
+		tables[p.audio.ID] = audio
+	}
+	tracks := make([]*Track, 0, len(tables))
+	var duration int64
+	for _, t := range p.tracks() {
+		if table, ok := tables[t.ID]; ok {
+			table.finish(p.base)
+			duration = max(duration, table.presentationDuration())
+			tracks = append(tracks, t)
+		}
+	}
+
+	// a chunk for the samples of each track in every GOP, video first
+	var payload int64
+	for _, frag := range p.fragments {
+		if frag.video.count > 0 {
+			video.chunkOffsets = append(video.chunkOffsets, payload)
+			video.chunkSamples = append(video.chunkSamples, uint32(frag.video.count))
+			payload += frag.video.bytes
+		}
+		if frag.audio.count > 0 {
+			audio.chunkOffsets = append(audio.chunkOffsets, payload)
+			audio.chunkSamples = append(audio.chunkSamples, uint32(frag.audio.count))
+			payload += frag.audio.bytes
+		}
+	}
+
+	mdatHeader := []byte{0, 0, 0, 0, 'm', 'd', 'a', 't'}
+	if payload+8 > math.MaxUint32 {
+		mdatHeader = []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(mdatHeader[8:], uint64(payload+16))
+	} else {
+		binary.BigEndian.PutUint32(mdatHeader, uint32(payload+8))
+	}
+
+	head := func() []byte {
+		b := &boxWriter{}
+		writeFtyp(b, "isom", "isom", "iso2", "avc1", "mp41")
+		writeMoov(b, tracks, uint64(duration), tables)
+		return b.buf
+	}
+	// the size of moov does not change with the chunk offsets, only with their width
+	headSize := int64(len(head()))
+	if headSize+int64(len(mdatHeader))+payload > math.MaxUint32 {
+		video.co64, audio.co64 = true, true
+		headSize = int64(len(head()))
+	}
+	video.dataStart = headSize + int64(len(mdatHeader))
+	audio.dataStart = video.dataStart
+
+	bw := bufio.NewWriterSize(w, writerBufferSize)
+	if _, err := bw.Write(head()); err != nil {
+		return err
+	} else if _, err := bw.Write(mdatHeader); err != nil {
+		return err
+	}
+	for i := range p.fragments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		video, audio, err := p.samples(src, i)
+		if err != nil {
+			return err
+		}
+		for _, s := range append(video, audio...) {
+			if n, err := io.Copy(bw, io.NewSectionReader(src, s.offset, int64(s.size))); err != nil {
+				return err
+			} else if n != int64(s.size) {
+				return fmt.Errorf("%w: sample at %d is truncated", ErrSourceChanged, s.offset)
+			}
+		}
+	}
+	return bw.Flush()
+}
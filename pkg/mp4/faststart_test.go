@@ -0,0 +1,80 @@
+package mp4
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+// findBox returns the content of the first box at the path of nested box types
+func findBox(t *testing.T, data []byte, path ...string) []byte {
+	t.Helper()
+	for _, typ := range path {
+		found := false
+		for _, b := range readBoxes(t, data) {
+			if b.typ == typ {
+				data, found = b.data, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("box %s of %v not found", typ, path)
+		}
+	}
+	return data
+}
+
+func TestRemux_Faststart(t *testing.T) {
+	data := testFlv()
+	var out bytes.Buffer
+	if err := Remux(context.Background(), bytes.NewReader(data), int64(len(data)), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := readBoxes(t, out.Bytes())
+	if len(boxes) != 3 || boxes[0].typ != "ftyp" || boxes[1].typ != "moov" || boxes[2].typ != "mdat" {
+		t.Fatalf("expected ftyp, moov and mdat, got %d boxes", len(boxes))
+	}
+	mdat := boxes[2].data
+	if len(mdat) != 12*5+12 {
+		t.Fatalf("unexpected mdat size %d", len(mdat))
+	}
+
+	traks := readBoxes(t, boxes[1].data)[1:]
+	video := findBox(t, traks[0].data, "mdia", "minf", "stbl")
+	if count := binary.BigEndian.Uint32(findBox(t, video, "stsz")[8:]); count != 12 {
+		t.Errorf("expected 12 video samples, got %d", count)
+	}
+	if stss := findBox(t, video, "stss"); binary.BigEndian.Uint32(stss[4:]) != 3 || binary.BigEndian.Uint32(stss[12:]) != 6 {
+		t.Errorf("unexpected sync samples % x", stss)
+	}
+	// the composition offset of every frame is 40 ms, which the edit list skips
+	if ctts := findBox(t, video, "ctts"); binary.BigEndian.Uint32(ctts[8:]) != 12 || binary.BigEndian.Uint32(ctts[12:]) != 40 {
+		t.Errorf("unexpected composition offsets % x", ctts)
+	}
+	if elst := findBox(t, traks[0].data, "edts", "elst"); binary.BigEndian.Uint64(elst[16:]) != 40 {
+		t.Errorf("unexpected edit list % x", elst)
+	}
+
+	// the chunks of the second GOP start with the keyframe, then its audio
+	stco := findBox(t, video, "stco")
+	second := binary.BigEndian.Uint32(stco[12:])
+	if !bytes.Equal(out.Bytes()[second:second+5], []byte{0, 0, 0, 1, 5}) {
+		t.Errorf("chunk offset %d does not point to the second keyframe", second)
+	}
+	audio := findBox(t, traks[1].data, "mdia", "minf", "stbl")
+	audioStco := findBox(t, audio, "stco")
+	if chunk := binary.BigEndian.Uint32(audioStco[12:]); out.Bytes()[chunk] != 0xA5 || chunk != second+25 {
+		t.Errorf("audio chunk offset %d does not follow the video chunk", chunk)
+	}
+}
+
+func TestRemux_Cancelled(t *testing.T) {
+	data := testFlv()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Remux(ctx, bytes.NewReader(data), int64(len(data)), &bytes.Buffer{}); err != context.Canceled {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	videoSampleEntrySize = 16 // duration, size, flags and composition offset
+	audioSampleEntrySize = 8  // duration and size
+	// mfhd of the moof, then tfhd, tfdt and trun of each traf without their samples
+	moofHeaderSize = 8 + 16
+	trafHeaderSize = 8 + 16 + 20 + 20
+	// sidx counts its references in 16 bits
+	maxSidxReferences = 0xFFFF
+)
+
+// FragmentedFile is the layout of a FLV file remuxed into fragmented MP4 with a fragment for each GOP.
+// The layout is computed from the tag headers only, the sizes and offsets of the output are known
+// before any fragment is written, so it can be read from any position like a regular file.
+type FragmentedFile struct {
+	Video *Track
+	Audio *Track
+	// duration of the movie in milliseconds
+	Duration int64
+
+	*plan
+	// ftyp, moov and sidx
+	head []byte
+	size int64
+}
+
+func moofSize(f *fragment) int64 {
+	size := int64(moofHeaderSize)
+	if f.video.count > 0 {
+		size += trafHeaderSize + int64(f.video.count)*videoSampleEntrySize
+	}
+	if f.audio.count > 0 {
+		size += trafHeaderSize + int64(f.audio.count)*audioSampleEntrySize
+	}
+	return size
+}
+
+// NewFragmentedFile scans the tags of a FLV file of the given size and plans its fragments
+func NewFragmentedFile(r io.ReaderAt, size int64) (*FragmentedFile, error) {
+	p, err := scanPlan(r, size, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := &FragmentedFile{
+		Video:    p.video,
+		Audio:    p.audio,
+		Duration: max(p.lastDts-p.base, 0),
+		plan:     p,
+	}
+	for _, frag := range f.fragments {
+		frag.size = moofSize(frag) + 8 + frag.video.bytes + frag.audio.bytes
+	}
+	f.head = f.writeHead()
+	f.size = int64(len(f.head))
+	for _, frag := range f.fragments {
+		frag.offset = f.size
+		f.size += frag.size
+	}
+	return f, nil
+}
+
+// Size returns the size of the whole MP4 output
+func (f *FragmentedFile) Size() int64 {
+	return f.size
+}
+
+func (f *FragmentedFile) writeHead() []byte {
+	w := &boxWriter{}
+	writeFtyp(w, "iso6", "iso6", "isom", "iso2", "avc1", "mp41")
+	writeMoov(w, f.tracks(), uint64(f.Duration), nil)
+
+	// the segment index lets players seek to a fragment without reading the ones before it
+	if len(f.fragments) > maxSidxReferences {
+		return w.buf
+	}
+	reference := f.tracks()[0]
+	sidx := w.startFull("sidx", 1, 0)
+	w.u32(reference.ID)
+	w.u32(timescale)
+	w.u64(0) // earliest presentation time
+	w.u64(0) // first offset
+	w.u16(0)
+	w.u16(uint16(len(f.fragments)))
+	for i, frag := range f.fragments {
+		end := f.base + f.Duration
+		if i+1 < len(f.fragments) {
+			end = f.fragments[i+1].time()
+		}
+		w.u32(uint32(frag.size))
+		w.u32(uint32(max(end-frag.time(), 0)))
+		if frag.keyframe || f.Video == nil {
+			w.u32(0x90000000) // starts with SAP of type 1
+		} else {
+			w.u32(0)
+		}
+	}
+	w.end(sidx)
+	return w.buf
+}
+
+// NewReader returns the MP4 output from the offset to the end, src is the FLV file which was scanned.
+// ErrSourceChanged is returned by the reader if the tags of src are no longer the same.
+func (f *FragmentedFile) NewReader(src io.ReaderAt, offset int64) io.Reader {
+	r := &fragmentReader{f: f, src: src}
+	if offset < 0 || offset >= f.size {
+		r.next = len(f.fragments)
+		return r
+	}
+	if offset < int64(len(f.head)) {
+		r.parts = []part{{data: f.head[offset:]}}
+		return r
+	}
+	r.next = sort.Search(len(f.fragments), func(i int) bool {
+		return f.fragments[i].offset+f.fragments[i].size > offset
+	})
+	r.skip = offset - f.fragments[r.next].offset
+	return r
+}
+
+// part is either bytes in memory or a range of the FLV file
+type part struct {
+	data   []byte
+	offset int64
+	size   int64
+}
+
+type fragmentReader struct {
+	f     *FragmentedFile
+	src   io.ReaderAt
+	next  int
+	skip  int64
+	parts []part
+}
+
+func (r *fragmentReader) Read(p []byte) (int, error) {
+	for len(r.parts) == 0 {
+		if r.next >= len(r.f.fragments) {
+			return 0, io.EOF
+		}
+		parts, err := r.f.build(r.src, r.next)
+		if err != nil {
+			return 0, err
+		}
+		r.next++
+		r.parts = skipParts(parts, r.skip)
+		r.skip = 0
+	}
+	cur := &r.parts[0]
+	var n int
+	if cur.data != nil {
+		n = copy(p, cur.data)
+		cur.data = cur.data[n:]
+		if len(cur.data) == 0 {
+			r.parts = r.parts[1:]
+		}
+		return n, nil
+	}
+	n, err := r.src.ReadAt(p[:min(int64(len(p)), cur.size)], cur.offset)
+	if n == 0 && err != nil {
+		if err == io.EOF {
+			err = ErrSourceChanged
+		}
+		return 0, err
+	}
+	cur.offset += int64(n)
+	cur.size -= int64(n)
+	if cur.size == 0 {
+		r.parts = r.parts[1:]
+	}
+	return n, nil
+}
+
+func skipParts(parts []part, skip int64) []part {
+	for len(parts) > 0 && skip > 0 {
+		cur := &parts[0]
+		if cur.data != nil {
+			n := min(int64(len(cur.data)), skip)
+			cur.data, skip = cur.data[n:], skip-n
+			if len(cur.data) > 0 {
+				break
+			}
+		} else {
+			n := min(cur.size, skip)
+			cur.offset, cur.size, skip = cur.offset+n, cur.size-n, skip-n
+			if cur.size > 0 {
+				break
+			}
+		}
+		parts = parts[1:]
+	}
+	return parts
+}
+
+// build reads the samples of a fragment and returns its moof and the sample data of mdat
+func (f *FragmentedFile) build(src io.ReaderAt, i int) ([]part, error) {
+	frag := f.fragments[i]
+	video, audio, err := f.samples(src, i)
+	if err != nil {
+		return nil, err
+	}
+
+	size := moofSize(frag)
+	w := &boxWriter{buf: make([]byte, 0, size+8)}
+	moof := w.start("moof")
+	mfhd := w.startFull("mfhd", 0, 0)
+	w.u32(uint32(i + 1))
+	w.end(mfhd)
+	dataOffset := size + 8
+	if len(video) > 0 {
+		f.writeTraf(w, f.Video, video, f.nextDts(i, true), dataOffset)
+		dataOffset += frag.video.bytes
+	}
+	if len(audio) > 0 {
+		f.writeTraf(w, f.Audio, audio, f.nextDts(i, false), dataOffset)
+	}
+	w.end(moof)
+	w.u32(uint32(8 + frag.video.bytes + frag.audio.bytes))
+	w.bytes([]byte("mdat"))
+	if int64(len(w.buf)) != size+8 {
+		return nil, fmt.Errorf("%w: fragment %d has unexpected moof size", ErrSourceChanged, i)
+	}
+
+	parts := make([]part, 0, 1+len(video)+len(audio))
+	parts = append(parts, part{data: w.buf})
+	for _, s := range append(video, audio...) {
+		parts = append(parts, part{offset: s.offset, size: int64(s.size)})
+	}
+	return parts, nil
+}
+
+func (f *FragmentedFile) writeTraf(w *boxWriter, t *Track, samples []sample, nextDts int64, dataOffset int64) {
+	traf := w.start("traf")
+	tfhd := w.startFull("tfhd", 0, 0x020000) // default base is moof
+	w.u32(t.ID)
+	w.end(tfhd)
+	tfdt := w.startFull("tfdt", 1, 0)
+	w.u64(uint64(max(samples[0].dts-f.base, 0)))
+	w.end(tfdt)
+
+	// data offset, sample duration and size, plus flags and composition offset of video
+	flags := uint32(0x000301)
+	if t.IsVideo() {
+		flags |= 0x000C00
+	}
+	trun := w.startFull("trun", 1, flags)
+	w.u32(uint32(len(samples)))
+	w.u32(uint32(dataOffset))
+	var duration int64
+	for j, s := range samples {
+		switch {
+		case j+1 < len(samples):
+			duration = samples[j+1].dts - s.dts
+		case nextDts >= 0:
+			duration = nextDts - s.dts
+		default:
+			duration = lastDuration(t.IsVideo(), duration)
+		}
+		w.u32(uint32(max(duration, 0)))
+		w.u32(s.size)
+		if t.IsVideo() {
+			if s.keyframe {
+				w.u32(sampleFlagsSync)
+			} else {
+				w.u32(sampleFlagsNonSync)
+			}
+			w.u32(uint32(s.cts))
+		}
+	}
+	w.end(trun)
+	w.end(traf)
+}
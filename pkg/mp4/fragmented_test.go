@@ -0,0 +1,136 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+// testFlv builds a recording with a keyframe every 5 frames, the payload of each frame is its index
+func testFlv() []byte {
+	var stream bytes.Buffer
+	stream.Write(flv.FlvHeader)
+	stream.Write([]byte{0, 0, 0, 0})
+	write := func(tagType byte, ts int32, body []byte) {
+		flv.WriteTag(&stream, &flv.Tag{Type: tagType, DataSize: uint32(len(body)), Timestamp: ts, Data: body})
+	}
+	// audio before the sequence headers is skipped
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x01, 0xEE})
+	write(flv.TagTypeVideo, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1, 0x00, 0x0C,
+		0x67, 0x64, 0x00, 0x28, 0xAC, 0xD9, 0x40, 0x78, 0x02, 0x27, 0xE5, 0x40, 0x01, 0x00, 0x02, 0x68, 0xEE})
+	write(flv.TagTypeAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	for i := range 12 {
+		frameType := byte(0x27)
+		if i%5 == 0 {
+			frameType = 0x17
+		}
+		write(flv.TagTypeVideo, int32(i*40), []byte{frameType, 0x01, 0, 0, 40, 0, 0, 0, 1, byte(i)})
+		write(flv.TagTypeAudio, int32(i*40), []byte{0xAF, 0x01, 0xA0 + byte(i)})
+	}
+	return stream.Bytes()
+}
+
+type box struct {
+	typ  string
+	data []byte
+}
+
+func readBoxes(t *testing.T, data []byte) []box {
+	t.Helper()
+	var boxes []box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated box header % x", data)
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("invalid size %d of box %q", size, data[4:8])
+		}
+		boxes = append(boxes, box{typ: string(data[4:8]), data: data[8:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func TestFragmentedFile_Remux(t *testing.T) {
+	src := bytes.NewReader(testFlv())
+	f, err := NewFragmentedFile(src, src.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Video == nil || f.Video.Codec != flv.VideoCodecAVC || f.Audio == nil || f.Audio.SampleRate != 44100 {
+		t.Fatalf("unexpected tracks %+v %+v", f.Video, f.Audio)
+	}
+	if f.Duration != 440 {
+		t.Errorf("expected duration 440, got %d", f.Duration)
+	}
+
+	out, err := io.ReadAll(f.NewReader(src, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(out)) != f.Size() {
+		t.Fatalf("expected %d bytes, got %d", f.Size(), len(out))
+	}
+	var types []string
+	boxes := readBoxes(t, out)
+	for _, b := range boxes {
+		types = append(types, b.typ)
+	}
+	expected := []string{"ftyp", "moov", "sidx", "moof", "mdat", "moof", "mdat", "moof", "mdat"}
+	if len(types) != len(expected) {
+		t.Fatalf("unexpected boxes %v", types)
+	}
+	for i := range types {
+		if types[i] != expected[i] {
+			t.Fatalf("unexpected boxes %v", types)
+		}
+	}
+
+	// the second GOP has 5 length-prefixed video frames followed by 5 AAC frames
+	mdat := boxes[6].data
+	video := []byte{0, 0, 0, 1, 5, 0, 0, 0, 1, 6, 0, 0, 0, 1, 7, 0, 0, 0, 1, 8, 0, 0, 0, 1, 9}
+	audio := []byte{0xA5, 0xA6, 0xA7, 0xA8, 0xA9}
+	if !bytes.Equal(mdat, append(video, audio...)) {
+		t.Errorf("unexpected mdat % x", mdat)
+	}
+	trafs := readBoxes(t, boxes[5].data[16:])
+	if len(trafs) != 2 {
+		t.Fatalf("expected video and audio traf, got %d", len(trafs))
+	}
+	tfdt := readBoxes(t, trafs[0].data)[1]
+	if binary.BigEndian.Uint64(tfdt.data[4:]) != 200 {
+		t.Errorf("expected the second GOP to start at 200, got %d", binary.BigEndian.Uint64(tfdt.data[4:]))
+	}
+
+	// reading from any offset continues the same output
+	for _, offset := range []int64{0, 100, int64(len(out)) - 30, int64(len(out)) - 3} {
+		part, err := io.ReadAll(f.NewReader(src, offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(part, out[offset:]) {
+			t.Errorf("output from offset %d differs", offset)
+		}
+	}
+}
+
+func TestFragmentedFile_SourceChanged(t *testing.T) {
+	data := testFlv()
+	f, err := NewFragmentedFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the recording is replaced by a file with fewer frames
+	changed := bytes.NewReader(data[:len(data)-40])
+	if _, err := io.ReadAll(f.NewReader(changed, f.fragments[2].offset)); err == nil {
+		t.Error("expected error when the source changed")
+	}
+
+	if _, err := NewFragmentedFile(bytes.NewReader([]byte("not flv")), 7); err != flv.ErrNotFlvFile {
+		t.Errorf("expected not FLV file error, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+const (
+	// audio only files have no keyframes to cut at, about 23 seconds of 44.1 kHz AAC
+	audioFragmentSamples = 1000
+
+	defaultVideoDuration = 33
+	defaultAudioDuration = 23
+)
+
+var (
+	ErrNoTracks      = errors.New("no supported audio or video track")
+	ErrSourceChanged = errors.New("source file changed since scanned")
+)
+
+// plan is the tracks of a FLV file and its samples grouped by GOP, found from the tag headers only
+type plan struct {
+	video *Track
+	audio *Track
+	// the samples before these positions are skipped as their decoder configuration is unknown
+	videoFrom int64
+	audioFrom int64
+	// the decoding time of the first and last sample
+	base      int64
+	lastDts   int64
+	fragments []*fragment
+}
+
+// fragment is the samples of the tags between start and end in the FLV file
+type fragment struct {
+	start    int64
+	end      int64
+	keyframe bool
+	video    trackRun
+	audio    trackRun
+
+	// position and size in the fragmented MP4 output
+	offset int64
+	size   int64
+}
+
+type trackRun struct {
+	count int
+	bytes int64
+	// the decoding time of the first sample
+	dts int64
+}
+
+func (r *trackRun) add(s sample) {
+	if r.count == 0 {
+		r.dts = s.dts
+	}
+	r.count++
+	r.bytes += int64(s.size)
+}
+
+// time returns the decoding time of the earliest sample
+func (f *fragment) time() int64 {
+	if f.video.count == 0 {
+		return f.audio.dts
+	} else if f.audio.count == 0 {
+		return f.video.dts
+	}
+	return min(f.video.dts, f.audio.dts)
+}
+
+// scanPlan scans the tags of a FLV file of the given size, onSample is called with every sample
+// in the file order if not nil.
+func scanPlan(r io.ReaderAt, size int64, onSample func(sample)) (*plan, error) {
+	start, err := readDataOffset(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &plan{}
+	scanner := &tagScanner{r: r, offset: start, end: size}
+	var cur *fragment
+	seenKeyframe := false
+	for {
+		tag, err := scanner.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if err := p.trackHeader(scanner, tag); err != nil {
+			return nil, err
+		}
+		s, ok := p.sample(tag)
+		if !ok {
+			continue
+		}
+		if s.video {
+			if s.keyframe {
+				seenKeyframe = true
+				cur = p.cut(cur, tag.offset)
+				cur.keyframe = true
+			} else if !seenKeyframe {
+				// undecodable without the keyframe before them
+				continue
+			}
+		} else if p.video == nil && cur != nil && cur.audio.count >= audioFragmentSamples {
+			cur = p.cut(cur, tag.offset)
+		}
+		if cur == nil {
+			cur = p.cut(nil, tag.offset)
+		}
+		if s.video {
+			cur.video.add(s)
+		} else {
+			cur.audio.add(s)
+		}
+		p.lastDts = max(p.lastDts, s.dts)
+		if onSample != nil {
+			onSample(s)
+		}
+	}
+	p.cut(cur, scanner.offset)
+	if len(p.fragments) == 0 {
+		return nil, ErrNoTracks
+	}
+	p.base = p.fragments[0].time()
+	return p, nil
+}
+
+// trackHeader creates the track from the first sequence header of each type
+func (p *plan) trackHeader(scanner *tagScanner, tag *scannedTag) error {
+	isVideo := tag.tagType == flv.TagTypeVideo && p.video == nil && flv.IsVideoSequenceHeader(tag.prefix)
+	isAudio := tag.tagType == flv.TagTypeAudio && p.audio == nil &&
+		flv.AudioCodec(tag.prefix) == flv.AudioCodecAAC && len(tag.prefix) >= 2 && tag.prefix[1] == 0
+	if !isVideo && !isAudio {
+		return nil
+	}
+	body, err := scanner.body(tag)
+	if err != nil {
+		return err
+	}
+	if isVideo {
+		if track, err := NewVideoTrack(body); err == nil {
+			p.video, p.videoFrom = track, tag.offset
+		}
+	} else if track, err := NewAudioTrack(body); err == nil {
+		p.audio, p.audioFrom = track, tag.offset
+	}
+	return nil
+}
+
+func (p *plan) sample(tag *scannedTag) (sample, bool) {
+	switch {
+	case tag.tagType == flv.TagTypeVideo && p.video != nil && tag.offset > p.videoFrom:
+		return toSample(tag, p.video)
+	case tag.tagType == flv.TagTypeAudio && p.audio != nil && tag.offset > p.audioFrom:
+		return toSample(tag, p.audio)
+	}
+	return sample{}, false
+}
+
+// cut ends the current fragment at the offset and starts the next one from there
+func (p *plan) cut(cur *fragment, offset int64) *fragment {
+	if cur != nil {
+		if cur.video.count+cur.audio.count == 0 {
+			return cur
+		}
+		cur.end = offset
+		p.fragments = append(p.fragments, cur)
+	}
+	return &fragment{start: offset}
+}
+
+// samples reads the video and audio samples of a fragment again from src,
+// ErrSourceChanged is returned if they are not the ones scanned.
+func (p *plan) samples(src io.ReaderAt, i int) (video, audio []sample, err error) {
+	frag := p.fragments[i]
+	scanner := &tagScanner{r: src, offset: frag.start, end: frag.end}
+	video = make([]sample, 0, frag.video.count)
+	audio = make([]sample, 0, frag.audio.count)
+	seenKeyframe := frag.keyframe
+	for {
+		tag, err := scanner.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		s, ok := p.sample(tag)
+		if !ok {
+			continue
+		}
+		if s.video {
+			seenKeyframe = seenKeyframe || s.keyframe
+			if seenKeyframe {
+				video = append(video, s)
+			}
+		} else {
+			audio = append(audio, s)
+		}
+	}
+	if len(video) != frag.video.count || len(audio) != frag.audio.count {
+		return nil, nil, fmt.Errorf("%w: fragment %d has %d video and %d audio samples", ErrSourceChanged, i, len(video), len(audio))
+	}
+	return video, audio, nil
+}
+
+// nextDts returns the decoding time of the first sample of the track after the fragment, -1 if none
+func (p *plan) nextDts(i int, video bool) int64 {
+	for _, frag := range p.fragments[i+1:] {
+		if video && frag.video.count > 0 {
+			return frag.video.dts
+		} else if !video && frag.audio.count > 0 {
+			return frag.audio.dts
+		}
+	}
+	return -1
+}
+
+func (p *plan) tracks() []*Track {
+	tracks := make([]*Track, 0, 2)
+	if p.video != nil {
+		tracks = append(tracks, p.video)
+	}
+	if p.audio != nil {
+		tracks = append(tracks, p.audio)
+	}
+	return tracks
+}
+
+// lastDuration is the duration of the last sample of a track, which has no next sample to count from
+func lastDuration(video bool, previous int64) int64 {
+	if previous > 0 {
+		return previous
+	} else if video {
+		return defaultVideoDuration
+	}
+	return defaultAudioDuration
+}
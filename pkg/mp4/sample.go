@@ -0,0 +1,129 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+const (
+	// the longest tag body header in front of the sample data, which is the enhanced RTMP coded frames
+	maxFrameHeaderSize = 8
+
+	sampleFlagsSync    = 0x02000000
+	sampleFlagsNonSync = 0x01010000
+)
+
+// sample is a video frame or raw AAC frame which stays in the FLV file
+type sample struct {
+	video    bool
+	keyframe bool
+	dts      int64
+	cts      int32
+	// position and size of the sample data in the FLV file
+	offset int64
+	size   uint32
+}
+
+// tagScanner walks the tags of a FLV file reading only their headers and the first bytes of the body,
+// so the frames are never loaded into memory.
+type tagScanner struct {
+	r      io.ReaderAt
+	offset int64
+	end    int64
+	buf    [flv.TagHeaderSize + maxFrameHeaderSize]byte
+}
+
+// scannedTag is a tag found by tagScanner, prefix is only valid until the next call
+type scannedTag struct {
+	offset    int64
+	tagType   byte
+	timestamp int32
+	dataSize  uint32
+	prefix    []byte
+}
+
+// next returns the next tag, io.EOF is returned when no complete tag is left before the end
+func (s *tagScanner) next() (*scannedTag, error) {
+	if s.offset+flv.TagHeaderSize > s.end {
+		return nil, io.EOF
+	}
+	n, err := s.r.ReadAt(s.buf[:], s.offset)
+	if n < flv.TagHeaderSize {
+		if err == nil || err == io.EOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	header := s.buf[:flv.TagHeaderSize]
+	tag := &scannedTag{
+		offset:    s.offset,
+		tagType:   header[0],
+		dataSize:  uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3]),
+		timestamp: int32(header[7])<<24 | int32(header[4])<<16 | int32(header[5])<<8 | int32(header[6]),
+	}
+	next := s.offset + flv.TagHeaderSize + int64(tag.dataSize) + flv.PrevTagSizeBytes
+	if next > s.end {
+		// truncated at the end of an interrupted recording
+		return nil, io.EOF
+	}
+	tag.prefix = s.buf[flv.TagHeaderSize:min(n, flv.TagHeaderSize+int(tag.dataSize))]
+	s.offset = next
+	return tag, nil
+}
+
+// body reads the whole body of a tag, which is needed for the sequence headers
+func (s *tagScanner) body(tag *scannedTag) ([]byte, error) {
+	data := make([]byte, tag.dataSize)
+	if _, err := s.r.ReadAt(data, tag.offset+flv.TagHeaderSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// toSample returns the sample carried by a tag, sequence headers, end of sequence
+// and codecs other than the track are skipped.
+func toSample(tag *scannedTag, track *Track) (sample, bool) {
+	if track == nil {
+		return sample{}, false
+	}
+	var headerSize int
+	s := sample{dts: int64(tag.timestamp)}
+	switch tag.tagType {
+	case flv.TagTypeVideo:
+		frame, err := flv.ParseVideoFrame(tag.prefix)
+		if err != nil || frame.SequenceHeader || frame.Payload == nil || frame.Codec != track.Codec {
+			return sample{}, false
+		}
+		headerSize = len(tag.prefix) - len(frame.Payload)
+		s.video, s.keyframe, s.cts = true, frame.Keyframe, frame.CompositionTime
+	case flv.TagTypeAudio:
+		frame, err := flv.ParseAudioFrame(tag.prefix)
+		if err != nil || frame.SequenceHeader {
+			return sample{}, false
+		}
+		headerSize = len(tag.prefix) - len(frame.Payload)
+	default:
+		return sample{}, false
+	}
+	if int(tag.dataSize) <= headerSize {
+		return sample{}, false
+	}
+	s.offset = tag.offset + flv.TagHeaderSize + int64(headerSize)
+	s.size = tag.dataSize - uint32(headerSize)
+	return s, true
+}
+
+// readDataOffset returns the position of the first tag after the FLV header
+func readDataOffset(r io.ReaderAt) (int64, error) {
+	header := make([]byte, flv.FlvHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil || string(header[:3]) != "FLV" {
+		return 0, flv.ErrNotFlvFile
+	}
+	offset := int64(binary.BigEndian.Uint32(header[5:9]))
+	if offset < flv.FlvHeaderSize {
+		return 0, flv.ErrNotFlvFile
+	}
+	return offset + flv.PrevTagSizeBytes, nil
+}
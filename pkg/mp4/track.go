@@ -0,0 +1,292 @@
+package mp4
+
+import (
+	"errors"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+const (
+	// both the movie and the tracks count in milliseconds as FLV timestamps do
+	timescale = 1000
+
+	videoTrackID = 1
+	audioTrackID = 2
+)
+
+var ErrInvalidSequenceHeader = errors.New("invalid sequence header")
+
+// Track is the decoder configuration of a video or audio track
+type Track struct {
+	ID    uint32
+	Codec string
+	// the AVC or HEVC decoder configuration record, or the AudioSpecificConfig
+	Config     []byte
+	Width      int
+	Height     int
+	SampleRate int
+	Channels   int
+}
+
+// NewVideoTrack creates the video track from the body of a video sequence header tag
+func NewVideoTrack(header []byte) (*Track, error) {
+	frame, err := flv.ParseVideoFrame(header)
+	if err != nil || !frame.SequenceHeader {
+		return nil, ErrInvalidSequenceHeader
+	}
+	info, err := flv.ParseVideoSequenceHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		ID:     videoTrackID,
+		Codec:  frame.Codec,
+		Config: append([]byte(nil), frame.Payload...),
+		Width:  info.Width,
+		Height: info.Height,
+	}, nil
+}
+
+// NewAudioTrack creates the audio track from the body of an AAC sequence header tag
+func NewAudioTrack(header []byte) (*Track, error) {
+	frame, err := flv.ParseAudioFrame(header)
+	if err != nil || !frame.SequenceHeader {
+		return nil, ErrInvalidSequenceHeader
+	}
+	config, err := flv.ParseAACConfig(frame.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		ID:         audioTrackID,
+		Codec:      flv.AudioCodecAAC,
+		Config:     append([]byte(nil), frame.Payload...),
+		SampleRate: config.SampleRate,
+		Channels:   config.Channels,
+	}, nil
+}
+
+// IsVideo reports whether the track is the video track
+func (t *Track) IsVideo() bool {
+	return t.ID == videoTrackID
+}
+
+func writeFtyp(w *boxWriter, major string, compatible ...string) {
+	pos := w.start("ftyp")
+	w.bytes([]byte(major))
+	w.u32(0x200)
+	for _, brand := range compatible {
+		w.bytes([]byte(brand))
+	}
+	w.end(pos)
+}
+
+// writeMoov writes the movie header and tracks with the sample tables by track id,
+// without tables the samples follow in movie fragments which are declared by mvex.
+func writeMoov(w *boxWriter, tracks []*Track, durationMs uint64, tables map[uint32]*sampleTable) {
+	moov := w.start("moov")
+
+	mvhd := w.startFull("mvhd", 1, 0)
+	w.u64(0) // creation time
+	w.u64(0) // modification time
+	w.u32(timescale)
+	w.u64(durationMs)
+	w.u32(0x00010000) // rate
+	w.u16(0x0100)     // volume
+	w.zeros(10)
+	w.matrix()
+	w.zeros(24)
+	w.u32(uint32(len(tracks) + 1))
+	w.end(mvhd)
+
+	for _, t := range tracks {
+		writeTrak(w, t, durationMs, tables[t.ID])
+	}
+	if tables != nil {
+		w.end(moov)
+		return
+	}
+
+	mvex := w.start("mvex")
+	mehd := w.startFull("mehd", 1, 0)
+	w.u64(durationMs)
+	w.end(mehd)
+	for _, t := range tracks {
+		trex := w.startFull("trex", 0, 0)
+		w.u32(t.ID)
+		w.u32(1) // sample description index
+		w.u32(0) // duration
+		w.u32(0) // size
+		if t.IsVideo() {
+			w.u32(sampleFlagsNonSync)
+		} else {
+			w.u32(sampleFlagsSync)
+		}
+		w.end(trex)
+	}
+	w.end(mvex)
+
+	w.end(moov)
+}
+
+func writeTrak(w *boxWriter, t *Track, durationMs uint64, table *sampleTable) {
+	trak := w.start("trak")
+	mediaDuration := durationMs
+	if table != nil {
+		mediaDuration = uint64(table.duration)
+		durationMs = uint64(table.presentationDuration())
+	}
+
+	tkhd := w.startFull("tkhd", 1, 0x03) // enabled and in movie
+	w.u64(0)
+	w.u64(0)
+	w.u32(t.ID)
+	w.u32(0)
+	w.u64(durationMs)
+	w.zeros(8)
+	w.u16(0) // layer
+	w.u16(0) // alternate group
+	if t.IsVideo() {
+		w.u16(0)
+	} else {
+		w.u16(0x0100)
+	}
+	w.u16(0)
+	w.matrix()
+	w.u32(uint32(t.Width) << 16)
+	w.u32(uint32(t.Height) << 16)
+	w.end(tkhd)
+	if table != nil {
+		table.writeEdts(w)
+	}
+
+	mdia := w.start("mdia")
+	mdhd := w.startFull("mdhd", 1, 0)
+	w.u64(0)
+	w.u64(0)
+	w.u32(timescale)
+	w.u64(mediaDuration)
+	w.u16(0x55C4) // und
+	w.u16(0)
+	w.end(mdhd)
+
+	hdlr := w.startFull("hdlr", 0, 0)
+	w.u32(0)
+	if t.IsVideo() {
+		w.bytes([]byte("vide"))
+	} else {
+		w.bytes([]byte("soun"))
+	}
+	w.zeros(12)
+	if t.IsVideo() {
+		w.bytes([]byte("VideoHandler\x00"))
+	} else {
+		w.bytes([]byte("SoundHandler\x00"))
+	}
+	w.end(hdlr)
+
+	minf := w.start("minf")
+	if t.IsVideo() {
+		vmhd := w.startFull("vmhd", 0, 1)
+		w.zeros(8)
+		w.end(vmhd)
+	} else {
+		smhd := w.startFull("smhd", 0, 0)
+		w.zeros(4)
+		w.end(smhd)
+	}
+	dinf := w.start("dinf")
+	dref := w.startFull("dref", 0, 0)
+	w.u32(1)
+	url := w.startFull("url ", 0, 1) // media in the same file
+	w.end(url)
+	w.end(dref)
+	w.end(dinf)
+
+	stbl := w.start("stbl")
+	writeStsd(w, t)
+	if table != nil {
+		table.writeTables(w)
+	} else {
+		for _, typ := range []string{"stts", "stsc", "stco"} {
+			pos := w.startFull(typ, 0, 0)
+			w.u32(0)
+			w.end(pos)
+		}
+		stsz := w.startFull("stsz", 0, 0)
+		w.u32(0)
+		w.u32(0)
+		w.end(stsz)
+	}
+	w.end(stbl)
+
+	w.end(minf)
+	w.end(mdia)
+	w.end(trak)
+}
+
+func writeStsd(w *boxWriter, t *Track) {
+	stsd := w.startFull("stsd", 0, 0)
+	w.u32(1)
+	switch t.Codec {
+	case flv.VideoCodecAVC, flv.VideoCodecHEVC:
+		entry, config := "avc1", "avcC"
+		if t.Codec == flv.VideoCodecHEVC {
+			entry, config = "hvc1", "hvcC"
+		}
+		pos := w.start(entry)
+		w.zeros(6)
+		w.u16(1) // data reference index
+		w.zeros(16)
+		w.u16(uint16(t.Width))
+		w.u16(uint16(t.Height))
+		w.u32(0x00480000) // 72 dpi
+		w.u32(0x00480000)
+		w.u32(0)
+		w.u16(1) // frame count
+		w.zeros(32)
+		w.u16(0x0018) // depth
+		w.u16(0xFFFF)
+		cfg := w.start(config)
+		w.bytes(t.Config)
+		w.end(cfg)
+		w.end(pos)
+	case flv.AudioCodecAAC:
+		pos := w.start("mp4a")
+		w.zeros(6)
+		w.u16(1)
+		w.zeros(8)
+		w.u16(uint16(t.Channels))
+		w.u16(16) // sample size
+		w.zeros(4)
+		w.u32(uint32(t.SampleRate) << 16)
+		writeEsds(w, t.Config)
+		w.end(pos)
+	}
+	w.end(stsd)
+}
+
+// writeEsds writes the elementary stream descriptor of AAC with the AudioSpecificConfig
+func writeEsds(w *boxWriter, asc []byte) {
+	esds := w.startFull("esds", 0, 0)
+	decoderSpecific := 2 + len(asc)
+	decoderConfig := 2 + 13 + decoderSpecific
+	w.u8(0x03) // ES descriptor
+	w.u8(uint8(3 + decoderConfig + 3))
+	w.u16(0) // ES id
+	w.u8(0)
+	w.u8(0x04) // decoder config descriptor
+	w.u8(uint8(decoderConfig - 2))
+	w.u8(0x40) // MPEG-4 audio
+	w.u8(0x15) // audio stream
+	w.zeros(3) // buffer size
+	w.u32(0)   // max bitrate
+	w.u32(0)   // average bitrate
+	w.u8(0x05) // decoder specific info
+	w.u8(uint8(len(asc)))
+	w.bytes(asc)
+	w.u8(0x06) // SL config descriptor
+	w.u8(1)
+	w.u8(0x02)
+	w.end(esds)
+}
@@ -0,0 +1,334 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+const (
+	PacketSize = 188
+
+	syncByte = 0x47
+	patPID   = 0x0000
+	pmtPID   = 0x1000
+	videoPID = 0x0100
+	audioPID = 0x0101
+
+	streamTypeAAC  = 0x0F
+	streamTypeAVC  = 0x1B
+	streamTypeHEVC = 0x24
+
+	streamIDVideo = 0xE0
+	streamIDAudio = 0xC0
+
+	// FLV timestamps are milliseconds while MPEG-TS counts in 90 kHz
+	clockRate = 90
+
+	avcNalAUD  = 9
+	hevcNalAUD = 35
+)
+
+var ErrInvalidDecoderConfig = errors.New("invalid decoder configuration record")
+
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// Muxer repackages the AVC/HEVC and AAC tags of a FLV stream into MPEG-TS.
+// Sequence headers must be written before the frames, the program tables are written
+// before the first frame so each muxer produces a self-contained segment.
+type Muxer struct {
+	w io.Writer
+
+	videoCodec    string
+	nalLengthSize int
+	// the parameter sets in Annex B prepended to every keyframe
+	parameterSets []byte
+	aac           *flv.AACConfig
+
+	tablesWritten bool
+	hasVideo      bool
+	hasAudio      bool
+	counters      map[uint16]byte
+
+	packet [PacketSize]byte
+	pes    []byte
+}
+
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		w:        w,
+		counters: make(map[uint16]byte),
+	}
+}
+
+// WriteTag writes a FLV tag, script tags and tags of unsupported codecs are ignored
+func (m *Muxer) WriteTag(tag *flv.Tag) error {
+	switch tag.Type {
+	case flv.TagTypeVideo:
+		frame, err := flv.ParseVideoFrame(tag.Data)
+		if errors.Is(err, flv.ErrUnsupportedCodec) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if frame.SequenceHeader {
+			return m.setVideoConfig(frame)
+		}
+		return m.writeVideo(tag.Timestamp, frame)
+	case flv.TagTypeAudio:
+		frame, err := flv.ParseAudioFrame(tag.Data)
+		if errors.Is(err, flv.ErrUnsupportedCodec) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if frame.SequenceHeader {
+			config, err := flv.ParseAACConfig(frame.Payload)
+			if err != nil {
+				return err
+			}
+			m.aac = config
+			return nil
+		}
+		return m.writeAudio(tag.Timestamp, frame.Payload)
+	}
+	return nil
+}
+
+func (m *Muxer) setVideoConfig(frame *flv.VideoFrame) error {
+	var sets [][]byte
+	var err error
+	switch frame.Codec {
+	case flv.VideoCodecAVC:
+		m.nalLengthSize, sets, err = parseAVCConfig(frame.Payload)
+	case flv.VideoCodecHEVC:
+		m.nalLengthSize, sets, err = parseHEVCConfig(frame.Payload)
+	}
+	if err != nil {
+		return err
+	}
+	m.videoCodec = frame.Codec
+	m.parameterSets = m.parameterSets[:0]
+	for _, set := range sets {
+		m.parameterSets = append(m.parameterSets, startCode...)
+		m.parameterSets = append(m.parameterSets, set...)
+	}
+	return nil
+}
+
+func (m *Muxer) writeVideo(timestamp int32, frame *flv.VideoFrame) error {
+	if m.videoCodec == "" || len(frame.Payload) == 0 {
+		return nil
+	}
+	if err := m.writeTables(); err != nil {
+		return err
+	} else if !m.hasVideo {
+		// the stream is not in the program tables
+		return nil
+	}
+
+	data := m.pes[:0]
+	data = append(data, pesReserved[:]...)
+	if m.videoCodec == flv.VideoCodecHEVC {
+		data = append(data, 0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50)
+	} else {
+		data = append(data, 0x00, 0x00, 0x00, 0x01, 0x09, 0xF0)
+	}
+	if frame.Keyframe {
+		data = append(data, m.parameterSets...)
+	}
+	payload := frame.Payload
+	for len(payload) >= m.nalLengthSize {
+		size := 0
+		for _, b := range payload[:m.nalLengthSize] {
+			size = size<<8 | int(b)
+		}
+		payload = payload[m.nalLengthSize:]
+		if size > len(payload) {
+			return flv.ErrInvalidTag
+		}
+		nal := payload[:size]
+		payload = payload[size:]
+		if len(nal) == 0 || m.isAUD(nal) {
+			continue
+		}
+		data = append(data, startCode...)
+		data = append(data, nal...)
+	}
+	m.pes = data
+
+	dts := int64(timestamp) * clockRate
+	pts := int64(timestamp+frame.CompositionTime) * clockRate
+	header := putPESHeader(data, streamIDVideo, pts, dts, false)
+	return m.writePackets(videoPID, data[pesHeaderSize-header:], adaptationField(frame.Keyframe, dts))
+}
+
+func (m *Muxer) isAUD(nal []byte) bool {
+	if m.videoCodec == flv.VideoCodecHEVC {
+		return (nal[0]>>1)&0x3F == hevcNalAUD
+	}
+	return nal[0]&0x1F == avcNalAUD
+}
+
+func (m *Muxer) writeAudio(timestamp int32, raw []byte) error {
+	if m.aac == nil || len(raw) == 0 {
+		return nil
+	}
+	if err := m.writeTables(); err != nil {
+		return err
+	} else if !m.hasAudio {
+		return nil
+	}
+
+	data := m.pes[:0]
+	data = append(data, pesReserved[:]...)
+	data = appendADTSHeader(data, m.aac, len(raw))
+	data = append(data, raw...)
+	m.pes = data
+
+	ts := int64(timestamp) * clockRate
+	header := putPESHeader(data, streamIDAudio, ts, ts, true)
+	var af []byte
+	if !m.hasVideo {
+		// audio carries the clock reference without video
+		af = adaptationField(true, ts)
+	}
+	return m.writePackets(audioPID, data[pesHeaderSize-header:], af)
+}
+
+// writeTables writes the PAT and PMT with the streams whose configuration is known
+func (m *Muxer) writeTables() error {
+	if m.tablesWritten {
+		return nil
+	}
+	m.tablesWritten = true
+	m.hasVideo = m.videoCodec != ""
+	m.hasAudio = m.aac != nil
+
+	pat := []byte{
+		0x00,       // table id
+		0xB0, 0x0D, // section length
+		0x00, 0x01, // transport stream id
+		0xC1, 0x00, 0x00, // version, section number, last section number
+		0x00, 0x01, // program number
+		0xE0 | pmtPID>>8, pmtPID & 0xFF,
+	}
+	if err := m.writeSection(patPID, pat); err != nil {
+		return err
+	}
+
+	pcrPID := uint16(audioPID)
+	if m.hasVideo {
+		pcrPID = videoPID
+	}
+	pmt := []byte{
+		0x02,       // table id
+		0xB0, 0x00, // section length
+		0x00, 0x01, // program number
+		0xC1, 0x00, 0x00,
+		0xE0 | byte(pcrPID>>8), byte(pcrPID),
+		0xF0, 0x00, // program info length
+	}
+	if m.hasVideo {
+		streamType := byte(streamTypeAVC)
+		if m.videoCodec == flv.VideoCodecHEVC {
+			streamType = streamTypeHEVC
+		}
+		pmt = append(pmt, streamType, 0xE0|videoPID>>8, videoPID&0xFF, 0xF0, 0x00)
+	}
+	if m.hasAudio {
+		pmt = append(pmt, streamTypeAAC, 0xE0|audioPID>>8, audioPID&0xFF, 0xF0, 0x00)
+	}
+	// section length counts the bytes after it including the CRC
+	binary.BigEndian.PutUint16(pmt[1:], 0xB000|uint16(len(pmt)-3+4))
+	return m.writeSection(pmtPID, pmt)
+}
+
+func (m *Muxer) writeSection(pid uint16, section []byte) error {
+	pkt := m.packet[:]
+	for i := range pkt {
+		pkt[i] = 0xFF
+	}
+	pkt[0] = syncByte
+	pkt[1] = 0x40 | byte(pid>>8)
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | m.nextCounter(pid)
+	pkt[4] = 0x00 // pointer field
+	n := copy(pkt[5:], section)
+	binary.BigEndian.PutUint32(pkt[5+n:], crc32MPEG(section))
+	_, err := m.w.Write(pkt)
+	return err
+}
+
+// writePackets splits a PES packet into TS packets, af is the adaptation field of the first packet
+func (m *Muxer) writePackets(pid uint16, data []byte, af []byte) error {
+	first := true
+	for first || len(data) > 0 {
+		pkt := m.packet[:]
+		pkt[0] = syncByte
+		pkt[1] = byte(pid >> 8)
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+
+		var adaptation []byte
+		if first {
+			adaptation = af
+		}
+		afSize := 0
+		if adaptation != nil {
+			afSize = 1 + len(adaptation)
+		}
+		// stuff the last packet through the adaptation field
+		if space := PacketSize - 4 - afSize; len(data) < space {
+			afSize += space - len(data)
+		}
+
+		pkt[3] = 0x10 | m.nextCounter(pid)
+		if afSize > 0 {
+			pkt[3] |= 0x20
+			pkt[4] = byte(afSize - 1)
+			if afSize > 1 {
+				rest := pkt[5 : 4+afSize]
+				n := copy(rest, adaptation)
+				if adaptation == nil {
+					rest[0] = 0x00 // no flags
+					n = 1
+				}
+				for i := n; i < len(rest); i++ {
+					rest[i] = 0xFF
+				}
+			}
+		}
+		n := copy(pkt[4+afSize:], data)
+		data = data[n:]
+		first = false
+
+		if _, err := m.w.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Muxer) nextCounter(pid uint16) byte {
+	c := m.counters[pid]
+	m.counters[pid] = (c + 1) & 0x0F
+	return c
+}
+
+// adaptationField returns the flags and PCR of the first packet of a frame
+func adaptationField(randomAccess bool, pcr int64) []byte {
+	flags := byte(0x10)
+	if randomAccess {
+		flags |= 0x40
+	}
+	return []byte{
+		flags,
+		byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1),
+		byte(pcr<<7) | 0x7E, 0x00,
+	}
+}
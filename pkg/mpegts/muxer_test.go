@@ -0,0 +1,173 @@
+package mpegts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xAC}
+	testPPS = []byte{0x68, 0xEE, 0x3C, 0x80}
+)
+
+func avcSequenceHeader() []byte {
+	body := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(testSPS)))
+	body = append(body, testSPS...)
+	body = append(body, 0x01)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(testPPS)))
+	return append(body, testPPS...)
+}
+
+// avcFrame builds a frame with a length-prefixed AUD and a slice of the given size
+func avcFrame(keyframe bool, cts int32, size int) []byte {
+	body := []byte{0x27, 0x01, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	if keyframe {
+		body[0] = 0x17
+	}
+	body = append(body, 0x00, 0x00, 0x00, 0x02, 0x09, 0xF0)
+	body = binary.BigEndian.AppendUint32(body, uint32(size))
+	body = append(body, 0x65)
+	return append(body, bytes.Repeat([]byte{0xAB}, size-1)...)
+}
+
+type pes struct {
+	pid      uint16
+	pts, dts int64
+	data     []byte
+	random   bool
+}
+
+// demux reassembles the PES packets in the order they start and validates the packet structure and program tables
+func demux(t *testing.T, ts []byte) (streams map[uint16]byte, packets []*pes) {
+	t.Helper()
+	if len(ts)%PacketSize != 0 {
+		t.Fatalf("stream size %d is not a multiple of packet size", len(ts))
+	}
+	streams = make(map[uint16]byte)
+	counters := make(map[uint16]byte)
+	current := make(map[uint16]*pes)
+	for off := 0; off < len(ts); off += PacketSize {
+		pkt := ts[off : off+PacketSize]
+		if pkt[0] != syncByte {
+			t.Fatalf("invalid sync byte at %d", off)
+		}
+		pid := uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+		start := pkt[1]&0x40 != 0
+		if c, ok := counters[pid]; ok && pkt[3]&0x0F != (c+1)&0x0F {
+			t.Fatalf("discontinuous counter of pid %d at %d", pid, off)
+		}
+		counters[pid] = pkt[3] & 0x0F
+
+		payload := pkt[4:]
+		random := false
+		if pkt[3]&0x20 != 0 {
+			afLen := int(payload[0])
+			random = afLen > 0 && payload[1]&0x40 != 0
+			payload = payload[1+afLen:]
+		}
+
+		switch pid {
+		case patPID, pmtPID:
+			section := payload[1:]
+			length := int(binary.BigEndian.Uint16(section[1:]) & 0x0FFF)
+			if crc32MPEG(section[:3+length]) != 0 {
+				t.Fatalf("invalid CRC of table %d", pid)
+			}
+			if pid == pmtPID {
+				for es := section[12 : 3+length-4]; len(es) >= 5; es = es[5:] {
+					streams[uint16(es[1]&0x1F)<<8|uint16(es[2])] = es[0]
+				}
+			}
+		default:
+			if start {
+				p := &pes{pid: pid, random: random}
+				packets = append(packets, p)
+				flags, headerLen := payload[7], int(payload[8])
+				p.pts = readTimestamp(payload[9:])
+				p.dts = p.pts
+				if flags&0x40 != 0 {
+					p.dts = readTimestamp(payload[14:])
+				}
+				current[pid] = p
+				payload = payload[9+headerLen:]
+			}
+			current[pid].data = append(current[pid].data, payload...)
+		}
+	}
+	return streams, packets
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func TestMuxer_AVCAndAAC(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMuxer(&out)
+	tags := []*flv.Tag{
+		{Type: flv.TagTypeScript, Data: []byte{0x02}},
+		{Type: flv.TagTypeVideo, Data: avcSequenceHeader()},
+		// AAC LC 44100 Hz stereo
+		{Type: flv.TagTypeAudio, Data: []byte{0xAF, 0x00, 0x12, 0x10}},
+		{Type: flv.TagTypeVideo, Timestamp: 1000, Data: avcFrame(true, 80, 400)},
+		{Type: flv.TagTypeAudio, Timestamp: 1010, Data: []byte{0xAF, 0x01, 0x21, 0x22, 0x23}},
+		{Type: flv.TagTypeVideo, Timestamp: 1040, Data: avcFrame(false, 0, 20)},
+	}
+	for _, tag := range tags {
+		if err := m.WriteTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	streams, packets := demux(t, out.Bytes())
+	if streams[videoPID] != streamTypeAVC || streams[audioPID] != streamTypeAAC {
+		t.Fatalf("unexpected streams %v", streams)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("expected 3 PES packets, got %d", len(packets))
+	}
+
+	key := packets[0]
+	if !key.random || key.dts != 1000*90 || key.pts != 1080*90 {
+		t.Errorf("unexpected keyframe timestamps pts=%d dts=%d random=%v", key.pts, key.dts, key.random)
+	}
+	expected := []byte{0, 0, 0, 1, 0x09, 0xF0, 0, 0, 0, 1}
+	expected = append(expected, testSPS...)
+	expected = append(append(expected, 0, 0, 0, 1), testPPS...)
+	expected = append(append(expected, 0, 0, 0, 1, 0x65), bytes.Repeat([]byte{0xAB}, 399)...)
+	if !bytes.Equal(key.data, expected) {
+		t.Errorf("unexpected keyframe data % x", key.data[:min(len(key.data), 32)])
+	}
+
+	audio := packets[1]
+	if audio.pid != audioPID || audio.pts != 1010*90 {
+		t.Errorf("unexpected audio packet pid=%d pts=%d", audio.pid, audio.pts)
+	}
+	adts := []byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0x5F, 0xFC, 0x21, 0x22, 0x23}
+	if !bytes.Equal(audio.data, adts) {
+		t.Errorf("unexpected ADTS frame % x", audio.data)
+	}
+
+	inter := packets[2]
+	if inter.random || !bytes.Equal(inter.data, append([]byte{0, 0, 0, 1, 0x09, 0xF0, 0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0xAB}, 19)...)) {
+		t.Errorf("inter frame should not carry parameter sets: % x", inter.data)
+	}
+}
+
+func TestMuxer_SkipsFramesBeforeSequenceHeader(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMuxer(&out)
+	if err := m.WriteTag(&flv.Tag{Type: flv.TagTypeVideo, Data: avcFrame(true, 0, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("frames without decoder configuration should be skipped")
+	}
+	if err := m.WriteTag(&flv.Tag{Type: flv.TagTypeVideo, Data: []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}}); err == nil {
+		t.Error("expected invalid decoder configuration error")
+	}
+}
@@ -0,0 +1,147 @@
+package mpegts
+
+import (
+	"encoding/binary"
+
+	"github.com/eric2788/bilirec/pkg/flv"
+)
+
+// the largest PES header with both PTS and DTS, reserved before the payload
+const pesHeaderSize = 19
+
+var pesReserved [pesHeaderSize]byte
+
+// putPESHeader writes the PES header right before the payload which starts at pesHeaderSize,
+// and returns the size of the header.
+func putPESHeader(data []byte, streamID byte, pts, dts int64, withLength bool) int {
+	size := 14
+	if pts != dts {
+		size = pesHeaderSize
+	}
+	h := data[pesHeaderSize-size : pesHeaderSize]
+	h[0], h[1], h[2], h[3] = 0x00, 0x00, 0x01, streamID
+	// zero length is only allowed for video
+	length := 0
+	if packetLength := len(data) - pesHeaderSize + size - 6; withLength && packetLength <= 0xFFFF {
+		length = packetLength
+	}
+	binary.BigEndian.PutUint16(h[4:], uint16(length))
+	h[6] = 0x80
+	if size == pesHeaderSize {
+		h[7], h[8] = 0xC0, 10
+		putTimestamp(h[9:], 0x3, pts)
+		putTimestamp(h[14:], 0x1, dts)
+	} else {
+		h[7], h[8] = 0x80, 5
+		putTimestamp(h[9:], 0x2, pts)
+	}
+	return size
+}
+
+func putTimestamp(b []byte, prefix byte, ts int64) {
+	ts &= 0x1FFFFFFFF
+	b[0] = prefix<<4 | byte(ts>>29)&0x0E | 0x01
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14)&0xFE | 0x01
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 0x01
+}
+
+// appendADTSHeader appends the ADTS header of a raw AAC frame
+func appendADTSHeader(b []byte, config *flv.AACConfig, rawSize int) []byte {
+	frameSize := 7 + rawSize
+	profile := byte(config.ObjectType-1) & 0x03
+	channels := byte(config.Channels)
+	return append(b,
+		0xFF, 0xF1, // sync word, MPEG-4, no CRC
+		profile<<6|byte(config.SampleRateIndex)<<2|channels>>2&0x01,
+		channels&0x03<<6|byte(frameSize>>11)&0x03,
+		byte(frameSize>>3),
+		byte(frameSize&0x07)<<5|0x1F,
+		0xFC,
+	)
+}
+
+// parseAVCConfig returns the NAL unit length size and the SPS and PPS of an AVCDecoderConfigurationRecord
+func parseAVCConfig(record []byte) (int, [][]byte, error) {
+	if len(record) < 7 {
+		return 0, nil, ErrInvalidDecoderConfig
+	}
+	lengthSize := int(record[4]&0x03) + 1
+	sets := make([][]byte, 0, 2)
+	pos := 5
+	for _, mask := range []byte{0x1F, 0xFF} {
+		if len(record) <= pos {
+			return 0, nil, ErrInvalidDecoderConfig
+		}
+		count := int(record[pos] & mask)
+		pos++
+		for range count {
+			if len(record) < pos+2 {
+				return 0, nil, ErrInvalidDecoderConfig
+			}
+			size := int(binary.BigEndian.Uint16(record[pos:]))
+			pos += 2
+			if len(record) < pos+size {
+				return 0, nil, ErrInvalidDecoderConfig
+			}
+			sets = append(sets, record[pos:pos+size])
+			pos += size
+		}
+	}
+	return lengthSize, sets, nil
+}
+
+// parseHEVCConfig returns the NAL unit length size and the VPS, SPS and PPS of a HEVCDecoderConfigurationRecord
+func parseHEVCConfig(record []byte) (int, [][]byte, error) {
+	if len(record) < 23 {
+		return 0, nil, ErrInvalidDecoderConfig
+	}
+	lengthSize := int(record[21]&0x03) + 1
+	sets := make([][]byte, 0, 3)
+	pos := 23
+	for range int(record[22]) {
+		if len(record) < pos+3 {
+			return 0, nil, ErrInvalidDecoderConfig
+		}
+		count := int(binary.BigEndian.Uint16(record[pos+1:]))
+		pos += 3
+		for range count {
+			if len(record) < pos+2 {
+				return 0, nil, ErrInvalidDecoderConfig
+			}
+			size := int(binary.BigEndian.Uint16(record[pos:]))
+			pos += 2
+			if len(record) < pos+size {
+				return 0, nil, ErrInvalidDecoderConfig
+			}
+			sets = append(sets, record[pos:pos+size])
+			pos += size
+		}
+	}
+	return lengthSize, sets, nil
+}
+
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc32MPEG is the CRC of the program tables, which is not reflected unlike hash/crc32
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}